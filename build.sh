@@ -1,2 +1,4 @@
 #! /bin/sh
-GOPATH=`pwd` go build -gccgoflags '-static' plabel.go
+go build -gccgoflags '-static' -o plabel ./cmd/plabel
+go build -gccgoflags '-static' -o cups/rastertoplabel/rastertoplabel ./cups/rastertoplabel
+go build -gccgoflags '-static' -o cups/backend/plabel ./cups/backend
@@ -0,0 +1,305 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "flag"
+  "fmt"
+  "io"
+  "os"
+  "strconv"
+  "strings"
+  "github.com/spag/plabel"
+  "github.com/spag/plabel/render"
+)
+
+const (
+  EXIT_OK = 0
+  EXIT_FAILURE = 1 //e.g. unreadable input file
+  EXIT_USAGE = 2
+  EXIT_PRINTER_UNAVAILABLE = 3 //printer not found or not responding
+  EXIT_MEDIA_ERROR = 4 //no media, wrong media or cutter jam
+  EXIT_COVER_OPEN = 5
+  EXIT_PRINTER_ERROR = 6 //weak batteries, high-voltage adapter or overheating
+
+  ERROR_CLASS_MEDIA = plabel.ERROR_NO_MEDIA | plabel.ERROR_CUTTER_JAM | plabel.ERROR_WRONG_MEDIA
+)
+
+// Command is a subcommand of plabel with its own options.
+type Command struct {
+  name string
+  arguments string
+  summary string
+  flags func(flags *CommandFlags, settings *Settings)
+  run func(settings *Settings, arguments []string) int
+}
+
+// CommandFlags defines every option once with an optional short name and
+// creates the help text from the definitions.
+type CommandFlags struct {
+  *flag.FlagSet
+  help []string
+  long_names map[string]string
+}
+
+func NewCommandFlags(name string) *CommandFlags {
+  flags := &CommandFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError), long_names: make(map[string]string)}
+  flags.SetOutput(io.Discard)
+  return flags
+}
+
+// ParseArguments parses the options and returns the other arguments.
+// Options may follow the arguments, all arguments after -- are kept.
+func (self *CommandFlags) ParseArguments(arguments []string) ([]string, error) {
+  var command_arguments []string
+  for remaining := arguments; ; remaining = self.Args()[1:] {
+    if err := self.Parse(remaining); err != nil {
+      return nil, err
+    }
+    if parsed := len(remaining) - self.NArg(); parsed > 0 && remaining[parsed - 1] == "--" {
+      return append(command_arguments, self.Args()...), nil
+    }
+    if self.NArg() == 0 {
+      return command_arguments, nil
+    }
+    command_arguments = append(command_arguments, self.Arg(0))
+  }
+}
+
+// IsSet reports whether an option was given on the command line, by its
+// long or short name.
+func (self *CommandFlags) IsSet(long string) (set bool) {
+  self.Visit(func(option *flag.Flag) {
+    if option.Name == long || self.long_names[option.Name] == long {
+      set = true
+    }
+  })
+  return
+}
+
+func (self *CommandFlags) addHelp(short string, long string, argument string, usage string) {
+  if len(short) > 0 {
+    self.long_names[short] = long
+  }
+  names := "    "
+  if len(short) > 0 {
+    names = "-" + short + ", "
+  }
+  names += "--" + long
+  if len(argument) > 0 {
+    names += " <" + argument + ">"
+  }
+  self.help = append(self.help, fmt.Sprintf("  %-28s %s", names, usage))
+}
+
+func (self *CommandFlags) String(value *string, short string, long string, default_value string, argument string, usage string) {
+  self.StringVar(value, long, default_value, usage)
+  if len(short) > 0 {
+    self.StringVar(value, short, default_value, usage)
+  }
+  self.addHelp(short, long, argument, usage)
+}
+
+func (self *CommandFlags) Uint(value *uint, short string, long string, default_value uint, argument string, usage string) {
+  self.UintVar(value, long, default_value, usage)
+  if len(short) > 0 {
+    self.UintVar(value, short, default_value, usage)
+  }
+  self.addHelp(short, long, argument, usage)
+}
+
+func (self *CommandFlags) Float(value *float64, short string, long string, default_value float64, argument string, usage string) {
+  self.Float64Var(value, long, default_value, usage)
+  if len(short) > 0 {
+    self.Float64Var(value, short, default_value, usage)
+  }
+  self.addHelp(short, long, argument, usage)
+}
+
+// Length defines an option of a length in mm, the argument may be given in
+// mm, cm or in, e.g. 2mm or 0.5in.
+func (self *CommandFlags) Length(value *float64, short string, long string, default_value float64, usage string) {
+  *value = default_value
+  self.Var((*length_value)(value), long, usage)
+  if len(short) > 0 {
+    self.Var((*length_value)(value), short, usage)
+  }
+  self.addHelp(short, long, "length", usage)
+}
+
+// Alias accepts an option under a former long name, hidden from the help.
+func (self *CommandFlags) Alias(alias string, long string) {
+  option := self.Lookup(long)
+  self.Var(option.Value, alias, option.Usage)
+  self.long_names[alias] = long
+}
+
+var length_units = map[string]float64{"mm": 1, "cm": 10, "in": render.MM_PER_INCH}
+
+// ParseLength returns a length in mm, numbers without unit are mm.
+func ParseLength(text string) (float64, error) {
+  number, factor := strings.TrimSpace(text), 1.0
+  for unit, unit_factor := range length_units {
+    if strings.HasSuffix(number, unit) {
+      number, factor = strings.TrimSpace(strings.TrimSuffix(number, unit)), unit_factor
+      break
+    }
+  }
+  length, err := strconv.ParseFloat(number, 64)
+  if err != nil || length < 0 {
+    return 0, fmt.Errorf("invalid length %s, expected e.g. 2mm, 1.5cm or 0.5in", text)
+  }
+  return length * factor, nil
+}
+
+type length_value float64
+
+func (self *length_value) Set(text string) error {
+  length, err := ParseLength(text)
+  if err != nil {
+    return err
+  }
+  *self = length_value(length)
+  return nil
+}
+
+func (self *length_value) String() string {
+  return strconv.FormatFloat(float64(*self), 'f', -1, 64) + "mm"
+}
+
+func (self *CommandFlags) Bool(value *bool, short string, long string, usage string) {
+  self.BoolVar(value, long, false, usage)
+  if len(short) > 0 {
+    self.BoolVar(value, short, false, usage)
+  }
+  self.addHelp(short, long, "", usage)
+}
+
+// Usage prints the help text of a command.
+func (self *Command) Usage(flags *CommandFlags) {
+  fmt.Fprintf(os.Stderr, "Usage: %s %s [options] %s\n\n%s\n\nOptions:\n", PROGRAM_NAME, self.name, self.arguments, self.summary)
+  fmt.Fprintln(os.Stderr, strings.Join(flags.help, "\n"))
+  fmt.Fprintln(os.Stderr, "  -h, --help                   Print usage information")
+}
+
+func Usage() {
+  CopyrightMessage()
+  fmt.Fprintf(os.Stderr, "Usage: %s <command> [options]\n\nCommands:\n", PROGRAM_NAME)
+  for _, command := range commands {
+    fmt.Fprintf(os.Stderr, "  %-10s %s\n", command.name, command.summary)
+  }
+  fmt.Fprintf(os.Stderr, `
+  %s help <command> prints the options of a command, %s --version the version.
+  The printer may be given as device path, serial:<serial> or model:<model>.
+
+Exit codes:
+  %d  success
+  %d  failure, e.g. unreadable input file
+  %d  invalid command line
+  %d  printer not found or not responding
+  %d  media error (no media, wrong media, cutter jam)
+  %d  cover open
+  %d  other printer error (batteries, adapter, overheating)
+`, PROGRAM_NAME, PROGRAM_NAME, EXIT_OK, EXIT_FAILURE, EXIT_USAGE, EXIT_PRINTER_UNAVAILABLE, EXIT_MEDIA_ERROR, EXIT_COVER_OPEN, EXIT_PRINTER_ERROR)
+}
+
+func FindCommand(name string) (*Command, bool) {
+  for index := range commands {
+    if commands[index].name == name {
+      return &commands[index], true
+    }
+  }
+  return nil, false
+}
+
+// RunCommand parses the command line and runs the selected command, the
+// result is the exit code of the program.
+func RunCommand(arguments []string) int {
+  if len(arguments) == 0 {
+    Usage()
+    return EXIT_USAGE
+  }
+
+  switch arguments[0] {
+  case "-V", "-version", "--version":
+    fmt.Printf("%s %s\n", PROGRAM_NAME, PROGRAM_VERSION)
+    return EXIT_OK
+  case "-h", "-help", "--help":
+    Usage()
+    return EXIT_OK
+  case "help":
+    if len(arguments) > 1 {
+      if command, ok := FindCommand(arguments[1]); ok {
+        var settings Settings
+        flags := NewCommandFlags(command.name)
+        command.flags(flags, &settings)
+        command.Usage(flags)
+        return EXIT_OK
+      }
+    }
+    Usage()
+    return EXIT_OK
+  }
+
+  command, ok := FindCommand(arguments[0])
+  if !ok {
+    fmt.Fprintf(os.Stderr, "%s: unknown command %s, see %s --help\n", PROGRAM_NAME, arguments[0], PROGRAM_NAME)
+    return EXIT_USAGE
+  }
+
+  var settings Settings
+  flags := NewCommandFlags(command.name)
+  command.flags(flags, &settings)
+
+  command_arguments, err := flags.ParseArguments(arguments[1:])
+  if err == flag.ErrHelp {
+    command.Usage(flags)
+    return EXIT_OK
+  }
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "%s %s: %s\n\n", PROGRAM_NAME, command.name, err)
+    command.Usage(flags)
+    return EXIT_USAGE
+  }
+
+  if settings.output_format != "" && settings.output_format != "text" && settings.output_format != "json" {
+    return UsageError(command, "unknown output format %s", settings.output_format)
+  }
+
+  logger, err := plabel.NewLogger(os.Stderr, settings.log_format, plabel.VerboseLevel(settings.verbose))
+  if err != nil {
+    return UsageError(command, "unknown log format %s", settings.log_format)
+  }
+  settings.logger = logger
+
+  if flags.Lookup("profile") != nil {
+    if err := settings.ApplyConfig(flags); err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR", err)
+      return EXIT_FAILURE
+    }
+  }
+
+  return command.run(&settings, command_arguments)
+}
+
+// UsageError reports an invalid command line.
+func UsageError(command *Command, format string, arguments ...any) int {
+  fmt.Fprintf(os.Stderr, "%s %s: %s, see %s help %s\n", PROGRAM_NAME, command.name, fmt.Sprintf(format, arguments...), PROGRAM_NAME, command.name)
+  return EXIT_USAGE
+}
+
+// ExitCode maps the printer errors to the exit code of their error class.
+func ExitCode(error_code uint16) int {
+  switch {
+  case error_code == 0:
+    return EXIT_OK
+  case error_code & plabel.ERROR_COVER_OPEN > 0:
+    return EXIT_COVER_OPEN
+  case error_code & ERROR_CLASS_MEDIA > 0:
+    return EXIT_MEDIA_ERROR
+  }
+  return EXIT_PRINTER_ERROR
+}
@@ -0,0 +1,59 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "slices"
+  "testing"
+  "github.com/spag/plabel"
+)
+
+func TestParseArguments(t *testing.T) {
+  for _, test := range []struct {
+    arguments []string
+    expected []string
+    copies uint
+    mirror bool
+  }{
+    {[]string{"a.png"}, []string{"a.png"}, 1, false},
+    {[]string{"-c", "2", "a.png", "b.png"}, []string{"a.png", "b.png"}, 2, false},
+    {[]string{"a.png", "--mirror", "b.png", "-c", "3"}, []string{"a.png", "b.png"}, 3, true},
+    {[]string{"--", "-c", "2"}, []string{"-c", "2"}, 1, false},
+    {[]string{"a.png", "--", "--mirror", "b.png"}, []string{"a.png", "--mirror", "b.png"}, 1, false},
+    {[]string{"-m", "--", "-"}, []string{"-"}, 1, true},
+    {[]string{"--mirror", "--"}, nil, 1, true},
+  } {
+    var settings Settings
+    flags := NewCommandFlags("print")
+    printFlags(flags, &settings)
+    arguments, err := flags.ParseArguments(test.arguments)
+    if err != nil {
+      t.Errorf("%v: %s", test.arguments, err)
+      continue
+    }
+    if !slices.Equal(arguments, test.expected) || settings.copies != test.copies || settings.mirror != test.mirror {
+      t.Errorf("%v: arguments %q, copies %d, mirror %t", test.arguments, arguments, settings.copies, settings.mirror)
+    }
+  }
+}
+
+func TestExitCode(t *testing.T) {
+  for _, test := range []struct {
+    error_code uint16
+    exit_code int
+  }{
+    {0, EXIT_OK},
+    {plabel.ERROR_NO_MEDIA, EXIT_MEDIA_ERROR},
+    {plabel.ERROR_CUTTER_JAM, EXIT_MEDIA_ERROR},
+    {plabel.ERROR_WRONG_MEDIA, EXIT_MEDIA_ERROR},
+    {plabel.ERROR_COVER_OPEN | plabel.ERROR_NO_MEDIA, EXIT_COVER_OPEN},
+    {plabel.ERROR_OVERHEATING, EXIT_PRINTER_ERROR},
+  } {
+    if exit_code := ExitCode(test.error_code); exit_code != test.exit_code {
+      t.Errorf("error %04x: exit code %d, expected %d", test.error_code, exit_code, test.exit_code)
+    }
+  }
+}
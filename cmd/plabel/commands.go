@@ -0,0 +1,894 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "bytes"
+  "context"
+  "encoding/binary"
+  "encoding/json"
+  "fmt"
+  "image"
+  "image/png"
+  "io"
+  "math"
+  "os"
+  "os/signal"
+  "path/filepath"
+  "strings"
+  "syscall"
+  "time"
+  "github.com/spag/plabel"
+  "github.com/spag/plabel/render"
+  "github.com/spag/plabel/transport"
+)
+
+const (
+  DEFAULT_PRINTER = "/dev/usb/lp0"
+  DEFAULT_MODEL = "PT-P700"
+  DEFAULT_MEDIA_WIDTH = 12 //mm
+)
+
+var commands []Command
+
+func init() {
+  commands = []Command{
+    {"print", "[file ...]", "Print image or label files (png, jpeg, gif, svg, pdf, zpl, - for stdin) as a chain, or a text or barcode label", printFlags, RunPrint},
+    {"info", "", "Show printer and media information", infoFlags, RunInfo},
+    {"status", "", "Show the printer status, the exit code reflects printer errors", statusFlags, RunStatus},
+    {"list", "", "List attached printers", listFlags, RunList},
+    {"preview", "[file]", "Render a label as printed into a PNG file or the terminal without printer", previewFlags, RunPreview},
+    {"template", "list | print <template> [[object=]data ...]", "List configured P-touch templates, or fill and print a stored template", templateFlags, RunTemplate},
+    {"dump", "<file>", "Decode a recorded session or raw command stream (- for stdin) into readable commands", dumpFlags, RunDump},
+    {"replay", "<session>", "Send the commands of a recorded session to a printer or the emulator", replayFlags, RunReplay},
+    {"serve", "", "Run the print server (REST API, IPP, raw socket and spool directory)", serveFlags, RunServe},
+  }
+}
+
+func printerFlags(flags *CommandFlags, settings *Settings, verbose uint) {
+  flags.String(&settings.printer_device, "p", "printer", DEFAULT_PRINTER, "device", "Printer device or configured printer name (default " + DEFAULT_PRINTER + ")")
+  configFlags(flags, settings)
+  flags.Uint(&settings.verbose, "v", "verbose", verbose, "0-4", fmt.Sprintf("Verbosity level (default %d)", verbose))
+  flags.String(&settings.log_format, "", "log-format", plabel.LOG_FORMAT_TEXT, "format", "Log records to stderr as text or json (default text)")
+  flags.Bool(&settings.simulate, "s", "simulate", "Just simulate, do not print")
+  flags.String(&settings.record_file, "", "record", "", "file", "Record the commands and status frames of the session to a file for replay")
+}
+
+func configFlags(flags *CommandFlags, settings *Settings) {
+  flags.String(&settings.profile, "P", "profile", "", "name", "Apply a profile of the configuration file")
+  flags.String(&settings.config_file, "", "config", "", "file", "Configuration file (default " + SYSTEM_CONFIG_FILE + " and\n" +
+    "                               ~/.config/plabel/" + CONFIG_FILE_NAME + ")")
+}
+
+func imageOptionFlags(flags *CommandFlags, settings *Settings) {
+  flags.Uint(&settings.black_threshold, "t", "threshold", plabel.DEFAULT_THRESHOLD, "0-255", fmt.Sprintf("Threshold at which a pixel is determined black (default %d)", plabel.DEFAULT_THRESHOLD))
+  flags.Bool(&settings.dither, "", "dither", "Dither gray areas instead of applying the threshold")
+  flags.String(&settings.font, "", "font", render.FONT_DEFAULT, "font", "Font of text labels: " + strings.Join(render.Fonts(), ", ") + " (default " + render.FONT_DEFAULT + ")")
+}
+
+func printOptionFlags(flags *CommandFlags, settings *Settings) {
+  imageOptionFlags(flags, settings)
+  flags.Bool(&settings.batch_mode, "b", "batch-mode", "Chain printing without feeding and end cut")
+  flags.Bool(&settings.mirror, "m", "mirror", "Mirror output")
+  flags.Bool(&settings.no_front_cut, "n", "no-cut", "No front cut")
+  flags.Length(&settings.feed_margin, "", "margin", -1, "Feed margin before and after the label, at least the minimum of\n" +
+    "                               the printer (default printer default)")
+  flags.Alias("feed-margin", "margin")
+}
+
+func ledgerFlags(flags *CommandFlags, settings *Settings) {
+  flags.String(&settings.ledger_file, "", "ledger", DefaultLedgerFile(), "file", "Tape ledger file (default ~/.local/state/plabel/" + LEDGER_FILE_NAME + ",\n" +
+    "                               empty disables tape tracking)")
+  flags.Float(&settings.tape_length, "", "tape-length", DEFAULT_TAPE_LENGTH, "m", fmt.Sprintf("Cassette length to warn before the tape runs out (default %d, 0 disables)", DEFAULT_TAPE_LENGTH))
+}
+
+func formatFlags(flags *CommandFlags, settings *Settings) {
+  flags.String(&settings.output_format, "", "format", "text", "text|json", "Output format (default text)")
+}
+
+func labelFlags(flags *CommandFlags, settings *Settings) {
+  flags.String(&settings.image_file, "f", "file", "", "file", "Image or label file (png, jpeg, gif, svg, pdf, zpl), - reads stdin")
+  flags.Uint(&settings.page, "", "page", 1, "n", "Page of PDF files (default 1)")
+  flags.String(&settings.text, "", "text", "", "text", "Print a text label")
+  flags.String(&settings.barcode, "", "barcode", "", "data", "Print a Code 128 barcode label")
+  flags.String(&settings.serial, "", "serial", "", "spec", "Number the copies, replacing " + SERIAL_PLACEHOLDER + " in text and barcode,\n" +
+    "                               spec is start[:step[:format]], e.g. 1:1:ASSET-%05d")
+}
+
+func escpFlags(flags *CommandFlags, settings *Settings) {
+  flags.Bool(&settings.escp, "", "escp", "Print --text or --barcode with the fonts and barcodes of the printer (ESC/P mode)")
+  flags.Uint(&settings.escp_size, "", "size", plabel.ESCP_DEFAULT_SIZE, "dots", fmt.Sprintf("Character size of ESC/P text (default %d)", plabel.ESCP_DEFAULT_SIZE))
+  flags.Bool(&settings.escp_bold, "", "bold", "Bold ESC/P text")
+  flags.Bool(&settings.escp_italic, "", "italic", "Italic ESC/P text")
+  flags.String(&settings.escp_align, "", "escp-align", "left", "align", "Alignment of ESC/P text lines: left, center, right (default left)")
+}
+
+func printFlags(flags *CommandFlags, settings *Settings) {
+  printerFlags(flags, settings, plabel.VERBOSE_INFO)
+  labelFlags(flags, settings)
+  escpFlags(flags, settings)
+  flags.Uint(&settings.copies, "c", "copies", 1, "n", "Number of copies of every label (default 1)")
+  flags.Bool(&settings.half_cut, "", "half-cut", "Half cut between chained labels")
+  flags.Length(&settings.label_length, "", "length", 0, "Length of every label including the feed margins, padded or\n" +
+    "                               clipped (default length of the label)")
+  flags.String(&settings.label_align, "", "align", "left", "align", "Alignment of labels within --length: left, center, right\n" +
+    "                               (default left)")
+  printOptionFlags(flags, settings)
+  ledgerFlags(flags, settings)
+}
+
+func templateFlags(flags *CommandFlags, settings *Settings) {
+  printerFlags(flags, settings, plabel.VERBOSE_INFO)
+  flags.Uint(&settings.copies, "c", "copies", 1, "n", "Number of copies (default 1)")
+  formatFlags(flags, settings)
+}
+
+func infoFlags(flags *CommandFlags, settings *Settings) {
+  printerFlags(flags, settings, plabel.VERBOSE_WARN)
+  formatFlags(flags, settings)
+  ledgerFlags(flags, settings)
+}
+
+func statusFlags(flags *CommandFlags, settings *Settings) {
+  printerFlags(flags, settings, plabel.VERBOSE_WARN)
+  formatFlags(flags, settings)
+}
+
+func listFlags(flags *CommandFlags, settings *Settings) {
+  formatFlags(flags, settings)
+}
+
+func previewFlags(flags *CommandFlags, settings *Settings) {
+  labelFlags(flags, settings)
+  escpFlags(flags, settings)
+  flags.String(&settings.output_file, "o", "output", "", "file", "PNG file to write the preview to, without it is shown in the terminal")
+  flags.String(&settings.model, "", "model", DEFAULT_MODEL, "model", "Printer model (default " + DEFAULT_MODEL + ")")
+  flags.Uint(&settings.media_width, "", "tape", DEFAULT_MEDIA_WIDTH, "mm", fmt.Sprintf("Tape width (default %d)", DEFAULT_MEDIA_WIDTH))
+  flags.String(&settings.terminal, "", "terminal", TERMINAL_AUTO, "mode", "Terminal graphics: auto, blocks, sixel, kitty (default auto)")
+  flags.String(&settings.tape_color, "", "tape-color", "white", "color", "Tape color shown in the terminal (default white):\n" +
+    "                               " + TapeColorNames())
+  flags.String(&settings.ink_color, "", "ink-color", "black", "color", "Ink color shown in the terminal (default black)")
+  imageOptionFlags(flags, settings)
+  configFlags(flags, settings)
+}
+
+func dumpFlags(flags *CommandFlags, settings *Settings) {
+}
+
+func replayFlags(flags *CommandFlags, settings *Settings) {
+  printerFlags(flags, settings, plabel.VERBOSE_WARN)
+  flags.Bool(&settings.emulate, "e", "emulate", "Send the session to the printer emulator instead of a printer")
+  flags.String(&settings.model, "", "model", DEFAULT_MODEL, "model", "Emulated printer model (default " + DEFAULT_MODEL + ")")
+  flags.Uint(&settings.media_width, "", "tape", DEFAULT_MEDIA_WIDTH, "mm", fmt.Sprintf("Emulated tape width (default %d)", DEFAULT_MEDIA_WIDTH))
+  flags.String(&settings.output_file, "o", "output", "", "file", "PNG file for the emulated labels, numbered if there are several")
+}
+
+func serveFlags(flags *CommandFlags, settings *Settings) {
+  printerFlags(flags, settings, plabel.VERBOSE_INFO)
+  printOptionFlags(flags, settings)
+  flags.String(&settings.pid_file, "", "pid-file", "", "file", "Save Process-ID to file")
+  flags.String(&settings.listen_address, "", "listen", DEFAULT_LISTEN_ADDRESS, "address",
+    "Listen address of the REST API and IPP service (ipp://<host>/ipp/print),\n" +
+    "                               default " + DEFAULT_LISTEN_ADDRESS + ", empty disables HTTP")
+  flags.String(&settings.raw_address, "", "raw-listen", "", "address", "Listen address of the raw socket for ZPL and image data, e.g. :9100")
+  flags.String(&settings.spool_directory, "", "spool-dir", "", "dir", "Print files dropped into <dir>/incoming (e.g. /var/spool/plabel)")
+  flags.Uint(&settings.poll_interval, "", "poll-interval", 30, "s", "Printer status poll interval (default 30, 0 disables)")
+  ledgerFlags(flags, settings)
+}
+
+// OpenPrinter opens the selected printer and requests its status. The
+// printer is nil if it cannot be opened, responding is false if it did
+// not send a status.
+func OpenPrinter(settings *Settings) (printer *plabel.Plabel, responding bool) {
+  device_path, err := transport.ResolvePrinterDevice(settings.printer_device)
+  if err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR selecting printer: ", err)
+    return nil, false
+  }
+  settings.printer_device = device_path
+
+  printer = newPrinter(settings)
+  if printer == nil {
+    return nil, false
+  }
+
+  if !printer.Open(settings.printer_device) {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR opening printer: ", settings.printer_device)
+    if !settings.simulate {
+      printer.Close()
+      return nil, false
+    }
+  }
+
+  return printer, startPrinter(printer)
+}
+
+// OpenEmulator opens the emulator of the selected model and tape like a
+// printer. The printer is nil if model or tape are not supported.
+func OpenEmulator(settings *Settings) (*plabel.Plabel, *transport.Emulator) {
+  model_information, ok := plabel.GetModelInformationByName(settings.model)
+  if !ok {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR unknown printer model", settings.model)
+    return nil, nil
+  }
+  if plabel.MediaWidthToMaxPixel(byte(settings.media_width), model_information.Resolution) == 0 || settings.media_width > uint(model_information.MaxTapeWidth) {
+    fmt.Fprintf(os.Stderr, "%s ERROR tape width %d mm not supported by %s\n", PROGRAM_NAME, settings.media_width, model_information.ModelName)
+    return nil, nil
+  }
+
+  printer := newPrinter(settings)
+  if printer == nil {
+    return nil, nil
+  }
+  emulator := transport.NewEmulator(model_information, byte(settings.media_width))
+  printer.Attach(emulator)
+  startPrinter(printer)
+  return printer, emulator
+}
+
+// newPrinter creates the printer with the logger and recording given by the
+// settings.
+func newPrinter(settings *Settings) *plabel.Plabel {
+  printer := plabel.New()
+  printer.Simulate = settings.simulate
+  if settings.logger != nil {
+    printer.Logger = settings.logger
+  }
+
+  if len(settings.record_file) > 0 {
+    fd, err := os.Create(settings.record_file)
+    if err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR recording session: ", err)
+      return nil
+    }
+    printer.Record(transport.NewRecorder(fd))
+  }
+  return printer
+}
+
+// startPrinter starts processing the status and initializes the printer,
+// it returns false if the printer did not send a status.
+func startPrinter(printer *plabel.Plabel) bool {
+  go printer.ProcessStatus()
+
+  printer.WaitForPrinterStatus(1000)
+
+  printer.Invalidate()
+  printer.Initialize()
+  printer.RequestStatus()
+
+  return printer.WaitForPrinterStatus(1000)
+}
+
+// CheckPrinter compares the printer model and installed tape with those
+// expected by the configuration.
+func CheckPrinter(settings *Settings, printer *plabel.Plabel) int {
+  if settings.simulate {
+    return EXIT_OK
+  }
+
+  model_name := printer.Model().ModelName
+  if len(settings.model) > 0 && !strings.EqualFold(model_name, settings.model) {
+    fmt.Fprintf(os.Stderr, "%s ERROR printer %s is a %s, expected %s\n", PROGRAM_NAME, settings.printer_device, model_name, settings.model)
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+
+  media_width := printer.Status().MediaWidth
+  if settings.media_width > 0 && uint(media_width) != settings.media_width {
+    fmt.Fprintf(os.Stderr, "%s ERROR wrong tape: %d mm installed, %d mm expected\n", PROGRAM_NAME, media_width, settings.media_width)
+    return EXIT_MEDIA_ERROR
+  }
+
+  return EXIT_OK
+}
+
+// OpenLedger opens the tape ledger of the printer, the ledger is nil if tape
+// tracking is disabled or the ledger cannot be read.
+func OpenLedger(settings *Settings, printer *plabel.Plabel) *TapeLedger {
+  if len(settings.ledger_file) == 0 {
+    return nil
+  }
+
+  ledger, err := OpenTapeLedger(settings.ledger_file, LedgerSerial(settings.printer_device), settings.tape_length, settings.logger)
+  if err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR ", err)
+    return nil
+  }
+  status := printer.Status()
+  if err := ledger.Observe(&status); err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR ", err)
+  }
+  return ledger
+}
+
+func checkNoArguments(command *Command, arguments []string) int {
+  if len(arguments) > 0 {
+    return UsageError(command, "unexpected argument %s", arguments[0])
+  }
+  return EXIT_OK
+}
+
+func RunPrint(settings *Settings, arguments []string) int {
+  command, _ := FindCommand("print")
+  if exit_code := checkLabelArguments(command, settings, arguments, math.MaxInt); exit_code != EXIT_OK {
+    return exit_code
+  }
+  if settings.black_threshold > 255 {
+    return UsageError(command, "threshold must be 0-255")
+  }
+  if settings.copies == 0 {
+    return UsageError(command, "copies must be at least 1")
+  }
+  if _, err := plabel.ParseAlign(settings.label_align); err != nil {
+    return UsageError(command, "%s", err)
+  }
+  if settings.label_length > 0 && settings.escp {
+    return UsageError(command, "--length does not apply to ESC/P labels, the printer lays them out")
+  }
+
+  printer, responding := OpenPrinter(settings)
+  if printer == nil {
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+  defer printer.Close()
+
+  if !responding && !settings.simulate {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR printer not responding")
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+  if status := printer.Status(); status.ErrorCode > 0 {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR printer error: ", status.ErrorDescription())
+    return ExitCode(status.ErrorCode)
+  }
+  if exit_code := CheckPrinter(settings, printer); exit_code != EXIT_OK {
+    return exit_code
+  }
+  if err := printer.CheckPrintOptions(settings.PrintOptions(printer)); err != nil {
+    return UsageError(command, "%s", err)
+  }
+
+  if settings.escp {
+    //the printer lays out ESC/P labels, their length is unknown to the ledger
+    if err := PrintEscp(printer, settings); err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR printing: ", err)
+      if printer.Status().ErrorCode > 0 {
+        return ExitCode(printer.Status().ErrorCode)
+      }
+      return EXIT_PRINTER_UNAVAILABLE
+    }
+    return EXIT_OK
+  }
+
+  ledger := OpenLedger(settings, printer)
+
+  labels, err := LoadLabels(settings, printer)
+  if err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR", err)
+    return EXIT_FAILURE
+  }
+
+  for index, label := range labels {
+    raster, err := printer.RasterLabel(label.img, label.format, settings.PrintOptions(printer))
+    if err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR", err)
+      return EXIT_FAILURE
+    }
+
+    for copy := 0; copy < label.copies; copy++ {
+      options := settings.PrintOptions(printer)
+      if index < len(labels) - 1 || copy < label.copies - 1 {
+        //chain the labels, the last one is fed and cut as configured
+        options.Chain = true
+      }
+
+      if err := printer.PrintRaster(context.Background(), raster, options); err != nil {
+        fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR printing: ", err)
+        if printer.Status().ErrorCode > 0 {
+          return ExitCode(printer.Status().ErrorCode)
+        }
+        return EXIT_PRINTER_UNAVAILABLE
+      }
+
+      resolution := printer.Model().Resolution
+      status := printer.Status()
+      if err := ledger.Record(&status, len(raster), printer.FeedMargin(options), resolution, !options.Chain); err != nil {
+        fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR ", err)
+      }
+    }
+  }
+  return EXIT_OK
+}
+
+func RunInfo(settings *Settings, arguments []string) int {
+  command, _ := FindCommand("info")
+  if exit_code := checkNoArguments(command, arguments); exit_code != EXIT_OK {
+    return exit_code
+  }
+
+  printer, responding := OpenPrinter(settings)
+  if printer == nil {
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+  defer printer.Close()
+
+  if !responding && !settings.simulate {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR printer not responding")
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+
+  ledger := OpenLedger(settings, printer)
+
+  if settings.output_format == "json" {
+    if err := ShowInfoJson(os.Stdout, printer); err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR", err)
+      return EXIT_FAILURE
+    }
+  } else {
+    ShowInfo(os.Stdout, printer)
+    status := printer.Status()
+    ShowTapeUsage(ledger, &status)
+  }
+  return EXIT_OK
+}
+
+func RunStatus(settings *Settings, arguments []string) int {
+  command, _ := FindCommand("status")
+  if exit_code := checkNoArguments(command, arguments); exit_code != EXIT_OK {
+    return exit_code
+  }
+
+  printer, responding := OpenPrinter(settings)
+  if printer == nil {
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+  defer printer.Close()
+
+  if !responding && !settings.simulate {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR printer not responding")
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+
+  status := printer.Status()
+  if settings.output_format == "json" {
+    if err := ShowInfoJson(os.Stdout, printer); err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR", err)
+      return EXIT_FAILURE
+    }
+  } else {
+    errors := "none"
+    if status.ErrorCode > 0 {
+      errors = status.ErrorDescription()
+    }
+    fmt.Printf("Printer.......: %s (%s)\n", printer.Model().ModelName, settings.printer_device)
+    fmt.Printf("Status........: %s, phase: %s\n", status.StatusDescription(), status.PhaseTypeDescription())
+    fmt.Printf("Error.........: (%04X) %s\n", status.ErrorCode, errors)
+    fmt.Printf("Media.........: %d mm %s, %s, text: %s\n", status.MediaWidth, status.MediaTypeDescription(), status.TapeColorDescription(), status.TextColorDescription())
+  }
+  return ExitCode(status.ErrorCode)
+}
+
+type ListEntry struct {
+  Device string `json:"device"`
+  VendorID uint16 `json:"vendor_id"`
+  ProductID uint16 `json:"product_id"`
+  Serial string `json:"serial"`
+  Model string `json:"model"`
+}
+
+func RunList(settings *Settings, arguments []string) int {
+  command, _ := FindCommand("list")
+  if exit_code := checkNoArguments(command, arguments); exit_code != EXIT_OK {
+    return exit_code
+  }
+
+  if settings.output_format != "json" {
+    ListPrinters()
+    return EXIT_OK
+  }
+
+  entries := []ListEntry{}
+  for _, device := range transport.FindPrinters(transport.SYSFS_ROOT, transport.DEVICE_ROOT) {
+    device.QueryStatus(transport.DISCOVERY_TIMEOUT)
+    entries = append(entries, ListEntry{device.DevicePath, device.VendorID, device.ProductID, device.Serial, device.ModelName()})
+  }
+
+  data, err := json.MarshalIndent(entries, "", "  ")
+  if err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR encoding printer list: ", err)
+    return EXIT_FAILURE
+  }
+  fmt.Println(string(data))
+  return EXIT_OK
+}
+
+func RunPreview(settings *Settings, arguments []string) int {
+  command, _ := FindCommand("preview")
+  if exit_code := checkLabelArguments(command, settings, arguments, 1); exit_code != EXIT_OK {
+    return exit_code
+  }
+  if settings.black_threshold > 255 {
+    return UsageError(command, "threshold must be 0-255")
+  }
+  terminal_mode, err := TerminalMode(settings.terminal)
+  if err != nil {
+    return UsageError(command, "%s", err)
+  }
+  colors, err := settings.TerminalColors()
+  if err != nil {
+    return UsageError(command, "%s", err)
+  }
+
+  model_information, ok := plabel.GetModelInformationByName(settings.model)
+  if !ok {
+    return UsageError(command, "unknown printer model %s", settings.model)
+  }
+  printer := plabel.New()
+  printer.SetModel(model_information, byte(settings.media_width))
+  if printer.PrintingWidth() == 0 || settings.media_width > uint(model_information.MaxTapeWidth) {
+    return UsageError(command, "tape width %d mm not supported by %s", settings.media_width, model_information.ModelName)
+  }
+
+  var img image.Image
+  if settings.escp {
+    escp_img, err := PreviewEscp(printer, settings)
+    if err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR", err)
+      return EXIT_FAILURE
+    }
+    img = escp_img
+  } else {
+    labels, err := LoadLabels(settings, printer)
+    if err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR", err)
+      return EXIT_FAILURE
+    }
+    img = labels[0].img
+  }
+
+  if settings.dither {
+    img = render.Dither(img)
+  }
+  preview := plabel.PreviewImage(img, printer.PrintingWidth(), byte(settings.black_threshold))
+
+  if len(settings.output_file) == 0 {
+    resolution := printer.Model().Resolution
+    if err := WriteTerminalPreview(os.Stdout, TapeImage(preview, settings.media_width, resolution), terminal_mode, colors, resolution); err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR writing preview: ", err)
+      return EXIT_FAILURE
+    }
+    return EXIT_OK
+  }
+
+  fd, err := os.Create(settings.output_file)
+  if err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR creating preview: ", err)
+    return EXIT_FAILURE
+  }
+  defer fd.Close()
+
+  if err := png.Encode(fd, preview); err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR writing preview: ", err)
+    return EXIT_FAILURE
+  }
+  return EXIT_OK
+}
+
+func RunTemplate(settings *Settings, arguments []string) int {
+  command, _ := FindCommand("template")
+  if len(arguments) == 0 {
+    return UsageError(command, "no action given")
+  }
+
+  switch arguments[0] {
+  case "list":
+    if exit_code := checkNoArguments(command, arguments[1:]); exit_code != EXIT_OK {
+      return exit_code
+    }
+    ListTemplates(settings.templates, settings.output_format)
+    return EXIT_OK
+  case "print":
+    if len(arguments) < 2 {
+      return UsageError(command, "no template given")
+    }
+  default:
+    return UsageError(command, "unknown action %s", arguments[0])
+  }
+
+  key, template, err := FindTemplate(settings.templates, arguments[1])
+  if err != nil {
+    return UsageError(command, "%s", err)
+  }
+  objects, err := TemplateObjects(template, arguments[2:])
+  if err == nil {
+    err = plabel.CheckTemplate(key, objects, int(settings.copies))
+  }
+  if err != nil {
+    return UsageError(command, "%s", err)
+  }
+
+  printer, responding := OpenPrinter(settings)
+  if printer == nil {
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+  defer printer.Close()
+
+  if !responding && !settings.simulate {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR printer not responding")
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+  if status := printer.Status(); status.ErrorCode > 0 {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR printer error: ", status.ErrorDescription())
+    return ExitCode(status.ErrorCode)
+  }
+  if exit_code := CheckPrinter(settings, printer); exit_code != EXIT_OK {
+    return exit_code
+  }
+  if !printer.Model().SupportsTemplates && !settings.simulate {
+    fmt.Fprintf(os.Stderr, "%s ERROR %s does not support P-touch templates\n", PROGRAM_NAME, printer.Model().ModelName)
+    return EXIT_FAILURE
+  }
+
+  if err := PrintTemplate(printer, key, objects, int(settings.copies)); err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR printing: ", err)
+    if printer.Status().ErrorCode > 0 {
+      return ExitCode(printer.Status().ErrorCode)
+    }
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+  return EXIT_OK
+}
+
+func RunDump(settings *Settings, arguments []string) int {
+  command, _ := FindCommand("dump")
+  if len(arguments) == 0 {
+    return UsageError(command, "no file given")
+  }
+  if exit_code := checkNoArguments(command, arguments[1:]); exit_code != EXIT_OK {
+    return exit_code
+  }
+
+  var data []byte
+  var err error
+  if arguments[0] == STDIN_FILE_NAME {
+    data, err = io.ReadAll(os.Stdin)
+  } else {
+    data, err = os.ReadFile(arguments[0])
+  }
+  if err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR", err)
+    return EXIT_FAILURE
+  }
+
+  disassembler := plabel.NewDisassembler()
+  if !bytes.HasPrefix(data, []byte(transport.RECORD_MAGIC)) {
+    printInstructions("", disassembler.Decode(data))
+  } else {
+    entries, err := transport.ReadRecording(bytes.NewReader(data))
+    if err != nil {
+      //dump what was recorded before the session broke off
+      fmt.Fprintf(os.Stderr, "%s ERROR %s: %s\n", PROGRAM_NAME, arguments[0], err)
+    }
+    for _, entry := range entries {
+      elapsed := fmt.Sprintf("%+8.3fs  ", entry.Time.Sub(entries[0].Time).Seconds())
+      if entry.Kind == plabel.RECORD_COMMAND {
+        printInstructions(elapsed, disassembler.Decode(entry.Data))
+        continue
+      }
+
+      var status plabel.PrinterStatus
+      if binary.Read(bytes.NewReader(entry.Data), binary.LittleEndian, &status) != nil || !status.IsValid() {
+        fmt.Printf("%s%-8s  invalid status frame of %d bytes\n", elapsed, "<", len(entry.Data))
+        continue
+      }
+      fmt.Printf("%s%-8s  status=%s phase=%s", elapsed, "<", status.StatusDescription(), status.PhaseTypeDescription())
+      if status.ErrorCode > 0 {
+        fmt.Printf(" error=%s", strings.TrimSpace(status.ErrorDescription()))
+      }
+      fmt.Println()
+    }
+  }
+  printInstructions("", disassembler.Flush())
+
+  fmt.Printf("\n%d instructions, %d pages, %d raster lines, %d warnings\n", disassembler.Instructions, disassembler.Pages, disassembler.Lines, disassembler.Warnings)
+  return EXIT_OK
+}
+
+// printInstructions prints the instructions with their offset in the
+// stream, warnings are printed below the instruction.
+func printInstructions(prefix string, instructions []plabel.Instruction) {
+  for _, instruction := range instructions {
+    fmt.Printf("%s%08x  %s\n", prefix, instruction.Offset, instruction.String())
+    for _, warning := range instruction.Warnings {
+      fmt.Printf("%s%-8s  WARNING %s\n", prefix, "", warning)
+    }
+  }
+}
+
+func RunReplay(settings *Settings, arguments []string) int {
+  command, _ := FindCommand("replay")
+  if len(arguments) == 0 {
+    return UsageError(command, "no session file given")
+  }
+  if exit_code := checkNoArguments(command, arguments[1:]); exit_code != EXIT_OK {
+    return exit_code
+  }
+  if len(settings.output_file) > 0 && !settings.emulate {
+    return UsageError(command, "--output requires --emulate")
+  }
+
+  fd, err := os.Open(arguments[0])
+  if err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR", err)
+    return EXIT_FAILURE
+  }
+  entries, err := transport.ReadRecording(fd)
+  fd.Close()
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "%s ERROR %s: %s\n", PROGRAM_NAME, arguments[0], err)
+    return EXIT_FAILURE
+  }
+
+  var printer *plabel.Plabel
+  var emulator *transport.Emulator
+  if settings.emulate {
+    if printer, emulator = OpenEmulator(settings); printer == nil {
+      return EXIT_FAILURE
+    }
+  } else {
+    var responding bool
+    if printer, responding = OpenPrinter(settings); printer == nil {
+      return EXIT_PRINTER_UNAVAILABLE
+    }
+    if !responding && !settings.simulate {
+      printer.Close()
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR printer not responding")
+      return EXIT_PRINTER_UNAVAILABLE
+    }
+  }
+  defer printer.Close()
+
+  //the model and tape of the recording are not checked, replays reproduce
+  //what was sent whatever the configuration expects
+  if err := transport.Replay(printer, entries, 10000); err != nil {
+    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR replaying: ", err)
+    if printer.Status().ErrorCode > 0 {
+      return ExitCode(printer.Status().ErrorCode)
+    }
+    return EXIT_PRINTER_UNAVAILABLE
+  }
+
+  if emulator != nil && len(settings.output_file) > 0 {
+    if err := WriteLabelImages(settings.output_file, emulator.Labels()); err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR writing labels: ", err)
+      return EXIT_FAILURE
+    }
+  }
+  return EXIT_OK
+}
+
+// WriteLabelImages writes labels as PNG files, several labels are numbered
+// before the extension, e.g. label-2.png.
+func WriteLabelImages(file_name string, labels []*image.Gray) error {
+  if len(labels) == 0 {
+    return fmt.Errorf("no label printed")
+  }
+
+  extension := filepath.Ext(file_name)
+  for index, label := range labels {
+    name := file_name
+    if len(labels) > 1 {
+      name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(file_name, extension), index + 1, extension)
+    }
+    fd, err := os.Create(name)
+    if err != nil {
+      return err
+    }
+    err = png.Encode(fd, label)
+    if close_err := fd.Close(); err == nil {
+      err = close_err
+    }
+    if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func RunServe(settings *Settings, arguments []string) int {
+  command, _ := FindCommand("serve")
+  if exit_code := checkNoArguments(command, arguments); exit_code != EXIT_OK {
+    return exit_code
+  }
+  if len(settings.listen_address) == 0 && len(settings.raw_address) == 0 && len(settings.spool_directory) == 0 {
+    return UsageError(command, "neither listen address nor spool directory given")
+  }
+  if settings.black_threshold > 255 {
+    return UsageError(command, "threshold must be 0-255")
+  }
+
+  if len(settings.pid_file) > 0 {
+    if err := CreatePIDFile(settings.pid_file) ; err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR creating PID file: ", err)
+      return EXIT_FAILURE
+    } else {
+      settings.logger.Info("created PID file", "file", settings.pid_file)
+    }
+    defer os.Remove(settings.pid_file)
+  }
+
+  //the printer may be switched on later, the device is opened again when
+  //the status is polled or a job is printed
+  device_spec := settings.printer_device
+  printer, _ := OpenPrinter(settings)
+  if printer == nil {
+    if printer = newPrinter(settings); printer == nil {
+      return EXIT_FAILURE
+    }
+    settings.logger.Warn("printer not available, waiting for it", "printer", device_spec)
+    go printer.ProcessStatus()
+  }
+  defer printer.Close()
+
+  queue := NewJobQueue(printer, settings, OpenLedger(settings, printer))
+  queue.device_spec = device_spec
+  go queue.Run()
+
+  if settings.poll_interval > 0 {
+    go queue.PollStatus(time.Duration(settings.poll_interval) * time.Second)
+  }
+
+  if len(settings.spool_directory) > 0 {
+    spool, err := NewSpool(settings.spool_directory, queue)
+    if err != nil {
+      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR ", err)
+      return EXIT_FAILURE
+    }
+    go spool.Run()
+  }
+
+  //a failing server ends the daemon through the shutdown below
+  server_errors := make(chan error, 2)
+  if len(settings.listen_address) > 0 {
+    go func() {
+      server_errors <- NewServer(queue).ListenAndServe(settings.listen_address)
+    }()
+  }
+
+  if len(settings.raw_address) > 0 {
+    go func() {
+      server_errors <- NewRawServer(queue).ListenAndServe(settings.raw_address)
+    }()
+  }
+
+  signal_channel := make(chan os.Signal, 1)
+  signal.Notify(signal_channel, syscall.SIGINT)
+  signal.Notify(signal_channel, syscall.SIGTERM)
+  signal.Notify(signal_channel, syscall.SIGHUP)
+
+  exit_code := EXIT_OK
+  for running := true; running; {
+    select {
+    case signal_rec := <-signal_channel:
+      if signal_rec == syscall.SIGHUP {
+        settings.logger.Info("received hangup signal", "signal", signal_rec.String())
+        continue
+      }
+      settings.logger.Info("received signal", "signal", signal_rec.String())
+      running = false
+    case err := <-server_errors:
+      settings.logger.Error("server failed", "error", err)
+      exit_code = EXIT_FAILURE
+      running = false
+    }
+  }
+
+  if !queue.Shutdown(SHUTDOWN_TIMEOUT * time.Second) {
+    settings.logger.Warn("printing job not finished", "timeout", SHUTDOWN_TIMEOUT)
+  }
+
+  settings.logger.Info("ended")
+  return exit_code
+}
@@ -0,0 +1,410 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "github.com/spag/plabel/render"
+)
+
+const (
+  SYSTEM_CONFIG_FILE = "/etc/plabel.toml"
+  CONFIG_FILE_NAME = "config.toml"
+)
+
+// PrinterConfig is a named printer of the configuration file:
+//
+//   [printers.shelf]
+//   device = "serial:000F1Z401370"
+//   model = "PT-P700"
+type PrinterConfig struct {
+  Device string
+  Model string
+}
+
+// ProfileConfig is a named set of print options, options that are not
+// configured keep their defaults:
+//
+//   [profiles.shelf-24mm]
+//   printer = "shelf"
+//   tape = 24            # mm, expected media width
+//   threshold = 160
+//   mirror = false
+//   cut = true
+//   feed_margin = 2.0    # mm before and after the label
+//   dither = true
+//   font = "5x7-bold"
+type ProfileConfig struct {
+  Printer string
+  Tape *uint
+  Threshold *uint
+  Mirror *bool
+  Cut *bool
+  FeedMargin *float64
+  Dither *bool
+  Font string
+}
+
+// TemplateConfig describes a P-touch template stored in the printer, so
+// that it can be listed and printed by name:
+//
+//   [templates.asset]
+//   key = 3
+//   description = "Asset tag, 24 mm"
+//   objects = ["name", "serial"]   # objects filled in by position
+type TemplateConfig struct {
+  Key uint
+  Description string
+  Objects []string
+}
+
+// Config holds the printers and profiles of /etc/plabel.toml and the user
+// configuration, the user configuration takes precedence. The top level keys
+// printer and profile select the defaults.
+type Config struct {
+  Printer string
+  Profile string
+  Printers map[string]PrinterConfig
+  Profiles map[string]ProfileConfig
+  Templates map[string]TemplateConfig
+}
+
+// DefaultConfigFiles returns the configuration files in the order they are
+// read, $XDG_CONFIG_HOME/plabel/config.toml defaults to ~/.config.
+func DefaultConfigFiles() []string {
+  files := []string{SYSTEM_CONFIG_FILE}
+  if config_directory, err := os.UserConfigDir(); err == nil {
+    files = append(files, filepath.Join(config_directory, PROGRAM_NAME, CONFIG_FILE_NAME))
+  }
+  return files
+}
+
+// LoadConfig reads and merges the configuration files. Missing files are
+// skipped unless required.
+func LoadConfig(file_names []string, required bool) (*Config, error) {
+  merged := make(map[string]any)
+  for _, file_name := range file_names {
+    data, err := os.ReadFile(file_name)
+    if os.IsNotExist(err) && !required {
+      continue
+    }
+    if err != nil {
+      return nil, fmt.Errorf("reading configuration: %s", err)
+    }
+
+    values, err := ParseToml(string(data))
+    if err != nil {
+      return nil, fmt.Errorf("%s: %s", file_name, err)
+    }
+    mergeTables(merged, values)
+  }
+
+  config, err := decodeConfig(merged)
+  if err != nil {
+    return nil, fmt.Errorf("configuration: %s", err)
+  }
+  return config, nil
+}
+
+// mergeTables merges the source into the destination table, values of the
+// source replace those of the destination.
+func mergeTables(destination map[string]any, source map[string]any) {
+  for key, value := range source {
+    source_table, source_is_table := value.(map[string]any)
+    destination_table, destination_is_table := destination[key].(map[string]any)
+    if source_is_table && destination_is_table {
+      mergeTables(destination_table, source_table)
+    } else {
+      destination[key] = value
+    }
+  }
+}
+
+func decodeConfig(values map[string]any) (*Config, error) {
+  config := &Config{
+    Printers: make(map[string]PrinterConfig),
+    Profiles: make(map[string]ProfileConfig),
+    Templates: make(map[string]TemplateConfig),
+  }
+
+  table := config_table{values, "", nil}
+  config.Printer = table.string("printer")
+  config.Profile = table.string("profile")
+
+  for _, name := range table.tables("printers") {
+    printer := table.table("printers", name)
+    config.Printers[name] = PrinterConfig{
+      Device: printer.string("device"),
+      Model: printer.string("model"),
+    }
+    printer.unknownKeys("device", "model")
+    if len(printer.errors) > 0 {
+      table.errors = append(table.errors, printer.errors...)
+    }
+  }
+
+  for _, name := range table.tables("profiles") {
+    profile := table.table("profiles", name)
+    config.Profiles[name] = ProfileConfig{
+      Printer: profile.string("printer"),
+      Tape: profile.uint("tape"),
+      Threshold: profile.uint("threshold"),
+      Mirror: profile.bool("mirror"),
+      Cut: profile.bool("cut"),
+      FeedMargin: profile.float("feed_margin"),
+      Dither: profile.bool("dither"),
+      Font: profile.string("font"),
+    }
+    profile.unknownKeys("printer", "tape", "threshold", "mirror", "cut", "feed_margin", "dither", "font")
+    if len(profile.errors) > 0 {
+      table.errors = append(table.errors, profile.errors...)
+    }
+  }
+
+  for _, name := range table.tables("templates") {
+    template := table.table("templates", name)
+    entry := TemplateConfig{
+      Description: template.string("description"),
+      Objects: template.strings("objects"),
+    }
+    if _, ok := template.values["key"]; !ok {
+      template.errors = append(template.errors, fmt.Sprintf("%s is required", template.path("key")))
+    } else if key := template.uint("key"); key != nil {
+      entry.Key = *key
+    }
+    config.Templates[name] = entry
+    template.unknownKeys("key", "description", "objects")
+    if len(template.errors) > 0 {
+      table.errors = append(table.errors, template.errors...)
+    }
+  }
+
+  table.unknownKeys("printer", "profile", "printers", "profiles", "templates")
+  if len(table.errors) > 0 {
+    return nil, fmt.Errorf("%s", strings.Join(table.errors, ", "))
+  }
+  return config, nil
+}
+
+// config_table reads typed values from a parsed table and collects the
+// errors, so that all mistakes in the configuration are reported at once.
+type config_table struct {
+  values map[string]any
+  name string
+  errors []string
+}
+
+func (self *config_table) path(key string) string {
+  if len(self.name) == 0 {
+    return key
+  }
+  return self.name + "." + key
+}
+
+func (self *config_table) typeError(key string, kind string) {
+  self.errors = append(self.errors, fmt.Sprintf("%s must be %s", self.path(key), kind))
+}
+
+func (self *config_table) string(key string) string {
+  value, ok := self.values[key]
+  if !ok {
+    return ""
+  }
+  if text, ok := value.(string); ok {
+    return text
+  }
+  self.typeError(key, "a string")
+  return ""
+}
+
+func (self *config_table) bool(key string) *bool {
+  value, ok := self.values[key]
+  if !ok {
+    return nil
+  }
+  if flag, ok := value.(bool); ok {
+    return &flag
+  }
+  self.typeError(key, "true or false")
+  return nil
+}
+
+func (self *config_table) uint(key string) *uint {
+  value, ok := self.values[key]
+  if !ok {
+    return nil
+  }
+  if integer, ok := value.(int64); ok && integer >= 0 {
+    number := uint(integer)
+    return &number
+  }
+  self.typeError(key, "a positive integer")
+  return nil
+}
+
+func (self *config_table) float(key string) *float64 {
+  value, ok := self.values[key]
+  if !ok {
+    return nil
+  }
+  switch number := value.(type) {
+  case float64:
+    return &number
+  case int64:
+    float := float64(number)
+    return &float
+  }
+  self.typeError(key, "a number")
+  return nil
+}
+
+func (self *config_table) strings(key string) []string {
+  value, ok := self.values[key]
+  if !ok {
+    return nil
+  }
+  if array, ok := value.([]any); ok {
+    var result []string
+    for _, entry := range array {
+      if text, ok := entry.(string); ok {
+        result = append(result, text)
+      } else {
+        self.typeError(key, "an array of strings")
+        return nil
+      }
+    }
+    return result
+  }
+  self.typeError(key, "an array of strings")
+  return nil
+}
+
+// tables returns the sorted names of the sub-tables of a table.
+func (self *config_table) tables(key string) (names []string) {
+  value, ok := self.values[key]
+  if !ok {
+    return nil
+  }
+  table, ok := value.(map[string]any)
+  if !ok {
+    self.typeError(key, "a table")
+    return nil
+  }
+
+  for name, entry := range table {
+    if _, ok := entry.(map[string]any); !ok {
+      self.typeError(key + "." + name, "a table")
+      continue
+    }
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return
+}
+
+// table returns a nested sub-table by its keys, the sub-table must exist.
+func (self *config_table) table(keys ...string) config_table {
+  table := self.values
+  for _, key := range keys {
+    table = table[key].(map[string]any)
+  }
+  return config_table{table, self.path(strings.Join(keys, ".")), nil}
+}
+
+func (self *config_table) unknownKeys(known ...string) {
+  for key := range self.values {
+    found := false
+    for _, known_key := range known {
+      found = found || key == known_key
+    }
+    if !found {
+      self.errors = append(self.errors, fmt.Sprintf("unknown key %s", self.path(key)))
+    }
+  }
+  sort.Strings(self.errors)
+}
+
+// ApplyConfig sets the printer and print options of the selected profile and
+// printer. Options given on the command line take precedence. The printer
+// may be given by its configured name.
+func (self *Settings) ApplyConfig(flags *CommandFlags) error {
+  config_files := DefaultConfigFiles()
+  if len(self.config_file) > 0 {
+    config_files = []string{self.config_file}
+  }
+  config, err := LoadConfig(config_files, len(self.config_file) > 0)
+  if err != nil {
+    return err
+  }
+
+  self.templates = config.Templates
+
+  profile_name := self.profile
+  if len(profile_name) == 0 {
+    profile_name = config.Profile
+  }
+  var profile ProfileConfig
+  if len(profile_name) > 0 {
+    var ok bool
+    if profile, ok = config.Profiles[profile_name]; !ok {
+      return fmt.Errorf("unknown profile %s", profile_name)
+    }
+  }
+
+  printer_name := ""
+  if flags.IsSet("printer") {
+    if _, ok := config.Printers[self.printer_device]; ok {
+      printer_name = self.printer_device
+    }
+  } else if len(profile.Printer) > 0 {
+    printer_name = profile.Printer
+  } else {
+    printer_name = config.Printer
+  }
+  if len(printer_name) > 0 {
+    printer, ok := config.Printers[printer_name]
+    if !ok {
+      return fmt.Errorf("unknown printer %s", printer_name)
+    }
+    if len(printer.Device) > 0 {
+      self.printer_device = printer.Device
+    }
+    if len(printer.Model) > 0 && !flags.IsSet("model") {
+      self.model = printer.Model
+    }
+  }
+
+  if profile.Tape != nil && !flags.IsSet("tape") {
+    self.media_width = *profile.Tape
+  }
+  if profile.Threshold != nil && !flags.IsSet("threshold") {
+    self.black_threshold = *profile.Threshold
+  }
+  if profile.Mirror != nil && !flags.IsSet("mirror") {
+    self.mirror = *profile.Mirror
+  }
+  if profile.Cut != nil && !flags.IsSet("no-cut") {
+    self.no_front_cut = !*profile.Cut
+  }
+  if profile.FeedMargin != nil && !flags.IsSet("margin") {
+    self.feed_margin = *profile.FeedMargin
+  }
+  if profile.Dither != nil && !flags.IsSet("dither") {
+    self.dither = *profile.Dither
+  }
+  if len(profile.Font) > 0 && !flags.IsSet("font") {
+    self.font = profile.Font
+  }
+
+  if len(self.font) > 0 && !render.IsFont(self.font) {
+    return fmt.Errorf("unknown font %s, available: %s", self.font, strings.Join(render.Fonts(), ", "))
+  }
+  return nil
+}
@@ -0,0 +1,103 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "context"
+  "fmt"
+  "image"
+  "strings"
+  "github.com/spag/plabel"
+  "github.com/spag/plabel/render"
+  "github.com/spag/plabel/transport"
+)
+
+// EscpStyle returns the text style of labels printed in ESC/P mode.
+func (self *Settings) EscpStyle() (plabel.EscpStyle, error) {
+  align, err := plabel.ParseEscpAlign(self.escp_align)
+  if err != nil {
+    return plabel.EscpStyle{}, err
+  }
+  if self.escp_size == 0 || self.escp_size > 0xffff {
+    return plabel.EscpStyle{}, fmt.Errorf("character size %d out of range", self.escp_size)
+  }
+  return plabel.EscpStyle{Size: uint16(self.escp_size), Font: plabel.ESCP_FONT_HELSINKI, Bold: self.escp_bold, Italic: self.escp_italic, Align: align}, nil
+}
+
+// EscpBarcode returns Code 128 barcode parameters fitting the printing
+// width, the data is printed below the bars if there is room for it.
+func EscpBarcode(printing_width uint16) plabel.EscpBarcode {
+  height := min(uint16(plabel.ESCP_DEFAULT_BARCODE_HEIGHT), printing_width)
+  text := height + render.BARCODE_MIN_TEXT_HEIGHT/2 <= printing_width
+  return plabel.EscpBarcode{Type: plabel.ESCP_BARCODE_CODE128, Height: height, Width: 1, Text: text}
+}
+
+// SendEscpLabel sends the text or barcode label in ESC/P mode, with the
+// serial number replacing the {serial} placeholder.
+func SendEscpLabel(printer *plabel.Plabel, settings *Settings, serial string) error {
+  style, err := settings.EscpStyle()
+  if err != nil {
+    return err
+  }
+  if len(settings.barcode) > 0 {
+    data := strings.ReplaceAll(settings.barcode, SERIAL_PLACEHOLDER, serial)
+    return printer.PrintEscpBarcode(data, EscpBarcode(printer.PrintingWidth()), style)
+  }
+  return printer.PrintEscpText(strings.ReplaceAll(settings.text, SERIAL_PLACEHOLDER, serial), style)
+}
+
+// PrintEscp prints the copies of the text or barcode label in ESC/P mode,
+// every copy is fed and cut by the printer.
+func PrintEscp(printer *plabel.Plabel, settings *Settings) error {
+  var serial *SerialNumber
+  if len(settings.serial) > 0 {
+    var err error
+    if serial, err = ParseSerialNumber(settings.serial); err != nil {
+      return err
+    }
+  }
+
+  for copy := 0; copy < max(int(settings.copies), 1); copy++ {
+    number := ""
+    if serial != nil {
+      number = serial.Number(copy)
+    }
+    if err := SendEscpLabel(printer, settings, number); err != nil {
+      return err
+    }
+
+    if err := printer.WaitForPrinting(context.Background()); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// PreviewEscp renders the first copy of the ESC/P label with the emulator.
+func PreviewEscp(printer *plabel.Plabel, settings *Settings) (*image.Gray, error) {
+  model_information := printer.Model()
+  emulator := transport.NewEmulator(&model_information, byte(settings.media_width))
+  printer.Attach(emulator)
+  defer printer.Close()
+
+  serial := ""
+  if len(settings.serial) > 0 {
+    serial_number, err := ParseSerialNumber(settings.serial)
+    if err != nil {
+      return nil, err
+    }
+    serial = serial_number.Number(0)
+  }
+  if err := SendEscpLabel(printer, settings, serial); err != nil {
+    return nil, err
+  }
+
+  labels := emulator.Labels()
+  if len(labels) == 0 {
+    return nil, fmt.Errorf("no label printed")
+  }
+  return labels[0], nil
+}
@@ -0,0 +1,326 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "bytes"
+  "encoding/binary"
+  "fmt"
+)
+
+// IPP message encoding (RFC 8010)
+const (
+  IPP_TAG_OPERATION = 0x01
+  IPP_TAG_JOB = 0x02
+  IPP_TAG_END = 0x03
+  IPP_TAG_PRINTER = 0x04
+  IPP_TAG_UNSUPPORTED_GROUP = 0x05
+
+  IPP_TAG_UNSUPPORTED_VALUE = 0x10
+  IPP_TAG_NO_VALUE = 0x13
+  IPP_TAG_INTEGER = 0x21
+  IPP_TAG_BOOLEAN = 0x22
+  IPP_TAG_ENUM = 0x23
+  IPP_TAG_OCTET_STRING = 0x30
+  IPP_TAG_RESOLUTION = 0x32
+  IPP_TAG_RANGE = 0x33
+  IPP_TAG_BEGIN_COLLECTION = 0x34
+  IPP_TAG_END_COLLECTION = 0x37
+  IPP_TAG_TEXT = 0x41
+  IPP_TAG_NAME = 0x42
+  IPP_TAG_KEYWORD = 0x44
+  IPP_TAG_URI = 0x45
+  IPP_TAG_URI_SCHEME = 0x46
+  IPP_TAG_CHARSET = 0x47
+  IPP_TAG_LANGUAGE = 0x48
+  IPP_TAG_MIME_TYPE = 0x49
+  IPP_TAG_MEMBER_NAME = 0x4a
+
+  IPP_RESOLUTION_DPI = 3
+)
+
+type IppValue struct {
+  Tag byte
+  Data []byte
+  Members []IppAttribute
+}
+
+type IppAttribute struct {
+  Name string
+  Values []IppValue
+}
+
+type IppGroup struct {
+  Tag byte
+  Attributes []IppAttribute
+}
+
+type IppMessage struct {
+  Version uint16
+  Code uint16
+  RequestId uint32
+  Groups []*IppGroup
+}
+
+func IppInteger(value int) IppValue {
+  return IppValue{Tag: IPP_TAG_INTEGER, Data: binary.BigEndian.AppendUint32(nil, uint32(int32(value)))}
+}
+
+func IppEnum(value int) IppValue {
+  return IppValue{Tag: IPP_TAG_ENUM, Data: binary.BigEndian.AppendUint32(nil, uint32(int32(value)))}
+}
+
+func IppBoolean(value bool) IppValue {
+  if value {
+    return IppValue{Tag: IPP_TAG_BOOLEAN, Data: []byte{1}}
+  }
+  return IppValue{Tag: IPP_TAG_BOOLEAN, Data: []byte{0}}
+}
+
+func IppString(tag byte, value string) IppValue {
+  return IppValue{Tag: tag, Data: []byte(value)}
+}
+
+func IppResolution(x int, y int) IppValue {
+  data := binary.BigEndian.AppendUint32(nil, uint32(x))
+  data = binary.BigEndian.AppendUint32(data, uint32(y))
+  return IppValue{Tag: IPP_TAG_RESOLUTION, Data: append(data, IPP_RESOLUTION_DPI)}
+}
+
+func IppRange(lower int, upper int) IppValue {
+  data := binary.BigEndian.AppendUint32(nil, uint32(int32(lower)))
+  return IppValue{Tag: IPP_TAG_RANGE, Data: binary.BigEndian.AppendUint32(data, uint32(int32(upper)))}
+}
+
+func IppCollection(members ...IppAttribute) IppValue {
+  return IppValue{Tag: IPP_TAG_BEGIN_COLLECTION, Members: members}
+}
+
+func IppStrings(tag byte, values ...string) []IppValue {
+  ipp_values := make([]IppValue, len(values))
+  for index, value := range values {
+    ipp_values[index] = IppString(tag, value)
+  }
+  return ipp_values
+}
+
+func (self IppValue) String() string {
+  return string(self.Data)
+}
+
+func (self IppValue) Integer() int {
+  if len(self.Data) != 4 {
+    return 0
+  }
+  return int(int32(binary.BigEndian.Uint32(self.Data)))
+}
+
+func (self *IppMessage) AddGroup(tag byte) *IppGroup {
+  group := &IppGroup{Tag: tag}
+  self.Groups = append(self.Groups, group)
+  return group
+}
+
+// Group returns the first group with the given tag or an empty group.
+func (self *IppMessage) Group(tag byte) *IppGroup {
+  for _, group := range self.Groups {
+    if group.Tag == tag {
+      return group
+    }
+  }
+  return &IppGroup{Tag: tag}
+}
+
+func (self *IppGroup) Add(name string, values ...IppValue) {
+  self.Attributes = append(self.Attributes, IppAttribute{name, values})
+}
+
+func (self *IppGroup) Find(name string) (*IppAttribute, bool) {
+  for index := range self.Attributes {
+    if self.Attributes[index].Name == name {
+      return &self.Attributes[index], true
+    }
+  }
+  return nil, false
+}
+
+// String returns the first value of an attribute or the default value.
+func (self *IppGroup) String(name string, default_value string) string {
+  if attribute, ok := self.Find(name); ok && len(attribute.Values) > 0 {
+    return attribute.Values[0].String()
+  }
+  return default_value
+}
+
+func (self *IppGroup) Integer(name string, default_value int) int {
+  if attribute, ok := self.Find(name); ok && len(attribute.Values) > 0 {
+    return attribute.Values[0].Integer()
+  }
+  return default_value
+}
+
+// ParseIppMessage decodes an IPP message and returns the trailing document data.
+func ParseIppMessage(data []byte) (*IppMessage, []byte, error) {
+  if len(data) < 9 {
+    return nil, nil, fmt.Errorf("IPP message too short")
+  }
+
+  message := &IppMessage{
+    Version: binary.BigEndian.Uint16(data[0:2]),
+    Code: binary.BigEndian.Uint16(data[2:4]),
+    RequestId: binary.BigEndian.Uint32(data[4:8]),
+  }
+
+  parser := ipp_parser{data: data, position: 8}
+  var group *IppGroup
+
+  for {
+    tag, err := parser.byte()
+    if err != nil {
+      return nil, nil, err
+    }
+
+    if tag == IPP_TAG_END {
+      return message, data[parser.position:], nil
+    }
+    if tag < IPP_TAG_UNSUPPORTED_VALUE {
+      group = message.AddGroup(tag)
+      continue
+    }
+    if group == nil {
+      return nil, nil, fmt.Errorf("IPP attribute outside of group")
+    }
+
+    name, value, err := parser.attribute(tag)
+    if err != nil {
+      return nil, nil, err
+    }
+
+    if len(name) > 0 {
+      group.Add(name, value)
+    } else if len(group.Attributes) > 0 {
+      last := &group.Attributes[len(group.Attributes) - 1]
+      last.Values = append(last.Values, value)
+    } else {
+      return nil, nil, fmt.Errorf("IPP additional value without attribute")
+    }
+  }
+}
+
+type ipp_parser struct {
+  data []byte
+  position int
+}
+
+func (self *ipp_parser) byte() (byte, error) {
+  if self.position >= len(self.data) {
+    return 0, fmt.Errorf("IPP message truncated")
+  }
+  self.position++
+  return self.data[self.position - 1], nil
+}
+
+func (self *ipp_parser) field() ([]byte, error) {
+  if self.position + 2 > len(self.data) {
+    return nil, fmt.Errorf("IPP message truncated")
+  }
+  length := int(binary.BigEndian.Uint16(self.data[self.position:]))
+  self.position += 2
+  if self.position + length > len(self.data) {
+    return nil, fmt.Errorf("IPP message truncated")
+  }
+  self.position += length
+  return self.data[self.position - length:self.position], nil
+}
+
+func (self *ipp_parser) attribute(tag byte) (string, IppValue, error) {
+  name, err := self.field()
+  if err != nil {
+    return "", IppValue{}, err
+  }
+  data, err := self.field()
+  if err != nil {
+    return "", IppValue{}, err
+  }
+
+  value := IppValue{Tag: tag, Data: data}
+  if tag == IPP_TAG_BEGIN_COLLECTION {
+    value.Members, err = self.collection()
+  }
+  return string(name), value, err
+}
+
+func (self *ipp_parser) collection() (members []IppAttribute, err error) {
+  for {
+    tag, err := self.byte()
+    if err != nil {
+      return nil, err
+    }
+
+    _, value, err := self.attribute(tag)
+    if err != nil {
+      return nil, err
+    }
+
+    switch {
+    case tag == IPP_TAG_END_COLLECTION:
+      return members, nil
+    case tag == IPP_TAG_MEMBER_NAME:
+      members = append(members, IppAttribute{Name: value.String()})
+    case len(members) > 0:
+      members[len(members) - 1].Values = append(members[len(members) - 1].Values, value)
+    default:
+      return nil, fmt.Errorf("IPP collection value without member name")
+    }
+  }
+}
+
+func (self *IppMessage) Encode() []byte {
+  var buffer bytes.Buffer
+
+  binary.Write(&buffer, binary.BigEndian, self.Version)
+  binary.Write(&buffer, binary.BigEndian, self.Code)
+  binary.Write(&buffer, binary.BigEndian, self.RequestId)
+
+  for _, group := range self.Groups {
+    buffer.WriteByte(group.Tag)
+    for _, attribute := range group.Attributes {
+      for index, value := range attribute.Values {
+        name := ""
+        if index == 0 {
+          name = attribute.Name
+        }
+        encodeIppValue(&buffer, name, value)
+      }
+    }
+  }
+
+  buffer.WriteByte(IPP_TAG_END)
+  return buffer.Bytes()
+}
+
+func encodeIppField(buffer *bytes.Buffer, tag byte, name string, data []byte) {
+  buffer.WriteByte(tag)
+  binary.Write(buffer, binary.BigEndian, uint16(len(name)))
+  buffer.WriteString(name)
+  binary.Write(buffer, binary.BigEndian, uint16(len(data)))
+  buffer.Write(data)
+}
+
+func encodeIppValue(buffer *bytes.Buffer, name string, value IppValue) {
+  encodeIppField(buffer, value.Tag, name, value.Data)
+  if value.Tag != IPP_TAG_BEGIN_COLLECTION {
+    return
+  }
+
+  for _, member := range value.Members {
+    encodeIppField(buffer, IPP_TAG_MEMBER_NAME, "", []byte(member.Name))
+    for _, member_value := range member.Values {
+      encodeIppValue(buffer, "", member_value)
+    }
+  }
+  encodeIppField(buffer, IPP_TAG_END_COLLECTION, "", nil)
+}
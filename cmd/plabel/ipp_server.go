@@ -0,0 +1,416 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "bytes"
+  "crypto/sha1"
+  "errors"
+  "fmt"
+  "io"
+  "net/http"
+  "os"
+  "strings"
+  "time"
+  "github.com/spag/plabel/render"
+)
+
+const (
+  IPP_PATH = "/ipp/print"
+
+  IPP_OP_PRINT_JOB = 0x0002
+  IPP_OP_VALIDATE_JOB = 0x0004
+  IPP_OP_GET_JOB_ATTRIBUTES = 0x0009
+  IPP_OP_GET_JOBS = 0x000a
+  IPP_OP_GET_PRINTER_ATTRIBUTES = 0x000b
+
+  //status codes of RFC 8011 section 5.4.15
+  IPP_STATUS_OK = 0x0000
+  IPP_STATUS_OK_IGNORED_OR_SUBSTITUTED = 0x0001
+  IPP_STATUS_BAD_REQUEST = 0x0400
+  IPP_STATUS_NOT_FOUND = 0x0406
+  IPP_STATUS_DOCUMENT_FORMAT_NOT_SUPPORTED = 0x040a
+  IPP_STATUS_DOCUMENT_FORMAT_ERROR = 0x0411
+  IPP_STATUS_OPERATION_NOT_SUPPORTED = 0x0501
+  IPP_STATUS_VERSION_NOT_SUPPORTED = 0x0503
+  IPP_STATUS_BUSY = 0x0507
+
+  IPP_ORIENTATION_PORTRAIT = 3
+
+  IPP_PRINTER_IDLE = 3
+  IPP_PRINTER_PROCESSING = 4
+  IPP_PRINTER_STOPPED = 5
+
+  IPP_JOB_PENDING = 3
+  IPP_JOB_PROCESSING = 5
+  IPP_JOB_ABORTED = 8
+  IPP_JOB_COMPLETED = 9
+
+  MIME_OCTET_STREAM = "application/octet-stream"
+  MIME_PNG = "image/png"
+  MIME_SVG = "image/svg+xml"
+  MIME_PDF = "application/pdf"
+  MIME_ZPL = "application/vnd.zebra-zpl"
+  MIME_PWG_RASTER = "image/pwg-raster"
+
+  IPP_LABEL_LENGTH = 100 //mm, default label length advertised for the tape
+  IPP_MIN_LABEL_LENGTH = 25 //mm
+  IPP_MAX_LABEL_LENGTH = 1000 //mm
+)
+
+// IppServer is an IPP/2.0 print service (IPP Everywhere subset) on top of the
+// job queue. The loaded tape is advertised as media size, documents are
+// accepted as PWG raster or PNG.
+type IppServer struct {
+  queue *JobQueue
+  started time.Time
+}
+
+func NewIppServer(queue *JobQueue) *IppServer {
+  return &IppServer{queue: queue, started: time.Now()}
+}
+
+func (self *IppServer) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+  if request.Method != http.MethodPost || request.Header.Get("Content-Type") != "application/ipp" {
+    http.Error(response, "IPP requests only", http.StatusBadRequest)
+    return
+  }
+
+  body, err := io.ReadAll(http.MaxBytesReader(response, request.Body, MAX_JOB_SIZE))
+  if err != nil {
+    http.Error(response, err.Error(), http.StatusRequestEntityTooLarge)
+    return
+  }
+
+  ipp_request, document, err := ParseIppMessage(body)
+  if err != nil {
+    http.Error(response, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  ipp_response := self.handleRequest(ipp_request, document, "ipp://" + request.Host + IPP_PATH)
+
+  response.Header().Set("Content-Type", "application/ipp")
+  response.Write(ipp_response.Encode())
+}
+
+func (self *IppServer) handleRequest(request *IppMessage, document []byte, printer_uri string) *IppMessage {
+  response := &IppMessage{Version: request.Version, Code: IPP_STATUS_OK, RequestId: request.RequestId}
+
+  operation := response.AddGroup(IPP_TAG_OPERATION)
+  operation.Add("attributes-charset", IppString(IPP_TAG_CHARSET, "utf-8"))
+  operation.Add("attributes-natural-language", IppString(IPP_TAG_LANGUAGE, "en"))
+
+  request_operation := request.Group(IPP_TAG_OPERATION)
+
+  switch {
+  case request.Version >> 8 != 1 && request.Version >> 8 != 2:
+    response.Version = 0x0200
+    response.Code = IPP_STATUS_VERSION_NOT_SUPPORTED
+  case len(request_operation.Attributes) < 2 ||
+      request_operation.Attributes[0].Name != "attributes-charset" ||
+      request_operation.Attributes[1].Name != "attributes-natural-language":
+    response.Code = IPP_STATUS_BAD_REQUEST
+    operation.Add("status-message", IppString(IPP_TAG_TEXT, "missing charset or natural language"))
+  default:
+    switch request.Code {
+    case IPP_OP_PRINT_JOB:
+      self.printJob(request, document, response, printer_uri)
+    case IPP_OP_VALIDATE_JOB:
+      self.validateJob(request, response)
+    case IPP_OP_GET_JOB_ATTRIBUTES:
+      self.getJobAttributes(request, response, printer_uri)
+    case IPP_OP_GET_JOBS:
+      self.getJobs(request, response, printer_uri)
+    case IPP_OP_GET_PRINTER_ATTRIBUTES:
+      self.getPrinterAttributes(request, response, printer_uri)
+    default:
+      response.Code = IPP_STATUS_OPERATION_NOT_SUPPORTED
+    }
+  }
+
+  return response
+}
+
+// documentFormat returns the requested format, application/octet-stream is
+// detected from the document data.
+func documentFormat(request *IppMessage, document []byte) string {
+  format := request.Group(IPP_TAG_OPERATION).String("document-format", MIME_OCTET_STREAM)
+  if format != MIME_OCTET_STREAM || document == nil {
+    return format
+  }
+
+  switch {
+  case bytes.HasPrefix(document, []byte("RaS2")):
+    return MIME_PWG_RASTER
+  case bytes.HasPrefix(document, []byte("\x89PNG")):
+    return MIME_PNG
+  case render.IsSVG(document):
+    return MIME_SVG
+  case render.IsPDF(document):
+    return MIME_PDF
+  case render.IsZPL(document):
+    return MIME_ZPL
+  }
+  return format
+}
+
+func (self *IppServer) validateJob(request *IppMessage, response *IppMessage) bool {
+  switch documentFormat(request, nil) {
+  case MIME_OCTET_STREAM, MIME_PNG, MIME_SVG, MIME_PDF, MIME_ZPL, MIME_PWG_RASTER:
+  default:
+    response.Code = IPP_STATUS_DOCUMENT_FORMAT_NOT_SUPPORTED
+    response.Group(IPP_TAG_OPERATION).Add("status-message", IppString(IPP_TAG_TEXT, "unsupported document format"))
+    return false
+  }
+
+  //labels are printed as laid out, other orientations are ignored
+  if attribute, ok := request.Group(IPP_TAG_JOB).Find("orientation-requested"); ok && len(attribute.Values) > 0 &&
+      attribute.Values[0].Integer() != IPP_ORIENTATION_PORTRAIT {
+    response.Code = IPP_STATUS_OK_IGNORED_OR_SUBSTITUTED
+    response.AddGroup(IPP_TAG_UNSUPPORTED_GROUP).Attributes = append([]IppAttribute(nil), *attribute)
+  }
+  return true
+}
+
+func (self *IppServer) printJob(request *IppMessage, document []byte, response *IppMessage, printer_uri string) {
+  if !self.validateJob(request, response) {
+    return
+  }
+
+  operation := request.Group(IPP_TAG_OPERATION)
+  job := &Job{
+    Name: operation.String("job-name", "untitled"),
+    User: operation.String("requesting-user-name", "anonymous"),
+    Image: document,
+  }
+
+  switch documentFormat(request, document) {
+  case MIME_PWG_RASTER:
+    job.Kind = JOB_KIND_RASTER
+  case MIME_PNG, MIME_SVG, MIME_PDF, MIME_ZPL:
+    job.Kind = JOB_KIND_IMAGE
+  default:
+    response.Code = IPP_STATUS_DOCUMENT_FORMAT_NOT_SUPPORTED
+    return
+  }
+
+  if err := self.queue.Submit(job); err != nil {
+    response.Code = IPP_STATUS_DOCUMENT_FORMAT_ERROR
+    if errors.Is(err, ErrJobQueueFull) {
+      response.Code = IPP_STATUS_BUSY
+    }
+    response.Group(IPP_TAG_OPERATION).Add("status-message", IppString(IPP_TAG_TEXT, err.Error()))
+    return
+  }
+
+  current, _ := self.queue.Job(job.Id)
+  self.addJobAttributes(response.AddGroup(IPP_TAG_JOB), &current, printer_uri, false)
+}
+
+func (self *IppServer) getJobAttributes(request *IppMessage, response *IppMessage, printer_uri string) {
+  operation := request.Group(IPP_TAG_OPERATION)
+
+  id := operation.Integer("job-id", 0)
+  if id == 0 {
+    fmt.Sscanf(operation.String("job-uri", ""), printer_uri + "/%d", &id)
+  }
+
+  job, ok := self.queue.Job(id)
+  if !ok {
+    response.Code = IPP_STATUS_NOT_FOUND
+    return
+  }
+
+  self.addJobAttributes(response.AddGroup(IPP_TAG_JOB), &job, printer_uri, true)
+}
+
+func (self *IppServer) getJobs(request *IppMessage, response *IppMessage, printer_uri string) {
+  operation := request.Group(IPP_TAG_OPERATION)
+  which_jobs := operation.String("which-jobs", "not-completed")
+  limit := operation.Integer("limit", 0)
+
+  count := 0
+  for _, job := range self.queue.Jobs() {
+    completed := job.State == JOB_STATE_COMPLETED || job.State == JOB_STATE_FAILED
+    if (which_jobs == "completed" && !completed) || (which_jobs == "not-completed" && completed) {
+      continue
+    }
+    if limit > 0 && count >= limit {
+      break
+    }
+    self.addJobAttributes(response.AddGroup(IPP_TAG_JOB), &job, printer_uri, true)
+    count++
+  }
+}
+
+func (self *IppServer) addJobAttributes(group *IppGroup, job *Job, printer_uri string, details bool) {
+  state, reason := IPP_JOB_PENDING, "job-queued"
+  switch job.State {
+  case JOB_STATE_PRINTING:
+    state, reason = IPP_JOB_PROCESSING, "job-printing"
+  case JOB_STATE_COMPLETED:
+    state, reason = IPP_JOB_COMPLETED, "job-completed-successfully"
+  case JOB_STATE_FAILED:
+    state, reason = IPP_JOB_ABORTED, "aborted-by-system"
+  }
+
+  group.Add("job-id", IppInteger(job.Id))
+  group.Add("job-uri", IppString(IPP_TAG_URI, fmt.Sprintf("%s/%d", printer_uri, job.Id)))
+  group.Add("job-state", IppEnum(state))
+  group.Add("job-state-reasons", IppString(IPP_TAG_KEYWORD, reason))
+  if !details {
+    return
+  }
+
+  group.Add("job-printer-uri", IppString(IPP_TAG_URI, printer_uri))
+  group.Add("job-name", IppString(IPP_TAG_NAME, job.Name))
+  group.Add("job-originating-user-name", IppString(IPP_TAG_NAME, job.User))
+  group.Add("job-printer-up-time", IppInteger(self.upTime(time.Now())))
+  group.Add("time-at-creation", IppInteger(self.upTime(job.Submitted)))
+  if job.Finished != nil {
+    group.Add("time-at-completed", IppInteger(self.upTime(*job.Finished)))
+  } else {
+    group.Add("time-at-completed", IppValue{Tag: IPP_TAG_NO_VALUE})
+  }
+  if len(job.Error) > 0 {
+    group.Add("job-state-message", IppString(IPP_TAG_TEXT, job.Error))
+  }
+}
+
+func (self *IppServer) upTime(at time.Time) int {
+  return int(at.Sub(self.started).Seconds()) + 1
+}
+
+func (self *IppServer) getPrinterAttributes(request *IppMessage, response *IppMessage, printer_uri string) {
+  requested := map[string]bool{}
+  if attribute, ok := request.Group(IPP_TAG_OPERATION).Find("requested-attributes"); ok {
+    for _, value := range attribute.Values {
+      requested[value.String()] = true
+    }
+  }
+  all := len(requested) == 0 || requested["all"] || requested["printer-description"] || requested["job-template"]
+
+  group := response.AddGroup(IPP_TAG_PRINTER)
+  for _, attribute := range self.printerAttributes(printer_uri) {
+    if all || requested[attribute.Name] {
+      group.Attributes = append(group.Attributes, attribute)
+    }
+  }
+}
+
+func (self *IppServer) printerState() (int, []string) {
+  status, _ := self.queue.PrinterStatus()
+  if status.ErrorCode > 0 {
+    return IPP_PRINTER_STOPPED, status.StateReasons()
+  }
+
+  for _, job := range self.queue.Jobs() {
+    if job.State == JOB_STATE_PRINTING || job.State == JOB_STATE_QUEUED {
+      return IPP_PRINTER_PROCESSING, []string{"none"}
+    }
+  }
+  return IPP_PRINTER_IDLE, []string{"none"}
+}
+
+func mediaSize(width_mm int, length IppValue) IppValue {
+  return IppCollection(IppAttribute{"media-size", []IppValue{IppCollection(
+    IppAttribute{"x-dimension", []IppValue{IppInteger(width_mm * 100)}},
+    IppAttribute{"y-dimension", []IppValue{length}},
+  )}})
+}
+
+func (self *IppServer) printerAttributes(printer_uri string) []IppAttribute {
+  status, model_information := self.queue.PrinterStatus()
+  state, reasons := self.printerState()
+
+  model_name := model_information.ModelName
+  if len(model_name) == 0 {
+    model_name = "P-touch"
+  }
+  model := "Brother " + model_name
+  resolution := int(model_information.Resolution)
+  if resolution == 0 {
+    resolution = 180
+  }
+
+  tape_width := int(status.MediaWidth)
+  if tape_width == 0 {
+    tape_width = 24
+  }
+  media := fmt.Sprintf("om_tape-%dmm_%dx%dmm", tape_width, tape_width, IPP_LABEL_LENGTH)
+  media_col := mediaSize(tape_width, IppInteger(IPP_LABEL_LENGTH * 100))
+
+  hostname, _ := os.Hostname()
+  uuid := sha1.Sum([]byte(hostname + self.queue.settings.printer_device))
+
+  queued := 0
+  for _, job := range self.queue.Jobs() {
+    if job.State == JOB_STATE_QUEUED || job.State == JOB_STATE_PRINTING {
+      queued++
+    }
+  }
+
+  return []IppAttribute{
+    {"printer-uri-supported", []IppValue{IppString(IPP_TAG_URI, printer_uri)}},
+    {"uri-security-supported", []IppValue{IppString(IPP_TAG_KEYWORD, "none")}},
+    {"uri-authentication-supported", []IppValue{IppString(IPP_TAG_KEYWORD, "none")}},
+    {"printer-name", []IppValue{IppString(IPP_TAG_NAME, PROGRAM_NAME)}},
+    {"printer-info", []IppValue{IppString(IPP_TAG_TEXT, model + " label printer")}},
+    {"printer-make-and-model", []IppValue{IppString(IPP_TAG_TEXT, model)}},
+    {"printer-uuid", []IppValue{IppString(IPP_TAG_URI, fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]))}},
+    {"printer-state", []IppValue{IppEnum(state)}},
+    {"printer-state-reasons", IppStrings(IPP_TAG_KEYWORD, reasons...)},
+    {"printer-is-accepting-jobs", []IppValue{IppBoolean(true)}},
+    {"printer-up-time", []IppValue{IppInteger(self.upTime(time.Now()))}},
+    {"queued-job-count", []IppValue{IppInteger(queued)}},
+    {"ipp-versions-supported", IppStrings(IPP_TAG_KEYWORD, "1.1", "2.0")},
+    {"ipp-features-supported", IppStrings(IPP_TAG_KEYWORD, "ipp-everywhere")},
+    {"operations-supported", []IppValue{IppEnum(IPP_OP_PRINT_JOB), IppEnum(IPP_OP_VALIDATE_JOB), IppEnum(IPP_OP_GET_JOB_ATTRIBUTES), IppEnum(IPP_OP_GET_JOBS), IppEnum(IPP_OP_GET_PRINTER_ATTRIBUTES)}},
+    {"charset-configured", []IppValue{IppString(IPP_TAG_CHARSET, "utf-8")}},
+    {"charset-supported", []IppValue{IppString(IPP_TAG_CHARSET, "utf-8")}},
+    {"natural-language-configured", []IppValue{IppString(IPP_TAG_LANGUAGE, "en")}},
+    {"generated-natural-language-supported", []IppValue{IppString(IPP_TAG_LANGUAGE, "en")}},
+    {"pdl-override-supported", []IppValue{IppString(IPP_TAG_KEYWORD, "attempted")}},
+    {"compression-supported", IppStrings(IPP_TAG_KEYWORD, "none")},
+    {"multiple-document-jobs-supported", []IppValue{IppBoolean(false)}},
+    {"document-format-default", []IppValue{IppString(IPP_TAG_MIME_TYPE, MIME_OCTET_STREAM)}},
+    {"document-format-supported", IppStrings(IPP_TAG_MIME_TYPE, MIME_OCTET_STREAM, MIME_PNG, MIME_SVG, MIME_PDF, MIME_ZPL, MIME_PWG_RASTER)},
+    {"color-supported", []IppValue{IppBoolean(false)}},
+    {"print-color-mode-default", []IppValue{IppString(IPP_TAG_KEYWORD, "monochrome")}},
+    {"print-color-mode-supported", IppStrings(IPP_TAG_KEYWORD, "monochrome")},
+    {"copies-default", []IppValue{IppInteger(1)}},
+    {"copies-supported", []IppValue{IppRange(1, 1)}},
+    {"sides-default", []IppValue{IppString(IPP_TAG_KEYWORD, "one-sided")}},
+    {"sides-supported", IppStrings(IPP_TAG_KEYWORD, "one-sided")},
+    {"orientation-requested-default", []IppValue{IppEnum(IPP_ORIENTATION_PORTRAIT)}},
+    {"orientation-requested-supported", []IppValue{IppEnum(IPP_ORIENTATION_PORTRAIT)}},
+    {"printer-resolution-default", []IppValue{IppResolution(resolution, resolution)}},
+    {"printer-resolution-supported", []IppValue{IppResolution(resolution, resolution)}},
+    {"pwg-raster-document-resolution-supported", []IppValue{IppResolution(resolution, resolution)}},
+    {"pwg-raster-document-type-supported", IppStrings(IPP_TAG_KEYWORD, "black_1", "sgray_8", "srgb_8")},
+    {"pwg-raster-document-sheet-back", []IppValue{IppString(IPP_TAG_KEYWORD, "normal")}},
+    {"media-default", []IppValue{IppString(IPP_TAG_KEYWORD, media)}},
+    {"media-ready", []IppValue{IppString(IPP_TAG_KEYWORD, media)}},
+    {"media-supported", IppStrings(IPP_TAG_KEYWORD, media,
+      fmt.Sprintf("custom_min_%dx%dmm", tape_width, IPP_MIN_LABEL_LENGTH),
+      fmt.Sprintf("custom_max_%dx%dmm", tape_width, IPP_MAX_LABEL_LENGTH))},
+    {"media-col-default", []IppValue{media_col}},
+    {"media-col-ready", []IppValue{media_col}},
+    {"media-col-database", []IppValue{media_col, mediaSize(tape_width, IppRange(IPP_MIN_LABEL_LENGTH * 100, IPP_MAX_LABEL_LENGTH * 100))}},
+    {"media-col-supported", IppStrings(IPP_TAG_KEYWORD, "media-size")},
+    {"media-size-supported", []IppValue{
+      IppCollection(IppAttribute{"x-dimension", []IppValue{IppInteger(tape_width * 100)}}, IppAttribute{"y-dimension", []IppValue{IppInteger(IPP_LABEL_LENGTH * 100)}}),
+      IppCollection(IppAttribute{"x-dimension", []IppValue{IppInteger(tape_width * 100)}}, IppAttribute{"y-dimension", []IppValue{IppRange(IPP_MIN_LABEL_LENGTH * 100, IPP_MAX_LABEL_LENGTH * 100)}}),
+    }},
+    {"media-bottom-margin-supported", []IppValue{IppInteger(0)}},
+    {"media-left-margin-supported", []IppValue{IppInteger(0)}},
+    {"media-right-margin-supported", []IppValue{IppInteger(0)}},
+    {"media-top-margin-supported", []IppValue{IppInteger(0)}},
+    {"printer-device-id", []IppValue{IppString(IPP_TAG_TEXT, fmt.Sprintf("MFG:Brother;MDL:%s;CMD:PWGRaster;", model_name))}},
+    {"printer-more-info", []IppValue{IppString(IPP_TAG_URI, strings.Replace(strings.TrimSuffix(printer_uri, IPP_PATH), "ipp://", "http://", 1) + "/status")}},
+  }
+}
@@ -0,0 +1,186 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "bytes"
+  "image/color"
+  "image/png"
+  "log/slog"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+  "github.com/spag/plabel/render"
+)
+
+// testQueue returns a job queue printing on the emulator of a PT-P700 with
+// 12 mm tape, configured by the options of the serve command.
+func testQueue(t *testing.T, arguments ...string) *JobQueue {
+  t.Helper()
+  command, _ := FindCommand("serve")
+  flags := NewCommandFlags(command.name)
+  var settings Settings
+  command.flags(flags, &settings)
+  if err := flags.Parse(append([]string{"--ledger", ""}, arguments...)); err != nil {
+    t.Fatal(err)
+  }
+  settings.model, settings.media_width = "PT-P700", 12
+  settings.logger = slog.New(slog.DiscardHandler)
+
+  printer, _ := OpenEmulator(&settings)
+  if printer == nil {
+    t.Fatal("opening emulator")
+  }
+  t.Cleanup(printer.Close)
+  return NewJobQueue(printer, &settings, nil)
+}
+
+// ippRequest sends an IPP request with charset and language to the server.
+func ippRequest(t *testing.T, url string, version uint16, operation uint16, attributes []IppAttribute, document []byte) *IppMessage {
+  t.Helper()
+  request := &IppMessage{Version: version, Code: operation, RequestId: 42}
+  group := request.AddGroup(IPP_TAG_OPERATION)
+  group.Add("attributes-charset", IppString(IPP_TAG_CHARSET, "utf-8"))
+  group.Add("attributes-natural-language", IppString(IPP_TAG_LANGUAGE, "en"))
+  group.Add("printer-uri", IppString(IPP_TAG_URI, "ipp://localhost" + IPP_PATH))
+  for _, attribute := range attributes {
+    if attribute.Name == "orientation-requested" {
+      request.AddGroup(IPP_TAG_JOB).Attributes = []IppAttribute{attribute}
+    } else {
+      group.Attributes = append(group.Attributes, attribute)
+    }
+  }
+
+  response, err := http.Post(url + IPP_PATH, "application/ipp", bytes.NewReader(append(request.Encode(), document...)))
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer response.Body.Close()
+  var body bytes.Buffer
+  body.ReadFrom(response.Body)
+
+  message, _, err := ParseIppMessage(body.Bytes())
+  if err != nil {
+    t.Fatal(err)
+  }
+  if message.RequestId != 42 {
+    t.Errorf("request id %d", message.RequestId)
+  }
+  return message
+}
+
+func testPng(t *testing.T) []byte {
+  t.Helper()
+  img := render.NewWhiteImage(40, 64)
+  render.FillRect(img, 4, 4, 32, 56, color.Gray{0})
+  var data bytes.Buffer
+  if err := png.Encode(&data, img); err != nil {
+    t.Fatal(err)
+  }
+  return data.Bytes()
+}
+
+func TestIppPrinterAttributes(t *testing.T) {
+  server := httptest.NewServer(NewServer(testQueue(t)).mux)
+  defer server.Close()
+
+  response := ippRequest(t, server.URL, 0x0101, IPP_OP_GET_PRINTER_ATTRIBUTES,
+    []IppAttribute{{"requested-attributes", IppStrings(IPP_TAG_KEYWORD, "printer-make-and-model", "media-ready", "orientation-requested-supported")}}, nil)
+  if response.Code != IPP_STATUS_OK || response.Version != 0x0101 {
+    t.Fatalf("status 0x%04x version 0x%04x", response.Code, response.Version)
+  }
+
+  printer := response.Group(IPP_TAG_PRINTER)
+  if model := printer.String("printer-make-and-model", ""); model != "Brother PT-P700" {
+    t.Errorf("printer-make-and-model %s", model)
+  }
+  if media := printer.String("media-ready", ""); media != "om_tape-12mm_12x100mm" {
+    t.Errorf("media-ready %s", media)
+  }
+  if attribute, ok := printer.Find("orientation-requested-supported"); !ok || len(attribute.Values) != 1 {
+    t.Errorf("orientation-requested-supported %v", attribute)
+  }
+  if _, ok := printer.Find("printer-uuid"); ok {
+    t.Error("printer-uuid not requested")
+  }
+}
+
+func TestIppPrintJob(t *testing.T) {
+  queue := testQueue(t)
+  go queue.Run()
+  server := httptest.NewServer(NewServer(queue).mux)
+  defer server.Close()
+
+  response := ippRequest(t, server.URL, 0x0200, IPP_OP_PRINT_JOB, []IppAttribute{
+    {"job-name", []IppValue{IppString(IPP_TAG_NAME, "box")}},
+    {"document-format", []IppValue{IppString(IPP_TAG_MIME_TYPE, MIME_OCTET_STREAM)}},
+  }, testPng(t))
+  if response.Code != IPP_STATUS_OK || response.Version != 0x0200 {
+    t.Fatalf("status 0x%04x version 0x%04x", response.Code, response.Version)
+  }
+  id := response.Group(IPP_TAG_JOB).Integer("job-id", 0)
+  if id == 0 {
+    t.Fatal("no job id")
+  }
+
+  for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+    response = ippRequest(t, server.URL, 0x0200, IPP_OP_GET_JOB_ATTRIBUTES, []IppAttribute{{"job-id", []IppValue{IppInteger(id)}}}, nil)
+    job := response.Group(IPP_TAG_JOB)
+    if state := job.Integer("job-state", 0); state == IPP_JOB_COMPLETED {
+      if name := job.String("job-name", ""); name != "box" {
+        t.Errorf("job-name %s", name)
+      }
+      break
+    } else if state == IPP_JOB_ABORTED || time.Now().After(deadline) {
+      t.Fatalf("job state %d: %s", state, job.String("job-state-message", ""))
+    }
+  }
+}
+
+func TestIppStatusCodes(t *testing.T) {
+  queue := testQueue(t)
+  //no job is taken from the queue, a second job does not fit
+  queue.queue = make(chan *Job, 1)
+  server := httptest.NewServer(NewServer(queue).mux)
+  defer server.Close()
+
+  png_document := []IppAttribute{{"document-format", []IppValue{IppString(IPP_TAG_MIME_TYPE, MIME_PNG)}}}
+  tests := []struct {
+    name       string
+    version    uint16
+    operation  uint16
+    attributes []IppAttribute
+    document   []byte
+    code       uint16
+  }{
+    {"unsupported version", 0x0300, IPP_OP_GET_PRINTER_ATTRIBUTES, nil, nil, IPP_STATUS_VERSION_NOT_SUPPORTED},
+    {"unsupported operation", 0x0200, 0x0010, nil, nil, IPP_STATUS_OPERATION_NOT_SUPPORTED},
+    {"unsupported format", 0x0200, IPP_OP_PRINT_JOB, []IppAttribute{{"document-format", []IppValue{IppString(IPP_TAG_MIME_TYPE, "application/postscript")}}},
+      []byte("%!PS"), IPP_STATUS_DOCUMENT_FORMAT_NOT_SUPPORTED},
+    {"broken document", 0x0200, IPP_OP_PRINT_JOB, png_document, []byte("\x89PNG broken"), IPP_STATUS_DOCUMENT_FORMAT_ERROR},
+    {"landscape", 0x0200, IPP_OP_VALIDATE_JOB, append(png_document, IppAttribute{"orientation-requested", []IppValue{IppEnum(4)}}), nil,
+      IPP_STATUS_OK_IGNORED_OR_SUBSTITUTED},
+    {"queued", 0x0200, IPP_OP_PRINT_JOB, png_document, testPng(t), IPP_STATUS_OK},
+    {"queue full", 0x0200, IPP_OP_PRINT_JOB, png_document, testPng(t), IPP_STATUS_BUSY},
+    {"unknown job", 0x0200, IPP_OP_GET_JOB_ATTRIBUTES, []IppAttribute{{"job-id", []IppValue{IppInteger(99)}}}, nil, IPP_STATUS_NOT_FOUND},
+  }
+  for _, test := range tests {
+    response := ippRequest(t, server.URL, test.version, test.operation, test.attributes, test.document)
+    if response.Code != test.code {
+      t.Errorf("%s: status 0x%04x, expected 0x%04x", test.name, response.Code, test.code)
+    }
+    if test.code == IPP_STATUS_OK_IGNORED_OR_SUBSTITUTED {
+      if _, ok := response.Group(IPP_TAG_UNSUPPORTED_GROUP).Find("orientation-requested"); !ok {
+        t.Errorf("%s: orientation-requested not returned as unsupported", test.name)
+      }
+    }
+  }
+
+  if status, model_information := queue.PrinterStatus(); model_information.ModelName != "PT-P700" || status.MediaWidth != 12 {
+    t.Errorf("printer status of %s with %d mm tape", model_information.ModelName, status.MediaWidth)
+  }
+}
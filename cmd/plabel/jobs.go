@@ -0,0 +1,433 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "bytes"
+  "context"
+  "errors"
+  "fmt"
+  "image"
+  "io"
+  "os"
+  "strings"
+  "sync"
+  "text/template"
+  "time"
+  "github.com/spag/plabel"
+  "github.com/spag/plabel/render"
+  "github.com/spag/plabel/transport"
+)
+
+const (
+  JOB_KIND_IMAGE = "image"
+  JOB_KIND_TEXT = "text"
+  JOB_KIND_TEMPLATE = "template"
+  JOB_KIND_RASTER = "raster"
+
+  JOB_STATE_QUEUED = "queued"
+  JOB_STATE_PRINTING = "printing"
+  JOB_STATE_COMPLETED = "completed"
+  JOB_STATE_FAILED = "failed"
+
+  JOB_QUEUE_LENGTH = 64
+  JOB_HISTORY_LENGTH = 256
+  JOB_RETRY_AFTER = 30 //s clients wait before submitting again to a full queue
+)
+
+// ErrJobQueueFull is returned by Submit for jobs that were valid but did not
+// fit into the queue.
+var ErrJobQueueFull = errors.New("job queue is full")
+
+type Job struct {
+  Id int `json:"id"`
+  Kind string `json:"kind"`
+  Name string `json:"name,omitempty"`
+  User string `json:"user,omitempty"`
+  Image []byte `json:"image,omitempty"`
+  Text string `json:"text,omitempty"`
+  Template string `json:"template,omitempty"`
+  Data map[string]string `json:"data,omitempty"`
+  Threshold uint `json:"threshold,omitempty"`
+  Mirror bool `json:"mirror,omitempty"`
+  NoCut bool `json:"no_cut,omitempty"`
+  BatchMode bool `json:"batch_mode,omitempty"`
+
+  State string `json:"state"`
+  Error string `json:"error,omitempty"`
+  Submitted time.Time `json:"submitted"`
+  Finished *time.Time `json:"finished,omitempty"`
+  Status *plabel.StatusReport `json:"status,omitempty"`
+
+  done chan struct{}
+}
+
+type JobQueue struct {
+  printer *plabel.Plabel
+  device_spec string //printer as configured, resolved again to reopen the device
+  settings *Settings
+  metrics *Metrics
+  ledger *TapeLedger
+
+  mutex sync.Mutex
+  printer_mutex sync.Mutex
+  jobs map[int]*Job
+  history []int
+  next_id int
+  queue chan *Job
+}
+
+func NewJobQueue(printer *plabel.Plabel, settings *Settings, ledger *TapeLedger) *JobQueue {
+  return &JobQueue{
+    printer: printer,
+    settings: settings,
+    metrics: NewMetrics(),
+    ledger: ledger,
+    jobs: make(map[int]*Job),
+    next_id: 1,
+    queue: make(chan *Job, JOB_QUEUE_LENGTH),
+  }
+}
+
+// Validate checks the job content before it is queued, so that clients get
+// an immediate error for jobs that can never be printed.
+func (self *Job) Validate() error {
+  switch self.Kind {
+  case JOB_KIND_IMAGE:
+    if _, _, err := DecodeImages(self.Image, DecodeOptions{plabel.DATA_LINE_PIXEL_WIDTH, plabel.LABEL_RESOLUTION, 1, plabel.DEFAULT_THRESHOLD}); err != nil {
+      return fmt.Errorf("decoding image: %s", err)
+    }
+  case JOB_KIND_TEXT:
+    if len(self.Text) == 0 {
+      return fmt.Errorf("empty text")
+    }
+  case JOB_KIND_TEMPLATE:
+    if _, err := template.New("label").Parse(self.Template); err != nil {
+      return fmt.Errorf("parsing template: %s", err)
+    }
+  case JOB_KIND_RASTER:
+    if _, err := readRasterPages(self.Image); err != nil {
+      return fmt.Errorf("decoding raster: %s", err)
+    }
+  default:
+    return fmt.Errorf("unknown job kind: %s", self.Kind)
+  }
+  return nil
+}
+
+// Render creates the label images with the decode options and font of text
+// labels, every image is printed as a separate label.
+func (self *Job) Render(options DecodeOptions, font string) ([]image.Image, string, error) {
+  printing_width := options.height
+  switch self.Kind {
+  case JOB_KIND_IMAGE:
+    return DecodeImages(self.Image, options)
+  case JOB_KIND_TEXT:
+    return []image.Image{render.RenderTextFont(self.Text, printing_width, font)}, JOB_KIND_TEXT, nil
+  case JOB_KIND_TEMPLATE:
+    var text strings.Builder
+    label_template, err := template.New("label").Option("missingkey=error").Parse(self.Template)
+    if err == nil {
+      err = label_template.Execute(&text, self.Data)
+    }
+    if err != nil {
+      return nil, "", fmt.Errorf("executing template: %s", err)
+    }
+    return []image.Image{render.RenderTextFont(text.String(), printing_width, font)}, JOB_KIND_TEMPLATE, nil
+  case JOB_KIND_RASTER:
+    pages, err := readRasterPages(self.Image)
+    return pages, JOB_KIND_RASTER, err
+  }
+  return nil, "", fmt.Errorf("unknown job kind: %s", self.Kind)
+}
+
+// readRasterPages decodes a CUPS or PWG raster document. The pages have the
+// tape width as page width and are turned into label orientation.
+func readRasterPages(data []byte) ([]image.Image, error) {
+  raster, err := plabel.NewCupsRasterReader(bytes.NewReader(data))
+  if err != nil {
+    return nil, err
+  }
+
+  var pages []image.Image
+  for {
+    _, page, err := raster.ReadPage()
+    if err == io.EOF && len(pages) > 0 {
+      return pages, nil
+    }
+    if err != nil {
+      return nil, err
+    }
+    pages = append(pages, render.RotateClockwise(page))
+  }
+}
+
+func (self *Job) PrintOptions(settings *Settings, printer *plabel.Plabel) plabel.PrintOptions {
+  options := settings.PrintOptions(printer)
+  if self.Threshold > 0 {
+    options.Threshold = byte(self.Threshold)
+  }
+  options.Mirror = options.Mirror || self.Mirror
+  options.NoFrontCut = options.NoFrontCut || self.NoCut
+  options.Chain = options.Chain || self.BatchMode
+  return options
+}
+
+// Copy returns the job without its image data, mutex must be held.
+func (self *Job) Copy() Job {
+  job := *self
+  job.Image = nil
+  return job
+}
+
+func (self *JobQueue) Submit(job *Job) error {
+  if err := job.Validate(); err != nil {
+    return err
+  }
+
+  self.mutex.Lock()
+  job.done = make(chan struct{})
+  job.Id = self.next_id
+  job.State = JOB_STATE_QUEUED
+  job.Submitted = time.Now()
+  self.next_id++
+  self.jobs[job.Id] = job
+  self.history = append(self.history, job.Id)
+  self.expireJobs()
+  self.mutex.Unlock()
+
+  select {
+  case self.queue <- job:
+    return nil
+  default:
+    self.finishJob(job, ErrJobQueueFull)
+    return ErrJobQueueFull
+  }
+}
+
+// expireJobs drops the oldest finished jobs from the history, mutex must be held.
+func (self *JobQueue) expireJobs() {
+  for len(self.history) > JOB_HISTORY_LENGTH {
+    job, ok := self.jobs[self.history[0]]
+    if ok && (job.State == JOB_STATE_QUEUED || job.State == JOB_STATE_PRINTING) {
+      return
+    }
+    delete(self.jobs, self.history[0])
+    self.history = self.history[1:]
+  }
+}
+
+func (self *JobQueue) Job(id int) (Job, bool) {
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+
+  if job, ok := self.jobs[id]; ok {
+    return job.Copy(), true
+  }
+  return Job{}, false
+}
+
+func (self *JobQueue) Jobs() []Job {
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+
+  jobs := make([]Job, 0, len(self.history))
+  for _, id := range self.history {
+    jobs = append(jobs, self.jobs[id].Copy())
+  }
+  return jobs
+}
+
+// PrinterStatus returns the status last received from the printer and the
+// model information, safe to call while a job is printing.
+func (self *JobQueue) PrinterStatus() (plabel.PrinterStatus, plabel.ModelInformation) {
+  return self.printer.Status(), self.printer.Model()
+}
+
+// QueryStatus requests a fresh status from the printer. While a job holds
+// the printer the status last received is returned without waiting.
+func (self *JobQueue) QueryStatus() plabel.StatusReport {
+  if self.printer_mutex.TryLock() {
+    self.requestStatus()
+    self.printer_mutex.Unlock()
+  }
+  return self.printer.StatusReport()
+}
+
+// PollStatus requests the printer status periodically, so that the metrics
+// show printer errors before a job fails. A printer not answering is opened
+// again, it may have been switched on or plugged in since.
+func (self *JobQueue) PollStatus(interval time.Duration) {
+  for {
+    time.Sleep(interval)
+    self.printer_mutex.Lock()
+    if !self.requestStatus() {
+      self.reopenPrinter()
+    }
+    self.printer_mutex.Unlock()
+  }
+}
+
+// reopenPrinter opens the device of the printer again and requests its
+// status, the printer mutex must be held.
+func (self *JobQueue) reopenPrinter() bool {
+  if self.printer.Simulate {
+    return false
+  }
+  device_path, err := transport.ResolvePrinterDevice(self.device_spec)
+  if err != nil {
+    self.settings.logger.Debug("printer not found", "printer", self.device_spec, "error", err)
+    return false
+  }
+  if _, err := os.Stat(device_path); err != nil {
+    self.settings.logger.Debug("printer not available", "device", device_path, "error", err)
+    return false
+  }
+  if !self.printer.Reopen(device_path) {
+    return false
+  }
+
+  self.printer.Invalidate()
+  self.printer.Initialize()
+  if !self.requestStatus() {
+    return false
+  }
+  self.settings.logger.Info("printer reopened", "device", device_path, "model", self.printer.Model().ModelName)
+  return true
+}
+
+// Shutdown waits until the job printing has finished, at most for the
+// timeout. The printer stays locked afterwards, queued jobs are not started.
+func (self *JobQueue) Shutdown(timeout time.Duration) bool {
+  locked := make(chan struct{})
+  go func() {
+    self.printer_mutex.Lock()
+    close(locked)
+  }()
+
+  select {
+  case <-locked:
+    return true
+  case <-time.After(timeout):
+    return false
+  }
+}
+
+// requestStatus requests the printer status and records the latency until
+// the status frame was read, the printer mutex must be held.
+func (self *JobQueue) requestStatus() bool {
+  start := time.Now()
+  self.printer.RequestStatus()
+  received := self.printer.WaitForPrinterStatus(1000)
+  self.metrics.StatusPolled(self.printer.StatusTime().Sub(start), received)
+  if received {
+    status := self.printer.Status()
+    if err := self.ledger.Observe(&status); err != nil {
+      self.settings.logger.Error("updating ledger", "error", err)
+    }
+  }
+  return received
+}
+
+func (self *JobQueue) Run() {
+  for job := range self.queue {
+    self.setState(job, JOB_STATE_PRINTING)
+    err := self.printJob(job)
+    self.finishJob(job, err)
+
+    if err != nil {
+      status := self.printer.Status()
+      self.metrics.JobFailed(status.ErrorList())
+    } else {
+      self.metrics.JobCompleted()
+    }
+
+    if err != nil {
+      self.settings.logger.Error("job failed", "job", job.Id, "error", err)
+    } else {
+      self.settings.logger.Info("job completed", "job", job.Id)
+    }
+  }
+}
+
+func (self *JobQueue) printJob(job *Job) error {
+  self.printer_mutex.Lock()
+  defer self.printer_mutex.Unlock()
+
+  self.printer.Initialize()
+  if !self.requestStatus() && !self.reopenPrinter() && !self.printer.Simulate {
+    return fmt.Errorf("printer not responding")
+  }
+  status := self.printer.Status()
+  if status.ErrorCode > 0 {
+    return fmt.Errorf("printer error: %s", status.ErrorDescription())
+  }
+
+  if width := self.settings.media_width; width > 0 && uint(status.MediaWidth) != width {
+    return fmt.Errorf("wrong tape: %d mm installed, %d mm expected", status.MediaWidth, width)
+  }
+
+  decode_options := self.settings.DecodeOptions(self.printer)
+  decode_options.threshold = job.PrintOptions(self.settings, self.printer).Threshold
+  images, format, err := job.Render(decode_options, self.settings.font)
+  if err != nil {
+    return err
+  }
+
+  for index, img := range images {
+    options := job.PrintOptions(self.settings, self.printer)
+    if index < len(images) - 1 {
+      //chain the labels, the last one is fed and cut as configured
+      options.Chain = true
+    }
+    raster, err := self.printer.RasterLabel(img, format, options)
+    if err != nil {
+      return err
+    }
+    if err := self.printer.PrintRaster(context.Background(), raster, options); err != nil {
+      return err
+    }
+
+    //the raster lines fitted to the label length are printed, not the image
+    resolution := self.printer.Model().Resolution
+    self.metrics.LabelPrinted(len(raster), resolution)
+    status := self.printer.Status()
+    if err := self.ledger.Record(&status, len(raster), self.printer.FeedMargin(options), resolution, !options.Chain); err != nil {
+      self.settings.logger.Error("updating ledger", "error", err)
+    }
+  }
+
+  return nil
+}
+
+func (self *JobQueue) setState(job *Job, state string) {
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+  job.State = state
+}
+
+func (self *JobQueue) finishJob(job *Job, err error) {
+  status := self.printer.StatusReport()
+  finished := time.Now()
+
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+
+  job.State = JOB_STATE_COMPLETED
+  if err != nil {
+    job.State = JOB_STATE_FAILED
+    job.Error = err.Error()
+  }
+  job.Finished = &finished
+  job.Status = &status
+  job.Image = nil
+  close(job.done)
+}
+
+// Wait blocks until the job is completed or failed.
+func (self *Job) Wait() {
+  <-self.done
+}
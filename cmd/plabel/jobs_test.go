@@ -0,0 +1,48 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "testing"
+  "time"
+  "github.com/spag/plabel"
+)
+
+func TestPrintJobLength(t *testing.T) {
+  queue := testQueue(t)
+  queue.settings.label_length = 20
+  queue.settings.label_align = "center"
+
+  if err := queue.printJob(&Job{Kind: JOB_KIND_TEXT, Text: "plabel"}); err != nil {
+    t.Fatal(err)
+  }
+  //the label is padded to the length between the feed margins
+  lines := MmToDots(20, plabel.LABEL_RESOLUTION) - 2 * int(queue.printer.Model().MinMargin)
+  if queue.metrics.labels_printed != 1 || queue.metrics.raster_lines != uint64(lines) {
+    t.Errorf("%d labels of %d lines printed, expected 1 of %d", queue.metrics.labels_printed, queue.metrics.raster_lines, lines)
+  }
+}
+
+func TestQueryStatusWhilePrinting(t *testing.T) {
+  queue := testQueue(t)
+  queue.QueryStatus()
+
+  //a job holds the printer, the status is answered from the last one received
+  queue.printer_mutex.Lock()
+  defer queue.printer_mutex.Unlock()
+  done := make(chan plabel.StatusReport)
+  go func() {
+    done <- queue.QueryStatus()
+  }()
+  select {
+  case report := <-done:
+    if report.Model.Name != "PT-P700" {
+      t.Errorf("status of %s", report.Model.Name)
+    }
+  case <-time.After(time.Second):
+    t.Fatal("status query waited for the job")
+  }
+}
@@ -0,0 +1,172 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "fmt"
+  "image"
+  "strconv"
+  "strings"
+  "github.com/spag/plabel"
+  "github.com/spag/plabel/render"
+)
+
+const (
+  SERIAL_PLACEHOLDER = "{serial}"
+  DEFAULT_SERIAL_FORMAT = "%d"
+)
+
+// Label is a label image to be printed copies times.
+type Label struct {
+  img image.Image
+  format string
+  copies int
+}
+
+// SerialNumber numbers copies, given as start:step:format on the command
+// line, e.g. 1:1:ASSET-%05d. Step and format are optional.
+type SerialNumber struct {
+  start int
+  step int
+  format string
+}
+
+func ParseSerialNumber(spec string) (*SerialNumber, error) {
+  parts := strings.SplitN(spec, ":", 3)
+  serial := &SerialNumber{step: 1, format: DEFAULT_SERIAL_FORMAT}
+
+  var err error
+  if serial.start, err = strconv.Atoi(parts[0]); err != nil {
+    return nil, fmt.Errorf("invalid serial start %s", parts[0])
+  }
+  if len(parts) > 1 && len(parts[1]) > 0 {
+    if serial.step, err = strconv.Atoi(parts[1]); err != nil {
+      return nil, fmt.Errorf("invalid serial step %s", parts[1])
+    }
+  }
+  if len(parts) > 2 && len(parts[2]) > 0 {
+    serial.format = parts[2]
+  }
+
+  if formatted := fmt.Sprintf(serial.format, serial.start); strings.Contains(formatted, "%!") {
+    return nil, fmt.Errorf("invalid serial format %s", serial.format)
+  }
+  return serial, nil
+}
+
+// Number returns the formatted serial number of a copy.
+func (self *SerialNumber) Number(copy int) string {
+  return fmt.Sprintf(self.format, self.start + copy * self.step)
+}
+
+// LoadLabels loads the label images given by file names, text or barcode,
+// text and barcodes are rendered for the printing width. All files are
+// loaded before the first label is printed, so that a bad file does not
+// break a chain. With serial numbers every copy is rendered on its own,
+// otherwise the copies of a label are printed from the same raster.
+func LoadLabels(settings *Settings, printer *plabel.Plabel) ([]Label, error) {
+  printing_width := printer.PrintingWidth()
+  copies := max(int(settings.copies), 1)
+
+  var serial *SerialNumber
+  if len(settings.serial) > 0 {
+    var err error
+    if serial, err = ParseSerialNumber(settings.serial); err != nil {
+      return nil, err
+    }
+  }
+
+  if len(settings.text) == 0 && len(settings.barcode) == 0 {
+    var labels []Label
+    for _, file_name := range settings.image_files {
+      images, format, err := LoadImages(file_name, settings.DecodeOptions(printer))
+      if err != nil {
+        return nil, fmt.Errorf("%s: %s", file_name, err)
+      }
+      for _, img := range images {
+        labels = append(labels, Label{img, format, copies})
+      }
+    }
+    return labels, nil
+  }
+
+  if serial == nil {
+    img, format, err := RenderLabel(settings, "", printing_width)
+    if err != nil {
+      return nil, err
+    }
+    return []Label{{img, format, copies}}, nil
+  }
+
+  labels := make([]Label, 0, copies)
+  for copy := 0; copy < copies; copy++ {
+    img, format, err := RenderLabel(settings, serial.Number(copy), printing_width)
+    if err != nil {
+      return nil, err
+    }
+    labels = append(labels, Label{img, format, 1})
+  }
+  return labels, nil
+}
+
+// RenderLabel renders the text or barcode label with the serial number
+// replacing the {serial} placeholder.
+func RenderLabel(settings *Settings, serial string, printing_width uint16) (image.Image, string, error) {
+  if len(settings.barcode) > 0 {
+    img, err := render.RenderBarcode(strings.ReplaceAll(settings.barcode, SERIAL_PLACEHOLDER, serial), int(printing_width))
+    return img, "barcode", err
+  }
+  text := strings.ReplaceAll(settings.text, SERIAL_PLACEHOLDER, serial)
+  return render.RenderTextFont(text, int(printing_width), settings.font), "text", nil
+}
+
+// checkLabelArguments collects the image files given as option and arguments,
+// "-" being stdin, and accepts either image files, text or a barcode.
+func checkLabelArguments(command *Command, settings *Settings, arguments []string, max_files int) int {
+  if len(settings.image_file) > 0 {
+    settings.image_files = append(settings.image_files, settings.image_file)
+  }
+  settings.image_files = append(settings.image_files, arguments...)
+
+  if len(settings.image_files) > max_files {
+    return UsageError(command, "only %d file may be given", max_files)
+  }
+  stdin_count := 0
+  for _, file_name := range settings.image_files {
+    if file_name == STDIN_FILE_NAME {
+      stdin_count++
+    }
+  }
+  if stdin_count > 1 {
+    return UsageError(command, "stdin may only be read once")
+  }
+
+  sources := 0
+  for _, given := range []bool{len(settings.image_files) > 0, len(settings.text) > 0, len(settings.barcode) > 0} {
+    if given {
+      sources++
+    }
+  }
+  if sources > 1 {
+    return UsageError(command, "files, --text and --barcode are mutually exclusive")
+  }
+  if sources == 0 {
+    return UsageError(command, "no file, text or barcode given")
+  }
+  if settings.escp && len(settings.image_files) > 0 {
+    return UsageError(command, "--escp prints --text or --barcode, not files")
+  }
+
+  if len(settings.serial) > 0 {
+    if !strings.Contains(settings.text + settings.barcode, SERIAL_PLACEHOLDER) {
+      return UsageError(command, "--serial requires %s in --text or --barcode", SERIAL_PLACEHOLDER)
+    }
+    if _, err := ParseSerialNumber(settings.serial); err != nil {
+      return UsageError(command, "%s", err)
+    }
+  }
+  return EXIT_OK
+}
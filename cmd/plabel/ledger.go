@@ -0,0 +1,286 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "log/slog"
+  "os"
+  "path/filepath"
+  "sync"
+  "syscall"
+  "time"
+  "github.com/spag/plabel"
+  "github.com/spag/plabel/render"
+  "github.com/spag/plabel/transport"
+)
+
+const (
+  DEFAULT_TAPE_LENGTH = 8 //m, length of a standard TZe cassette
+  TAPE_WARN_FRACTION = 0.9
+  TAPE_CUT_LEADER = 24.5 //mm between print head and cutter, fed when a label is cut off
+  LEDGER_FILE_NAME = "tape-ledger.json"
+)
+
+// CassetteRecord is the tape consumption of the cassette installed in a printer.
+type CassetteRecord struct {
+  Serial string `json:"serial"`
+  MediaWidth byte `json:"media_width"`
+  TapeColor byte `json:"tape_color"`
+  MediaType byte `json:"media_type"`
+  Installed time.Time `json:"installed"`
+  Labels uint64 `json:"labels"`
+  RasterLines uint64 `json:"raster_lines"`
+  Used float64 `json:"used_millimeters"`
+}
+
+// PrinterRecord remembers the cassette last seen in a printer, so that a
+// cassette change can be detected.
+type PrinterRecord struct {
+  Cassette string `json:"cassette"`
+}
+
+// TapeLedger keeps a persistent account of the tape used per printer and
+// cassette. P-touch printers do not report the remaining tape, it is
+// estimated from the printed raster lines, feed margins and cut leaders.
+// The ledger file is shared by the print daemon and single print commands,
+// every change re-reads it under a file lock before writing it back.
+type TapeLedger struct {
+  file_name string
+  serial string
+  tape_length float64 //mm
+  logger *slog.Logger
+  mutex sync.Mutex
+
+  Cassettes map[string]*CassetteRecord `json:"cassettes"`
+  Printers map[string]*PrinterRecord `json:"printers"`
+}
+
+// DefaultLedgerFile returns the ledger location below the user state
+// directory ($XDG_STATE_HOME or ~/.local/state).
+func DefaultLedgerFile() string {
+  state_directory := os.Getenv("XDG_STATE_HOME")
+  if len(state_directory) == 0 {
+    home_directory, err := os.UserHomeDir()
+    if err != nil {
+      return ""
+    }
+    state_directory = filepath.Join(home_directory, ".local", "state")
+  }
+  return filepath.Join(state_directory, PROGRAM_NAME, LEDGER_FILE_NAME)
+}
+
+// LedgerSerial identifies the printer in the ledger by its USB serial number,
+// the device path is used if the serial is unknown.
+func LedgerSerial(device_path string) string {
+  if device, ok := transport.LookupPrinterDevice(device_path); ok && len(device.Serial) > 0 {
+    return device.Serial
+  }
+  return device_path
+}
+
+// OpenTapeLedger loads the ledger file for the given printer, a missing file
+// starts an empty ledger. The tape length is given in meters.
+func OpenTapeLedger(file_name string, serial string, tape_length float64, logger *slog.Logger) (*TapeLedger, error) {
+  ledger := &TapeLedger{
+    file_name: file_name,
+    serial: serial,
+    tape_length: tape_length * 1000,
+    logger: logger,
+  }
+  if err := ledger.load(); err != nil {
+    return nil, err
+  }
+  return ledger, nil
+}
+
+func cassetteKey(serial string, status *plabel.PrinterStatus) string {
+  return fmt.Sprintf("%s/%d/%d", serial, status.MediaWidth, status.TapeColor)
+}
+
+// Observe updates the ledger with a printer status. A new record is started
+// when the printer reports a cassette of another width, colour or media type
+// than before. Statuses without media, e.g. while the cover is open to clear
+// a jam, keep the record of the installed cassette.
+func (self *TapeLedger) Observe(status *plabel.PrinterStatus) error {
+  if self == nil || !status.IsValid() {
+    return nil
+  }
+  if status.ErrorCode & (plabel.ERROR_NO_MEDIA | plabel.ERROR_COVER_OPEN) > 0 || status.MediaWidth == 0 {
+    return nil
+  }
+
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+
+  key := cassetteKey(self.serial, status)
+  if self.installed(key, status.MediaType) {
+    return nil
+  }
+
+  return self.update(func() bool {
+    if self.installed(key, status.MediaType) {
+      return false
+    }
+    if record, ok := self.Cassettes[key]; ok && record.MediaType == 0 && self.Printers[self.serial].Cassette == key {
+      //record written before the media type was kept
+      record.MediaType = status.MediaType
+      return true
+    }
+
+    self.Cassettes[key] = &CassetteRecord{
+      Serial: self.serial,
+      MediaWidth: status.MediaWidth,
+      TapeColor: status.TapeColor,
+      MediaType: status.MediaType,
+      Installed: time.Now(),
+    }
+    self.Printers[self.serial] = &PrinterRecord{Cassette: key}
+    return true
+  })
+}
+
+// installed reports whether the printer's current record is the given
+// cassette, mutex must be held.
+func (self *TapeLedger) installed(key string, media_type byte) bool {
+  printer, ok := self.Printers[self.serial]
+  if !ok || printer.Cassette != key {
+    return false
+  }
+  record, ok := self.Cassettes[key]
+  return ok && record.MediaType == media_type
+}
+
+// Record adds a printed label to the installed cassette. The label uses its
+// raster lines plus the feed margins, a label that is fed and cut also uses
+// the cut leader. A warning is logged when the cassette is nearly used up.
+func (self *TapeLedger) Record(status *plabel.PrinterStatus, raster_lines int, margin_dots int, resolution uint16, cut bool) error {
+  if self == nil {
+    return nil
+  }
+  if resolution == 0 {
+    resolution = plabel.LABEL_RESOLUTION
+  }
+
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+
+  key := cassetteKey(self.serial, status)
+  used := 0.0
+  err := self.update(func() bool {
+    record, ok := self.Cassettes[key]
+    if !ok {
+      //no valid status seen yet, e.g. when simulating
+      return false
+    }
+
+    dots := raster_lines + 2 * margin_dots
+    record.Labels++
+    record.RasterLines += uint64(raster_lines)
+    record.Used += float64(dots) / float64(resolution) * render.MM_PER_INCH
+    if cut {
+      record.Used += TAPE_CUT_LEADER
+    }
+    used = record.Used
+    return true
+  })
+
+  if self.tape_length > 0 && used >= self.tape_length * TAPE_WARN_FRACTION {
+    self.logger.Warn("tape nearly used up", "used_m", used / 1000, "length_m", self.tape_length / 1000,
+      "media_width", status.MediaWidth, "tape_color", status.TapeColorDescription())
+  }
+  return err
+}
+
+// Cassette returns a copy of the record of the installed cassette.
+func (self *TapeLedger) Cassette(status *plabel.PrinterStatus) (CassetteRecord, bool) {
+  if self == nil {
+    return CassetteRecord{}, false
+  }
+
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+
+  if record, ok := self.Cassettes[cassetteKey(self.serial, status)]; ok {
+    return *record, true
+  }
+  return CassetteRecord{}, false
+}
+
+// TapeLength returns the configured cassette length in mm.
+func (self *TapeLedger) TapeLength() float64 {
+  return self.tape_length
+}
+
+// update applies a change to the ledger while holding an exclusive lock of
+// the ledger file. The file is re-read first, so that the usage recorded by
+// other processes is kept. The change returns false if it changed nothing.
+// Mutex must be held.
+func (self *TapeLedger) update(change func() bool) error {
+  if err := os.MkdirAll(filepath.Dir(self.file_name), 0755); err != nil {
+    return fmt.Errorf("writing tape ledger: %s", err)
+  }
+  lock_file, err := os.OpenFile(self.file_name + ".lock", os.O_RDWR | os.O_CREATE, 0644)
+  if err != nil {
+    return fmt.Errorf("locking tape ledger: %s", err)
+  }
+  //closing the lock file releases the lock
+  defer lock_file.Close()
+  if err := syscall.Flock(int(lock_file.Fd()), syscall.LOCK_EX); err != nil {
+    return fmt.Errorf("locking tape ledger: %s", err)
+  }
+
+  if err := self.load(); err != nil {
+    return err
+  }
+  if !change() {
+    return nil
+  }
+  return self.save()
+}
+
+// load replaces the records with those of the ledger file, a missing file
+// leaves the ledger empty.
+func (self *TapeLedger) load() error {
+  self.Cassettes = make(map[string]*CassetteRecord)
+  self.Printers = make(map[string]*PrinterRecord)
+
+  data, err := os.ReadFile(self.file_name)
+  if os.IsNotExist(err) {
+    return nil
+  }
+  if err != nil {
+    return fmt.Errorf("reading tape ledger: %s", err)
+  }
+  if err := json.Unmarshal(data, self); err != nil {
+    return fmt.Errorf("decoding tape ledger %s: %s", self.file_name, err)
+  }
+  if self.Cassettes == nil {
+    self.Cassettes = make(map[string]*CassetteRecord)
+  }
+  if self.Printers == nil {
+    self.Printers = make(map[string]*PrinterRecord)
+  }
+  return nil
+}
+
+// save writes the ledger atomically, the ledger file must be locked.
+func (self *TapeLedger) save() error {
+  data, err := json.MarshalIndent(self, "", "  ")
+  if err != nil {
+    return err
+  }
+
+  temp_file_name := self.file_name + ".tmp"
+  if err := os.WriteFile(temp_file_name, data, 0644); err != nil {
+    return fmt.Errorf("writing tape ledger: %s", err)
+  }
+  if err := os.Rename(temp_file_name, self.file_name); err != nil {
+    return fmt.Errorf("writing tape ledger: %s", err)
+  }
+  return nil
+}
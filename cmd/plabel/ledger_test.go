@@ -0,0 +1,110 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "log/slog"
+  "path/filepath"
+  "sync"
+  "testing"
+  "github.com/spag/plabel"
+)
+
+func testStatus(media_width byte, media_type byte, tape_color byte) *plabel.PrinterStatus {
+  return &plabel.PrinterStatus{
+    PrintHeadMark: 0x80,
+    Size: 0x20,
+    ManufacturerCode: 0x42,
+    MediaWidth: media_width,
+    MediaType: media_type,
+    TapeColor: tape_color,
+  }
+}
+
+func testLedger(t *testing.T, file_name string) *TapeLedger {
+  ledger, err := OpenTapeLedger(file_name, "A1B2C3", DEFAULT_TAPE_LENGTH, slog.New(slog.DiscardHandler))
+  if err != nil {
+    t.Fatal(err)
+  }
+  return ledger
+}
+
+func TestTapeLedgerMerge(t *testing.T) {
+  file_name := filepath.Join(t.TempDir(), LEDGER_FILE_NAME)
+  status := testStatus(12, 0x01, 0x01)
+
+  //the print daemon and a single print command share the ledger file
+  daemon := testLedger(t, file_name)
+  command := testLedger(t, file_name)
+  if err := daemon.Observe(status); err != nil {
+    t.Fatal(err)
+  }
+  if err := command.Observe(status); err != nil {
+    t.Fatal(err)
+  }
+
+  var wait sync.WaitGroup
+  for _, ledger := range []*TapeLedger{daemon, command} {
+    wait.Add(1)
+    go func() {
+      defer wait.Done()
+      for range 10 {
+        if err := ledger.Record(status, 180, 0, 180, false); err != nil {
+          t.Error(err)
+        }
+      }
+    }()
+  }
+  wait.Wait()
+
+  record, ok := testLedger(t, file_name).Cassette(status)
+  if !ok {
+    t.Fatal("cassette not recorded")
+  }
+  if record.Labels != 20 || record.RasterLines != 20 * 180 {
+    t.Errorf("recorded %d labels with %d lines, expected 20 with %d", record.Labels, record.RasterLines, 20 * 180)
+  }
+}
+
+func TestTapeLedgerObserve(t *testing.T) {
+  file_name := filepath.Join(t.TempDir(), LEDGER_FILE_NAME)
+  ledger := testLedger(t, file_name)
+
+  status := testStatus(12, 0x01, 0x01)
+  cover_open := testStatus(12, 0x01, 0x01)
+  cover_open.ErrorCode = plabel.ERROR_COVER_OPEN
+  no_media := testStatus(0, 0, 0)
+  no_media.ErrorCode = plabel.ERROR_NO_MEDIA
+
+  for _, test := range []struct {
+    name string
+    status *plabel.PrinterStatus
+    labels uint64
+  } {
+    {"installed", status, 0},
+    {"cover opened", cover_open, 1},
+    {"cover closed", status, 2},
+    {"cassette removed", no_media, 3},
+    {"same cassette", status, 4},
+    {"other width", testStatus(24, 0x01, 0x01), 0},
+    {"other colour", testStatus(24, 0x01, 0x08), 0},
+    {"other media type", testStatus(24, 0x03, 0x08), 0},
+  } {
+    if err := ledger.Observe(test.status); err != nil {
+      t.Fatal(test.name, err)
+    }
+    if test.status.ErrorCode == 0 {
+      status = test.status
+    }
+    record, _ := testLedger(t, file_name).Cassette(status)
+    if record.Labels != test.labels {
+      t.Errorf("%s: %d labels recorded, expected %d", test.name, record.Labels, test.labels)
+    }
+    if err := ledger.Record(status, 180, 0, 180, false); err != nil {
+      t.Fatal(test.name, err)
+    }
+  }
+}
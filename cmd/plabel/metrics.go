@@ -0,0 +1,164 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "fmt"
+  "io"
+  "net/http"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+  "github.com/spag/plabel"
+  "github.com/spag/plabel/render"
+)
+
+var status_poll_buckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Metrics collects the counters of the print daemon and exports them in the
+// Prometheus text format.
+type Metrics struct {
+  mutex sync.Mutex
+
+  labels_printed uint64
+  raster_lines uint64
+  tape_used float64 //mm
+  jobs_completed uint64
+  jobs_failed map[string]uint64
+
+  status_polls uint64
+  status_poll_timeouts uint64
+  status_poll_sum float64
+  status_poll_buckets []uint64
+}
+
+func NewMetrics() *Metrics {
+  return &Metrics{
+    jobs_failed: make(map[string]uint64),
+    status_poll_buckets: make([]uint64, len(status_poll_buckets)),
+  }
+}
+
+func (self *Metrics) LabelPrinted(raster_lines int, resolution uint16) {
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+
+  self.labels_printed++
+  self.raster_lines += uint64(raster_lines)
+  if resolution == 0 {
+    resolution = plabel.LABEL_RESOLUTION
+  }
+  self.tape_used += float64(raster_lines) / float64(resolution) * render.MM_PER_INCH
+}
+
+func (self *Metrics) JobCompleted() {
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+  self.jobs_completed++
+}
+
+// JobFailed counts a failed job for every printer error set, failures without
+// printer error are counted as "other".
+func (self *Metrics) JobFailed(errors []plabel.CodeDescription) {
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+
+  if len(errors) == 0 {
+    self.jobs_failed["other"]++
+  }
+  for _, printer_error := range errors {
+    self.jobs_failed[printer_error.Description]++
+  }
+}
+
+func (self *Metrics) StatusPolled(duration time.Duration, received bool) {
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+
+  if !received {
+    self.status_poll_timeouts++
+    return
+  }
+
+  seconds := duration.Seconds()
+  self.status_polls++
+  self.status_poll_sum += seconds
+  for index, bound := range status_poll_buckets {
+    if seconds <= bound {
+      self.status_poll_buckets[index]++
+    }
+  }
+}
+
+func (self *Metrics) Write(writer io.Writer, status *plabel.PrinterStatus, model_information *plabel.ModelInformation) {
+  self.mutex.Lock()
+  defer self.mutex.Unlock()
+
+  writeMetric(writer, "plabel_labels_printed_total", "counter", "Labels printed.", "", float64(self.labels_printed))
+  writeMetric(writer, "plabel_raster_lines_total", "counter", "Raster lines sent to the printer.", "", float64(self.raster_lines))
+  writeMetric(writer, "plabel_tape_used_millimeters_total", "counter", "Tape used by printed raster lines.", "", self.tape_used)
+  writeMetric(writer, "plabel_jobs_completed_total", "counter", "Jobs printed successfully.", "", float64(self.jobs_completed))
+
+  fmt.Fprintf(writer, "# HELP plabel_jobs_failed_total Failed jobs by printer error.\n# TYPE plabel_jobs_failed_total counter\n")
+  errors := make([]string, 0, len(self.jobs_failed))
+  for description := range self.jobs_failed {
+    errors = append(errors, description)
+  }
+  sort.Strings(errors)
+  for _, description := range errors {
+    fmt.Fprintf(writer, "plabel_jobs_failed_total{error=\"%s\"} %d\n", escapeLabel(description), self.jobs_failed[description])
+  }
+
+  fmt.Fprintf(writer, "# HELP plabel_status_poll_duration_seconds Time until the printer answered a status request.\n# TYPE plabel_status_poll_duration_seconds histogram\n")
+  for index, bound := range status_poll_buckets {
+    fmt.Fprintf(writer, "plabel_status_poll_duration_seconds_bucket{le=\"%g\"} %d\n", bound, self.status_poll_buckets[index])
+  }
+  fmt.Fprintf(writer, "plabel_status_poll_duration_seconds_bucket{le=\"+Inf\"} %d\n", self.status_polls)
+  fmt.Fprintf(writer, "plabel_status_poll_duration_seconds_sum %g\n", self.status_poll_sum)
+  fmt.Fprintf(writer, "plabel_status_poll_duration_seconds_count %d\n", self.status_polls)
+  writeMetric(writer, "plabel_status_poll_timeouts_total", "counter", "Status requests the printer did not answer.", "", float64(self.status_poll_timeouts))
+
+  up := 0.0
+  if status.IsValid() {
+    up = 1
+  }
+  writeMetric(writer, "plabel_printer_up", "gauge", "Printer answered with a valid status.", "", up)
+  writeMetric(writer, "plabel_printer_info", "gauge", "Printer model.", fmt.Sprintf("{model=\"%s\"}", escapeLabel(model_information.ModelName)), 1)
+
+  fmt.Fprintf(writer, "# HELP plabel_printer_error Printer error bits of the last status.\n# TYPE plabel_printer_error gauge\n")
+  for _, printer_error := range plabel.KnownErrors() {
+    value := 0
+    if status.ErrorCode & printer_error.Code > 0 {
+      value = 1
+    }
+    fmt.Fprintf(writer, "plabel_printer_error{code=\"0x%04x\",error=\"%s\"} %d\n", printer_error.Code, escapeLabel(printer_error.Description), value)
+  }
+
+  writeMetric(writer, "plabel_media_width_millimeters", "gauge", "Width of the installed media.", "", float64(status.MediaWidth))
+  writeMetric(writer, "plabel_media_info", "gauge", "Type and colors of the installed media.",
+    fmt.Sprintf("{media_type=\"%s\",tape_color=\"%s\",text_color=\"%s\"}", escapeLabel(status.MediaTypeDescription()), escapeLabel(status.TapeColorDescription()), escapeLabel(status.TextColorDescription())), 1)
+}
+
+func writeMetric(writer io.Writer, name string, kind string, help string, labels string, value float64) {
+  fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n%s%s %g\n", name, help, name, kind, name, labels, value)
+}
+
+func escapeLabel(value string) string {
+  return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func (self *Server) handleMetrics(response http.ResponseWriter, request *http.Request) {
+  status, model_information := self.queue.PrinterStatus()
+
+  response.Header().Set("Content-Type", "text/plain; version=0.0.4")
+  self.queue.metrics.Write(response, &status, &model_information)
+
+  if record, ok := self.queue.ledger.Cassette(&status); ok {
+    writeMetric(response, "plabel_cassette_used_millimeters", "gauge", "Estimated tape used of the installed cassette.", "", record.Used)
+    writeMetric(response, "plabel_cassette_length_millimeters", "gauge", "Configured cassette length.", "", self.queue.ledger.TapeLength())
+  }
+}
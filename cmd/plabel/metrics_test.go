@@ -0,0 +1,43 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "io"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+func TestMetrics(t *testing.T) {
+  queue := testQueue(t)
+  queue.QueryStatus()
+  queue.metrics.LabelPrinted(180, 180)
+  queue.metrics.JobFailed(nil)
+
+  server := httptest.NewServer(NewServer(queue).mux)
+  defer server.Close()
+  response, err := server.Client().Get(server.URL + "/metrics")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer response.Body.Close()
+  body, _ := io.ReadAll(response.Body)
+
+  for _, line := range []string{
+    //the emulator answers at once, the latency is not rounded to the poll interval
+    `plabel_status_poll_duration_seconds_bucket{le="0.01"} 1`,
+    `plabel_status_poll_duration_seconds_count 1`,
+    `plabel_tape_used_millimeters_total 25.4`,
+    `plabel_jobs_failed_total{error="other"} 1`,
+    `plabel_printer_info{model="PT-P700"} 1`,
+    `plabel_media_width_millimeters 12`,
+  } {
+    if !strings.Contains(string(body), line + "\n") {
+      t.Errorf("missing %s in\n%s", line, body)
+    }
+  }
+}
@@ -0,0 +1,291 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022 
+ */
+
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "log/slog"
+  "os"
+	"image"
+  _ "image/gif"
+	_ "image/png"
+	_ "image/jpeg"
+	"github.com/spag/plabel"
+  "github.com/spag/plabel/render"
+  "github.com/spag/plabel/transport"
+)
+
+const (
+  PROGRAM_NAME = "plabel"
+  PROGRAM_VERSION = "0.0.1"
+
+  STDIN_FILE_NAME = "-"
+)
+
+type Settings struct {
+  pid_file string
+  printer_device string
+  image_file string
+  image_files []string
+  text string
+  barcode string
+  serial string
+  copies uint
+  output_file string
+  terminal string
+  tape_color string
+  ink_color string
+  model string
+  media_width uint
+  black_threshold uint
+  verbose uint
+  simulate bool
+  emulate bool
+  record_file string
+  output_format string
+  log_format string
+  logger *slog.Logger
+  batch_mode bool
+  no_front_cut bool
+  mirror bool
+  listen_address string
+  raw_address string
+  spool_directory string
+  poll_interval uint
+  ledger_file string
+  tape_length float64
+  config_file string
+  profile string
+  feed_margin float64
+  label_length float64
+  label_align string
+  dither bool
+  half_cut bool
+  font string
+  page uint
+  escp bool
+  escp_size uint
+  escp_bold bool
+  escp_italic bool
+  escp_align string
+  templates map[string]TemplateConfig
+}
+
+// PrintOptions returns the options for printing one label, lengths in mm
+// are converted to dots of the printer. A negative feed margin keeps the
+// printer default.
+func (self *Settings) PrintOptions(printer *plabel.Plabel) plabel.PrintOptions {
+  resolution := printer.Model().Resolution
+  //an unknown alignment is reported by the command, labels are aligned left
+  align, _ := plabel.ParseAlign(self.label_align)
+  return plabel.PrintOptions{
+    Threshold: byte(self.black_threshold),
+    Dither: self.dither,
+    Mirror: self.mirror,
+    NoFrontCut: self.no_front_cut,
+    HalfCut: self.half_cut,
+    Chain: self.batch_mode,
+    SetFeedMargin: self.feed_margin >= 0,
+    FeedMargin: uint16(MmToDots(max(self.feed_margin, 0), resolution)),
+    Length: MmToDots(self.label_length, resolution),
+    Align: align,
+  }
+}
+
+// DecodeOptions control how label files are decoded: drawings are rendered
+// for the label height in pixels, PDF pages at the printer resolution and
+// trimmed to the content darker than the threshold.
+type DecodeOptions struct {
+  height int
+  resolution uint16
+  page int
+  threshold byte
+}
+
+func (self *Settings) DecodeOptions(printer *plabel.Plabel) DecodeOptions {
+  resolution := printer.Model().Resolution
+  if resolution == 0 {
+    resolution = plabel.LABEL_RESOLUTION
+  }
+  return DecodeOptions{int(printer.PrintingWidth()), resolution, max(int(self.page), 1), byte(self.black_threshold)}
+}
+
+func CopyrightMessage() {
+  fmt.Fprintf(os.Stderr, "%s - Version %s\nCopyright (c) 2021-2022, sipomat ltd.\n\n", PROGRAM_NAME, PROGRAM_VERSION)
+}
+
+func CreatePIDFile(pid_file_name string) error {
+  pid_file, err := os.Create(pid_file_name)
+  if err != nil {
+    return fmt.Errorf("error creating PID file: %s\n", err)
+  }
+
+  defer pid_file.Close()
+
+  if _, err = fmt.Fprintf(pid_file, "%d", os.Getpid()) ;  err != nil {
+    return fmt.Errorf("error creating PID file: %s\n", err)
+  }
+
+  return nil
+}
+
+func ListPrinters() {
+  devices := transport.FindPrinters(transport.SYSFS_ROOT, transport.DEVICE_ROOT)
+  if len(devices) == 0 {
+    fmt.Println("No printers found")
+    return
+  }
+
+  fmt.Printf("%-16s %-9s %-16s %s\n", "Device", "USB-ID", "Serial", "Model")
+  for _, device := range devices {
+    device.QueryStatus(transport.DISCOVERY_TIMEOUT)
+    fmt.Printf("%-16s %04x:%04x %-16s %s\n", device.DevicePath, device.VendorID, device.ProductID, device.Serial, device.ModelName())
+  }
+}
+
+// LoadImages decodes an image file, "-" reads the image from stdin. SVG
+// drawings, PDF pages and ZPL labels are rendered as given by the options.
+func LoadImages(file_name string, options DecodeOptions) ([]image.Image, string, error) {
+  var data []byte
+  var err error
+  if file_name == STDIN_FILE_NAME {
+    data, err = io.ReadAll(os.Stdin)
+  } else {
+    data, err = os.ReadFile(file_name)
+  }
+  if err != nil {
+    return nil, "", fmt.Errorf("opening file: %s", err)
+  }
+
+  images, format, err := DecodeImages(data, options)
+  if err != nil {
+    return nil, "", fmt.Errorf("decoding file: %s", err)
+  }
+
+  return images, format, nil
+}
+
+// DecodeImages decodes png, jpeg, gif, svg, pdf or zpl data. SVG drawings
+// are rasterized at the printer resolution with the drawing height mapped
+// to the label height. PDF pages are rasterized at the printer resolution
+// and the white margins are trimmed, so that a label exported on a larger
+// page prints at its original size. ZPL data yields an image for every
+// label format it contains, other data a single image.
+func DecodeImages(data []byte, options DecodeOptions) ([]image.Image, string, error) {
+  if render.IsZPL(data) {
+    labels, err := render.RenderZPL(data, options.height, float64(options.resolution))
+    if err != nil {
+      return nil, "", err
+    }
+    images := make([]image.Image, len(labels))
+    for index, label := range labels {
+      images[index] = label
+    }
+    return images, "zpl", nil
+  }
+
+  img, format, err := decodeImage(data, options)
+  if err != nil {
+    return nil, "", err
+  }
+  return []image.Image{img}, format, nil
+}
+
+func decodeImage(data []byte, options DecodeOptions) (image.Image, string, error) {
+  if render.IsSVG(data) {
+    img, err := render.RenderSVG(data, options.height)
+    return img, "svg", err
+  }
+  if render.IsPDF(data) {
+    page, err := render.RenderPDF(data, options.page, float64(options.resolution))
+    if err != nil {
+      return nil, "", err
+    }
+    img := render.TrimImage(page, options.threshold)
+    if img == nil {
+      return nil, "", fmt.Errorf("page %d is empty", options.page)
+    }
+    return img, "pdf", nil
+  }
+  return image.Decode(bytes.NewReader(data))
+}
+
+func SendFile(printer *plabel.Plabel, file_name string, options DecodeOptions) bool {
+  images, format, err := LoadImages(file_name, options)
+	if err != nil {
+    fmt.Fprintf(os.Stderr, "ERROR %s\n", err)
+		return false
+	}
+
+  for _, img := range images {
+    if !printer.SendImage(img, format, options.threshold) {
+      return false
+    }
+  }
+  return true
+}
+
+// ShowInfo writes the printer and media information of the info command.
+func ShowInfo(output io.Writer, printer *plabel.Plabel) {
+  status := printer.Status()
+  model_information := printer.Model()
+  if status.ErrorCode > 0 {
+    fmt.Fprintf(output, "Printer Status - ERROR: (%02x) %s\n", status.ErrorCode, status.ErrorDescription())
+  }
+
+  fmt.Fprintf(output, "\nPrinter:\n")
+  fmt.Fprintf(output, "Model.........: (%02X) %s\n", status.ModelCode, model_information.ModelName)
+  fmt.Fprintf(output, "Pixel width...: %d\n", model_information.PixelWidth)
+  fmt.Fprintf(output, "Resolution....: %d dpi\n", model_information.Resolution)
+  fmt.Fprintf(output, "\nMedia:\n")
+  fmt.Fprintf(output, "Type..........: (%d) %s\n", status.MediaType, status.MediaTypeDescription())
+  fmt.Fprintf(output, "Width.........: %d mm\n", status.MediaWidth)
+  fmt.Fprintf(output, "Length........: %d mm\n", status.MediaLength)
+  fmt.Fprintf(output, "Color.........: %s, text: %s\n", status.TapeColorDescription(), status.TextColorDescription())
+  fmt.Fprintf(output, "Pixel width...: %d\n", plabel.MediaWidthToMaxPixel(status.MediaWidth, model_information.Resolution))
+  fmt.Fprintf(output, "\nPrinting:\n")
+  fmt.Fprintf(output, "Max. width....: %d px\n", printer.PrintingWidth())
+  fmt.Fprintln(output)
+}
+
+// ShowInfoJson writes the status report of the printer as JSON.
+func ShowInfoJson(output io.Writer, printer *plabel.Plabel) error {
+  encoder := json.NewEncoder(output)
+  encoder.SetIndent("", "  ")
+  if err := encoder.Encode(printer.StatusReport()); err != nil {
+    return fmt.Errorf("encoding printer information: %s", err)
+  }
+  return nil
+}
+
+func ShowTapeUsage(ledger *TapeLedger, status *plabel.PrinterStatus) {
+  record, ok := ledger.Cassette(status)
+  if !ok {
+    return
+  }
+
+  fmt.Printf("Tape used.....: %.2f m", record.Used / 1000)
+  if ledger.TapeLength() > 0 {
+    fmt.Printf(" of %.2f m", ledger.TapeLength() / 1000)
+  }
+  fmt.Printf(" (%d labels since %s)\n", record.Labels, record.Installed.Format("2006-01-02"))
+}
+
+// MmToDots converts a length in mm into dots of the resolution, with
+// LABEL_RESOLUTION for printers of unknown resolution.
+func MmToDots(mm float64, resolution uint16) int {
+  if resolution == 0 {
+    resolution = plabel.LABEL_RESOLUTION
+  }
+  return int(mm / render.MM_PER_INCH * float64(resolution) + 0.5)
+}
+
+func main() {
+  os.Exit(RunCommand(os.Args[1:]))
+}
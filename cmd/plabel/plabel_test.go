@@ -0,0 +1,42 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "strings"
+  "testing"
+  "github.com/spag/plabel"
+)
+
+func TestShowInfo(t *testing.T) {
+  queue := testQueue(t)
+  queue.QueryStatus()
+
+  var output bytes.Buffer
+  ShowInfo(&output, queue.printer)
+  for _, line := range []string{
+    "Model.........: (67) PT-P700",
+    "Width.........: 12 mm",
+  } {
+    if !strings.Contains(output.String(), line + "\n") {
+      t.Errorf("missing %s in\n%s", line, output.String())
+    }
+  }
+
+  output.Reset()
+  if err := ShowInfoJson(&output, queue.printer); err != nil {
+    t.Fatal(err)
+  }
+  var report plabel.StatusReport
+  if err := json.Unmarshal(output.Bytes(), &report); err != nil {
+    t.Fatal(err)
+  }
+  if report.MaxPrintingWidth != queue.printer.PrintingWidth() {
+    t.Errorf("max printing width %d, expected %d", report.MaxPrintingWidth, queue.printer.PrintingWidth())
+  }
+}
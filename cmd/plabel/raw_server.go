@@ -0,0 +1,95 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "net"
+  "os"
+  "time"
+)
+
+const (
+  RAW_IDLE_TIMEOUT = 5 //s, a pause this long ends the document of a connection
+  RAW_READ_SIZE = 64 << 10
+)
+
+// RawServer accepts documents on a raw socket (AppSocket, usually port
+// 9100) like a Zebra printer, so that systems sending ZPL can print without
+// changes. Every connection is a job, the document ends when the client
+// closes the connection or pauses sending.
+type RawServer struct {
+  queue *JobQueue
+}
+
+func NewRawServer(queue *JobQueue) *RawServer {
+  return &RawServer{queue: queue}
+}
+
+// ListenAndServe accepts connections on the address, it returns when the
+// socket cannot be opened.
+func (self *RawServer) ListenAndServe(address string) error {
+  listener, err := net.Listen("tcp", address)
+  if err != nil {
+    return fmt.Errorf("serving raw socket on %s: %s", address, err)
+  }
+  self.queue.settings.logger.Info("raw socket listening", "address", address)
+
+  for {
+    connection, err := listener.Accept()
+    if err != nil {
+      self.queue.settings.logger.Error("raw socket", "error", err)
+      continue
+    }
+    go self.handleConnection(connection)
+  }
+}
+
+func (self *RawServer) handleConnection(connection net.Conn) {
+  defer connection.Close()
+
+  document, err := readRawDocument(connection)
+  if err != nil {
+    self.queue.settings.logger.Error("raw socket - reading document", "error", err)
+    return
+  }
+  if len(document) == 0 {
+    return
+  }
+
+  host, _, _ := net.SplitHostPort(connection.RemoteAddr().String())
+  job := &Job{Kind: JOB_KIND_IMAGE, Name: "raw", User: host, Image: document}
+  if err := self.queue.Submit(job); err != nil {
+    self.queue.settings.logger.Error("raw socket - submitting job", "client", host, "error", err)
+    return
+  }
+  self.queue.settings.logger.Info("raw socket - queued job", "job", job.Id, "client", host)
+}
+
+// readRawDocument reads until the client closes the connection or sends
+// nothing for RAW_IDLE_TIMEOUT, a silent client yields an empty document.
+func readRawDocument(connection net.Conn) ([]byte, error) {
+  var document []byte
+  buffer := make([]byte, RAW_READ_SIZE)
+  for {
+    connection.SetReadDeadline(time.Now().Add(RAW_IDLE_TIMEOUT * time.Second))
+    count, err := connection.Read(buffer)
+    document = append(document, buffer[:count]...)
+    if len(document) > MAX_JOB_SIZE {
+      return nil, fmt.Errorf("document larger than %d bytes", MAX_JOB_SIZE)
+    }
+
+    switch {
+    case err == nil:
+      continue
+    case errors.Is(err, io.EOF), errors.Is(err, os.ErrDeadlineExceeded):
+      return document, nil
+    }
+    return nil, err
+  }
+}
@@ -0,0 +1,163 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "net/http"
+  "strconv"
+  "strings"
+)
+
+const (
+  DEFAULT_LISTEN_ADDRESS = ":8631"
+  MAX_JOB_SIZE = 16 << 20
+  SHUTDOWN_TIMEOUT = 60 //s waiting for the job printing
+)
+
+// Server exposes the job queue as REST API:
+//
+//   POST /jobs         submit a job, either as JSON document or as raw image
+//   GET  /jobs         list the jobs
+//   GET  /jobs/{id}    get the job state
+//   GET  /status       get the printer status
+//   GET  /metrics      Prometheus metrics
+//
+// The IPP print service is available at /ipp/print on the same address.
+type Server struct {
+  queue *JobQueue
+  mux *http.ServeMux
+}
+
+func NewServer(queue *JobQueue) *Server {
+  self := &Server{queue: queue, mux: http.NewServeMux()}
+  self.mux.HandleFunc("/jobs", self.handleJobs)
+  self.mux.HandleFunc("/jobs/", self.handleGetJob)
+  self.mux.HandleFunc("/status", self.handleStatus)
+  self.mux.HandleFunc("/metrics", self.handleMetrics)
+  self.mux.Handle(IPP_PATH, NewIppServer(queue))
+  return self
+}
+
+// ListenAndServe serves HTTP on the address, it returns when the server
+// fails.
+func (self *Server) ListenAndServe(address string) error {
+  self.queue.settings.logger.Info("listening", "address", address)
+  if err := http.ListenAndServe(address, self.mux); err != nil {
+    return fmt.Errorf("serving HTTP on %s: %s", address, err)
+  }
+  return nil
+}
+
+func (self *Server) handleJobs(response http.ResponseWriter, request *http.Request) {
+  switch request.Method {
+  case http.MethodPost:
+    self.handleSubmitJob(response, request)
+  case http.MethodGet:
+    writeJson(response, http.StatusOK, self.queue.Jobs())
+  default:
+    writeError(response, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+  }
+}
+
+func (self *Server) handleSubmitJob(response http.ResponseWriter, request *http.Request) {
+  var job Job
+
+  body, err := io.ReadAll(http.MaxBytesReader(response, request.Body, MAX_JOB_SIZE))
+  if err != nil {
+    writeError(response, http.StatusRequestEntityTooLarge, err)
+    return
+  }
+
+  if strings.HasPrefix(request.Header.Get("Content-Type"), "application/json") {
+    if err := json.Unmarshal(body, &job); err != nil {
+      writeError(response, http.StatusBadRequest, err)
+      return
+    }
+  } else {
+    job.Kind = JOB_KIND_IMAGE
+    job.Image = body
+    if err := readJobOptions(&job, request); err != nil {
+      writeError(response, http.StatusBadRequest, err)
+      return
+    }
+  }
+
+  if err := self.queue.Submit(&job); errors.Is(err, ErrJobQueueFull) {
+    response.Header().Set("Retry-After", strconv.Itoa(JOB_RETRY_AFTER))
+    writeError(response, http.StatusServiceUnavailable, err)
+    return
+  } else if err != nil {
+    writeError(response, http.StatusBadRequest, err)
+    return
+  }
+
+  response.Header().Set("Location", fmt.Sprintf("/jobs/%d", job.Id))
+  current, _ := self.queue.Job(job.Id)
+  writeJson(response, http.StatusAccepted, current)
+}
+
+// Raw image uploads carry their options as query parameters.
+func readJobOptions(job *Job, request *http.Request) error {
+  query := request.URL.Query()
+
+  if value := query.Get("threshold"); len(value) > 0 {
+    threshold, err := strconv.ParseUint(value, 10, 8)
+    if err != nil {
+      return fmt.Errorf("invalid threshold: %s", value)
+    }
+    job.Threshold = uint(threshold)
+  }
+
+  job.Mirror = query.Get("mirror") == "1" || query.Get("mirror") == "true"
+  job.NoCut = query.Get("no_cut") == "1" || query.Get("no_cut") == "true"
+  job.BatchMode = query.Get("batch_mode") == "1" || query.Get("batch_mode") == "true"
+  return nil
+}
+
+func (self *Server) handleGetJob(response http.ResponseWriter, request *http.Request) {
+  if request.Method != http.MethodGet {
+    writeError(response, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+    return
+  }
+
+  id, err := strconv.Atoi(strings.TrimPrefix(request.URL.Path, "/jobs/"))
+  if err != nil {
+    writeError(response, http.StatusBadRequest, fmt.Errorf("invalid job id"))
+    return
+  }
+
+  job, ok := self.queue.Job(id)
+  if !ok {
+    writeError(response, http.StatusNotFound, fmt.Errorf("job %d not found", id))
+    return
+  }
+
+  writeJson(response, http.StatusOK, job)
+}
+
+func (self *Server) handleStatus(response http.ResponseWriter, request *http.Request) {
+  if request.Method != http.MethodGet {
+    writeError(response, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+    return
+  }
+  writeJson(response, http.StatusOK, self.queue.QueryStatus())
+}
+
+func writeJson(response http.ResponseWriter, status int, value any) {
+  response.Header().Set("Content-Type", "application/json")
+  response.WriteHeader(status)
+  encoder := json.NewEncoder(response)
+  encoder.SetIndent("", "  ")
+  encoder.Encode(value)
+}
+
+func writeError(response http.ResponseWriter, status int, err error) {
+  writeJson(response, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,189 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+const (
+  SPOOL_INCOMING = "incoming"
+  SPOOL_ACTIVE = "active"
+  SPOOL_DONE = "done"
+  SPOOL_FAILED = "failed"
+  SPOOL_RESULT_SUFFIX = ".result.json"
+
+  SPOOL_POLL_INTERVAL = 1000 //ms
+  SPOOL_SETTLE_TIME = 1000 //ms, files modified more recently are still being written
+)
+
+// Spool prints the files dropped into <directory>/incoming. A file is moved
+// to active while it is printed and to done or failed afterwards, together
+// with a .result.json sidecar. Files found in active on start are left over
+// from a crash and are printed again. A file named like an earlier job gets a
+// sequence suffix, e.g. label-1.png.
+type Spool struct {
+  directory string
+  queue *JobQueue
+}
+
+type SpoolResult struct {
+  File string `json:"file"`
+  Job
+}
+
+func NewSpool(directory string, queue *JobQueue) (*Spool, error) {
+  for _, name := range []string{SPOOL_INCOMING, SPOOL_ACTIVE, SPOOL_DONE, SPOOL_FAILED} {
+    if err := os.MkdirAll(filepath.Join(directory, name), 0755); err != nil {
+      return nil, fmt.Errorf("creating spool directory: %s", err)
+    }
+  }
+  return &Spool{directory: directory, queue: queue}, nil
+}
+
+func (self *Spool) Run() {
+  for _, name := range self.listFiles(SPOOL_ACTIVE, 0) {
+    self.queue.settings.logger.Info("spool - retrying job", "file", name)
+    self.processFile(name)
+  }
+
+  for {
+    for _, name := range self.listFiles(SPOOL_INCOMING, SPOOL_SETTLE_TIME * time.Millisecond) {
+      active_name, err := self.moveFile(name, SPOOL_INCOMING, SPOOL_ACTIVE)
+      if err != nil {
+        self.queue.settings.logger.Error("spool - moving job", "file", name, "error", err)
+        continue
+      }
+      self.processFile(active_name)
+    }
+    time.Sleep(SPOOL_POLL_INTERVAL * time.Millisecond)
+  }
+}
+
+// listFiles returns the job files of a spool directory in order of arrival.
+func (self *Spool) listFiles(directory string, settle_time time.Duration) []string {
+  entries, err := os.ReadDir(filepath.Join(self.directory, directory))
+  if err != nil {
+    self.queue.settings.logger.Error("spool - reading directory", "directory", directory, "error", err)
+    return nil
+  }
+
+  type spool_file struct {
+    name string
+    modified time.Time
+  }
+
+  var files []spool_file
+  for _, entry := range entries {
+    if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+      continue
+    }
+    info, err := entry.Info()
+    if err != nil || time.Since(info.ModTime()) < settle_time {
+      continue
+    }
+    files = append(files, spool_file{entry.Name(), info.ModTime()})
+  }
+
+  sort.Slice(files, func(i, j int) bool {
+    if files[i].modified.Equal(files[j].modified) {
+      return files[i].name < files[j].name
+    }
+    return files[i].modified.Before(files[j].modified)
+  })
+
+  names := make([]string, len(files))
+  for index, file := range files {
+    names[index] = file.name
+  }
+  return names
+}
+
+func (self *Spool) processFile(name string) {
+  result := SpoolResult{File: name}
+
+  job, err := self.readJob(name)
+  if err == nil {
+    err = self.queue.Submit(job)
+  }
+
+  if err == nil {
+    job.Wait()
+    result.Job, _ = self.queue.Job(job.Id)
+  } else {
+    if job != nil {
+      result.Job = job.Copy()
+    }
+    finished := time.Now()
+    status := self.queue.printer.StatusReport()
+    result.Status = &status
+    result.State = JOB_STATE_FAILED
+    result.Error = err.Error()
+    result.Finished = &finished
+  }
+
+  target := SPOOL_DONE
+  if result.State != JOB_STATE_COMPLETED {
+    target = SPOOL_FAILED
+    self.queue.settings.logger.Error("spool - job failed", "file", name, "error", result.Error)
+  }
+
+  target_name, err := self.moveFile(name, SPOOL_ACTIVE, target)
+  if err != nil {
+    self.queue.settings.logger.Error("spool - moving job", "file", name, "error", err)
+  }
+
+  data, _ := json.MarshalIndent(result, "", "  ")
+  if err := os.WriteFile(self.path(target, target_name + SPOOL_RESULT_SUFFIX), data, 0644); err != nil {
+    self.queue.settings.logger.Error("spool - writing result", "file", name, "error", err)
+  }
+}
+
+// readJob reads a job description (.json) or an image file.
+func (self *Spool) readJob(name string) (*Job, error) {
+  data, err := os.ReadFile(self.path(SPOOL_ACTIVE, name))
+  if err != nil {
+    return nil, err
+  }
+
+  job := new(Job)
+  if strings.EqualFold(filepath.Ext(name), ".json") {
+    if err := json.Unmarshal(data, job); err != nil {
+      return nil, fmt.Errorf("decoding job: %s", err)
+    }
+  } else {
+    job.Kind = JOB_KIND_IMAGE
+    job.Image = data
+  }
+  return job, nil
+}
+
+// moveFile moves a job file between spool directories and returns its name
+// in the target directory. An earlier job or result of the same name is kept,
+// the file gets the first free sequence suffix instead.
+func (self *Spool) moveFile(name string, from string, to string) (string, error) {
+  extension := filepath.Ext(name)
+  target := name
+  for sequence := 1; self.exists(to, target) || self.exists(to, target + SPOOL_RESULT_SUFFIX); sequence++ {
+    target = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, extension), sequence, extension)
+  }
+  return target, os.Rename(self.path(from, name), self.path(to, target))
+}
+
+func (self *Spool) exists(directory string, name string) bool {
+  _, err := os.Lstat(self.path(directory, name))
+  return !os.IsNotExist(err)
+}
+
+func (self *Spool) path(directory string, name string) string {
+  return filepath.Join(self.directory, directory, name)
+}
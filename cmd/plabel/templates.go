@@ -0,0 +1,114 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+  "github.com/spag/plabel"
+)
+
+type TemplateEntry struct {
+  Name string `json:"name"`
+  Key uint `json:"key"`
+  Description string `json:"description,omitempty"`
+  Objects []string `json:"objects,omitempty"`
+}
+
+// FindTemplate looks up a template by its configured name or key, keys of
+// templates that are not configured are accepted as they are.
+func FindTemplate(templates map[string]TemplateConfig, name string) (int, TemplateConfig, error) {
+  if template, ok := templates[name]; ok {
+    return int(template.Key), template, nil
+  }
+
+  key, err := strconv.Atoi(name)
+  if err != nil {
+    return 0, TemplateConfig{}, fmt.Errorf("unknown template %s", name)
+  }
+  for _, template := range templates {
+    if int(template.Key) == key {
+      return key, template, nil
+    }
+  }
+  return key, TemplateConfig{}, nil
+}
+
+// TemplateObjects parses the object data given as object=data. Data without
+// object name fills the configured objects of the template in order.
+func TemplateObjects(template TemplateConfig, arguments []string) ([]plabel.TemplateObject, error) {
+  var objects []plabel.TemplateObject
+  position := 0
+  for _, argument := range arguments {
+    name, data, named := strings.Cut(argument, "=")
+    if named && len(template.Objects) > 0 && !templateHasObject(template, name) {
+      //the data itself contains =
+      named = false
+    }
+    if !named {
+      if position >= len(template.Objects) {
+        return nil, fmt.Errorf("no object for %q, use object=data", argument)
+      }
+      name, data = template.Objects[position], argument
+      position++
+    }
+    objects = append(objects, plabel.TemplateObject{Name: name, Data: data})
+  }
+  return objects, nil
+}
+
+func templateHasObject(template TemplateConfig, name string) bool {
+  for _, object := range template.Objects {
+    if object == name {
+      return true
+    }
+  }
+  return false
+}
+
+// ListTemplates shows the configured templates sorted by key.
+func ListTemplates(templates map[string]TemplateConfig, output_format string) {
+  entries := make([]TemplateEntry, 0, len(templates))
+  for name, template := range templates {
+    entries = append(entries, TemplateEntry{name, template.Key, template.Description, template.Objects})
+  }
+  sort.Slice(entries, func(i int, j int) bool {
+    return entries[i].Key < entries[j].Key || entries[i].Key == entries[j].Key && entries[i].Name < entries[j].Name
+  })
+
+  if output_format == "json" {
+    data, _ := json.MarshalIndent(entries, "", "  ")
+    fmt.Println(string(data))
+    return
+  }
+
+  if len(entries) == 0 {
+    fmt.Fprintln(os.Stderr, "No templates configured, add [templates.<name>] to the configuration file")
+    return
+  }
+  fmt.Printf("%-4s %-16s %-24s %s\n", "Key", "Name", "Objects", "Description")
+  for _, entry := range entries {
+    fmt.Printf("%-4d %-16s %-24s %s\n", entry.Key, entry.Name, strings.Join(entry.Objects, ","), entry.Description)
+  }
+}
+
+// PrintTemplate prints a stored template and waits for the printer, like
+// PrintRaster for raster labels.
+func PrintTemplate(printer *plabel.Plabel, key int, objects []plabel.TemplateObject, copies int) error {
+  if err := printer.PrintTemplate(key, objects, copies); err != nil {
+    return err
+  }
+
+  ctx, cancel := context.WithTimeout(context.Background(), time.Duration(min(10000 * copies, 60000)) * time.Millisecond)
+  defer cancel()
+  return printer.WaitForPrinting(ctx)
+}
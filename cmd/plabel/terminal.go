@@ -0,0 +1,124 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "fmt"
+  "image"
+  "image/color"
+  "io"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+  "syscall"
+  "unsafe"
+  "github.com/spag/plabel/render"
+)
+
+const (
+  TERMINAL_AUTO = "auto"
+  TERMINAL_PICTURE_SCALE = 2 //graphics pixels per printed dot
+  DEFAULT_TERMINAL_COLUMNS = 80
+)
+
+// tape_colors are the colors of the tapes and inks shown by the preview.
+var tape_colors = map[string]color.RGBA{
+  "white": {0xff, 0xff, 0xff, 0xff},
+  "clear": {0xe8, 0xe8, 0xe0, 0xff},
+  "black": {0x10, 0x10, 0x10, 0xff},
+  "red": {0xd0, 0x20, 0x20, 0xff},
+  "blue": {0x20, 0x50, 0xc0, 0xff},
+  "yellow": {0xf8, 0xd8, 0x20, 0xff},
+  "green": {0x30, 0xa0, 0x40, 0xff},
+  "silver": {0xc0, 0xc0, 0xc8, 0xff},
+  "gold": {0xd4, 0xaf, 0x37, 0xff},
+  "orange": {0xff, 0x80, 0x20, 0xff},
+  "pink": {0xff, 0x80, 0xc0, 0xff},
+}
+
+func TapeColorNames() string {
+  names := make([]string, 0, len(tape_colors))
+  for name := range tape_colors {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return strings.Join(names, ", ")
+}
+
+// TerminalColors returns the colors of the tape and ink given by name.
+func (self *Settings) TerminalColors() (render.TerminalColors, error) {
+  tape, ok := tape_colors[strings.ToLower(self.tape_color)]
+  if !ok {
+    return render.TerminalColors{}, fmt.Errorf("unknown tape color %s", self.tape_color)
+  }
+  ink, ok := tape_colors[strings.ToLower(self.ink_color)]
+  if !ok {
+    return render.TerminalColors{}, fmt.Errorf("unknown ink color %s", self.ink_color)
+  }
+  return render.TerminalColors{Tape: tape, Ink: ink, Ruler: color.RGBA{0x80, 0x80, 0x80, 0xff}}, nil
+}
+
+// TerminalMode returns the graphics of the terminal for the mode, auto
+// selects kitty or sixel graphics for terminals known to support them.
+func TerminalMode(mode string) (string, error) {
+  switch mode {
+  case render.TERMINAL_BLOCKS, render.TERMINAL_SIXEL, render.TERMINAL_KITTY:
+    return mode, nil
+  case TERMINAL_AUTO, "":
+  default:
+    return "", fmt.Errorf("unknown terminal mode %s", mode)
+  }
+
+  term := os.Getenv("TERM")
+  program := os.Getenv("TERM_PROGRAM")
+  switch {
+  case len(os.Getenv("KITTY_WINDOW_ID")) > 0 || term == "xterm-kitty" || term == "xterm-ghostty" || program == "WezTerm":
+    return render.TERMINAL_KITTY, nil
+  case strings.Contains(term, "sixel") || strings.HasPrefix(term, "foot") || term == "mlterm" || program == "iTerm.app":
+    return render.TERMINAL_SIXEL, nil
+  }
+  return render.TERMINAL_BLOCKS, nil
+}
+
+// TerminalColumns returns the width of the terminal on stdout.
+func TerminalColumns() int {
+  var size struct {
+    rows, columns, width, height uint16
+  }
+  _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+  if errno == 0 && size.columns > 0 {
+    return int(size.columns)
+  }
+  if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+    return columns
+  }
+  return DEFAULT_TERMINAL_COLUMNS
+}
+
+// TapeImage places the printed area of the label centered on the full
+// width of the tape.
+func TapeImage(preview *image.Gray, media_width uint, resolution uint16) *image.Gray {
+  height := max(int(float64(media_width) * float64(resolution) / render.MM_PER_INCH + 0.5), preview.Bounds().Dy())
+  tape := render.NewWhiteImage(preview.Bounds().Dx(), height)
+  top := (height - preview.Bounds().Dy()) / 2
+  for y := 0; y < preview.Bounds().Dy(); y++ {
+    copy(tape.Pix[(top + y) * tape.Stride:], preview.Pix[y * preview.Stride:y * preview.Stride + preview.Bounds().Dx()])
+  }
+  return tape
+}
+
+// WriteTerminalPreview shows the label as it looks on tape with a ruler in
+// mm in the terminal.
+func WriteTerminalPreview(writer io.Writer, tape *image.Gray, mode string, colors render.TerminalColors, resolution uint16) error {
+  switch mode {
+  case render.TERMINAL_SIXEL:
+    return render.WriteSixel(writer, render.TerminalPicture(tape, colors, resolution, TERMINAL_PICTURE_SCALE))
+  case render.TERMINAL_KITTY:
+    return render.WriteKitty(writer, render.TerminalPicture(tape, colors, resolution, TERMINAL_PICTURE_SCALE))
+  }
+  return render.WriteHalfBlocks(writer, tape, colors, resolution, TerminalColumns())
+}
@@ -0,0 +1,280 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// ParseToml parses the subset of TOML used by the configuration file: tables,
+// dotted and quoted keys, basic and literal strings, integers, floats,
+// booleans and single-line arrays. Values are string, int64, float64, bool
+// and []any, tables are map[string]any.
+func ParseToml(data string) (map[string]any, error) {
+  root := make(map[string]any)
+  table := root
+
+  for number, line := range strings.Split(data, "\n") {
+    parser := toml_parser{line: strings.TrimSpace(line)}
+    if parser.end() {
+      continue
+    }
+
+    var err error
+    if strings.HasPrefix(parser.line, "[[") {
+      err = fmt.Errorf("arrays of tables are not supported")
+    } else if parser.line[0] == '[' {
+      table, err = parser.table(root)
+    } else {
+      err = parser.keyValue(table)
+    }
+    if err != nil {
+      return nil, fmt.Errorf("line %d: %s", number + 1, err)
+    }
+  }
+
+  return root, nil
+}
+
+type toml_parser struct {
+  line string
+  position int
+}
+
+func (self *toml_parser) skipSpace() {
+  for self.position < len(self.line) && (self.line[self.position] == ' ' || self.line[self.position] == '\t') {
+    self.position++
+  }
+}
+
+// end reports whether only whitespace or a comment is left.
+func (self *toml_parser) end() bool {
+  self.skipSpace()
+  return self.position >= len(self.line) || self.line[self.position] == '#'
+}
+
+func (self *toml_parser) expect(char byte) error {
+  self.skipSpace()
+  if self.position >= len(self.line) || self.line[self.position] != char {
+    return fmt.Errorf("expected '%c'", char)
+  }
+  self.position++
+  return nil
+}
+
+func (self *toml_parser) table(root map[string]any) (map[string]any, error) {
+  self.position++
+  keys, err := self.key()
+  if err != nil {
+    return nil, err
+  }
+  if err := self.expect(']'); err != nil {
+    return nil, err
+  }
+  if !self.end() {
+    return nil, fmt.Errorf("unexpected text after table header")
+  }
+
+  table := root
+  for _, key := range keys {
+    value, ok := table[key]
+    if !ok {
+      value = make(map[string]any)
+      table[key] = value
+    }
+    if table, ok = value.(map[string]any); !ok {
+      return nil, fmt.Errorf("%s is not a table", strings.Join(keys, "."))
+    }
+  }
+  return table, nil
+}
+
+func (self *toml_parser) keyValue(table map[string]any) error {
+  keys, err := self.key()
+  if err != nil {
+    return err
+  }
+  if err := self.expect('='); err != nil {
+    return err
+  }
+  value, err := self.value()
+  if err != nil {
+    return err
+  }
+  if !self.end() {
+    return fmt.Errorf("unexpected text after value")
+  }
+
+  for _, key := range keys[:len(keys) - 1] {
+    child, ok := table[key]
+    if !ok {
+      child = make(map[string]any)
+      table[key] = child
+    }
+    if table, ok = child.(map[string]any); !ok {
+      return fmt.Errorf("%s is not a table", key)
+    }
+  }
+
+  key := keys[len(keys) - 1]
+  if _, ok := table[key]; ok {
+    return fmt.Errorf("duplicate key %s", key)
+  }
+  table[key] = value
+  return nil
+}
+
+// key parses a dotted key of bare and quoted parts.
+func (self *toml_parser) key() ([]string, error) {
+  var keys []string
+  for {
+    self.skipSpace()
+    if self.position >= len(self.line) {
+      return nil, fmt.Errorf("expected key")
+    }
+
+    switch self.line[self.position] {
+    case '"', '\'':
+      key, err := self.string()
+      if err != nil {
+        return nil, err
+      }
+      keys = append(keys, key)
+    default:
+      start := self.position
+      for self.position < len(self.line) && isBareKeyChar(self.line[self.position]) {
+        self.position++
+      }
+      if start == self.position {
+        return nil, fmt.Errorf("expected key")
+      }
+      keys = append(keys, self.line[start:self.position])
+    }
+
+    self.skipSpace()
+    if self.position >= len(self.line) || self.line[self.position] != '.' {
+      return keys, nil
+    }
+    self.position++
+  }
+}
+
+func isBareKeyChar(char byte) bool {
+  return char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '_' || char == '-'
+}
+
+func (self *toml_parser) value() (any, error) {
+  self.skipSpace()
+  if self.position >= len(self.line) {
+    return nil, fmt.Errorf("expected value")
+  }
+
+  switch self.line[self.position] {
+  case '"', '\'':
+    return self.string()
+  case '[':
+    return self.array()
+  }
+
+  start := self.position
+  for self.position < len(self.line) && strings.IndexByte(" \t,]#", self.line[self.position]) < 0 {
+    self.position++
+  }
+  token := self.line[start:self.position]
+
+  switch token {
+  case "true":
+    return true, nil
+  case "false":
+    return false, nil
+  }
+  number := strings.ReplaceAll(token, "_", "")
+  if integer, err := strconv.ParseInt(number, 0, 64); err == nil {
+    return integer, nil
+  }
+  if float, err := strconv.ParseFloat(number, 64); err == nil {
+    return float, nil
+  }
+  return nil, fmt.Errorf("invalid value %s", token)
+}
+
+func (self *toml_parser) array() ([]any, error) {
+  self.position++
+  values := []any{}
+  for {
+    self.skipSpace()
+    if self.position < len(self.line) && self.line[self.position] == ']' {
+      self.position++
+      return values, nil
+    }
+
+    value, err := self.value()
+    if err != nil {
+      return nil, err
+    }
+    values = append(values, value)
+
+    self.skipSpace()
+    if self.position < len(self.line) && self.line[self.position] == ',' {
+      self.position++
+    } else if err := self.expect(']'); err != nil {
+      return nil, err
+    } else {
+      return values, nil
+    }
+  }
+}
+
+func (self *toml_parser) string() (string, error) {
+  quote := self.line[self.position]
+  self.position++
+
+  var value strings.Builder
+  for self.position < len(self.line) {
+    char := self.line[self.position]
+    self.position++
+
+    switch {
+    case char == quote:
+      return value.String(), nil
+    case char == '\\' && quote == '"':
+      if self.position >= len(self.line) {
+        return "", fmt.Errorf("unterminated string")
+      }
+      escape := self.line[self.position]
+      self.position++
+      switch escape {
+      case 'n':
+        value.WriteByte('\n')
+      case 't':
+        value.WriteByte('\t')
+      case '"', '\\':
+        value.WriteByte(escape)
+      case 'u', 'U':
+        length := 4
+        if escape == 'U' {
+          length = 8
+        }
+        if self.position + length > len(self.line) {
+          return "", fmt.Errorf("invalid unicode escape")
+        }
+        code, err := strconv.ParseUint(self.line[self.position:self.position + length], 16, 32)
+        if err != nil {
+          return "", fmt.Errorf("invalid unicode escape")
+        }
+        value.WriteRune(rune(code))
+        self.position += length
+      default:
+        return "", fmt.Errorf("invalid escape \\%c", escape)
+      }
+    default:
+      value.WriteByte(char)
+    }
+  }
+  return "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,82 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestParseToml(t *testing.T) {
+  for _, test := range []struct {
+    name string
+    toml string
+    expected map[string]any
+  }{
+    {"empty", "\n  # comment only\n\n", map[string]any{}},
+    {"values", "s = \"text\"\ni = 42\nn = -7\nh = 0x1f\nu = 1_000\nf = 2.5\ne = 1e3\nb = true\nc = false # comment",
+      map[string]any{"s": "text", "i": int64(42), "n": int64(-7), "h": int64(31), "u": int64(1000), "f": 2.5, "e": 1000.0, "b": true, "c": false}},
+    {"dotted keys", "a.b.c = 1\na.b.d = 2\na . e = 3",
+      map[string]any{"a": map[string]any{"b": map[string]any{"c": int64(1), "d": int64(2)}, "e": int64(3)}}},
+    {"quoted keys", "\"a.b\" = 1\nsite.\"key with space\" = 2\n'literal' = 3",
+      map[string]any{"a.b": int64(1), "site": map[string]any{"key with space": int64(2)}, "literal": int64(3)}},
+    {"tables", "top = 1\n[printer.\"office 1\"]\nmodel = \"PT-P700\"\n[profile.small]  # 12 mm\ntape = 12\n[printer.\"office 1\".usb]\nserial = \"A1\"",
+      map[string]any{"top": int64(1),
+        "printer": map[string]any{"office 1": map[string]any{"model": "PT-P700", "usb": map[string]any{"serial": "A1"}}},
+        "profile": map[string]any{"small": map[string]any{"tape": int64(12)}}}},
+    {"table reopened", "[a]\nx = 1\n[b]\n[a]\ny = 2",
+      map[string]any{"a": map[string]any{"x": int64(1), "y": int64(2)}, "b": map[string]any{}}},
+    {"escapes", `s = "tab\there\nquote \" backslash \\ \u00e9 \U0001F600"`,
+      map[string]any{"s": "tab\there\nquote \" backslash \\ é 😀"}},
+    {"literal strings", `path = 'C:\labels\#1 "x"'`, map[string]any{"path": `C:\labels\#1 "x"`}},
+    {"hash in string", `s = "a # b" # comment`, map[string]any{"s": "a # b"}},
+    {"arrays", `a = [1, 2.5, "x", true]` + "\nb = []\nc = [ [1, 2], ['y'] ]\nd = [1, 2, ]",
+      map[string]any{"a": []any{int64(1), 2.5, "x", true}, "b": []any{},
+        "c": []any{[]any{int64(1), int64(2)}, []any{"y"}}, "d": []any{int64(1), int64(2)}}},
+  } {
+    values, err := ParseToml(test.toml)
+    if err != nil {
+      t.Errorf("%s: %s", test.name, err)
+      continue
+    }
+    if !reflect.DeepEqual(values, test.expected) {
+      t.Errorf("%s: parsed %#v, expected %#v", test.name, values, test.expected)
+    }
+  }
+}
+
+func TestParseTomlErrors(t *testing.T) {
+  for _, test := range []struct {
+    name string
+    toml string
+    err string
+  }{
+    {"duplicate key", "a = 1\nb = 2\na = 3", "line 3: duplicate key a"},
+    {"duplicate dotted key", "[t]\nx.y = 1\nx.y = 2", "line 3: duplicate key y"},
+    {"duplicate quoted key", "\"a\" = 1\na = 2", "line 2: duplicate key a"},
+    {"value as table", "a = 1\n\n[a]", "line 3: a is not a table"},
+    {"value as dotted key", "a = 1\na.b = 2", "line 2: a is not a table"},
+    {"array of tables", "# printers\n[[printer]]", "line 2: arrays of tables are not supported"},
+    {"missing equals", "a 1", "line 1: expected '='"},
+    {"missing value", "a =", "line 1: expected value"},
+    {"missing key", "= 1", "line 1: expected key"},
+    {"invalid value", "\na = yes", "line 2: invalid value yes"},
+    {"text after value", "a = \"x\" y", "line 1: unexpected text after value"},
+    {"text after table", "[a] b", "line 1: unexpected text after table header"},
+    {"unterminated table", "[a", "line 1: expected ']'"},
+    {"unterminated string", "a = 1\nb = \"text", "line 2: unterminated string"},
+    {"unterminated array", "a = [1, 2", "line 1: expected ']'"},
+    {"array without value", "a = [1,", "line 1: expected value"},
+    {"invalid escape", `a = "\x41"`, `line 1: invalid escape \x`},
+    {"invalid unicode escape", `a = "\u00g9"`, "line 1: invalid unicode escape"},
+    {"short unicode escape", `a = "\u00"`, "line 1: invalid unicode escape"},
+  } {
+    _, err := ParseToml(test.toml)
+    if err == nil || err.Error() != test.err {
+      t.Errorf("%s: error %v, expected %s", test.name, err, test.err)
+    }
+  }
+}
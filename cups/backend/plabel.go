@@ -0,0 +1,173 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+// plabel is a CUPS backend for Brother P-touch printers attached by USB.
+//
+// Without arguments the attached printers are listed for device discovery,
+// otherwise the job data (the output of rastertoplabel) is sent to the
+// printer given by DEVICE_URI. Supported URIs:
+//
+//   plabel://serial/<serial number>
+//   plabel://model/<model name>
+//   plabel:///dev/usb/lp0
+package main
+
+import (
+  "fmt"
+  "io"
+  "log/slog"
+  "net/url"
+  "os"
+  "strings"
+  "github.com/spag/plabel"
+  "github.com/spag/plabel/transport"
+)
+
+const (
+  CUPS_BACKEND_OK = 0
+  CUPS_BACKEND_FAILED = 1
+  CUPS_BACKEND_STOP = 4
+  CUPS_BACKEND_RETRY = 6
+
+  STATUS_TIMEOUT = 2000 //ms
+  PRINTING_TIMEOUT = 60000 //ms
+  WRITE_BLOCK_SIZE = 4096
+)
+
+// DeviceUri returns the plabel:// URI of a discovered printer.
+func DeviceUri(device *transport.PrinterDevice) string {
+  if len(device.Serial) > 0 {
+    return "plabel://serial/" + url.PathEscape(device.Serial)
+  }
+  return "plabel://" + device.DevicePath
+}
+
+// ResolveDeviceUri maps a plabel:// URI to a printer specification as
+// accepted by transport.ResolvePrinterDevice.
+func ResolveDeviceUri(device_uri string) (string, error) {
+  uri, err := url.Parse(device_uri)
+  if err != nil || uri.Scheme != "plabel" {
+    return "", fmt.Errorf("invalid device URI %s", device_uri)
+  }
+
+  value := strings.TrimPrefix(uri.Path, "/")
+  switch uri.Host {
+  case "":
+    return uri.Path, nil
+  case "serial", "model":
+    return uri.Host + ":" + value, nil
+  }
+  return "", fmt.Errorf("invalid device URI %s", device_uri)
+}
+
+func ListDevices() {
+  for _, device := range transport.FindPrinters(transport.SYSFS_ROOT, transport.DEVICE_ROOT) {
+    device.QueryStatus(transport.DISCOVERY_TIMEOUT)
+    model := device.ModelName()
+    fmt.Printf("direct %s \"Brother %s\" \"Brother %s (plabel)\" \"MFG:Brother;MDL:%s;CMD:PT-CBP;\" \"\"\n",
+      DeviceUri(&device), model, model, model)
+  }
+}
+
+// ReportState sends the printer errors as state reasons to the scheduler.
+func ReportState(printer *plabel.Plabel) bool {
+  status := printer.Status()
+  if status.ErrorCode == 0 {
+    fmt.Fprintln(os.Stderr, "STATE: -media-empty,media-jam,media-needed,cover-open,other")
+    return true
+  }
+
+  fmt.Fprintf(os.Stderr, "STATE: +%s\n", strings.Join(status.StateReasons(), ","))
+  fmt.Fprintf(os.Stderr, "ERROR: %s\n", status.ErrorDescription())
+  return false
+}
+
+func main() {
+  if len(os.Args) == 1 {
+    ListDevices()
+    os.Exit(CUPS_BACKEND_OK)
+  }
+
+  if len(os.Args) < 6 || len(os.Args) > 7 {
+    fmt.Fprintf(os.Stderr, "Usage: %s job user title copies options [file]\n", os.Args[0])
+    os.Exit(CUPS_BACKEND_FAILED)
+  }
+
+  input := io.Reader(os.Stdin)
+  if len(os.Args) == 7 {
+    fd, err := os.Open(os.Args[6])
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "ERROR: Unable to open print file: %s\n", err)
+      os.Exit(CUPS_BACKEND_FAILED)
+    }
+    defer fd.Close()
+    input = fd
+  }
+
+  device_uri := os.Getenv("DEVICE_URI")
+  if len(device_uri) == 0 {
+    device_uri = os.Args[0]
+  }
+
+  spec, err := ResolveDeviceUri(device_uri)
+  if err == nil {
+    spec, err = transport.ResolvePrinterDevice(spec)
+  }
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+    os.Exit(CUPS_BACKEND_RETRY)
+  }
+
+  os.Exit(SendJob(spec, input))
+}
+
+func SendJob(device_path string, input io.Reader) int {
+  printer := plabel.New()
+  printer.Logger, _ = plabel.NewLogger(os.Stderr, plabel.LOG_FORMAT_TEXT, slog.LevelWarn)
+
+  if !printer.Open(device_path) {
+    fmt.Fprintf(os.Stderr, "ERROR: Unable to open printer %s\n", device_path)
+    return CUPS_BACKEND_RETRY
+  }
+
+  defer printer.Close()
+  go printer.ProcessStatus()
+
+  printer.Invalidate()
+  printer.Initialize()
+  printer.RequestStatus()
+
+  if !printer.WaitForPrinterStatus(STATUS_TIMEOUT) {
+    fmt.Fprintln(os.Stderr, "ERROR: Printer does not respond")
+    return CUPS_BACKEND_RETRY
+  }
+  if !ReportState(printer) {
+    return CUPS_BACKEND_RETRY
+  }
+
+  printer.ResetStatus()
+  buffer := make([]byte, WRITE_BLOCK_SIZE)
+  for {
+    count, err := input.Read(buffer)
+    if count > 0 {
+      printer.SendCommand(buffer[:count])
+    }
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "ERROR: Reading print data: %s\n", err)
+      return CUPS_BACKEND_FAILED
+    }
+  }
+
+  printer.WaitForPrintingCompleted(PRINTING_TIMEOUT)
+  if printer.Status().StatusCode == plabel.STATUS_ERROR {
+    ReportState(printer)
+    return CUPS_BACKEND_STOP
+  }
+
+  return CUPS_BACKEND_OK
+}
@@ -0,0 +1,136 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+// rastertoplabel is a CUPS filter converting CUPS raster into the P-touch
+// raster command stream.
+//
+// Usage: rastertoplabel job user title copies options [file]
+//
+// Options (besides those set in the page header by the PPD):
+//   PlabelModel=<model>      printer model, e.g. PT-P700 (default)
+//   PlabelThreshold=<0-255>  threshold at which a pixel is determined black
+package main
+
+import (
+  "fmt"
+  "io"
+  "log/slog"
+  "os"
+  "strconv"
+  "strings"
+  "github.com/spag/plabel"
+  "github.com/spag/plabel/render"
+)
+
+const (
+  DEFAULT_MODEL = "PT-P700"
+  POINTS_PER_INCH = 72
+)
+
+// ParseCupsOptions splits the CUPS option string "name=value name ..." into
+// a map, options without value are set to "true".
+func ParseCupsOptions(options string) map[string]string {
+  option_map := make(map[string]string)
+  for _, option := range strings.Fields(options) {
+    name, value, found := strings.Cut(option, "=")
+    if !found {
+      value = "true"
+    }
+    option_map[name] = value
+  }
+  return option_map
+}
+
+func main() {
+  if len(os.Args) < 6 || len(os.Args) > 7 {
+    fmt.Fprintf(os.Stderr, "Usage: %s job user title copies options [file]\n", os.Args[0])
+    os.Exit(1)
+  }
+
+  input := io.Reader(os.Stdin)
+  if len(os.Args) == 7 {
+    fd, err := os.Open(os.Args[6])
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "ERROR: Unable to open raster file: %s\n", err)
+      os.Exit(1)
+    }
+    defer fd.Close()
+    input = fd
+  }
+
+  options := ParseCupsOptions(os.Args[5])
+
+  model_name := DEFAULT_MODEL
+  if value, ok := options["PlabelModel"]; ok {
+    model_name = value
+  }
+  model_information, ok := plabel.GetModelInformationByName(model_name)
+  if !ok {
+    fmt.Fprintf(os.Stderr, "ERROR: Unknown printer model %s\n", model_name)
+    os.Exit(1)
+  }
+
+  threshold := plabel.DEFAULT_THRESHOLD
+  if value, ok := options["PlabelThreshold"]; ok {
+    if parsed, err := strconv.ParseUint(value, 10, 8); err == nil {
+      threshold = int(parsed)
+    }
+  }
+
+  if err := Filter(input, os.Stdout, model_information, byte(threshold)); err != nil {
+    fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+    os.Exit(1)
+  }
+}
+
+// Filter converts all pages of a raster stream. A page is only sent after
+// the next one was read, the last page ends with print and feed.
+func Filter(input io.Reader, output io.Writer, model_information *plabel.ModelInformation, threshold byte) error {
+  raster, err := plabel.NewCupsRasterReader(input)
+  if err != nil {
+    return err
+  }
+
+  printer := plabel.New()
+  printer.Logger, _ = plabel.NewLogger(os.Stderr, plabel.LOG_FORMAT_TEXT, slog.LevelWarn)
+  printer.AttachWriter(output)
+
+  header, page, err := raster.ReadPage()
+  if err != nil {
+    return fmt.Errorf("reading first page: %s", err)
+  }
+
+  printer.Invalidate()
+  printer.Initialize()
+
+  for page_number := 1; ; page_number++ {
+    next_header, next_page, err := raster.ReadPage()
+    if err != nil && err != io.EOF {
+      return fmt.Errorf("reading page %d: %s", page_number + 1, err)
+    }
+
+    fmt.Fprintf(os.Stderr, "INFO: Printing page %d\n", page_number)
+    fmt.Fprintf(os.Stderr, "PAGE: %d 1\n", page_number)
+
+    media_width := byte((header.PageSize[0]*254 / POINTS_PER_INCH + 5) / 10)
+    printer.SetModel(model_information, media_width)
+
+    printer.SwitchRasterMode()
+    printer.SetCutMirror(header.CutMedia != plabel.CUPS_CUT_NONE, false)
+    printer.SetCompression()
+    spec := printer.RasterSpec()
+    spec.Threshold = threshold
+    spec.Mirror = header.MirrorPrint != 0
+    printer.SendRaster(printer.RasterImage(render.RotateClockwise(page), "cups-raster", spec))
+
+    if err == io.EOF {
+      printer.PrintAndFeed()
+      return nil
+    }
+
+    printer.Print()
+    header, page = next_header, next_page
+  }
+}
@@ -0,0 +1,93 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package main
+
+import (
+  "bytes"
+  "encoding/binary"
+  "testing"
+  "github.com/spag/plabel"
+)
+
+// testRasterPage returns a page of 12 mm tape at 180 dpi in 1 bit white
+// color space, with the first lines black.
+func testRasterPage(height uint32, black_lines int) []byte {
+  const width, bytes_per_line = 85, 11
+  header := make([]byte, plabel.CUPS_RASTER_HEADER_LENGTH)
+  for offset, value := range map[int]uint32{268: plabel.CUPS_CUT_PAGE, 276: 180, 280: 180, 352: 34, 356: height * 72 / 180,
+    372: width, 376: height, 384: 1, 388: 1, 392: bytes_per_line, 400: plabel.CUPS_COLOR_SPACE_W} {
+    binary.BigEndian.PutUint32(header[offset:], value)
+  }
+
+  page := bytes.Repeat([]byte{0xff}, int(height) * bytes_per_line)
+  for index := range black_lines * bytes_per_line {
+    page[index] = 0x00
+  }
+  return append(header, page...)
+}
+
+func disassemble(t *testing.T, output []byte) (*plabel.Disassembler, []plabel.Instruction) {
+  t.Helper()
+  disassembler := plabel.NewDisassembler()
+  instructions := append(disassembler.Decode(output), disassembler.Flush()...)
+  if disassembler.Warnings > 0 {
+    for _, instruction := range instructions {
+      if len(instruction.Warnings) > 0 {
+        t.Errorf("%s: %v", instruction.String(), instruction.Warnings)
+      }
+    }
+  }
+  return disassembler, instructions
+}
+
+func TestFilter(t *testing.T) {
+  model_information, _ := plabel.GetModelInformationByName("PT-P700")
+  tests := []struct {
+    name  string
+    pages [][]byte
+    lines int
+  }{
+    {"one page", [][]byte{testRasterPage(100, 10)}, 100},
+    {"two pages", [][]byte{testRasterPage(100, 10), testRasterPage(60, 60)}, 160},
+  }
+
+  for _, test := range tests {
+    input := bytes.NewBufferString("RaSt")
+    for _, page := range test.pages {
+      input.Write(page)
+    }
+    var output bytes.Buffer
+    if err := Filter(input, &output, model_information, plabel.DEFAULT_THRESHOLD); err != nil {
+      t.Fatalf("%s: %s", test.name, err)
+    }
+
+    disassembler, instructions := disassemble(t, output.Bytes())
+    if disassembler.Pages != len(test.pages) || disassembler.Lines != test.lines {
+      t.Errorf("%s: %d pages of %d lines, expected %d of %d", test.name, disassembler.Pages, disassembler.Lines, len(test.pages), test.lines)
+    }
+    if last := instructions[len(instructions) - 1]; last.Name != "SUB" {
+      t.Errorf("%s: ends with %s, expected print and feed", test.name, last.String())
+    }
+  }
+}
+
+func TestFilterInvalidRaster(t *testing.T) {
+  model_information, _ := plabel.GetModelInformationByName("PT-P700")
+  page := testRasterPage(100, 10)
+  //lines of 64 bytes announced for 85 pixels
+  binary.BigEndian.PutUint32(page[392:], 64)
+
+  for name, input := range map[string][]byte{
+    "no raster": []byte("%!PS-Adobe-3.0\n"),
+    "truncated": append([]byte("RaSt"), testRasterPage(100, 10)[:1000]...),
+    "line length": append([]byte("RaSt"), page...),
+  } {
+    var output bytes.Buffer
+    if err := Filter(bytes.NewReader(input), &output, model_information, plabel.DEFAULT_THRESHOLD); err == nil {
+      t.Errorf("%s: filtered without error", name)
+    }
+  }
+}
@@ -0,0 +1,279 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+const (
+	CUPS_RASTER_HEADER_LENGTH = 1796
+	CUPS_RASTER_MAX_WIDTH     = 1024  //px across the tape, 36 mm tapes at 720 dpi
+	CUPS_RASTER_MAX_HEIGHT    = 32768 //px along the tape, more than 1 m at 720 dpi
+
+	CUPS_COLOR_SPACE_W    = 0
+	CUPS_COLOR_SPACE_RGB  = 1
+	CUPS_COLOR_SPACE_K    = 3
+	CUPS_COLOR_SPACE_SW   = 18
+	CUPS_COLOR_SPACE_SRGB = 19
+	CUPS_COLOR_SPACE_ADOBE_RGB = 20
+
+	CUPS_CUT_NONE     = 0
+	CUPS_CUT_FILE     = 1
+	CUPS_CUT_JOB      = 2
+	CUPS_CUT_SET      = 3
+	CUPS_CUT_PAGE     = 4
+)
+
+// CupsRasterHeader holds the fields of cups_page_header2_t used for printing.
+// PWG raster uses the same layout.
+type CupsRasterHeader struct {
+	MediaType string
+	CutMedia uint32
+	HWResolution [2]uint32
+	MirrorPrint uint32
+	NumCopies uint32
+	PageSize [2]uint32
+	Width uint32
+	Height uint32
+	BitsPerColor uint32
+	BitsPerPixel uint32
+	BytesPerLine uint32
+	ColorOrder uint32
+	ColorSpace uint32
+	PageSizeName string
+}
+
+// CupsRasterReader reads CUPS raster (version 1, 2 and 3, both byte orders)
+// and PWG raster streams page by page. The page size is taken from untrusted
+// headers, pages larger than MaxWidth x MaxHeight are rejected.
+type CupsRasterReader struct {
+	reader *bufio.Reader
+	byte_order binary.ByteOrder
+	compressed bool
+
+	MaxWidth uint32
+	MaxHeight uint32
+}
+
+func NewCupsRasterReader(reader io.Reader) (*CupsRasterReader, error) {
+	self := &CupsRasterReader{reader: bufio.NewReader(reader), MaxWidth: CUPS_RASTER_MAX_WIDTH, MaxHeight: CUPS_RASTER_MAX_HEIGHT}
+
+	sync := make([]byte, 4)
+	if _, err := io.ReadFull(self.reader, sync); err != nil {
+		return nil, fmt.Errorf("reading raster synchronization word: %s", err)
+	}
+
+	switch string(sync) {
+	case "RaSt", "RaS3":
+		self.byte_order = binary.BigEndian
+	case "tSaR", "3SaR":
+		self.byte_order = binary.LittleEndian
+	case "RaS2":
+		self.byte_order = binary.BigEndian
+		self.compressed = true
+	case "2SaR":
+		self.byte_order = binary.LittleEndian
+		self.compressed = true
+	default:
+		return nil, fmt.Errorf("no CUPS raster stream")
+	}
+
+	return self, nil
+}
+
+// ReadPage returns the next page as grayscale image, io.EOF after the last page.
+func (self *CupsRasterReader) ReadPage() (*CupsRasterHeader, *image.Gray, error) {
+	raw_header := make([]byte, CUPS_RASTER_HEADER_LENGTH)
+	if _, err := io.ReadFull(self.reader, raw_header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, nil, fmt.Errorf("truncated raster page header")
+		}
+		return nil, nil, err
+	}
+
+	header := self.parseHeader(raw_header)
+	if err := self.checkHeader(header); err != nil {
+		return nil, nil, err
+	}
+
+	img := image.NewGray(image.Rect(0, 0, int(header.Width), int(header.Height)))
+	line := make([]byte, header.BytesPerLine)
+
+	for y := 0; y < int(header.Height); {
+		repeat := 1
+		var err error
+
+		if self.compressed {
+			repeat, err = self.readCompressedLine(header, line)
+		} else {
+			_, err = io.ReadFull(self.reader, line)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading raster line %d: %s", y, err)
+		}
+
+		for ; repeat > 0 && y < int(header.Height); repeat-- {
+			if err := convertRasterLine(header, line, img.Pix[y*img.Stride:(y+1)*img.Stride]); err != nil {
+				return nil, nil, err
+			}
+			y++
+		}
+	}
+
+	return header, img, nil
+}
+
+// checkHeader rejects pages the reader cannot convert before any memory is
+// allocated for them.
+func (self *CupsRasterReader) checkHeader(header *CupsRasterHeader) error {
+	if header.Width == 0 || header.Height == 0 || header.Width > self.MaxWidth || header.Height > self.MaxHeight {
+		return fmt.Errorf("invalid raster page size %dx%d", header.Width, header.Height)
+	}
+	switch header.BitsPerPixel {
+	case 1, 8, 24:
+	default:
+		return fmt.Errorf("unsupported raster depth %d bit", header.BitsPerPixel)
+	}
+	if header.BytesPerLine != (header.Width*header.BitsPerPixel + 7) / 8 {
+		return fmt.Errorf("invalid raster line length %d for %d pixels of %d bit", header.BytesPerLine, header.Width, header.BitsPerPixel)
+	}
+	if header.ColorOrder != 0 {
+		return fmt.Errorf("unsupported raster color order %d", header.ColorOrder)
+	}
+	return nil
+}
+
+func (self *CupsRasterReader) parseHeader(raw []byte) *CupsRasterHeader {
+	value := func(offset int) uint32 {
+		return self.byte_order.Uint32(raw[offset:offset + 4])
+	}
+	text := func(offset int) string {
+		field := raw[offset:offset + 64]
+		if end := bytes.IndexByte(field, 0); end >= 0 {
+			field = field[:end]
+		}
+		return string(field)
+	}
+
+	return &CupsRasterHeader{
+		MediaType: text(128),
+		CutMedia: value(268),
+		HWResolution: [2]uint32{value(276), value(280)},
+		MirrorPrint: value(332),
+		NumCopies: value(340),
+		PageSize: [2]uint32{value(352), value(356)},
+		Width: value(372),
+		Height: value(376),
+		BitsPerColor: value(384),
+		BitsPerPixel: value(388),
+		BytesPerLine: value(392),
+		ColorOrder: value(396),
+		ColorSpace: value(400),
+		PageSizeName: text(1732),
+	}
+}
+
+// readCompressedLine decodes one line of the version 2 run length encoding
+// and returns how often the line is repeated.
+func (self *CupsRasterReader) readCompressedLine(header *CupsRasterHeader, line []byte) (int, error) {
+	pixel_size := int(header.BitsPerPixel + 7) / 8
+
+	repeat, err := self.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	for position := 0; position < len(line); {
+		control, err := self.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case control == 128:
+			//remaining pixels are blank
+			blank := blankRasterByte(header)
+			for ; position < len(line); position++ {
+				line[position] = blank
+			}
+		case control < 128:
+			pixel := make([]byte, pixel_size)
+			if _, err := io.ReadFull(self.reader, pixel); err != nil {
+				return 0, err
+			}
+			for count := int(control) + 1; count > 0 && position < len(line); count-- {
+				position += copy(line[position:], pixel)
+			}
+		default:
+			count := (257 - int(control)) * pixel_size
+			if position + count > len(line) {
+				return 0, fmt.Errorf("literal run exceeds line length")
+			}
+			if _, err := io.ReadFull(self.reader, line[position:position + count]); err != nil {
+				return 0, err
+			}
+			position += count
+		}
+	}
+
+	return int(repeat) + 1, nil
+}
+
+func blankRasterByte(header *CupsRasterHeader) byte {
+	if header.ColorSpace == CUPS_COLOR_SPACE_K {
+		return 0x00
+	}
+	return 0xff
+}
+
+// convertRasterLine converts a raster line into 8 bit gray pixels, 0 is black.
+func convertRasterLine(header *CupsRasterHeader, line []byte, gray []byte) error {
+	switch header.ColorSpace {
+	case CUPS_COLOR_SPACE_W, CUPS_COLOR_SPACE_SW, CUPS_COLOR_SPACE_K:
+		black_is_set := header.ColorSpace == CUPS_COLOR_SPACE_K
+
+		switch header.BitsPerPixel {
+		case 1:
+			for x := range gray {
+				set := line[x/8] & (0x80 >> (x % 8)) != 0
+				if set == black_is_set {
+					gray[x] = 0x00
+				} else {
+					gray[x] = 0xff
+				}
+			}
+		case 8:
+			for x := range gray {
+				gray[x] = line[x]
+				if black_is_set {
+					gray[x] = 0xff - line[x]
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported raster depth %d bit", header.BitsPerPixel)
+		}
+
+	case CUPS_COLOR_SPACE_RGB, CUPS_COLOR_SPACE_SRGB, CUPS_COLOR_SPACE_ADOBE_RGB:
+		if header.BitsPerPixel != 24 {
+			return fmt.Errorf("unsupported raster depth %d bit", header.BitsPerPixel)
+		}
+		for x := range gray {
+			r, g, b := uint32(line[x*3]), uint32(line[x*3 + 1]), uint32(line[x*3 + 2])
+			gray[x] = byte((19595*r + 38470*g + 7471*b + 1<<15) >> 16)
+		}
+
+	default:
+		return fmt.Errorf("unsupported raster color space %d", header.ColorSpace)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// testRasterHeader returns a page header of an uncompressed CUPS raster
+// stream in the byte order.
+func testRasterHeader(order binary.ByteOrder, width uint32, height uint32, bits_per_pixel uint32, bytes_per_line uint32, color_space uint32) []byte {
+	header := make([]byte, CUPS_RASTER_HEADER_LENGTH)
+	for offset, value := range map[int]uint32{372: width, 376: height, 384: min(bits_per_pixel, 8), 388: bits_per_pixel, 392: bytes_per_line, 400: color_space} {
+		order.PutUint32(header[offset:], value)
+	}
+	return header
+}
+
+func TestCupsRasterReadPage(t *testing.T) {
+	for _, test := range []struct {
+		sync  string
+		order binary.ByteOrder
+	}{{"RaSt", binary.BigEndian}, {"tSaR", binary.LittleEndian}, {"RaS3", binary.BigEndian}} {
+		var stream bytes.Buffer
+		stream.WriteString(test.sync)
+		stream.Write(testRasterHeader(test.order, 12, 2, 1, 2, CUPS_COLOR_SPACE_W))
+		stream.Write([]byte{0x0f, 0xff, 0xff, 0x0f})
+
+		raster, err := NewCupsRasterReader(&stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, page, err := raster.ReadPage()
+		if err != nil {
+			t.Fatalf("%s: %s", test.sync, err)
+		}
+		if header.Width != 12 || page.Bounds().Dx() != 12 || page.Bounds().Dy() != 2 {
+			t.Fatalf("%s: page %v", test.sync, page.Bounds())
+		}
+		//white space set bits are white
+		if page.Pix[0] != 0x00 || page.Pix[4] != 0xff || page.Pix[12 + 7] != 0xff || page.Pix[12 + 11] != 0x00 {
+			t.Errorf("%s: pixels % x", test.sync, page.Pix)
+		}
+	}
+}
+
+func TestCupsRasterCompressed(t *testing.T) {
+	var stream bytes.Buffer
+	stream.WriteString("RaS2")
+	stream.Write(testRasterHeader(binary.BigEndian, 8, 3, 8, 8, CUPS_COLOR_SPACE_SW))
+	//line repeated 3 times: 4 pixels 0x00, 2 literal pixels, rest blank
+	stream.Write([]byte{2, 3, 0x00, 0xff, 0x40, 0x80, 128})
+
+	raster, _ := NewCupsRasterReader(&stream)
+	_, page, err := raster.ReadPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x00, 0x00, 0x00, 0x00, 0x40, 0x80, 0xff, 0xff}
+	for y := 0; y < 3; y++ {
+		if !bytes.Equal(page.Pix[y * 8:(y + 1) * 8], expected) {
+			t.Errorf("line %d: % x", y, page.Pix[y * 8:(y + 1) * 8])
+		}
+	}
+}
+
+func TestCupsRasterInvalidHeaders(t *testing.T) {
+	tests := []struct {
+		name           string
+		width          uint32
+		height         uint32
+		bits_per_pixel uint32
+		bytes_per_line uint32
+		message        string
+	}{
+		{"empty", 0, 10, 1, 0, "page size"},
+		{"too wide", 0x7fffffff, 1, 8, 0x7fffffff, "page size"},
+		{"too long", 128, 0xffffffff, 1, 16, "page size"},
+		{"huge depth", 16, 16, 0xfffffff8, 2, "depth"},
+		{"short lines", 128, 16, 8, 16, "line length"},
+		{"long lines", 16, 16, 1, 0x10000000, "line length"},
+	}
+	for _, test := range tests {
+		var stream bytes.Buffer
+		stream.WriteString("RaS2")
+		stream.Write(testRasterHeader(binary.BigEndian, test.width, test.height, test.bits_per_pixel, test.bytes_per_line, CUPS_COLOR_SPACE_K))
+
+		raster, _ := NewCupsRasterReader(&stream)
+		if _, _, err := raster.ReadPage(); err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: error %v, expected %s", test.name, err, test.message)
+		}
+	}
+}
+
+func TestCupsRasterTruncated(t *testing.T) {
+	var stream bytes.Buffer
+	stream.WriteString("RaSt")
+	stream.Write(testRasterHeader(binary.BigEndian, 8, 100, 8, 8, CUPS_COLOR_SPACE_W))
+	stream.Write(make([]byte, 80))
+
+	raster, _ := NewCupsRasterReader(&stream)
+	if _, _, err := raster.ReadPage(); err == nil {
+		t.Error("truncated page read without error")
+	}
+	if _, err := NewCupsRasterReader(strings.NewReader("%!PS")); err == nil {
+		t.Error("PostScript read as raster")
+	}
+}
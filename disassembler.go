@@ -0,0 +1,460 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+)
+
+// Instruction is one command of a command stream with its description and
+// the problems found in it.
+type Instruction struct {
+	Offset      int
+	Data        []byte
+	Name        string //e.g. "ESC i z"
+	Description string
+	Warnings    []string
+}
+
+func (self *Instruction) String() string {
+	if len(self.Description) == 0 {
+		return self.Name
+	}
+	return self.Name + " " + self.Description
+}
+
+// Disassembler splits a command stream into instructions. It follows the
+// command mode and compression selected by the stream, so that commands are
+// decoded as the printer would decode them, and counts pages and raster
+// lines.
+type Disassembler struct {
+	Mode        byte
+	Compression byte
+
+	Instructions int
+	Pages        int
+	Lines        int
+	Warnings     int
+
+	offset          int
+	pending         []byte
+	page_lines      int
+	announced_lines int //raster number of ESC i z
+}
+
+func NewDisassembler() *Disassembler {
+	return &Disassembler{Mode: COMMAND_MODE_ESCP}
+}
+
+// Decode returns the complete instructions of the stream so far, incomplete
+// ones are kept until the rest is decoded.
+func (self *Disassembler) Decode(data []byte) (instructions []Instruction) {
+	self.pending = append(self.pending, data...)
+	for len(self.pending) > 0 {
+		instruction := self.decode(self.pending)
+		if instruction.Data == nil {
+			break
+		}
+		instruction.Offset = self.offset
+		instruction.Data = append([]byte(nil), instruction.Data...)
+		self.offset += len(instruction.Data)
+		self.pending = self.pending[len(instruction.Data):]
+
+		self.Instructions++
+		self.Warnings += len(instruction.Warnings)
+		instructions = append(instructions, instruction)
+	}
+	return
+}
+
+// Flush returns warnings for an incomplete command and raster lines that
+// are not printed at the end of the stream.
+func (self *Disassembler) Flush() (instructions []Instruction) {
+	if len(self.pending) > 0 {
+		instructions = append(instructions, Instruction{self.offset, self.pending, "truncated", fmt.Sprintf("%d bytes", len(self.pending)), []string{"incomplete command at end of stream"}})
+		self.offset += len(self.pending)
+		self.pending = nil
+	}
+	if self.page_lines > 0 {
+		instructions = append(instructions, Instruction{self.offset, nil, "end", "", []string{fmt.Sprintf("%d raster lines not printed", self.page_lines)}})
+		self.page_lines = 0
+	}
+	for _, instruction := range instructions {
+		self.Warnings += len(instruction.Warnings)
+	}
+	return
+}
+
+// instruction returns the first length bytes of data as instruction, the
+// data is nil if the command is incomplete.
+func instruction(data []byte, length int, name string, description string, warnings ...string) Instruction {
+	if len(data) < length {
+		return Instruction{}
+	}
+	return Instruction{Data: data[:length], Name: name, Description: description, Warnings: warnings}
+}
+
+func (self *Disassembler) decode(data []byte) Instruction {
+	switch data[0] {
+	case 0x00:
+		count := len(data) - len(bytes.TrimLeft(data, "\x00"))
+		return instruction(data, count, "NUL", fmt.Sprintf("x%d invalidate", count))
+	case 0x0c:
+		return self.print(data, "FF", "print")
+	case 0x1a:
+		return self.print(data, "SUB", "print and feed")
+	case 0x1b:
+		return self.escape(data)
+	}
+
+	switch self.Mode {
+	case COMMAND_MODE_RASTER:
+		return self.raster(data)
+	case COMMAND_MODE_TEMPLATE:
+		return self.template(data)
+	}
+	return self.escp(data)
+}
+
+func (self *Disassembler) print(data []byte, name string, description string) Instruction {
+	self.Pages++
+	var warnings []string
+	if self.Mode == COMMAND_MODE_RASTER {
+		description += fmt.Sprintf(" page=%d lines=%d", self.Pages, self.page_lines)
+		if self.announced_lines > 0 && self.announced_lines != self.page_lines {
+			warnings = append(warnings, fmt.Sprintf("ESC i z announced %d lines, %d sent", self.announced_lines, self.page_lines))
+		}
+		if self.page_lines == 0 {
+			warnings = append(warnings, "page without raster lines")
+		}
+	} else {
+		description += fmt.Sprintf(" page=%d", self.Pages)
+	}
+	self.page_lines = 0
+	self.announced_lines = 0
+	return instruction(data, 1, name, description, warnings...)
+}
+
+func (self *Disassembler) escape(data []byte) Instruction {
+	if len(data) < 2 {
+		return Instruction{}
+	}
+	switch data[1] {
+	case '@':
+		self.Compression = 0
+		self.page_lines = 0
+		self.announced_lines = 0
+		return instruction(data, 2, "ESC @", "initialize")
+	case 'i':
+		return self.escapeI(data)
+	}
+	if self.Mode == COMMAND_MODE_ESCP {
+		return self.escpEscape(data)
+	}
+	return instruction(data, 2, fmt.Sprintf("ESC 0x%02x", data[1]), "", "unknown command")
+}
+
+func (self *Disassembler) escapeI(data []byte) Instruction {
+	if len(data) < 3 {
+		return Instruction{}
+	}
+	on := func(bit int) string {
+		if len(data) > 3 && data[3] & (1 << bit) != 0 {
+			return "on"
+		}
+		return "off"
+	}
+
+	switch data[2] {
+	case 'S':
+		return instruction(data, 3, "ESC i S", "status request")
+	case 'a':
+		if len(data) < 4 {
+			return Instruction{}
+		}
+		self.Mode = data[3]
+		if name, ok := command_mode_names[data[3]]; ok {
+			return instruction(data, 4, "ESC i a", "mode=" + name)
+		}
+		return instruction(data, 4, "ESC i a", fmt.Sprintf("mode=0x%02x", data[3]), "unknown command mode")
+	case 'z':
+		if len(data) < 13 {
+			return Instruction{}
+		}
+		self.announced_lines = int(data[7]) | int(data[8]) << 8 | int(data[9]) << 16 | int(data[10]) << 24
+		page := map[byte]string{0: "start", 1: "other", 2: "last"}[data[11]]
+		if len(page) == 0 {
+			page = fmt.Sprintf("0x%02x", data[11])
+		}
+		return instruction(data, 13, "ESC i z", fmt.Sprintf("valid=0x%02x kind=%s width=%d length=%d lines=%d page=%s",
+			data[3], mediaTypeName(data[4]), data[5], data[6], self.announced_lines, page))
+	case 'M':
+		return instruction(data, 4, "ESC i M", fmt.Sprintf("auto-cut=%s mirror=%s", on(6), on(7)))
+	case 'K':
+		return instruction(data, 4, "ESC i K", fmt.Sprintf("half-cut=%s no-chain=%s special-tape=%s no-buffer-clearing=%s", on(2), on(3), on(4), on(7)))
+	case 'A':
+		if len(data) < 4 {
+			return Instruction{}
+		}
+		return instruction(data, 4, "ESC i A", fmt.Sprintf("cut-every=%d", data[3]))
+	case 'd':
+		if len(data) < 5 {
+			return Instruction{}
+		}
+		return instruction(data, 5, "ESC i d", fmt.Sprintf("margin=%d dots", int(data[3]) | int(data[4]) << 8))
+	}
+
+	if self.Mode == COMMAND_MODE_ESCP {
+		barcode, barcode_data, length := ParseEscpBarcode(data)
+		switch {
+		case length == 0:
+			return Instruction{}
+		case length > 0:
+			return instruction(data, length, "ESC i B", fmt.Sprintf("type=%s height=%d width=%d text=%t data=%q",
+				barcodeTypeName(barcode.Type), barcode.Height, barcode.Width, barcode.Text, barcode_data))
+		}
+	}
+	return instruction(data, 3, fmt.Sprintf("ESC i 0x%02x", data[2]), "", "unknown command")
+}
+
+func (self *Disassembler) raster(data []byte) Instruction {
+	switch data[0] {
+	case 'M':
+		if len(data) < 2 {
+			return Instruction{}
+		}
+		self.Compression = data[1]
+		switch data[1] {
+		case 0x00:
+			return instruction(data, 2, "M", "compression=none")
+		case COMPRESSION_TIFF:
+			return instruction(data, 2, "M", "compression=tiff")
+		}
+		return instruction(data, 2, "M", fmt.Sprintf("compression=0x%02x", data[1]), "unknown compression")
+	case 'Z':
+		self.page_lines++
+		self.Lines++
+		return instruction(data, 1, "Z", "empty line")
+	case 'G':
+		if len(data) < 3 {
+			return Instruction{}
+		}
+		length := int(data[1]) | int(data[2]) << 8
+		if len(data) < 3 + length {
+			return Instruction{}
+		}
+		self.page_lines++
+		self.Lines++
+
+		var warnings []string
+		line, encoding := data[3:3+length], "raw"
+		if self.Compression == COMPRESSION_TIFF {
+			var ok bool
+			encoding = "packbits"
+			if line, ok = UnpackBits(line); !ok {
+				warnings = append(warnings, "packbits run exceeds the data")
+			}
+		}
+		if len(line) != DATA_LINE_BUFFER_LENGTH {
+			warnings = append(warnings, fmt.Sprintf("line of %d bytes, expected %d", len(line), DATA_LINE_BUFFER_LENGTH))
+		}
+		dots := 0
+		for _, octet := range line {
+			dots += bits.OnesCount8(octet)
+		}
+		return instruction(data, 3 + length, "G", fmt.Sprintf("len=%d %s dots=%d", length, encoding, dots), warnings...)
+	}
+	return instruction(data, 1, fmt.Sprintf("0x%02x", data[0]), "", "unexpected byte in raster mode")
+}
+
+func (self *Disassembler) template(data []byte) Instruction {
+	if data[0] != '^' {
+		return instruction(data, 1, fmt.Sprintf("0x%02x", data[0]), "", "unexpected byte in template mode")
+	}
+	if len(data) < 3 {
+		return Instruction{}
+	}
+
+	name := string(data[:3])
+	switch name {
+	case "^II":
+		return instruction(data, 3, name, "initialize")
+	case "^FF":
+		self.Pages++
+		return instruction(data, 3, name, fmt.Sprintf("print page=%d", self.Pages))
+	case "^TS":
+		return instruction(data, 6, name, "template=" + string(data[3:min(6, len(data))]))
+	case "^CN":
+		return instruction(data, 6, name, "copies=" + string(data[3:min(6, len(data))]))
+	case "^ON":
+		end := bytes.IndexByte(data, 0x00)
+		if end < 0 {
+			return Instruction{}
+		}
+		return instruction(data, end + 1, name, fmt.Sprintf("object=%q", data[3:end]))
+	case "^DI":
+		if len(data) < 5 {
+			return Instruction{}
+		}
+		length := 5 + (int(data[3]) | int(data[4]) << 8)
+		if len(data) < length {
+			return Instruction{}
+		}
+		return instruction(data, length, name, fmt.Sprintf("data=%q", data[5:length]))
+	}
+	return instruction(data, 3, name, "", "unknown template command")
+}
+
+func (self *Disassembler) escp(data []byte) Instruction {
+	switch data[0] {
+	case 0x0a:
+		return instruction(data, 1, "LF", "")
+	case 0x0d:
+		return instruction(data, 1, "CR", "")
+	case 0x09:
+		return instruction(data, 1, "HT", "")
+	}
+	if data[0] < 0x20 {
+		return instruction(data, 1, fmt.Sprintf("0x%02x", data[0]), "", "unknown control character")
+	}
+
+	length := 0
+	for length < len(data) && data[length] >= 0x20 {
+		length++
+	}
+	return instruction(data, length, "text", fmt.Sprintf("%q", data[:length]))
+}
+
+func (self *Disassembler) escpEscape(data []byte) Instruction {
+	switch data[1] {
+	case 'X':
+		if len(data) < 5 {
+			return Instruction{}
+		}
+		return instruction(data, 5, "ESC X", fmt.Sprintf("size=%d dots", int(data[3]) | int(data[4]) << 8))
+	case 'k':
+		if len(data) < 3 {
+			return Instruction{}
+		}
+		return instruction(data, 3, "ESC k", "font=" + escpFontName(data[2]))
+	case 'a':
+		if len(data) < 3 {
+			return Instruction{}
+		}
+		names := []string{"left", "center", "right", "justify"}
+		if int(data[2]) < len(names) {
+			return instruction(data, 3, "ESC a", "align=" + names[data[2]])
+		}
+		return instruction(data, 3, "ESC a", fmt.Sprintf("align=0x%02x", data[2]), "unknown alignment")
+	case '3':
+		if len(data) < 3 {
+			return Instruction{}
+		}
+		return instruction(data, 3, "ESC 3", fmt.Sprintf("line-feed=%d dots", data[2]))
+	case '$':
+		if len(data) < 4 {
+			return Instruction{}
+		}
+		return instruction(data, 4, "ESC $", fmt.Sprintf("position=%d dots", int(data[2]) | int(data[3]) << 8))
+	case 'E':
+		return instruction(data, 2, "ESC E", "bold on")
+	case 'F':
+		return instruction(data, 2, "ESC F", "bold off")
+	case '4':
+		return instruction(data, 2, "ESC 4", "italic on")
+	case '5':
+		return instruction(data, 2, "ESC 5", "italic off")
+	}
+	return instruction(data, 2, fmt.Sprintf("ESC 0x%02x", data[1]), "", "unknown command")
+}
+
+// ParseEscpBarcode parses ESC i with barcode parameters, the data follows B
+// and is terminated by a backslash. The length is 0 if the command is
+// incomplete, -1 if it is not a barcode.
+func ParseEscpBarcode(data []byte) (barcode EscpBarcode, barcode_data string, length int) {
+	barcode = EscpBarcode{ESCP_BARCODE_CODE128, ESCP_DEFAULT_BARCODE_HEIGHT, 1, false}
+	for position := 2; ; position += 2 {
+		if position + 1 >= len(data) {
+			return barcode, "", 0
+		}
+		value := data[position+1]
+		switch data[position] {
+		case 'B':
+			end := bytes.IndexByte(data[position+1:], ESCP_BARCODE_END)
+			if end < 0 {
+				return barcode, "", 0
+			}
+			return barcode, string(data[position+1 : position+1+end]), position + end + 2
+		case 't':
+			barcode.Type = value
+		case 's':
+			barcode.Text = value == '1'
+		case 'w':
+			barcode.Width = min(value - '0', 3)
+		case 'h':
+			if position + 2 >= len(data) {
+				return barcode, "", 0
+			}
+			barcode.Height = uint16(value) | uint16(data[position+2]) << 8
+			position++
+		case 'r', 'z', 'e':
+		default:
+			return barcode, "", -1
+		}
+	}
+}
+
+var command_mode_names = map[byte]string{
+	COMMAND_MODE_ESCP:     "escp",
+	COMMAND_MODE_RASTER:   "raster",
+	COMMAND_MODE_TEMPLATE: "template",
+}
+
+func mediaTypeName(media_type byte) string {
+	names := map[byte]string{
+		MEDIA_TYPE_NO_TAPE:       "none",
+		MEDIA_TYPE_LAMINATED:     "laminated",
+		MEDIA_TYPE_NON_LAMINATED: "non-laminated",
+		MEDIA_TYPE_HEAT_SHRINK:   "heat-shrink",
+		MEDIA_TYPE_INCOMPATIBLE:  "incompatible",
+	}
+	if name, ok := names[media_type]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", media_type)
+}
+
+func escpFontName(font byte) string {
+	names := map[byte]string{
+		ESCP_FONT_BROUGHAM:           "brougham",
+		ESCP_FONT_LETTER_GOTHIC_BOLD: "letter-gothic-bold",
+		ESCP_FONT_BRUSSELS:           "brussels",
+		ESCP_FONT_HELSINKI:           "helsinki",
+		ESCP_FONT_SAN_DIEGO:          "san-diego",
+	}
+	if name, ok := names[font]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", font)
+}
+
+func barcodeTypeName(barcode_type byte) string {
+	names := map[byte]string{
+		ESCP_BARCODE_CODE39:  "code39",
+		ESCP_BARCODE_ITF:     "itf",
+		ESCP_BARCODE_EAN:     "ean",
+		ESCP_BARCODE_UPC_E:   "upc-e",
+		ESCP_BARCODE_CODABAR: "codabar",
+		ESCP_BARCODE_CODE128: "code128",
+		ESCP_BARCODE_GS1_128: "gs1-128",
+	}
+	if name, ok := names[barcode_type]; ok {
+		return name
+	}
+	return fmt.Sprintf("%q", barcode_type)
+}
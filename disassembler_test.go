@@ -0,0 +1,169 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// testRasterJob returns the commands of a label of two raster lines and an
+// empty line, as sent to a printer of the model.
+func testRasterJob(t *testing.T, compression bool) []byte {
+	t.Helper()
+	model_information, ok := GetModelInformationByName("PT-P700")
+	if !ok {
+		t.Fatal("PT-P700 not found")
+	}
+	model := *model_information
+	model.UseCompression = compression
+
+	var output bytes.Buffer
+	printer := New()
+	printer.SetModel(&model, 12)
+	printer.AttachWriter(&output)
+
+	line := make([]byte, DATA_LINE_BUFFER_LENGTH)
+	line[4] = 0xff
+	printer.Invalidate()
+	printer.Initialize()
+	printer.SwitchRasterMode()
+	printer.SetPrintInformation(MEDIA_TYPE_LAMINATED, 12, false, 3)
+	printer.SetCutMirror(true, false)
+	printer.SetCompression()
+	printer.SendRasterGraphics(line)
+	printer.SendRasterGraphics(bytes.Repeat([]byte{0x01}, DATA_LINE_BUFFER_LENGTH))
+	printer.SendZeroRasterGraphics()
+	printer.PrintAndFeed()
+	return output.Bytes()
+}
+
+func disassemble(data []byte) (*Disassembler, []string) {
+	disassembler := NewDisassembler()
+	var listing []string
+	for _, instruction := range append(disassembler.Decode(data), disassembler.Flush()...) {
+		listing = append(listing, instruction.String())
+	}
+	return disassembler, listing
+}
+
+func TestDisassembleRasterJob(t *testing.T) {
+	for _, test := range []struct {
+		compression bool
+		mode        string
+		lines       []string
+	}{
+		{true, "M compression=tiff", []string{"G len=17 packbits dots=8", "G len=17 packbits dots=16"}},
+		{false, "M compression=none", []string{"G len=16 raw dots=8", "G len=16 raw dots=16"}},
+	} {
+		disassembler, listing := disassemble(testRasterJob(t, test.compression))
+		expected := []string{
+			"NUL x100 invalidate",
+			"ESC @ initialize",
+			"ESC i a mode=raster",
+			"ESC i z valid=0x86 kind=laminated width=12 length=0 lines=3 page=start",
+			"ESC i M auto-cut=on mirror=off",
+			test.mode,
+			test.lines[0],
+			test.lines[1],
+			"Z empty line",
+			"SUB print and feed page=1 lines=3",
+		}
+		if !slices.Equal(listing, expected) {
+			t.Errorf("compression %t: listing\n%q\nexpected\n%q", test.compression, listing, expected)
+		}
+		if disassembler.Mode != COMMAND_MODE_RASTER || disassembler.Pages != 1 || disassembler.Lines != 3 || disassembler.Warnings != 0 {
+			t.Errorf("compression %t: mode %d, %d pages, %d lines, %d warnings", test.compression, disassembler.Mode,
+				disassembler.Pages, disassembler.Lines, disassembler.Warnings)
+		}
+	}
+}
+
+func TestDisassembleIncremental(t *testing.T) {
+	job := testRasterJob(t, true)
+	_, expected := disassemble(job)
+
+	//commands split across writes are decoded once complete, a run of NUL
+	//bytes ends with the write
+	disassembler := NewDisassembler()
+	listing := []string{disassembler.Decode(job[:100])[0].String()}
+	for _, octet := range job[100:] {
+		for _, instruction := range disassembler.Decode([]byte{octet}) {
+			listing = append(listing, instruction.String())
+		}
+	}
+	for _, instruction := range disassembler.Flush() {
+		listing = append(listing, instruction.String())
+	}
+	if !slices.Equal(listing, expected) {
+		t.Errorf("listing\n%q\nexpected\n%q", listing, expected)
+	}
+}
+
+func TestDisassembleCompression(t *testing.T) {
+	raw_line := append([]byte{'G', 0x10, 0x00}, make([]byte, 16)...)
+	packed_line := append([]byte{'G', 0x11, 0x00, 0x0f}, make([]byte, 16)...)
+
+	var job []byte
+	job = append(job, 0x1b, 'i', 'a', COMMAND_MODE_RASTER)
+	job = append(job, 'M', COMPRESSION_TIFF)
+	job = append(job, packed_line...)
+	job = append(job, 'M', 0x00)
+	job = append(job, raw_line...)
+	job = append(job, 'M', COMPRESSION_TIFF)
+	//initialize switches compression off, the raster mode is kept
+	job = append(job, 0x1b, '@')
+	job = append(job, raw_line...)
+	job = append(job, 0x0c)
+
+	disassembler, listing := disassemble(job)
+	expected := []string{
+		"ESC i a mode=raster",
+		"M compression=tiff",
+		"G len=17 packbits dots=0",
+		"M compression=none",
+		"G len=16 raw dots=0",
+		"M compression=tiff",
+		"ESC @ initialize",
+		"G len=16 raw dots=0",
+		"FF print page=1 lines=1",
+	}
+	if !slices.Equal(listing, expected) {
+		t.Errorf("listing\n%q\nexpected\n%q", listing, expected)
+	}
+	if disassembler.Compression != 0 || disassembler.Warnings != 0 {
+		t.Errorf("compression %d, %d warnings", disassembler.Compression, disassembler.Warnings)
+	}
+}
+
+func TestDisassembleWarnings(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		job      []byte
+		warnings []string
+	}{
+		{"announced lines", []byte{0x1b, 'i', 'a', COMMAND_MODE_RASTER, 0x1b, 'i', 'z', 0x86, 0x01, 12, 0, 5, 0, 0, 0, 0, 0, 'Z', 0x1a},
+			[]string{"ESC i z announced 5 lines, 1 sent"}},
+		{"empty page", []byte{0x1b, 'i', 'a', COMMAND_MODE_RASTER, 0x0c}, []string{"page without raster lines"}},
+		{"short line", []byte{0x1b, 'i', 'a', COMMAND_MODE_RASTER, 'G', 0x02, 0x00, 0xff, 0xff, 0x1a}, []string{"line of 2 bytes, expected 16"}},
+		{"packbits overrun", []byte{0x1b, 'i', 'a', COMMAND_MODE_RASTER, 'M', COMPRESSION_TIFF, 'G', 0x02, 0x00, 0x0f, 0xff, 0x1a},
+			[]string{"packbits run exceeds the data", "line of 1 bytes, expected 16"}},
+		{"not printed", []byte{0x1b, 'i', 'a', COMMAND_MODE_RASTER, 'Z', 'Z'}, []string{"2 raster lines not printed"}},
+		{"truncated", []byte{0x1b, 'i', 'a', COMMAND_MODE_RASTER, 'G', 0x10, 0x00, 0xff}, []string{"incomplete command at end of stream"}},
+		{"unknown mode", []byte{0x1b, 'i', 'a', 0x7f}, []string{"unknown command mode"}},
+		{"unexpected byte", []byte{0x1b, 'i', 'a', COMMAND_MODE_RASTER, 'x'}, []string{"unexpected byte in raster mode"}},
+	} {
+		disassembler := NewDisassembler()
+		var warnings []string
+		for _, instruction := range append(disassembler.Decode(test.job), disassembler.Flush()...) {
+			warnings = append(warnings, instruction.Warnings...)
+		}
+		if !slices.Equal(warnings, test.warnings) || disassembler.Warnings != len(test.warnings) {
+			t.Errorf("%s: warnings %q, counted %d", test.name, warnings, disassembler.Warnings)
+		}
+	}
+}
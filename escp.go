@@ -0,0 +1,206 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"fmt"
+	"strings"
+	"github.com/spag/plabel/render"
+)
+
+const (
+	ESCP_ALIGN_LEFT    = 0x00
+	ESCP_ALIGN_CENTER  = 0x01
+	ESCP_ALIGN_RIGHT   = 0x02
+	ESCP_ALIGN_JUSTIFY = 0x03
+
+	//printer-resident fonts selected by ESC k
+	ESCP_FONT_BROUGHAM           = 0x00
+	ESCP_FONT_LETTER_GOTHIC_BOLD = 0x01
+	ESCP_FONT_BRUSSELS           = 0x02
+	ESCP_FONT_HELSINKI           = 0x03
+	ESCP_FONT_SAN_DIEGO          = 0x04
+
+	//barcode types of ESC i B
+	ESCP_BARCODE_CODE39  = '0'
+	ESCP_BARCODE_ITF     = '1'
+	ESCP_BARCODE_EAN     = '5'
+	ESCP_BARCODE_UPC_E   = '6'
+	ESCP_BARCODE_CODABAR = '9'
+	ESCP_BARCODE_CODE128 = 'a'
+	ESCP_BARCODE_GS1_128 = 'b'
+
+	ESCP_BARCODE_END = '\\' //terminates the barcode data
+
+	ESCP_DEFAULT_SIZE           = 32 //dots, character size after ESC @
+	ESCP_DEFAULT_BARCODE_HEIGHT = 48 //dots
+)
+
+// EscpStyle is the text style of ESC/P labels printed with the fonts built
+// into the printer. The text runs along the tape, Size is the character
+// height in dots.
+type EscpStyle struct {
+	Size   uint16
+	Font   byte
+	Bold   bool
+	Italic bool
+	Align  byte
+}
+
+// EscpBarcode are the parameters of barcodes drawn by the printer itself.
+// Width selects the narrow bar width from 0 (extra small) to 3 (large).
+type EscpBarcode struct {
+	Type   byte
+	Height uint16
+	Width  byte
+	Text   bool //print the data below the bars
+}
+
+// ParseEscpAlign returns the alignment for the names left, center, right
+// and justify.
+func ParseEscpAlign(name string) (byte, error) {
+	switch strings.ToLower(name) {
+	case "left":
+		return ESCP_ALIGN_LEFT, nil
+	case "center", "centre":
+		return ESCP_ALIGN_CENTER, nil
+	case "right":
+		return ESCP_ALIGN_RIGHT, nil
+	case "justify":
+		return ESCP_ALIGN_JUSTIFY, nil
+	}
+	return 0, fmt.Errorf("unknown alignment %s", name)
+}
+
+// SetEscpCharacterSize sets the character height in dots (ESC X).
+func (self *Plabel) SetEscpCharacterSize(size uint16) {
+	self.SendCommand([]byte{0x1b, 0x58, 0x00, byte(size), byte(size >> 8)})
+}
+
+// SelectEscpFont selects a printer-resident font (ESC k).
+func (self *Plabel) SelectEscpFont(font byte) {
+	self.SendCommand([]byte{0x1b, 0x6b, font})
+}
+
+// SetEscpBold switches bold printing on (ESC E) or off (ESC F).
+func (self *Plabel) SetEscpBold(bold bool) {
+	if bold {
+		self.SendCommand([]byte{0x1b, 0x45})
+	} else {
+		self.SendCommand([]byte{0x1b, 0x46})
+	}
+}
+
+// SetEscpItalic switches italic printing on (ESC 4) or off (ESC 5).
+func (self *Plabel) SetEscpItalic(italic bool) {
+	if italic {
+		self.SendCommand([]byte{0x1b, 0x34})
+	} else {
+		self.SendCommand([]byte{0x1b, 0x35})
+	}
+}
+
+// SetEscpAlignment aligns the following lines (ESC a).
+func (self *Plabel) SetEscpAlignment(align byte) {
+	self.SendCommand([]byte{0x1b, 0x61, align})
+}
+
+// SetEscpStyle sends all settings of the style.
+func (self *Plabel) SetEscpStyle(style EscpStyle) {
+	self.SelectEscpFont(style.Font)
+	if style.Size > 0 {
+		self.SetEscpCharacterSize(style.Size)
+	}
+	self.SetEscpBold(style.Bold)
+	self.SetEscpItalic(style.Italic)
+	self.SetEscpAlignment(style.Align)
+}
+
+// SendEscpText sends lines of text separated by newlines, every line is
+// ended with CR LF.
+func (self *Plabel) SendEscpText(text string) {
+	for _, line := range strings.Split(text, "\n") {
+		self.SendCommand(append([]byte(strings.TrimSuffix(line, "\r")), 0x0d, 0x0a))
+	}
+}
+
+// SendEscpBarcode sends a barcode drawn by the printer (ESC i ... B).
+func (self *Plabel) SendEscpBarcode(data string, barcode EscpBarcode) {
+	text := byte('0')
+	if barcode.Text {
+		text = '1'
+	}
+	command := []byte{0x1b, 0x69, 't', barcode.Type, 's', text, 'h', byte(barcode.Height), byte(barcode.Height >> 8), 'w', '0' + barcode.Width, 'B'}
+	command = append(command, data...)
+	self.SendCommand(append(command, ESCP_BARCODE_END, 0x0d, 0x0a))
+}
+
+// PrintEscpText prints a text label with the printer-resident fonts.
+func (self *Plabel) PrintEscpText(text string, style EscpStyle) error {
+	if err := CheckEscpText(text); err != nil {
+		return err
+	}
+
+	self.Initialize()
+	self.SwitchEscpMode()
+	self.SetEscpStyle(style)
+	self.SendEscpText(text)
+	self.Print()
+	return nil
+}
+
+// PrintEscpBarcode prints a barcode label drawn by the printer, aligned as
+// given by the style.
+func (self *Plabel) PrintEscpBarcode(data string, barcode EscpBarcode, style EscpStyle) error {
+	if err := CheckEscpBarcode(data, barcode); err != nil {
+		return err
+	}
+
+	self.Initialize()
+	self.SwitchEscpMode()
+	self.SetEscpStyle(style)
+	self.SendEscpBarcode(data, barcode)
+	self.Print()
+	return nil
+}
+
+// CheckEscpText checks that the text only contains characters the printer
+// prints, control characters would be taken as commands.
+func CheckEscpText(text string) error {
+	if len(text) == 0 {
+		return fmt.Errorf("empty text")
+	}
+	for _, char := range text {
+		if (char < 0x20 && char != '\n' && char != '\r') || char > 0x7e {
+			return fmt.Errorf("character %q not supported in ESC/P mode", char)
+		}
+	}
+	return nil
+}
+
+// CheckEscpBarcode checks the barcode parameters and data.
+func CheckEscpBarcode(data string, barcode EscpBarcode) error {
+	if !strings.ContainsRune("01569ab", rune(barcode.Type)) {
+		return fmt.Errorf("unknown barcode type %q", barcode.Type)
+	}
+	if barcode.Width > 3 {
+		return fmt.Errorf("barcode width %d out of range 0-3", barcode.Width)
+	}
+	if barcode.Height == 0 {
+		return fmt.Errorf("barcode height missing")
+	}
+	if strings.ContainsRune(data, ESCP_BARCODE_END) {
+		return fmt.Errorf("barcode data must not contain %q", ESCP_BARCODE_END)
+	}
+	if barcode.Type == ESCP_BARCODE_CODE128 {
+		_, err := render.Code128Symbols(data)
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("empty barcode")
+	}
+	return CheckEscpText(data)
+}
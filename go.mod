@@ -0,0 +1,3 @@
+module github.com/spag/plabel
+
+go 1.24
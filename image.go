@@ -0,0 +1,83 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"image"
+	"image/color"
+	"github.com/spag/plabel/render"
+)
+
+// SendImage sends an image as raster graphics. The image rows are mapped to
+// the print head pins, images higher than the printing width are cropped
+// evenly at top and bottom. Every image column is one raster line.
+func (self *Plabel) SendImage(img image.Image, format string, threshold byte) bool {
+	spec := self.RasterSpec()
+	spec.Threshold = threshold
+	self.SendRaster(self.RasterImage(img, format, spec))
+	return true
+}
+
+// RasterImage converts an image into raster lines as described by the spec,
+// usually the RasterSpec of the printer with the threshold and mirroring of
+// the label set. A label can be sent several times without converting it
+// again.
+func (self *Plabel) RasterImage(img image.Image, format string, spec RasterSpec) [][]byte {
+	min_y, max_y, margin := printableRows(img, uint16(spec.PrintingWidth))
+	self.Logger.Info("raster image", "format", format, "height", img.Bounds().Dy(), "lines", img.Bounds().Dx(), "margin", margin,
+		"offset", spec.Offset + (spec.PrintingWidth - (max_y - min_y)) / 2, "printing_width", spec.PrintingWidth, "mirror", spec.Mirror)
+
+	return PackRaster(img, spec)
+}
+
+// RasterSpec returns the raster lines of the model for the installed tape.
+func (self *Plabel) RasterSpec() RasterSpec {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return NewRasterSpec(&self.ModelInformation, self.media_width)
+}
+
+// SendRaster sends raster lines created by RasterImage.
+func (self *Plabel) SendRaster(raster [][]byte) {
+	self.Logger.Debug("sending raster", "model", self.Model().ModelName, "lines", len(raster))
+	for _, line := range raster {
+		self.SendRasterGraphics(line)
+	}
+}
+
+// printableRows returns the image rows within the printing width, images
+// higher than the printing width are cropped evenly at top and bottom.
+func printableRows(img image.Image, printing_width uint16) (min_y int, max_y int, margin int) {
+	min_y = img.Bounds().Min.Y
+	max_y = img.Bounds().Max.Y
+
+	if height := max_y - min_y; height > int(printing_width) {
+		margin = (height - int(printing_width)) / 2
+		min_y += margin
+		max_y = min_y + int(printing_width)
+	}
+
+	return
+}
+
+// PreviewImage returns the label as printed by SendImage: cropped to the
+// printing width, centered on the tape and reduced to black and white.
+func PreviewImage(img image.Image, printing_width uint16, threshold byte) *image.Gray {
+	min_y, max_y, _ := printableRows(img, printing_width)
+	padding := (int(printing_width) - (max_y - min_y)) / 2
+	preview := render.NewWhiteImage(img.Bounds().Dx(), int(printing_width))
+
+	for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+		for y := min_y; y < max_y; y++ {
+			c := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			if c.Y < threshold {
+				preview.SetGray(x - img.Bounds().Min.X, y - min_y + padding, color.Gray{0})
+			}
+		}
+	}
+
+	return preview
+}
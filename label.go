@@ -0,0 +1,109 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"github.com/spag/plabel/render"
+)
+
+const (
+	LABEL_RESOLUTION = 180 //dpi of the printers supported
+	LABEL_GAP        = 8   //dots between the drawings, about 1 mm
+)
+
+// Label is composed of drawings placed one after the other along the tape,
+// every drawing is centered across the printing width of the tape. Drawings
+// higher than the printing width are cropped evenly at top and bottom.
+type Label struct {
+	Tape byte //mm
+	Gap  int  //dots between the drawings
+
+	drawings []image.Image
+	err      error
+}
+
+// NewLabel creates an empty label for the tape width in mm.
+func NewLabel(tape byte) *Label {
+	return &Label{Tape: tape, Gap: LABEL_GAP}
+}
+
+// Height returns the printing width of the tape in dots, 0 if the tape is
+// not supported.
+func (self *Label) Height() int {
+	return int(MediaWidthToMaxPixel(self.Tape, LABEL_RESOLUTION))
+}
+
+// Length returns the length of the label in dots without feed margins.
+func (self *Label) Length() int {
+	length := 0
+	for index, drawing := range self.drawings {
+		if index > 0 {
+			length += self.Gap
+		}
+		length += drawing.Bounds().Dx()
+	}
+	return length
+}
+
+// Err returns the first error of the drawings, Print fails with it.
+func (self *Label) Err() error {
+	return self.err
+}
+
+// DrawImage appends an image, its x axis runs along the tape.
+func (self *Label) DrawImage(img image.Image) {
+	self.drawings = append(self.drawings, img)
+}
+
+// DrawText appends text rendered with one of the built-in fonts as large
+// as the printing width allows, an empty font selects the default font.
+func (self *Label) DrawText(text string, font string) {
+	if self.Height() == 0 {
+		self.fail(fmt.Errorf("tape width %d mm not supported", self.Tape))
+		return
+	}
+	self.DrawImage(render.RenderTextFont(text, self.Height(), font))
+}
+
+// DrawBarcode appends a Code 128 barcode filling the printing width.
+func (self *Label) DrawBarcode(data string) {
+	if self.Height() == 0 {
+		self.fail(fmt.Errorf("tape width %d mm not supported", self.Tape))
+		return
+	}
+	img, err := render.RenderBarcode(data, self.Height())
+	if err != nil {
+		self.fail(err)
+		return
+	}
+	self.DrawImage(img)
+}
+
+func (self *Label) fail(err error) {
+	if self.err == nil {
+		self.err = err
+	}
+}
+
+// Image renders the drawings onto a white image as high as the printing
+// width of the tape.
+func (self *Label) Image() *image.Gray {
+	height := self.Height()
+	img := render.NewWhiteImage(max(self.Length(), 1), height)
+
+	x := 0
+	for _, drawing := range self.drawings {
+		bounds := drawing.Bounds()
+		top := (height - bounds.Dy()) / 2
+		target := image.Rect(x, top, x + bounds.Dx(), top + bounds.Dy())
+		draw.Draw(img, target, drawing, bounds.Min, draw.Over)
+		x += bounds.Dx() + self.Gap
+	}
+	return img
+}
@@ -0,0 +1,57 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+
+	LEVEL_TRACE = slog.LevelDebug - 4 //every command sent to the printer
+)
+
+// VerboseLevel maps the verbosity levels of the command line to log levels,
+// errors are always logged.
+func VerboseLevel(verbose uint) slog.Level {
+	switch {
+	case verbose >= VERBOSE_TRACE:
+		return LEVEL_TRACE
+	case verbose >= VERBOSE_DEBUG:
+		return slog.LevelDebug
+	case verbose >= VERBOSE_INFO:
+		return slog.LevelInfo
+	case verbose >= VERBOSE_WARN:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+// NewLogger creates a logger writing text or JSON records from the given
+// level on, the trace level is named TRACE.
+func NewLogger(writer io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.LevelKey && len(groups) == 0 && attr.Value.Any() == LEVEL_TRACE {
+				attr.Value = slog.StringValue("TRACE")
+			}
+			return attr
+		},
+	}
+
+	switch format {
+	case LOG_FORMAT_TEXT, "":
+		return slog.New(slog.NewTextHandler(writer, options)), nil
+	case LOG_FORMAT_JSON:
+		return slog.New(slog.NewJSONHandler(writer, options)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %s", format)
+}
@@ -0,0 +1,64 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022 
+ */
+
+package plabel
+
+import (
+	"strings"
+)
+
+const (
+	PRINTER_P1230PC = 0x59
+	PRINTER_H500		= 0x64
+	PRINTER_E500		= 0x65
+	PRINTER_P700		= 0x67
+	PRINTER_P750W		= 0x68
+)
+
+type ModelInformation struct {
+	ModelCode byte
+	IsValid bool
+	ModelName string
+	PixelWidth uint16
+	Resolution uint16
+	UseCompression bool
+	MinTapeWidth byte
+	MaxTapeWidth byte
+	SupportsTemplates bool //P-touch Template mode with templates stored in the printer
+	MinMargin uint16 //dots, shortest feed margin before and after a label
+}
+
+//the minimum margin is 2 mm (14 dots) for all models, as given by the raster
+//command references of the PT-H500/P700/E500 and PT-P750W
+var model_map = map[byte]ModelInformation{
+	PRINTER_P1230PC: 	ModelInformation{PRINTER_P1230PC, true, "PT-P1230PC", 64, 180, false, 4, 12, false, 14},
+	PRINTER_H500: 		ModelInformation{PRINTER_H500, 		true, "PT-H500", 		128, 180, true, 4, 24, false, 14},
+	PRINTER_E500: 		ModelInformation{PRINTER_E500, 		true, "PT-E500", 		128, 180, true, 4, 24, false, 14},
+	PRINTER_P700: 		ModelInformation{PRINTER_P700, 		true, "PT-P700", 		128, 180, true, 4, 24, false, 14},
+	PRINTER_P750W: 		ModelInformation{PRINTER_P750W, 	true, "PT-P750W", 	128, 180, true, 4, 24, true, 14},
+}
+
+func GetModelInformation(model_code byte) (*ModelInformation) {
+	return new(ModelInformation).GetModelInformation(model_code)
+}
+
+func (self *ModelInformation) GetModelInformation(model_code byte) *ModelInformation {
+	if model_information, ok := model_map[model_code]; ok {
+    return &model_information
+	}
+
+	return &ModelInformation{ModelCode: model_code, ModelName: "Unknown"}
+}
+
+// GetModelInformationByName looks up a model by its name, e.g. "PT-P700".
+func GetModelInformationByName(model_name string) (*ModelInformation, bool) {
+	for _, model_information := range model_map {
+		if strings.EqualFold(model_information.ModelName, model_name) {
+			return &model_information, true
+		}
+	}
+
+	return &ModelInformation{ModelName: "Unknown"}, false
+}
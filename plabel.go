@@ -3,235 +3,596 @@
  * Copyright (c) 2021-2022 
  */
 
-package main
+// Package plabel drives Brother P-touch label printers: the command
+// protocol in raster, ESC/P and template mode, the printer status and the
+// conversion of labels into raster lines. Labels are rendered by package
+// render, printers are found, recorded and emulated by package transport.
+package plabel
 
 import (
-  "syscall"
-  "fmt"
-  "os"
-	"os/signal"
-	"flag"
-  "image/color"
-	"image"
-  _ "image/gif"
-	_ "image/png"
-	_ "image/jpeg"
-	"plabel"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"encoding/binary"
 )
 
 const (
-  PROGRAM_NAME = "plabel"
-  PROGRAM_VERSION = "0.0.1"
+	VERBOSE_TRACE = 4
+	VERBOSE_DEBUG = 3
+	VERBOSE_INFO  = 2
+	VERBOSE_WARN  = 1
+
+	LOOP_DELAY = 100 //ms
+
+	COMMAND_MODE_ESCP 		= 0x00 //ESC/P mode (default)
+	COMMAND_MODE_RASTER 	= 0x01 //Raster mode
+	COMMAND_MODE_TEMPLATE = 0x03 //P-touch Template mode 
+
+	PI_KIND 		= 0x02  // Media type
+	PI_WIDTH 		= 0x04  // Media width
+	PI_LENGTH 	= 0x08  // Media length
+	PI_QUALITY 	= 0x40  // Priority given to print quality(Not used)
+	PI_RECOVER 	= 0x80  // Printer recovery always on
+
+	MEDIA_TYPE_NO_TAPE 				= 0x00
+	MEDIA_TYPE_LAMINATED 			= 0x01
+	MEDIA_TYPE_NON_LAMINATED 	= 0x03
+	MEDIA_TYPE_HEAT_SHRINK 		= 0x11
+	MEDIA_TYPE_INCOMPATIBLE		= 0xff
+
+	DATA_LINE_PIXEL_WIDTH 	= 128
+	DATA_LINE_BUFFER_LENGTH = 16
+
+	COMPRESSION_TIFF = 0x02
+
+	RECORD_COMMAND = 'C' //bytes sent to the printer
+	RECORD_STATUS  = 'S' //status frame received from the printer
 )
 
-type Settings struct {
-	pid_file string
-  printer_device string
-	image_file string
-	black_threshold uint
-  verbose uint
-  simulate bool
-  show_info bool
-  batch_mode bool
-  no_front_cut bool
-  mirror bool
-}
-
-func CopyrightMessage() {
-  fmt.Fprintf(os.Stderr, "%s - Version %s\nCopyright (c) 2021-2022, sipomat ltd.\n\n", PROGRAM_NAME, PROGRAM_VERSION)
-}
-
-func ReadCommandLineParameters(settings *Settings) {
-  flag.Usage = func() {
-    CopyrightMessage()
-    fmt.Fprintf(os.Stderr, "Usage: %s <parameters>\n\n", os.Args[0])
-    //flag.PrintDefaults()
-		fmt.Println(`  -h, --help                  Print usage information
-      --pid-file <file>       Save Process-ID to file
-  -p, --printer <device>      Printer device
-  -f, --file <file>           Print from file (png)
-  -t, --threshold <0-255>     Threshold at which a pixel is determined black
-  -v, --verbose <0-4>         Verbosity level
-  -b, --batch-mode            Chain printing without feeding and end cut
-  -m, --mirror                Mirror output
-  -n, --no-cut                No front cut
-  -s, --simulate              Just simulate, do not print.
-  -i, --info                  Get printer information
-		`)
-    os.Exit(1)
-  }
-
-	flag.StringVar(&settings.printer_device, "p", "/dev/usb/lp0", "Printer")
-  flag.StringVar(&settings.printer_device, "printer", "/dev/usb/lp0", "Printer")
-	flag.StringVar(&settings.pid_file, "pid-file", "", "Save Process-ID to file")
-	flag.StringVar(&settings.image_file, "f", "", "Prit from file")
-	flag.StringVar(&settings.image_file, "file", "", "Prit from file")
-	flag.UintVar(&settings.black_threshold, "t", 182, "Threshold at which a pixel is determined black")
-	flag.UintVar(&settings.black_threshold, "threshold", 182, "Threshold at which a pixel is determined black")
-  flag.BoolVar(&settings.simulate, "s", false, "simulate")
-  flag.BoolVar(&settings.simulate, "simulate", false, "simulate")
-  flag.UintVar(&settings.verbose, "v", 2, "Verbosity level")
-	flag.UintVar(&settings.verbose, "verbose", 2, "Verbosity level")
-  flag.BoolVar(&settings.show_info, "i", false, "printer info")
-  flag.BoolVar(&settings.show_info, "info", false, "printer info")
-  flag.BoolVar(&settings.batch_mode, "b", false, "batch_mode printing")
-  flag.BoolVar(&settings.batch_mode, "batch-mode", false, "batch_mode printing")
-  flag.BoolVar(&settings.no_front_cut, "n", false, "no-cut printing")
-  flag.BoolVar(&settings.no_front_cut, "no-cut", false, "no-cut printing")
-  flag.BoolVar(&settings.mirror, "m", false, "mirror printing")
-  flag.BoolVar(&settings.mirror, "mirror", false, "mirror printing")
-  flag.Parse()
-}
-
-func CreatePIDFile(pid_file_name string) error {
-  pid_file, err := os.Create(pid_file_name)
-  if err != nil {
-    return fmt.Errorf("error creating PID file: %s\n", err)
-  }
-
-  defer pid_file.Close()
-
-  if _, err = fmt.Fprintf(pid_file, "%d", os.Getpid()) ;  err != nil {
-    return fmt.Errorf("error creating PID file: %s\n", err)
-  }
-
-  return nil
-}
-
-func SendFile(printer *plabel.Plabel, file_name string, threshold byte) bool {
-  var index byte
-  var height int
-  var length int
-  var padding byte
-  var margin int
-
-  fd, err := os.Open(file_name)
+// SessionRecorder records the commands sent to and the status frames
+// received from the printer, see transport.Recorder.
+type SessionRecorder interface {
+	Record(kind byte, data []byte)
+	Close() error
+}
+
+type Plabel struct {
+	recorder SessionRecorder
+	disassembler *Disassembler //decodes the commands traced
+	active atomic.Bool
+
+	//the device and the status are shared with ProcessStatus
+	mutex sync.Mutex
+	device io.ReadWriteCloser
+	status_updated bool
+	is_printing bool
+	status_time time.Time //when the last status frame was received
+	media_width byte //mm, of the tape given to SetModel
+
+	Logger *slog.Logger //discards the records unless set
+	Simulate bool
+
+	//written by ProcessStatus, read them with Status, Model and PrintingWidth
+	//while it runs
+	PrinterStatus PrinterStatus
+	ModelInformation ModelInformation
+	StatusCode byte
+	InitalSettings bool
+
+	MaxPrintingWidth uint16
+}
 
+func New() (self *Plabel) {
+	self = new(Plabel)
+	self.active.Store(true)
+	self.Logger = slog.New(slog.DiscardHandler)
+	self.MaxPrintingWidth = 128
+	return
+}
+
+func (self *Plabel) Open(device_path string) bool {
+	var err error
+
+	self.Logger.Info("using printer device", "device", device_path)
+	device, err := os.OpenFile(device_path, os.O_RDWR, 0)
 	if err != nil {
-    fmt.Fprintf(os.Stderr, "ERROR opeing file: %s\n", err)
+		self.Logger.Error("opening printer", "device", device_path, "error", err)
 		return false
 	}
+	self.Attach(device)
+	return true
+}
 
-	defer fd.Close()
+// Reopen closes the device and opens the device node again, e.g. after the
+// printer was switched off or plugged in again.
+func (self *Plabel) Reopen(device_path string) bool {
+	self.closeDevice()
+	return self.Open(device_path)
+}
 
-	img, format, err := image.Decode(fd)
-	if err != nil {
-    fmt.Fprintf(os.Stderr, "ERROR decoding file: %s\n", err)
-		return false
+// Attach uses an already opened stream as printer device.
+func (self *Plabel) Attach(device io.ReadWriteCloser) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.device = device
+}
+
+// AttachWriter uses a write-only stream as printer device, e.g. stdout of a
+// print filter. No status is received from such a device.
+func (self *Plabel) AttachWriter(writer io.Writer) {
+	self.Attach(&write_only_device{writer})
+}
+
+func (self *Plabel) getDevice() io.ReadWriteCloser {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.device
+}
+
+func (self *Plabel) closeDevice() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.device != nil {
+		self.device.Close()
+		self.device = nil
+	}
+}
+
+// Record records the commands sent and the status frames received from now
+// on, the recorder is closed with the printer.
+func (self *Plabel) Record(recorder SessionRecorder) {
+	self.recorder = recorder
+}
+
+func (self *Plabel) Close() {
+	self.active.Store(false)
+	self.closeDevice()
+	if self.recorder != nil {
+		if err := self.recorder.Close(); err != nil {
+			self.Logger.Error("recording session", "error", err)
+		}
 	}
+}
 
-  length = img.Bounds().Max.X - img.Bounds().Min.X
+// SetModel sets the model information and the printing width for the
+// installed media without a status from the printer.
+func (self *Plabel) SetModel(model_information *ModelInformation, media_width byte) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.setModel(model_information, media_width)
+}
 
-  height = img.Bounds().Max.Y - img.Bounds().Min.Y
-  min_y := img.Bounds().Min.Y
-  max_y := img.Bounds().Max.Y
+// setModel is SetModel, mutex must be held.
+func (self *Plabel) setModel(model_information *ModelInformation, media_width byte) {
+	self.ModelInformation = *model_information
+	self.media_width = media_width
 
-  if height > int(printer.MaxPrintingWidth) {
-    margin = (height - int(printer.MaxPrintingWidth)) / 2
-    min_y = img.Bounds().Min.Y + margin
-    max_y = img.Bounds().Max.Y - margin
-    height = max_y - min_y
-  }
+	media_max_pixel := MediaWidthToMaxPixel(media_width, self.ModelInformation.Resolution)
+	self.MaxPrintingWidth = self.ModelInformation.PixelWidth
+	if media_max_pixel < self.ModelInformation.PixelWidth {
+		self.MaxPrintingWidth = media_max_pixel
+	}
+}
 
-  padding = byte((plabel.DATA_LINE_PIXEL_WIDTH-height)/2)
+func (self *Plabel) ProcessStatus() {
+	self.Logger.Debug("waiting for printer status")
+	for self.active.Load() {
+		device := self.getDevice()
+		if device == nil {
+			time.Sleep(LOOP_DELAY * time.Millisecond)
+			continue
+		}
 
-  fmt.Printf("SendFile - type: %s, height: %d px, length: %d px, margins: %d px, padding: %d px, printing width: %d\n", format, img.Bounds().Max.Y - img.Bounds().Min.Y, length, margin, padding, printer.MaxPrintingWidth)
+		var status PrinterStatus
+		frame := make([]byte, binary.Size(status))
+		_, err := io.ReadFull(device, frame)
+		if err == nil {
+			if self.recorder != nil {
+				self.recorder.Record(RECORD_STATUS, frame)
+			}
+			err = binary.Read(bytes.NewReader(frame), binary.LittleEndian, &status)
+		}
 
-	for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
-    index = 0
-    line := make([]byte, plabel.DATA_LINE_BUFFER_LENGTH)
-		for y := min_y; y < max_y; y++ {
-			c := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
-			if c.Y < threshold {
-        octet := (index + padding)/8
-        line[octet] |= (1 << (7-((index + padding) % 8)))
+		if err != nil {
+			time.Sleep(LOOP_DELAY * time.Millisecond)
+			continue
+		}
 
-			}     
-      index += 1
+		if !status.IsValid() {
+			self.Logger.Error("invalid status frame from printer", "frame", fmt.Sprintf("% x", frame))
+			time.Sleep(10 * time.Second)
 		}
 
-    printer.SendRasterGraphics(line)
+		self.mutex.Lock()
+		self.PrinterStatus = status
+		if !self.InitalSettings {
+			self.InitalSettings = true
+			self.setModel(GetModelInformation(status.ModelCode), status.MediaWidth)
+		}
+
+		self.StatusCode = status.StatusCode
+		self.status_time = time.Now()
+		self.status_updated = true
+		
+		if status.StatusCode == STATUS_PHASE_CHANGE {
+			self.is_printing = status.PhaseType == PHASE_PRINTING
+		}
+		model_name := self.ModelInformation.ModelName
+		self.mutex.Unlock()
+
+		self.logStatus(&status, model_name)
+	}
+}
+
+// Status returns a copy of the last status received.
+func (self *Plabel) Status() PrinterStatus {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.PrinterStatus
+}
+
+// Model returns a copy of the model information.
+func (self *Plabel) Model() ModelInformation {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.ModelInformation
+}
+
+// PrintingWidth returns the printable dots across the installed tape.
+func (self *Plabel) PrintingWidth() uint16 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.MaxPrintingWidth
+}
+
+// StatusTime returns when the last status frame was received.
+func (self *Plabel) StatusTime() time.Time {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.status_time
+}
+
+// logStatus logs the status received, errors with the error bits set and
+// the details of the media at debug level.
+func (self *Plabel) logStatus(status *PrinterStatus, model_name string) {
+	attributes := []any{
+		"model", model_name,
+		"status_code", status.StatusCode,
+		"status", status.StatusDescription(),
+		"phase", status.PhaseTypeDescription(),
+	}
+	if status.ErrorCode > 0 {
+		var errors []string
+		for _, error_bit := range status.ErrorList() {
+			errors = append(errors, error_bit.Description)
+		}
+		self.Logger.Warn("printer error", append(attributes, "error_code", status.ErrorCode, "errors", errors)...)
+		return
+	}
+	self.Logger.Info("printer status", attributes...)
+	self.Logger.Debug("printer media",
+		"notification", status.NotificationDescription(),
+		"media_type", status.MediaTypeDescription(),
+		"media_width", status.MediaWidth,
+		"media_length", status.MediaLength,
+		"tape_color", status.TapeColorDescription(),
+		"text_color", status.TextColorDescription())
+}
+
+func (self *Plabel) SendCommand(command []byte) {
+	//simulated jobs are recorded too, to capture them without a printer
+	if self.recorder != nil {
+		self.recorder.Record(RECORD_COMMAND, command)
+	}
+	if self.Logger.Enabled(context.Background(), LEVEL_TRACE) {
+		self.traceCommand(command)
+	}
+	if self.Simulate {
+		return
+	}
+	if device := self.getDevice(); device != nil {
+		device.Write(command)
+	}
+}
+
+// traceCommand logs the commands sent as decoded by the disassembler.
+func (self *Plabel) traceCommand(command []byte) {
+	if self.disassembler == nil {
+		self.disassembler = NewDisassembler()
+	}
+	for _, instruction := range self.disassembler.Decode(command) {
+		self.Logger.Log(context.Background(), LEVEL_TRACE, "command", "offset", instruction.Offset, "command", instruction.String())
+		for _, warning := range instruction.Warnings {
+			self.Logger.Warn("malformed command", "offset", instruction.Offset, "command", instruction.Name, "warning", warning)
+		}
+	}
+}
+
+func (self *Plabel) Invalidate() {
+	self.SendCommand(make([]byte, 100))
+}
+
+func (self *Plabel) Initialize() {
+	self.SendCommand([]byte{0x1b, 0x40})
+}
+
+func (self *Plabel) RequestStatus() {
+	self.ResetStatus()
+	self.SendCommand([]byte{0x1b, 0x69, 0x53})
+}
+
+func (self *Plabel) SwitchDynamicCommandMode(mode byte) {
+	self.SendCommand([]byte{0x1b, 0x69, 0x61, mode})
+}
+
+func (self *Plabel) SwitchRasterMode() {
+	self.SwitchDynamicCommandMode(COMMAND_MODE_RASTER)
+}
+
+func (self *Plabel) SwitchEscpMode() {
+	self.SwitchDynamicCommandMode(COMMAND_MODE_ESCP)
+}
+
+func (self *Plabel) SetPrintInformation(media_type byte, media_width byte, is_starting_page bool, raster_number uint32) {
+	var valid_flag byte
+	//var media_type byte
+	//var media_width byte
+	var media_length byte
+	var starting_page byte
+	var raster_number_0 byte
+	var raster_number_1 byte
+	var raster_number_2 byte
+	var raster_number_3 byte
+
+	valid_flag = PI_RECOVER
+	if media_type > MEDIA_TYPE_NO_TAPE {
+		valid_flag += PI_KIND
+	}
+	if media_width > 0 {
+		valid_flag += PI_WIDTH
 	}
+	
+	if is_starting_page {
+		starting_page = 1
+	}
+
+	raster_number_0 = byte(raster_number & 0x000000ff)
+	raster_number_1 = byte((raster_number >> 8) & 0x000000ff)
+	raster_number_2 = byte((raster_number >> 16) & 0x000000ff)
+	raster_number_3 = byte((raster_number >> 24) & 0x000000ff)
 
-  return true
+	self.SendCommand([]byte{0x1B, 0x69, 0x7A, valid_flag, media_type, media_width, media_length, raster_number_0, raster_number_1, raster_number_2, raster_number_3, starting_page, 0x00})
 }
 
-func main() {
-  var settings Settings
-	var run_process bool = true
+func (self *Plabel) SetCutMirror(cut bool, mirror bool) {
+	var settings byte
 
-	ReadCommandLineParameters(&settings)
+	//Setst the cut at the beginning of the page. End cut is done anyway if no chain printing enabled
+	if cut {
+		settings |= (1 << 6)
+	}
+	if mirror {
+		settings |= (1 << 7)
+	}
+
+	self.SendCommand([]byte{0x1B, 0x69, 0x4d, settings})
+}
+
+func (self *Plabel) SetAdvancedModeSettings(half_cut bool, no_chain_printing bool, special_tape bool, no_buffer_clearing bool) {
+	var settings byte
 
-  if len(settings.pid_file) > 0 {
-    if err := CreatePIDFile(settings.pid_file) ; err != nil {
-      fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR creating PID file: ", err)
-      os.Exit(1)
-    } else {
-      fmt.Println(PROGRAM_NAME, " created PID file: ", settings.pid_file)
-    }
+	//Half cut between chained labels, the tape backing is left intact
+	if half_cut {
+		settings |= (1 << 2)
 	}
 
-  printer := 	plabel.New()
-  printer.Simulate = settings.simulate
-  printer.Verbose = byte(settings.verbose)
+	//true = No chain printing(Feeding and cutting are performed after the last one is printed.)
+	//false = Chain printing(Feeding and cutting are not performed after the last one is printed.)
+	if no_chain_printing {
+		settings |= (1 << 3)
+	}
 
-  if !printer.Open(settings.printer_device) {
-    fmt.Fprintln(os.Stderr, PROGRAM_NAME, "ERROR opening printer: ", settings.printer_device)
-    if (!settings.simulate) {
-      os.Exit(1)
-    }
-  }
+	//Labels are not cut when special tape is installed.
+	//Special tape (no cutting) on/off
+	if special_tape {
+		settings |= (1 << 4)
+	}
 
-  defer printer.Close()
-  go printer.ProcessStatus()
+	//No buffer clearing when printing on/off
+	if no_buffer_clearing {
+		settings |= (1 << 7)
+	}
 
-  printer.WaitForPrinterStatus(1000)
+	self.SendCommand([]byte{0x1B, 0x69, 0x4B, settings}) 
+}
 
-  printer.Invalidate()
-  printer.Initialize()
-  printer.RequestStatus()
+func (self *Plabel) SetFeedMargins(margin_dots uint16) {
+	low_octet := byte(margin_dots & 0xff)
+	high_octet := byte((margin_dots >> 8) & 0xff)
+	self.SendCommand([]byte{0x1B, 0x69, 0x64, low_octet, high_octet})
+}
+
+func (self *Plabel) Print() {
+	self.ResetStatus()
+	self.SendCommand([]byte{0x0c})
+	if self.Simulate {
+		self.setStatusCode(STATUS_PRINTING_COMPLETED)
+	}
+}
+
+func (self *Plabel) PrintAndFeed() {
+	self.ResetStatus()
+	self.SendCommand([]byte{0x1a})
+	if self.Simulate {
+		self.setStatusCode(STATUS_PRINTING_COMPLETED)
+	}
+}
+
+func (self *Plabel) setStatusCode(status_code byte) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.StatusCode = status_code
+}
 
-  printer.WaitForPrinterStatus(1000)
+// SetCompression selects the compression of the lines sent by
+// SendRasterGraphics, models without compression get uncompressed lines.
+func (self *Plabel) SetCompression() {
+	if self.Model().UseCompression {
+		self.SendCommand([]byte{0x4D, COMPRESSION_TIFF})
+	} else {
+		self.SendCommand([]byte{0x4D, 0x00})
+	}
+}
 
-  if (settings.show_info) {
-    printer.ShowInfo()
-  }
-  
-  if len(settings.image_file) > 0 {
-    printer.SwitchRasterMode()
-    printer.SetCutMirror(!settings.no_front_cut, settings.mirror)
-    printer.SetCompression()
-    //printer.SetFeedMargins(16)
-    //printer.SetPrintInformation(0, 0, false, 0)
-    if SendFile(printer, settings.image_file, byte(settings.black_threshold)) {
-      if settings.batch_mode {
-        printer.Print()
-      } else {
-        printer.PrintAndFeed()
-      }
-    }
+func (self *Plabel) SendZeroRasterGraphics() {
+	self.SendCommand([]byte{0x5a})
+}
 
-    printer.WaitForPrintingCompleted(10000)
-  }
+func (self *Plabel) SendRasterGraphics(raster_data []byte) {
+	if self.Model().UseCompression {
+		self.SendRasterGraphicsCompressed(raster_data)
+	} else {
+		self.SendRasterGraphicsUncompressed(raster_data)
+	}
+}
 
-  run_process = false
+// SendRasterGraphicsUncompressed sends a raster line of any length, the
+// length is given by the G command.
+func (self *Plabel) SendRasterGraphicsUncompressed(raster_data []byte) {
+	rb := []byte{0x47, byte(len(raster_data)), byte(len(raster_data) >> 8)}
+	rb = append(rb, raster_data...)
+
+	if self.Logger.Enabled(context.Background(), slog.LevelDebug) {
+		self.DisplayRasterGraphics(raster_data)
+	}
+
+	self.SendCommand(rb)
+}
 
-	signal_channel := make(chan os.Signal)
-  signal.Notify(signal_channel, syscall.SIGINT)
-  signal.Notify(signal_channel, syscall.SIGTERM)
-  signal.Notify(signal_channel, syscall.SIGHUP)
+// SendRasterGraphicsCompressed sends a raster line as PackBits literal runs,
+// a run holds at most 128 bytes.
+func (self *Plabel) SendRasterGraphicsCompressed(raster_data []byte) {
+	var packed []byte
+	for line := raster_data; len(line) > 0; {
+		run := min(len(line), 128)
+		packed = append(packed, byte(run - 1))
+		packed = append(packed, line[:run]...)
+		line = line[run:]
+	}
+	rb := []byte{0x47, byte(len(packed)), byte(len(packed) >> 8)}
+	rb = append(rb, packed...)
+
+	if self.Logger.Enabled(context.Background(), slog.LevelDebug) {
+		self.DisplayRasterGraphics(raster_data)
+	}
+
+	self.SendCommand(rb)
+}
+
+// UnpackBits decompresses TIFF PackBits data, truncated runs are copied as
+// far as they go and reported by ok.
+func UnpackBits(data []byte) (line []byte, ok bool) {
+	ok = true
+	for position := 0; position < len(data); {
+		count := int(int8(data[position]))
+		position++
+		switch {
+		case count >= 0:
+			end := position + count + 1
+			if end > len(data) {
+				end, ok = len(data), false
+			}
+			line = append(line, data[position:end]...)
+			position = end
+		case count == -128:
+			//no operation
+		case position < len(data):
+			line = append(line, bytes.Repeat(data[position:position+1], 1 - count)...)
+			position++
+		default:
+			ok = false
+		}
+	}
+	return
+}
+
+// DisplayRasterGraphics logs a raster line as picture of the pins, the last
+// pin first.
+func (self *Plabel) DisplayRasterGraphics(raster_data []byte) {
+	spec := self.RasterSpec()
+	var pins strings.Builder
+	for pin := spec.Pins - 1; pin >= 0; pin-- {
+		if spec.Pin(raster_data, pin) {
+			pins.WriteString("█")
+		} else {
+			pins.WriteString(" ")
+		}
+	}
+
+	self.Logger.Debug("raster line", "pins", pins.String())
+}
+
+func (self *Plabel) TimeMilliseconds() int64 {
+	return time.Now().UnixNano() / 1000000  
+}
+
+func (self *Plabel) ResetStatus() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.status_updated = false
+}
+
+// statusUpdated reports whether a status was received since ResetStatus and
+// whether the printer is printing.
+func (self *Plabel) statusUpdated() (updated bool, printing bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.status_updated, self.is_printing
+}
+
+func (self *Plabel) WaitForPrinterStatus(timeout uint16) bool {
+	start_time := self.TimeMilliseconds()
 	
-  for run_process {    
-    select {
-    case signal_rec := <-signal_channel:
-      if signal_rec == syscall.SIGHUP {
-        fmt.Println(PROGRAM_NAME, " SIGNAL - received hangup signal: ", signal_rec)
-      } else {
-        fmt.Println(PROGRAM_NAME, " SIGNAL - received signal: ", signal_rec)
-        run_process = false
-      }
-    }
-  }
-
-  fmt.Println(PROGRAM_NAME, " ended")
+	for self.active.Load() && self.TimeMilliseconds() < start_time + int64(timeout)  {
+		if updated, _ := self.statusUpdated(); updated {
+			return true
+		}
+		time.Sleep(LOOP_DELAY * time.Millisecond)
+	}
+	return false
+}
+
+func (self *Plabel) WaitForPrintingCompleted(timeout uint16) bool {
+	start_time := self.TimeMilliseconds()
+	
+	for self.active.Load() && self.TimeMilliseconds() < start_time + int64(timeout) {
+		if updated, printing := self.statusUpdated(); updated && !printing {
+			return true
+		}
+		time.Sleep(LOOP_DELAY * time.Millisecond)
+	}
+	return false
+}
+
+type write_only_device struct {
+	writer io.Writer
+}
+
+func (self *write_only_device) Read(data []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (self *write_only_device) Write(data []byte) (int, error) {
+	return self.writer.Write(data)
+}
+
+func (self *write_only_device) Close() error {
+	return nil
 }
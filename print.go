@@ -0,0 +1,241 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"time"
+	"github.com/spag/plabel/render"
+)
+
+const (
+	DEFAULT_THRESHOLD   = 182
+	DEFAULT_FEED_MARGIN = 14    //dots the printers feed before and after every label
+	PRINT_TIMEOUT       = 10000 //ms waiting for a label without deadline of the context
+)
+
+// PrintOptions control how labels are printed, the zero value prints one
+// label fed and cut with the feed margin of the printer.
+type PrintOptions struct {
+	Threshold     byte   //pixels darker are printed, 0 selects DEFAULT_THRESHOLD
+	Dither        bool   //dither gray areas instead of applying the threshold
+	Mirror        bool   //reverse the raster lines, the printer does not mirror
+	NoFrontCut    bool
+	HalfCut       bool
+	Chain         bool   //neither feed nor cut after the label, the next one follows
+	SetFeedMargin bool   //send FeedMargin instead of keeping the printer default
+	FeedMargin    uint16 //dots before and after the label
+	Length        int    //dots including the feed margins, 0 keeps the length of the label
+	Align         byte   //ALIGN_LEFT, ALIGN_CENTER or ALIGN_RIGHT within Length
+	Copies        int    //0 prints one copy
+}
+
+// FeedMargin returns the feed margin of the options in dots, the minimum
+// margin of the model if the printer default is kept. Models of unknown
+// minimum use DEFAULT_FEED_MARGIN.
+func (self *Plabel) FeedMargin(options PrintOptions) int {
+	if options.SetFeedMargin {
+		return int(options.FeedMargin)
+	}
+	return int(self.minMargin())
+}
+
+func (self *Plabel) minMargin() uint16 {
+	if minimum := self.Model().MinMargin; minimum > 0 {
+		return minimum
+	}
+	return DEFAULT_FEED_MARGIN
+}
+
+// PrintLabel prints the copies of a label, chained but for the last one. It
+// fails without sending anything if the label does not fit the tape
+// installed or the printer reports an error.
+func (self *Plabel) PrintLabel(ctx context.Context, label *Label, options PrintOptions) error {
+	if err := self.checkLabel(label); err != nil {
+		return err
+	}
+
+	raster, err := self.rasterLabel(label.Image(), "label", LABEL_RESOLUTION, options)
+	if err != nil {
+		return err
+	}
+
+	copies := max(options.Copies, 1)
+	for copy := 0; copy < copies; copy++ {
+		copy_options := options
+		copy_options.Chain = options.Chain || copy < copies - 1
+		if err := self.PrintRaster(ctx, raster, copy_options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RasterLabel converts a label image into raster lines for the printer,
+// dithered or thresholded and mirrored as given by the options and fitted
+// to their length. Copies of the label reuse the raster lines.
+func (self *Plabel) RasterLabel(img image.Image, format string, options PrintOptions) ([][]byte, error) {
+	return self.rasterLabel(img, format, 0, options)
+}
+
+// rasterLabel is RasterLabel for images of another resolution than the
+// printer, 0 if equal.
+func (self *Plabel) rasterLabel(img image.Image, format string, resolution uint16, options PrintOptions) ([][]byte, error) {
+	if options.Dither {
+		img = render.Dither(img)
+	}
+	threshold := options.Threshold
+	if threshold == 0 {
+		threshold = DEFAULT_THRESHOLD
+	}
+	spec := self.RasterSpec()
+	spec.Threshold = threshold
+	spec.Mirror = options.Mirror
+	spec.ImageResolution = resolution
+	return self.FitLabel(self.RasterImage(img, format, spec), options)
+}
+
+func (self *Plabel) checkLabel(label *Label) error {
+	if err := label.Err(); err != nil {
+		return err
+	}
+	if label.Height() == 0 {
+		return fmt.Errorf("tape width %d mm not supported", label.Tape)
+	}
+	if label.Length() == 0 {
+		return fmt.Errorf("empty label")
+	}
+	status := self.Status()
+	if status.ErrorCode > 0 {
+		return fmt.Errorf("printer error: %s", status.ErrorDescription())
+	}
+	if width := status.MediaWidth; width > 0 && width != label.Tape {
+		return fmt.Errorf("wrong tape: %d mm installed, label for %d mm", width, label.Tape)
+	}
+	return nil
+}
+
+// CheckPrintOptions checks the feed margin against the minimum of the model
+// and that the label length leaves room between the margins. Models of
+// unknown minimum are held to DEFAULT_FEED_MARGIN.
+func (self *Plabel) CheckPrintOptions(options PrintOptions) error {
+	model_information := self.Model()
+	resolution := float64(model_information.Resolution)
+	if resolution == 0 {
+		resolution = LABEL_RESOLUTION
+	}
+	minimum := self.minMargin()
+	if options.SetFeedMargin && options.FeedMargin < minimum {
+		return fmt.Errorf("feed margin %.1f mm below the minimum of %.1f mm of %s", float64(options.FeedMargin) * render.MM_PER_INCH / resolution,
+			float64(minimum) * render.MM_PER_INCH / resolution, model_information.ModelName)
+	}
+	if options.Length > 0 && options.Length <= 2 * self.FeedMargin(options) {
+		return fmt.Errorf("label length %.1f mm not longer than twice the feed margin of %.1f mm", float64(options.Length) * render.MM_PER_INCH / resolution,
+			float64(self.FeedMargin(options)) * render.MM_PER_INCH / resolution)
+	}
+	return nil
+}
+
+// FitLabel pads or clips the raster lines of a label to the length of the
+// options, the feed margins before and after the label included. Mirrored
+// labels are read from the back of the tape, left and right alignment are
+// swapped.
+func (self *Plabel) FitLabel(raster [][]byte, options PrintOptions) ([][]byte, error) {
+	if options.Length == 0 {
+		return raster, nil
+	}
+	if err := self.CheckPrintOptions(options); err != nil {
+		return nil, err
+	}
+
+	length := options.Length - 2 * self.FeedMargin(options)
+	if len(raster) > length {
+		self.Logger.Warn("label clipped", "lines", len(raster), "length", length)
+	}
+	align := options.Align
+	if options.Mirror {
+		switch align {
+		case ALIGN_LEFT:
+			align = ALIGN_RIGHT
+		case ALIGN_RIGHT:
+			align = ALIGN_LEFT
+		}
+	}
+	spec := self.RasterSpec()
+	return spec.FitRaster(raster, length, align), nil
+}
+
+// PrintRaster prints one label of raster lines created by RasterImage and
+// waits until printing is completed, copies reuse the raster lines.
+func (self *Plabel) PrintRaster(ctx context.Context, raster [][]byte, options PrintOptions) error {
+	if err := self.CheckPrintOptions(options); err != nil {
+		return err
+	}
+
+	self.SwitchRasterMode()
+	//the raster lines are mirrored already
+	self.SetCutMirror(!options.NoFrontCut, false)
+	if options.HalfCut {
+		self.SetAdvancedModeSettings(true, false, false, false)
+	}
+	if options.SetFeedMargin {
+		self.SetFeedMargins(options.FeedMargin)
+	}
+	self.SetCompression()
+	self.SendRaster(raster)
+
+	if options.Chain {
+		self.Print()
+	} else {
+		self.PrintAndFeed()
+	}
+
+	return self.WaitForPrinting(ctx)
+}
+
+// WaitForPrinting waits until printing is completed and fails if the
+// printer reports an error or the context is canceled first. PRINT_TIMEOUT
+// applies if the context has no deadline.
+func (self *Plabel) WaitForPrinting(ctx context.Context) error {
+	completed := self.waitForPrintingCompleted(ctx)
+	if status := self.Status(); status.StatusCode == STATUS_ERROR {
+		return fmt.Errorf("printer error: %s", status.ErrorDescription())
+	}
+	if !completed && !self.Simulate {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("timeout waiting for printing to complete")
+	}
+	return nil
+}
+
+// waitForPrintingCompleted is WaitForPrintingCompleted ending with the
+// context, PRINT_TIMEOUT applies if the context has no deadline.
+func (self *Plabel) waitForPrintingCompleted(ctx context.Context) bool {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, PRINT_TIMEOUT * time.Millisecond)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(LOOP_DELAY * time.Millisecond)
+	defer ticker.Stop()
+	for self.active.Load() {
+		if updated, printing := self.statusUpdated(); updated && !printing {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return false
+}
@@ -5,6 +5,10 @@
 
 package plabel
 
+import (
+	"slices"
+)
+
 const (
 	STATUS_PRINTING_COMPLETED = 0x01
 	STATUS_ERROR 							= 0x02
@@ -12,6 +16,15 @@ const (
 
 	PHASE_EDITING		= 0x00
 	PHASE_PRINTING 	= 0x01
+
+	//bits of ErrorCode
+	ERROR_NO_MEDIA             = 0x0001
+	ERROR_CUTTER_JAM           = 0x0004
+	ERROR_WEAK_BATTERIES       = 0x0008
+	ERROR_HIGH_VOLTAGE_ADAPTER = 0x0040
+	ERROR_WRONG_MEDIA          = 0x0100
+	ERROR_COVER_OPEN           = 0x1000
+	ERROR_OVERHEATING          = 0x2000
 )
 
 type PrinterStatus struct {
@@ -47,25 +60,69 @@ func (self *PrinterStatus) IsValid() (bool) {
 }
 
 func (self *PrinterStatus) ErrorDescription() (ed string) {
-	error_map := map[uint16]string{
-		0x0001: "No media",
-		0x0004: "Cutter jam",
-		0x0008: "Weak batteries",
-		0x0040: "High-voltage adapter",
-		0x0100: "Wrong media",
-		0x1000: "Cover open",
-		0x2000: "Overheating",
-	}
-
-	for bitmask, description := range error_map {
-		if self.ErrorCode & bitmask > 0 {
-			ed += description + " "
+	for _, error_bit := range self.ErrorList() {
+		ed += error_bit.Description + " "
+	}
+
+	return
+}
+
+var error_bits = []CodeDescription{
+	{ERROR_NO_MEDIA, "No media"},
+	{ERROR_CUTTER_JAM, "Cutter jam"},
+	{ERROR_WEAK_BATTERIES, "Weak batteries"},
+	{ERROR_HIGH_VOLTAGE_ADAPTER, "High-voltage adapter"},
+	{ERROR_WRONG_MEDIA, "Wrong media"},
+	{ERROR_COVER_OPEN, "Cover open"},
+	{ERROR_OVERHEATING, "Overheating"},
+}
+
+// KnownErrors returns all error bits the printers report, ordered by bit.
+func KnownErrors() []CodeDescription {
+	return slices.Clone(error_bits)
+}
+
+// ErrorList returns the error bits set in ErrorCode, ordered by bit.
+func (self *PrinterStatus) ErrorList() (errors []CodeDescription) {
+	errors = []CodeDescription{}
+	for _, error_bit := range error_bits {
+		if self.ErrorCode & uint16(error_bit.Code) > 0 {
+			errors = append(errors, error_bit)
 		}
 	}
 
 	return
 }
 
+// StateReasons maps the error bits to IPP printer-state-reasons keywords, as
+// also used by the CUPS STATE: messages.
+func (self *PrinterStatus) StateReasons() (reasons []string) {
+	reason_bits := []struct {
+		bitmask uint16
+		reason string
+	}{
+		{ERROR_NO_MEDIA, "media-empty"},
+		{ERROR_CUTTER_JAM, "media-jam"},
+		{ERROR_WEAK_BATTERIES, "other-warning"},
+		{ERROR_HIGH_VOLTAGE_ADAPTER, "other"},
+		{ERROR_WRONG_MEDIA, "media-needed"},
+		{ERROR_COVER_OPEN, "cover-open"},
+		{ERROR_OVERHEATING, "other"},
+	}
+
+	for _, reason_bit := range reason_bits {
+		if self.ErrorCode & reason_bit.bitmask > 0 && !slices.Contains(reasons, reason_bit.reason) {
+			reasons = append(reasons, reason_bit.reason)
+		}
+	}
+
+	if len(reasons) == 0 {
+		reasons = append(reasons, "none")
+	}
+
+	return
+}
+
 func (self *PrinterStatus) StatusDescription() (ed string) {
 	status_map := map[byte]string{
 		0x00: "Reply to status request",
@@ -0,0 +1,183 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+const (
+	PIN_ORDER_MSB_FIRST = 0 //first pin in the most significant bit of the first byte
+	PIN_ORDER_LSB_FIRST = 1 //first pin in the least significant bit of the first byte
+
+	ALIGN_LEFT   = ESCP_ALIGN_LEFT   //at the start of the label, printed first
+	ALIGN_CENTER = ESCP_ALIGN_CENTER
+	ALIGN_RIGHT  = ESCP_ALIGN_RIGHT
+)
+
+// ParseAlign parses the alignment of a label along the tape: left, center
+// or right.
+func ParseAlign(name string) (byte, error) {
+	switch strings.ToLower(name) {
+	case "left":
+		return ALIGN_LEFT, nil
+	case "center", "centre":
+		return ALIGN_CENTER, nil
+	case "right":
+		return ALIGN_RIGHT, nil
+	}
+	return 0, fmt.Errorf("unknown label alignment %s", name)
+}
+
+// RasterSpec describes how the rows of an image are mapped onto the pins of
+// the print head. The image x axis runs along the tape, every column becomes
+// one raster line with the top row on the first pin of the printing width.
+type RasterSpec struct {
+	Pins            int    //pins of the print head, one bit per pin in a line
+	PrintingWidth   int    //pins covered by the tape
+	Offset          int    //first pin of the printing width
+	PinOrder        byte   //PIN_ORDER_MSB_FIRST or PIN_ORDER_LSB_FIRST within a byte
+	Resolution      uint16 //dpi of the print head
+	ImageResolution uint16 //dpi of the images, scaled to Resolution, 0 if equal
+	Mirror          bool   //reverse the lines instead of mirroring by the printer
+	Threshold       byte   //pixels darker are printed
+}
+
+// NewRasterSpec returns the raster lines of the model with tape of the given
+// width in mm installed, the printing width centered on the print head.
+func NewRasterSpec(model_information *ModelInformation, media_width byte) RasterSpec {
+	resolution := model_information.Resolution
+	if resolution == 0 {
+		resolution = LABEL_RESOLUTION
+	}
+
+	printing_width := int(MediaWidthToMaxPixel(media_width, resolution))
+	if model_information.PixelWidth > 0 && (printing_width == 0 || printing_width > int(model_information.PixelWidth)) {
+		printing_width = int(model_information.PixelWidth)
+	}
+	if printing_width == 0 {
+		printing_width = DATA_LINE_PIXEL_WIDTH
+	}
+	spec := NewRasterSpecWidth(printing_width, resolution)
+	if pins := int(model_information.PixelWidth); pins > spec.Pins {
+		//print heads of 360 dpi have more pins than the lines of 180 dpi
+		spec.Pins, spec.Offset = pins, (pins - printing_width) / 2
+	}
+	return spec
+}
+
+// NewRasterSpecWidth returns the raster lines for the printing width in
+// pins, centered on the print head.
+func NewRasterSpecWidth(printing_width int, resolution uint16) RasterSpec {
+	return RasterSpec{
+		Pins:          DATA_LINE_PIXEL_WIDTH,
+		PrintingWidth: printing_width,
+		Offset:        (DATA_LINE_PIXEL_WIDTH - printing_width) / 2,
+		PinOrder:      PIN_ORDER_MSB_FIRST,
+		Resolution:    resolution,
+		Threshold:     DEFAULT_THRESHOLD,
+	}
+}
+
+// LineLength returns the length of a raster line in bytes.
+func (self *RasterSpec) LineLength() int {
+	return (self.Pins + 7) / 8
+}
+
+// SetPin sets the bit of a pin in a raster line, pins outside the line are
+// ignored.
+func (self *RasterSpec) SetPin(line []byte, pin int) {
+	if octet, bit := self.pinBit(pin); octet >= 0 && octet < len(line) {
+		line[octet] |= bit
+	}
+}
+
+// Pin returns whether a pin is set in a raster line.
+func (self *RasterSpec) Pin(line []byte, pin int) bool {
+	octet, bit := self.pinBit(pin)
+	return octet >= 0 && octet < len(line) && line[octet] & bit != 0
+}
+
+func (self *RasterSpec) pinBit(pin int) (octet int, bit byte) {
+	if pin < 0 || pin >= self.Pins {
+		return -1, 0
+	}
+	if self.PinOrder == PIN_ORDER_LSB_FIRST {
+		return pin / 8, 1 << (pin % 8)
+	}
+	return pin / 8, 1 << (7 - pin % 8)
+}
+
+// PackRaster converts an image into raster lines ready to be sent. Images
+// higher than the printing width are cropped evenly at top and bottom, lower
+// ones are centered within the printing width.
+func PackRaster(img image.Image, spec RasterSpec) [][]byte {
+	if spec.ImageResolution > 0 && spec.ImageResolution != spec.Resolution {
+		img = scaleImage(img, int(spec.Resolution), int(spec.ImageResolution))
+	}
+
+	bounds := img.Bounds()
+	min_y, max_y, _ := printableRows(img, uint16(spec.PrintingWidth))
+	first_pin := spec.Offset + (spec.PrintingWidth - (max_y - min_y)) / 2
+
+	raster := make([][]byte, 0, bounds.Dx())
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		line := make([]byte, spec.LineLength())
+		for y := min_y; y < max_y; y++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < spec.Threshold {
+				spec.SetPin(line, first_pin + y - min_y)
+			}
+		}
+		raster = append(raster, line)
+	}
+
+	if spec.Mirror {
+		for left, right := 0, len(raster) - 1; left < right; left, right = left + 1, right - 1 {
+			raster[left], raster[right] = raster[right], raster[left]
+		}
+	}
+	return raster
+}
+
+// FitRaster pads raster lines with blank lines or clips them to the length
+// in lines, aligned along the tape by ALIGN_LEFT, ALIGN_CENTER or
+// ALIGN_RIGHT.
+func (self *RasterSpec) FitRaster(raster [][]byte, length int, align byte) [][]byte {
+	offset := 0
+	switch align {
+	case ALIGN_CENTER:
+		offset = (length - len(raster)) / 2
+	case ALIGN_RIGHT:
+		offset = length - len(raster)
+	}
+
+	fitted := make([][]byte, length)
+	for index := range fitted {
+		if source := index - offset; source >= 0 && source < len(raster) {
+			fitted[index] = raster[source]
+		} else {
+			fitted[index] = make([]byte, self.LineLength())
+		}
+	}
+	return fitted
+}
+
+// scaleImage scales an image by numerator/denominator sampling the nearest
+// pixel, e.g. images of 180 dpi for a print head of 360 dpi by 2/1.
+func scaleImage(img image.Image, numerator int, denominator int) *image.Gray {
+	bounds := img.Bounds()
+	scaled := image.NewGray(image.Rect(0, 0, bounds.Dx() * numerator / denominator, bounds.Dy() * numerator / denominator))
+	for y := 0; y < scaled.Rect.Dy(); y++ {
+		for x := 0; x < scaled.Rect.Dx(); x++ {
+			c := color.GrayModel.Convert(img.At(bounds.Min.X + x * denominator / numerator, bounds.Min.Y + y * denominator / numerator)).(color.Gray)
+			scaled.SetGray(x, y, c)
+		}
+	}
+	return scaled
+}
@@ -0,0 +1,217 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"bytes"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testImage returns an image of rows with '#' black, '+' gray and other
+// characters white pixels.
+func testImage(rows ...string) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, pixel := range row {
+			switch pixel {
+			case '#':
+				img.SetGray(x, y, color.Gray{0x00})
+			case '+':
+				img.SetGray(x, y, color.Gray{0x80})
+			default:
+				img.SetGray(x, y, color.Gray{0xff})
+			}
+		}
+	}
+	return img
+}
+
+func hexLines(raster [][]byte) []string {
+	lines := make([]string, len(raster))
+	for index, line := range raster {
+		lines[index] = hex.EncodeToString(line)
+	}
+	return lines
+}
+
+func TestPackRaster(t *testing.T) {
+	width_4 := NewRasterSpecWidth(4, LABEL_RESOLUTION) //pins 62 to 65 of 128
+	lsb_first := width_4
+	lsb_first.PinOrder = PIN_ORDER_LSB_FIRST
+	mirror := width_4
+	mirror.Mirror = true
+	threshold := width_4
+	threshold.Threshold = 0x80
+	scaled := NewRasterSpecWidth(4, 360)
+	scaled.ImageResolution = LABEL_RESOLUTION
+	full_width := NewRasterSpecWidth(DATA_LINE_PIXEL_WIDTH, LABEL_RESOLUTION)
+
+	const blank = "00000000000000000000000000000000"
+	for _, test := range []struct {
+		name  string
+		spec  RasterSpec
+		image *image.Gray
+		lines []string
+	}{
+		{"msb first", width_4, testImage("#", "#", "#", "#"), []string{"0000000000000003c000000000000000"}},
+		{"lsb first", lsb_first, testImage("#", "#", "#", "#"), []string{"00000000000000c00300000000000000"}},
+		{"top row on first pin", width_4, testImage("#", ".", ".", "."), []string{"00000000000000020000000000000000"}},
+		{"centered", width_4, testImage("#", "#"), []string{"00000000000000018000000000000000"}},
+		{"cropped", width_4, testImage("#", "#", "#", ".", ".", "#"), []string{"00000000000000030000000000000000"}},
+		{"lines along the tape", width_4, testImage("#.", "..", "..", ".."), []string{"00000000000000020000000000000000", blank}},
+		{"mirror", mirror, testImage("#.", "..", "..", ".."), []string{blank, "00000000000000020000000000000000"}},
+		{"threshold", threshold, testImage("+", "#", "+", "+"), []string{"00000000000000010000000000000000"}},
+		{"180 dpi image on 360 dpi", scaled, testImage("#", "."), []string{"00000000000000030000000000000000", "00000000000000030000000000000000"}},
+		{"full width", full_width, testImage(slices.Repeat([]string{"#"}, DATA_LINE_PIXEL_WIDTH)...), []string{"ffffffffffffffffffffffffffffffff"}},
+	} {
+		lines := hexLines(PackRaster(test.image, test.spec))
+		if !slices.Equal(lines, test.lines) {
+			t.Errorf("%s: lines %v, expected %v", test.name, lines, test.lines)
+		}
+	}
+}
+
+func TestNewRasterSpec(t *testing.T) {
+	p700, _ := GetModelInformationByName("PT-P700")
+	p1230pc, _ := GetModelInformationByName("PT-P1230PC")
+
+	for _, test := range []struct {
+		name           string
+		model          *ModelInformation
+		media_width    byte
+		pins           int
+		printing_width int
+		offset         int
+		resolution     uint16
+	}{
+		{"PT-P700 12 mm", p700, 12, 128, 70, 29, 180},
+		{"PT-P700 24 mm", p700, 24, 128, 128, 0, 180},
+		{"PT-P1230PC 12 mm", p1230pc, 12, 128, 64, 32, 180},
+		{"unknown model", &ModelInformation{}, 0, 128, 128, 0, 180},
+		{"360 dpi print head", &ModelInformation{PixelWidth: 256, Resolution: 360}, 12, 256, 256, 0, 360},
+	} {
+		spec := NewRasterSpec(test.model, test.media_width)
+		if spec.Pins != test.pins || spec.PrintingWidth != test.printing_width || spec.Offset != test.offset || spec.Resolution != test.resolution {
+			t.Errorf("%s: %d pins, printing width %d, offset %d at %d dpi", test.name, spec.Pins, spec.PrintingWidth, spec.Offset, spec.Resolution)
+		}
+	}
+}
+
+func TestSendRasterGraphics(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		model      *ModelInformation
+		line       []byte
+		compressed bool
+		command    string
+	}{
+		{"16 bytes", &ModelInformation{PixelWidth: 128}, bytes.Repeat([]byte{0xa5}, 16), false,
+			"471000" + strings.Repeat("a5", 16)},
+		{"16 bytes packbits", &ModelInformation{PixelWidth: 128, UseCompression: true}, bytes.Repeat([]byte{0xa5}, 16), true,
+			"4711000f" + strings.Repeat("a5", 16)},
+		{"32 bytes", &ModelInformation{PixelWidth: 256}, bytes.Repeat([]byte{0xa5}, 32), false,
+			"472000" + strings.Repeat("a5", 32)},
+		{"32 bytes packbits", &ModelInformation{PixelWidth: 256, UseCompression: true}, bytes.Repeat([]byte{0xa5}, 32), true,
+			"4721001f" + strings.Repeat("a5", 32)},
+		//a literal run holds at most 128 bytes
+		{"160 bytes packbits", &ModelInformation{PixelWidth: 1280, UseCompression: true}, bytes.Repeat([]byte{0xa5}, 160), true,
+			"47a2007f" + strings.Repeat("a5", 128) + "1f" + strings.Repeat("a5", 32)},
+	} {
+		var output bytes.Buffer
+		printer := New()
+		printer.SetModel(test.model, 12)
+		printer.AttachWriter(&output)
+		printer.SendRasterGraphics(test.line)
+		if command := hex.EncodeToString(output.Bytes()); command != test.command {
+			t.Errorf("%s: command %s, expected %s", test.name, command, test.command)
+		}
+		if line, ok := UnpackBits(output.Bytes()[3:]); test.compressed && (!ok || !bytes.Equal(line, test.line)) {
+			t.Errorf("%s: unpacked line %x", test.name, line)
+		}
+	}
+}
+
+func TestFitRaster(t *testing.T) {
+	spec := NewRasterSpecWidth(4, LABEL_RESOLUTION)
+	raster := PackRaster(testImage("#.", "..", "..", ".."), spec)
+	const label = "00000000000000020000000000000000"
+	const blank = "00000000000000000000000000000000"
+
+	for _, test := range []struct {
+		align byte
+		lines []string
+	}{
+		{ALIGN_LEFT, []string{label, blank, blank, blank}},
+		{ALIGN_CENTER, []string{blank, label, blank, blank}},
+		{ALIGN_RIGHT, []string{blank, blank, label, blank}},
+	} {
+		if lines := hexLines(spec.FitRaster(raster, 4, test.align)); !slices.Equal(lines, test.lines) {
+			t.Errorf("align %d: lines %v, expected %v", test.align, lines, test.lines)
+		}
+	}
+	if lines := hexLines(spec.FitRaster(raster, 1, ALIGN_LEFT)); !slices.Equal(lines, []string{label}) {
+		t.Errorf("clipped lines %v", lines)
+	}
+}
+
+func TestFitLabelMirror(t *testing.T) {
+	printer := New()
+	p700, _ := GetModelInformationByName("PT-P700")
+	printer.SetModel(p700, 12)
+
+	spec := printer.RasterSpec()
+	spec.Mirror = true
+	raster := PackRaster(testImage("#."), spec)
+	options := PrintOptions{Length: 2 * DEFAULT_FEED_MARGIN + 4, Align: ALIGN_LEFT, Mirror: true}
+	fitted, err := printer.FitLabel(raster, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//read from the back of the tape, the label is aligned to the end
+	for index, line := range fitted {
+		if printed := slices.ContainsFunc(line, func(octet byte) bool { return octet != 0 }); printed != (index == 3) {
+			t.Errorf("line %d printed %t", index, printed)
+		}
+	}
+}
+
+func TestParseAlign(t *testing.T) {
+	for name, expected := range map[string]byte{"left": ALIGN_LEFT, "Center": ALIGN_CENTER, "centre": ALIGN_CENTER, "right": ALIGN_RIGHT} {
+		if align, err := ParseAlign(name); err != nil || align != expected {
+			t.Errorf("%s: alignment %d, %v", name, align, err)
+		}
+	}
+	if _, err := ParseAlign("justify"); err == nil {
+		t.Error("labels justified")
+	}
+}
+
+func TestCheckPrintOptions(t *testing.T) {
+	p700, _ := GetModelInformationByName("PT-P700")
+	for _, test := range []struct {
+		model   *ModelInformation
+		margin  uint16
+		success bool
+	}{
+		{p700, 14, true},
+		{p700, 7, false},
+		//unknown models are held to the default margin
+		{&ModelInformation{ModelName: "Unknown"}, 14, true},
+		{&ModelInformation{ModelName: "Unknown"}, 7, false},
+	} {
+		printer := New()
+		printer.SetModel(test.model, 12)
+		err := printer.CheckPrintOptions(PrintOptions{SetFeedMargin: true, FeedMargin: test.margin})
+		if (err == nil) != test.success {
+			t.Errorf("%s with margin %d: %v", test.model.ModelName, test.margin, err)
+		}
+	}
+}
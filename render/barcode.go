@@ -0,0 +1,157 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+const (
+	CODE128_START_B = 104
+	CODE128_START_C = 105
+	CODE128_CODE_B  = 100
+	CODE128_CODE_C  = 99
+	CODE128_STOP    = 106
+
+	BARCODE_MODULE_WIDTH = 2  //px per module, 0.28 mm at 180 dpi
+	BARCODE_QUIET_ZONE   = 10 //modules before and after the bars
+	BARCODE_MIN_TEXT_HEIGHT = 32 //px, lower barcodes are printed without text
+)
+
+// Bar and space widths of the Code 128 symbols in modules, the stop symbol
+// includes the termination bar.
+var code128_patterns = []string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+// Code128Symbols encodes printable ASCII text as Code 128 symbol values
+// including start, check and stop symbols. Runs of four and more digits are
+// packed into code set C, everything else uses code set B.
+func Code128Symbols(data string) ([]int, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty barcode")
+	}
+	for _, char := range data {
+		if char < 0x20 || char > 0x7e {
+			return nil, fmt.Errorf("character %q not supported by Code 128", char)
+		}
+	}
+
+	digit_run := func(position int) int {
+		count := 0
+		for position + count < len(data) && data[position + count] >= '0' && data[position + count] <= '9' {
+			count++
+		}
+		return count
+	}
+
+	var symbols []int
+	code_c := false
+	if run := digit_run(0); run == len(data) && run % 2 == 0 || run >= 4 {
+		symbols = append(symbols, CODE128_START_C)
+		code_c = true
+	} else {
+		symbols = append(symbols, CODE128_START_B)
+	}
+
+	for position := 0; position < len(data); {
+		run := digit_run(position)
+		switch {
+		case code_c && run >= 2:
+			symbols = append(symbols, int(data[position] - '0') * 10 + int(data[position + 1] - '0'))
+			position += 2
+			continue
+		case code_c:
+			symbols = append(symbols, CODE128_CODE_B)
+			code_c = false
+		case run >= 4:
+			//an odd run is started in code set B with one digit
+			if run % 2 == 1 {
+				symbols = append(symbols, int(data[position]) - 0x20)
+				position++
+			}
+			symbols = append(symbols, CODE128_CODE_C)
+			code_c = true
+			continue
+		}
+		symbols = append(symbols, int(data[position]) - 0x20)
+		position++
+	}
+
+	check := symbols[0]
+	for index, symbol := range symbols[1:] {
+		check += (index + 1) * symbol
+	}
+	symbols = append(symbols, check % 103, CODE128_STOP)
+	return symbols, nil
+}
+
+// RenderBarcode renders data as Code 128 barcode for the given height,
+// usually the printing width of the tape. The bars run across the tape,
+// the text is printed below the bars if the label is high enough.
+func RenderBarcode(data string, height int) (*image.Gray, error) {
+	symbols, err := Code128Symbols(data)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := Code128Modules(symbols)
+
+	bar_height := height
+	text_width, text_height := TextSize(data, 1)
+	if height >= BARCODE_MIN_TEXT_HEIGHT {
+		bar_height = height - text_height - FONT_CELL_HEIGHT/2
+	}
+
+	img := NewWhiteImage(max(modules * BARCODE_MODULE_WIDTH, text_width), height)
+
+	DrawCode128(img, symbols, 0, 0, BARCODE_MODULE_WIDTH, bar_height)
+
+	if bar_height < height {
+		DrawText(img, data, (img.Rect.Dx() - text_width) / 2, height - text_height, 1)
+	}
+
+	return img, nil
+}
+
+// Code128Modules returns the width of the symbols in modules including the
+// quiet zones.
+func Code128Modules(symbols []int) int {
+	modules := 2 * BARCODE_QUIET_ZONE
+	for _, symbol := range symbols {
+		for _, width := range code128_patterns[symbol] {
+			modules += int(width - '0')
+		}
+	}
+	return modules
+}
+
+// DrawCode128 draws the bars of the symbols, x and y being the top left
+// corner of the quiet zone.
+func DrawCode128(img *image.Gray, symbols []int, x int, y int, module int, height int) {
+	x += BARCODE_QUIET_ZONE * module
+	for _, symbol := range symbols {
+		for index, width := range code128_patterns[symbol] {
+			bar_width := int(width - '0') * module
+			if index % 2 == 0 {
+				FillRect(img, x, y, bar_width, height, color.Gray{0})
+			}
+			x += bar_width
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"image"
+	"image/color"
+)
+
+// Dither reduces an image to black and white with Floyd-Steinberg error
+// diffusion, gray areas are kept as dot patterns instead of being cut off
+// at the threshold.
+func Dither(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	dithered := image.NewGray(image.Rect(0, 0, width, bounds.Dy()))
+
+	current := make([]int, width + 2)
+	next := make([]int, width + 2)
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < width; x++ {
+			current[x + 1] += int(color.GrayModel.Convert(img.At(bounds.Min.X + x, bounds.Min.Y + y)).(color.Gray).Y)
+		}
+
+		for x := 0; x < width; x++ {
+			value := current[x + 1]
+			output := 0
+			if value >= 128 {
+				output = 0xff
+			}
+			dithered.Pix[y*dithered.Stride + x] = byte(output)
+
+			diffusion := value - output
+			current[x + 2] += diffusion * 7 / 16
+			next[x] += diffusion * 3 / 16
+			next[x + 1] += diffusion * 5 / 16
+			next[x + 2] += diffusion / 16
+		}
+
+		current, next = next, current
+		clear(next)
+	}
+
+	return dithered
+}
+
+// RotateClockwise rotates an image by 90 degrees clockwise. Pages with the
+// tape width as page width are turned into the label orientation this way.
+func RotateClockwise(img *image.Gray) *image.Gray {
+	bounds := img.Bounds()
+	rotated := image.NewGray(image.Rect(0, 0, bounds.Dy(), bounds.Dx()))
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			rotated.SetGray(bounds.Dy() - 1 - y, x, img.GrayAt(bounds.Min.X + x, bounds.Min.Y + y))
+		}
+	}
+
+	return rotated
+}
+
+// TrimImage crops the white margins of an image, pixels lighter than the
+// threshold count as white. Nil is returned for blank images.
+func TrimImage(img *image.Gray, threshold byte) *image.Gray {
+	bounds := img.Bounds()
+	trimmed := image.Rectangle{}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if img.GrayAt(x, y).Y < threshold {
+				trimmed = trimmed.Union(image.Rect(x, y, x + 1, y + 1))
+			}
+		}
+	}
+
+	if trimmed.Empty() {
+		return nil
+	}
+	return img.SubImage(trimmed).(*image.Gray)
+}
@@ -0,0 +1,399 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+const (
+	PATH_SUBSAMPLES     = 4   //scanlines per pixel row for anti-aliasing
+	PATH_FLATNESS       = 0.5 //px, segment length of flattened curves
+	PATH_MAX_SEGMENTS   = 256 //per curve
+	PATH_CIRCLE_SEGMENTS = 24
+
+	CAP_BUTT   = 0
+	CAP_ROUND  = 1
+	CAP_SQUARE = 2
+)
+
+// Point is a position in pixels.
+type Point struct {
+	X float64
+	Y float64
+}
+
+// Matrix is an affine transformation as used by SVG and PDF:
+// x' = a*x + c*y + e, y' = b*x + d*y + f.
+type Matrix [6]float64
+
+var IdentityMatrix = Matrix{1, 0, 0, 1, 0, 0}
+
+func TranslateMatrix(x float64, y float64) Matrix {
+	return Matrix{1, 0, 0, 1, x, y}
+}
+
+func ScaleMatrix(x float64, y float64) Matrix {
+	return Matrix{x, 0, 0, y, 0, 0}
+}
+
+// RotateMatrix rotates by angle degrees, clockwise on the y-down image.
+func RotateMatrix(angle float64) Matrix {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	return Matrix{cos, sin, -sin, cos, 0, 0}
+}
+
+// Multiply returns the transformation applying other first and then self.
+func (self Matrix) Multiply(other Matrix) Matrix {
+	return Matrix{
+		self[0]*other[0] + self[2]*other[1],
+		self[1]*other[0] + self[3]*other[1],
+		self[0]*other[2] + self[2]*other[3],
+		self[1]*other[2] + self[3]*other[3],
+		self[0]*other[4] + self[2]*other[5] + self[4],
+		self[1]*other[4] + self[3]*other[5] + self[5],
+	}
+}
+
+func (self Matrix) Apply(x float64, y float64) Point {
+	return Point{self[0]*x + self[2]*y + self[4], self[1]*x + self[3]*y + self[5]}
+}
+
+// Invert returns the inverse transformation, singular matrices have none.
+func (self Matrix) Invert() (Matrix, bool) {
+	determinant := self[0]*self[3] - self[1]*self[2]
+	if determinant == 0 {
+		return IdentityMatrix, false
+	}
+	a, b, c, d := self[3]/determinant, -self[1]/determinant, -self[2]/determinant, self[0]/determinant
+	return Matrix{a, b, c, d, -(a*self[4] + c*self[5]), -(b*self[4] + d*self[5])}, true
+}
+
+// Scale returns the mean scale factor, used for line widths.
+func (self Matrix) Scale() float64 {
+	return math.Sqrt(math.Abs(self[0]*self[3] - self[1]*self[2]))
+}
+
+// Path is a set of polygons in pixels. Coordinates given to the drawing
+// methods are transformed by the path matrix, curves are flattened into
+// line segments.
+type Path struct {
+	Matrix   Matrix
+	polygons [][]Point
+	current  Point
+	closed   bool
+}
+
+func NewPath(matrix Matrix) *Path {
+	return &Path{Matrix: matrix}
+}
+
+func (self *Path) MoveTo(x float64, y float64) {
+	self.current = self.Matrix.Apply(x, y)
+	self.polygons = append(self.polygons, []Point{self.current})
+	self.closed = false
+}
+
+func (self *Path) LineTo(x float64, y float64) {
+	self.lineTo(self.Matrix.Apply(x, y))
+}
+
+func (self *Path) lineTo(point Point) {
+	if len(self.polygons) == 0 || self.closed {
+		self.polygons = append(self.polygons, []Point{self.current})
+		self.closed = false
+	}
+	last := len(self.polygons) - 1
+	self.polygons[last] = append(self.polygons[last], point)
+	self.current = point
+}
+
+// QuadTo adds a quadratic Bézier curve.
+func (self *Path) QuadTo(x1 float64, y1 float64, x float64, y float64) {
+	p0 := self.current
+	p1 := self.Matrix.Apply(x1, y1)
+	p2 := self.Matrix.Apply(x, y)
+
+	segments := curveSegments(p0, p1, p2)
+	for step := 1; step <= segments; step++ {
+		t := float64(step) / float64(segments)
+		u := 1 - t
+		self.lineTo(Point{
+			u*u*p0.X + 2*u*t*p1.X + t*t*p2.X,
+			u*u*p0.Y + 2*u*t*p1.Y + t*t*p2.Y,
+		})
+	}
+}
+
+// CubicTo adds a cubic Bézier curve.
+func (self *Path) CubicTo(x1 float64, y1 float64, x2 float64, y2 float64, x float64, y float64) {
+	p0 := self.current
+	p1 := self.Matrix.Apply(x1, y1)
+	p2 := self.Matrix.Apply(x2, y2)
+	p3 := self.Matrix.Apply(x, y)
+
+	segments := curveSegments(p0, p1, p2, p3)
+	for step := 1; step <= segments; step++ {
+		t := float64(step) / float64(segments)
+		u := 1 - t
+		self.lineTo(Point{
+			u*u*u*p0.X + 3*u*u*t*p1.X + 3*u*t*t*p2.X + t*t*t*p3.X,
+			u*u*u*p0.Y + 3*u*u*t*p1.Y + 3*u*t*t*p2.Y + t*t*t*p3.Y,
+		})
+	}
+}
+
+// Close closes the current polygon, the next segment starts at its first point.
+func (self *Path) Close() {
+	if len(self.polygons) == 0 {
+		return
+	}
+	polygon := self.polygons[len(self.polygons)-1]
+	if first := polygon[0]; first != self.current {
+		self.lineTo(first)
+	}
+	self.closed = true
+}
+
+// Rect adds a closed rectangle.
+func (self *Path) Rect(x float64, y float64, width float64, height float64) {
+	self.MoveTo(x, y)
+	self.LineTo(x+width, y)
+	self.LineTo(x+width, y+height)
+	self.LineTo(x, y+height)
+	self.Close()
+}
+
+// Ellipse adds a closed ellipse around cx, cy.
+func (self *Path) Ellipse(cx float64, cy float64, rx float64, ry float64) {
+	//four cubic curves, kappa approximates a quarter circle
+	const kappa = 0.5522847498
+	self.MoveTo(cx+rx, cy)
+	self.CubicTo(cx+rx, cy+ry*kappa, cx+rx*kappa, cy+ry, cx, cy+ry)
+	self.CubicTo(cx-rx*kappa, cy+ry, cx-rx, cy+ry*kappa, cx-rx, cy)
+	self.CubicTo(cx-rx, cy-ry*kappa, cx-rx*kappa, cy-ry, cx, cy-ry)
+	self.CubicTo(cx+rx*kappa, cy-ry, cx+rx, cy-ry*kappa, cx+rx, cy)
+	self.Close()
+}
+
+// Append adds the polygons of another path.
+func (self *Path) Append(other *Path) {
+	for _, polygon := range other.polygons {
+		if len(polygon) > 1 {
+			self.polygons = append(self.polygons, polygon)
+		}
+	}
+	self.current = other.current
+}
+
+// Bounds returns the bounding box of the path in pixels.
+func (self *Path) Bounds() (min Point, max Point, ok bool) {
+	min = Point{math.Inf(1), math.Inf(1)}
+	max = Point{math.Inf(-1), math.Inf(-1)}
+	for _, polygon := range self.polygons {
+		for _, point := range polygon {
+			min = Point{math.Min(min.X, point.X), math.Min(min.Y, point.Y)}
+			max = Point{math.Max(max.X, point.X), math.Max(max.Y, point.Y)}
+			ok = true
+		}
+	}
+	return
+}
+
+func curveSegments(points ...Point) int {
+	length := 0.0
+	for index := 1; index < len(points); index++ {
+		length += math.Hypot(points[index].X-points[index-1].X, points[index].Y-points[index-1].Y)
+	}
+	return max(1, min(PATH_MAX_SEGMENTS, int(math.Ceil(length/PATH_FLATNESS/4))))
+}
+
+// StrokeOutline returns the outline of the path stroked with the given line
+// width in pixels. Joins are drawn round, open polygons get the line cap.
+// The outline is filled with the non-zero rule.
+func (self *Path) StrokeOutline(width float64, line_cap int) *Path {
+	outline := NewPath(IdentityMatrix)
+	half := width / 2
+	if half <= 0 {
+		return outline
+	}
+
+	for _, polygon := range self.polygons {
+		if len(polygon) == 0 {
+			continue
+		}
+		closed := len(polygon) > 2 && polygon[0] == polygon[len(polygon)-1]
+
+		for index := 1; index < len(polygon); index++ {
+			p0, p1 := polygon[index-1], polygon[index]
+			length := math.Hypot(p1.X-p0.X, p1.Y-p0.Y)
+			if length == 0 {
+				continue
+			}
+			dx, dy := (p1.X-p0.X)/length*half, (p1.Y-p0.Y)/length*half
+			if !closed && line_cap == CAP_SQUARE {
+				if index == 1 {
+					p0 = Point{p0.X - dx, p0.Y - dy}
+				}
+				if index == len(polygon)-1 {
+					p1 = Point{p1.X + dx, p1.Y + dy}
+				}
+			}
+
+			outline.polygons = append(outline.polygons, []Point{
+				{p0.X - dy, p0.Y + dx},
+				{p1.X - dy, p1.Y + dx},
+				{p1.X + dy, p1.Y - dx},
+				{p0.X + dy, p0.Y - dx},
+			})
+		}
+
+		for index, point := range polygon {
+			end := index == 0 || index == len(polygon)-1
+			if !end || closed || line_cap == CAP_ROUND || len(polygon) == 1 && line_cap != CAP_BUTT {
+				outline.circle(point, half)
+			}
+		}
+	}
+	return outline
+}
+
+// circle adds a polygon with the same orientation as the stroke segments.
+func (self *Path) circle(center Point, radius float64) {
+	polygon := make([]Point, PATH_CIRCLE_SEGMENTS)
+	for index := range polygon {
+		sin, cos := math.Sincos(-2 * math.Pi * float64(index) / PATH_CIRCLE_SEGMENTS)
+		polygon[index] = Point{center.X + radius*cos, center.Y + radius*sin}
+	}
+	self.polygons = append(self.polygons, polygon)
+}
+
+type path_edge struct {
+	x0, y0, x1, y1 float64
+	direction      int
+}
+
+type path_crossing struct {
+	x         float64
+	direction int
+}
+
+// Fill paints the path into the image with the given gray value and opacity,
+// using the even-odd or non-zero winding rule. Edges are anti-aliased.
+func (self *Path) Fill(img *image.Gray, c color.Gray, opacity float64, even_odd bool) {
+	var edges []path_edge
+	for _, polygon := range self.polygons {
+		for index := range polygon {
+			p0, p1 := polygon[index], polygon[(index+1)%len(polygon)]
+			switch {
+			case p0.Y < p1.Y:
+				edges = append(edges, path_edge{p0.X, p0.Y, p1.X, p1.Y, 1})
+			case p0.Y > p1.Y:
+				edges = append(edges, path_edge{p1.X, p1.Y, p0.X, p0.Y, -1})
+			}
+		}
+	}
+	if len(edges) == 0 {
+		return
+	}
+	sort.Slice(edges, func(i int, j int) bool { return edges[i].y0 < edges[j].y0 })
+
+	bounds := img.Bounds()
+	min_y := max(bounds.Min.Y, int(math.Floor(edges[0].y0)))
+	max_y := bounds.Min.Y
+	for _, edge := range edges {
+		max_y = max(max_y, int(math.Ceil(edge.y1)))
+	}
+	max_y = min(max_y, bounds.Max.Y)
+
+	coverage := make([]float64, bounds.Dx())
+	var crossings []path_crossing
+	for y := min_y; y < max_y; y++ {
+		clear(coverage)
+		for sample := 0; sample < PATH_SUBSAMPLES; sample++ {
+			scan_y := float64(y) + (float64(sample)+0.5)/PATH_SUBSAMPLES
+
+			crossings = crossings[:0]
+			for _, edge := range edges {
+				if edge.y0 > scan_y {
+					break
+				}
+				if scan_y >= edge.y1 {
+					continue
+				}
+				x := edge.x0 + (scan_y-edge.y0)*(edge.x1-edge.x0)/(edge.y1-edge.y0)
+				crossings = append(crossings, path_crossing{x, edge.direction})
+			}
+			sort.Slice(crossings, func(i int, j int) bool { return crossings[i].x < crossings[j].x })
+
+			winding := 0
+			for index, crossing := range crossings {
+				if even_odd {
+					winding ^= 1
+				} else {
+					winding += crossing.direction
+				}
+				if winding != 0 && index+1 < len(crossings) {
+					addCoverage(coverage, crossing.x-float64(bounds.Min.X), crossings[index+1].x-float64(bounds.Min.X), 1.0/PATH_SUBSAMPLES)
+				}
+			}
+		}
+
+		row := img.Pix[(y-bounds.Min.Y)*img.Stride:]
+		for x, value := range coverage {
+			if value <= 0 {
+				continue
+			}
+			alpha := math.Min(value, 1) * opacity
+			row[x] = byte(float64(row[x])*(1-alpha) + float64(c.Y)*alpha + 0.5)
+		}
+	}
+}
+
+// addCoverage adds the span from x0 to x1 to the coverage of a pixel row,
+// the pixels at the ends are covered partially.
+func addCoverage(coverage []float64, x0 float64, x1 float64, weight float64) {
+	x0 = math.Max(x0, 0)
+	x1 = math.Min(x1, float64(len(coverage)))
+	if x1 <= x0 {
+		return
+	}
+
+	first, last := int(x0), int(x1)
+	if first == last {
+		coverage[first] += (x1 - x0) * weight
+		return
+	}
+	coverage[first] += (float64(first+1) - x0) * weight
+	for x := first + 1; x < last; x++ {
+		coverage[x] += weight
+	}
+	if last < len(coverage) {
+		coverage[last] += (x1 - float64(last)) * weight
+	}
+}
+
+// GlyphPath adds a character of the built-in 5x7 font as filled squares,
+// so that text can be drawn at any size by vector renderers. The glyph cell
+// is size units high with the baseline at x, y.
+func (self *Path) GlyphPath(char rune, x float64, y float64, size float64) {
+	if char < FONT_FIRST_CHAR || int(char-FONT_FIRST_CHAR) >= len(font_5x7) {
+		char = '?'
+	}
+	unit := size / FONT_CELL_HEIGHT
+	top := y - FONT_GLYPH_HEIGHT*unit
+
+	for column, bits := range font_5x7[char-FONT_FIRST_CHAR] {
+		for row := 0; row < FONT_GLYPH_HEIGHT; row++ {
+			if bits&(1<<row) != 0 {
+				self.Rect(x+float64(column)*unit, top+float64(row)*unit, unit, unit)
+			}
+		}
+	}
+}
@@ -0,0 +1,646 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+const (
+	PDF_SNIFF_LENGTH = 1024
+	PDF_MAX_DEPTH    = 32 //nesting of page trees, forms and references
+)
+
+// PDF objects are decoded into these types, numbers are float64, strings
+// []byte and arrays []any.
+type pdf_name string
+type pdf_keyword string
+type pdf_dict map[string]any
+
+type pdf_ref struct {
+	number     int
+	generation int
+}
+
+type pdf_stream struct {
+	dict pdf_dict
+	raw  []byte
+}
+
+var pdf_object_pattern = regexp.MustCompile(`(?:^|[^0-9])([0-9]+)[ \t\r\n\f\x00]+([0-9]+)[ \t\r\n\f\x00]+obj\b`)
+
+// pdf_document gives access to the objects of a PDF file. Objects are
+// located by scanning the file, so that files with broken cross-reference
+// tables can be read as well, the last definition of an object wins.
+type pdf_document struct {
+	data            []byte
+	offsets         map[int]int
+	objects         map[int]any
+	trailer         pdf_dict
+	object_streams  bool
+}
+
+// IsPDF reports whether the data looks like a PDF document.
+func IsPDF(data []byte) bool {
+	return bytes.Contains(data[:min(len(data), PDF_SNIFF_LENGTH)], []byte("%PDF-"))
+}
+
+func openPDF(data []byte) (*pdf_document, error) {
+	if !IsPDF(data) {
+		return nil, fmt.Errorf("pdf: not a PDF document")
+	}
+
+	document := &pdf_document{
+		data:    data,
+		offsets: make(map[int]int),
+		objects: make(map[int]any),
+		trailer: make(pdf_dict),
+	}
+	for _, match := range pdf_object_pattern.FindAllSubmatchIndex(data, -1) {
+		number, _ := strconv.Atoi(string(data[match[2]:match[3]]))
+		document.offsets[number] = match[2]
+	}
+
+	//classic trailers and cross-reference streams, later updates win
+	for position := 0; ; {
+		index := bytes.Index(data[position:], []byte("trailer"))
+		if index < 0 {
+			break
+		}
+		position += index + len("trailer")
+		lexer := pdf_lexer{data: data, position: position, references: true}
+		if trailer, ok := lexer.object().(pdf_dict); ok {
+			for key, value := range trailer {
+				document.trailer[key] = value
+			}
+		}
+	}
+	for number := range document.offsets {
+		if stream, ok := document.get(number).(*pdf_stream); ok && stream.dict.name("Type") == "XRef" {
+			for _, key := range []string{"Root", "Encrypt"} {
+				if value, ok := stream.dict[key]; ok {
+					document.trailer[key] = value
+				}
+			}
+		}
+	}
+
+	if _, encrypted := document.trailer["Encrypt"]; encrypted {
+		return nil, fmt.Errorf("pdf: encrypted documents are not supported")
+	}
+	if _, ok := document.resolve(document.trailer["Root"]).(pdf_dict); !ok {
+		//no usable trailer, look for the catalog
+		for number := range document.offsets {
+			if catalog, ok := document.get(number).(pdf_dict); ok && catalog.name("Type") == "Catalog" {
+				document.trailer["Root"] = pdf_ref{number, 0}
+			}
+		}
+	}
+	return document, nil
+}
+
+// get returns an object by number, objects of object streams are loaded
+// when an object is not found in the file.
+func (self *pdf_document) get(number int) any {
+	if object, ok := self.objects[number]; ok {
+		return object
+	}
+
+	offset, ok := self.offsets[number]
+	if !ok {
+		if !self.object_streams {
+			self.loadObjectStreams()
+			return self.objects[number]
+		}
+		return nil
+	}
+
+	//guard against reference loops while parsing the stream length
+	self.objects[number] = nil
+	lexer := pdf_lexer{data: self.data, position: offset, references: true}
+	lexer.object()
+	lexer.object()
+	if keyword := lexer.object(); keyword != pdf_keyword("obj") {
+		return nil
+	}
+	object := lexer.object()
+	if dict, ok := object.(pdf_dict); ok {
+		position := lexer.position
+		if lexer.object() == pdf_keyword("stream") {
+			object = self.readStream(dict, lexer.position)
+		} else {
+			lexer.position = position
+		}
+	}
+	self.objects[number] = object
+	return object
+}
+
+func (self *pdf_document) readStream(dict pdf_dict, start int) *pdf_stream {
+	//the stream keyword is followed by CRLF or LF
+	if start < len(self.data) && self.data[start] == '\r' {
+		start++
+	}
+	if start < len(self.data) && self.data[start] == '\n' {
+		start++
+	}
+
+	if length, ok := self.resolve(dict["Length"]).(float64); ok && length >= 0 && start+int(length) <= len(self.data) {
+		end := start + int(length)
+		rest := bytes.TrimLeft(self.data[end:min(end+32, len(self.data))], " \t\r\n\f\x00")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			return &pdf_stream{dict, self.data[start:end]}
+		}
+	}
+
+	end := bytes.Index(self.data[start:], []byte("endstream"))
+	if end < 0 {
+		return &pdf_stream{dict, self.data[start:]}
+	}
+	return &pdf_stream{dict, bytes.TrimRight(self.data[start:start+end], "\r\n")}
+}
+
+// loadObjectStreams loads the compressed objects of all object streams,
+// objects defined directly in the file take precedence.
+func (self *pdf_document) loadObjectStreams() {
+	self.object_streams = true
+	for number := range self.offsets {
+		stream, ok := self.get(number).(*pdf_stream)
+		if !ok || stream.dict.name("Type") != "ObjStm" {
+			continue
+		}
+		data, err := self.decodeStream(stream)
+		if err != nil {
+			continue
+		}
+
+		count, _ := self.resolve(stream.dict["N"]).(float64)
+		first, _ := self.resolve(stream.dict["First"]).(float64)
+		header := pdf_lexer{data: data}
+		for index := 0; index < int(count); index++ {
+			object_number, ok1 := header.object().(float64)
+			offset, ok2 := header.object().(float64)
+			if !ok1 || !ok2 || int(first+offset) > len(data) {
+				break
+			}
+			if _, defined := self.offsets[int(object_number)]; defined {
+				continue
+			}
+			if _, loaded := self.objects[int(object_number)]; !loaded {
+				lexer := pdf_lexer{data: data, position: int(first + offset), references: true}
+				self.objects[int(object_number)] = lexer.object()
+			}
+		}
+	}
+}
+
+// resolve follows references.
+func (self *pdf_document) resolve(object any) any {
+	for depth := 0; depth < PDF_MAX_DEPTH; depth++ {
+		reference, ok := object.(pdf_ref)
+		if !ok {
+			return object
+		}
+		object = self.get(reference.number)
+	}
+	return nil
+}
+
+func (self *pdf_document) dict(object any) pdf_dict {
+	switch object := self.resolve(object).(type) {
+	case pdf_dict:
+		return object
+	case *pdf_stream:
+		return object.dict
+	}
+	return nil
+}
+
+func (self *pdf_document) array(object any) []any {
+	array, _ := self.resolve(object).([]any)
+	return array
+}
+
+func (self *pdf_document) number(object any, default_value float64) float64 {
+	if number, ok := self.resolve(object).(float64); ok {
+		return number
+	}
+	return default_value
+}
+
+func (self *pdf_document) numbers(object any) []float64 {
+	var numbers []float64
+	for _, value := range self.array(object) {
+		numbers = append(numbers, self.number(value, 0))
+	}
+	return numbers
+}
+
+func (self pdf_dict) name(key string) pdf_name {
+	name, _ := self[key].(pdf_name)
+	return name
+}
+
+// decodeStream applies the stream filters, DCT encoded image data is
+// returned as is.
+func (self *pdf_document) decodeStream(stream *pdf_stream) ([]byte, error) {
+	filters := []any{self.resolve(stream.dict["Filter"])}
+	parameters := []any{self.resolve(stream.dict["DecodeParms"])}
+	if array, ok := filters[0].([]any); ok {
+		filters = array
+		parameters = self.array(stream.dict["DecodeParms"])
+	}
+
+	data := stream.raw
+	for index, filter := range filters {
+		var parameter pdf_dict
+		if index < len(parameters) {
+			parameter = self.dict(parameters[index])
+		}
+
+		var err error
+		switch self.resolve(filter) {
+		case nil:
+			continue
+		case pdf_name("FlateDecode"), pdf_name("Fl"):
+			data, err = pdfInflate(data)
+			if err == nil {
+				data, err = self.predictor(data, parameter)
+			}
+		case pdf_name("ASCIIHexDecode"), pdf_name("AHx"):
+			data, err = pdfHexDecode(data)
+		case pdf_name("ASCII85Decode"), pdf_name("A85"):
+			data, err = pdfASCII85Decode(data)
+		case pdf_name("DCTDecode"), pdf_name("DCT"):
+			return data, nil
+		default:
+			err = fmt.Errorf("pdf: filter %v not supported", self.resolve(filter))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func pdfInflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("pdf: %s", err)
+	}
+	inflated, err := io.ReadAll(reader)
+	//truncated streams are common, use what could be decoded
+	if err != nil && len(inflated) == 0 {
+		return nil, fmt.Errorf("pdf: %s", err)
+	}
+	return inflated, nil
+}
+
+// predictor reverses the PNG predictors of flate encoded data.
+func (self *pdf_document) predictor(data []byte, parameter pdf_dict) ([]byte, error) {
+	predictor := self.number(parameter["Predictor"], 1)
+	if predictor < 10 {
+		return data, nil
+	}
+	colors := self.number(parameter["Colors"], 1)
+	bits := self.number(parameter["BitsPerComponent"], 8)
+	columns := self.number(parameter["Columns"], 1)
+	pixel_bytes := max(1, int(colors*bits+7)/8)
+	row_bytes := int(colors*bits*columns+7) / 8
+
+	var decoded []byte
+	previous := make([]byte, row_bytes)
+	for position := 0; position+row_bytes < len(data)+1 && position < len(data); position += row_bytes + 1 {
+		filter := data[position]
+		row := make([]byte, row_bytes)
+		copy(row, data[position+1:min(len(data), position+1+row_bytes)])
+		for index := range row {
+			var left, up, up_left int
+			if index >= pixel_bytes {
+				left = int(row[index-pixel_bytes])
+				up_left = int(previous[index-pixel_bytes])
+			}
+			up = int(previous[index])
+			switch filter {
+			case 1:
+				row[index] += byte(left)
+			case 2:
+				row[index] += byte(up)
+			case 3:
+				row[index] += byte((left + up) / 2)
+			case 4:
+				estimate := left + up - up_left
+				distance_left, distance_up, distance_up_left := abs(estimate-left), abs(estimate-up), abs(estimate-up_left)
+				switch {
+				case distance_left <= distance_up && distance_left <= distance_up_left:
+					row[index] += byte(left)
+				case distance_up <= distance_up_left:
+					row[index] += byte(up)
+				default:
+					row[index] += byte(up_left)
+				}
+			}
+		}
+		decoded = append(decoded, row...)
+		previous = row
+	}
+	return decoded, nil
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func pdfHexDecode(data []byte) ([]byte, error) {
+	var digits []byte
+	for _, char := range data {
+		if char == '>' {
+			break
+		}
+		if isPDFWhitespace(char) {
+			continue
+		}
+		digits = append(digits, char)
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	decoded := make([]byte, len(digits)/2)
+	if _, err := hex.Decode(decoded, digits); err != nil {
+		return nil, fmt.Errorf("pdf: %s", err)
+	}
+	return decoded, nil
+}
+
+func pdfASCII85Decode(data []byte) ([]byte, error) {
+	var decoded []byte
+	var group [5]byte
+	count := 0
+	for _, char := range bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~")) {
+		if char == '~' {
+			break
+		}
+		switch {
+		case isPDFWhitespace(char):
+		case char == 'z' && count == 0:
+			decoded = append(decoded, 0, 0, 0, 0)
+		case char < '!' || char > 'u':
+			return nil, fmt.Errorf("pdf: invalid ASCII85 data")
+		default:
+			group[count] = char - '!'
+			count++
+			if count == 5 {
+				value := uint32(0)
+				for _, digit := range group {
+					value = value*85 + uint32(digit)
+				}
+				decoded = append(decoded, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+				count = 0
+			}
+		}
+	}
+	if count > 1 {
+		//a final partial group is padded with the highest digit
+		for index := count; index < 5; index++ {
+			group[index] = 84
+		}
+		value := uint32(0)
+		for _, digit := range group {
+			value = value*85 + uint32(digit)
+		}
+		decoded = append(decoded, []byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}[:count-1]...)
+	}
+	return decoded, nil
+}
+
+func isPDFWhitespace(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\r' || char == '\n' || char == '\f' || char == 0
+}
+
+func isPDFDelimiter(char byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), char) >= 0
+}
+
+// pdf_lexer parses objects of PDF files and content streams. Keywords and
+// content stream operators are returned as pdf_keyword, references are only
+// recognized in files.
+type pdf_lexer struct {
+	data       []byte
+	position   int
+	references bool
+	depth      int
+}
+
+func (self *pdf_lexer) skipWhitespace() {
+	for self.position < len(self.data) {
+		char := self.data[self.position]
+		if char == '%' {
+			for self.position < len(self.data) && self.data[self.position] != '\n' && self.data[self.position] != '\r' {
+				self.position++
+			}
+		} else if !isPDFWhitespace(char) {
+			return
+		}
+		self.position++
+	}
+}
+
+// object returns the next object or keyword, nil at the end of the data.
+func (self *pdf_lexer) object() any {
+	self.skipWhitespace()
+	if self.position >= len(self.data) || self.depth > PDF_MAX_DEPTH {
+		return nil
+	}
+
+	char := self.data[self.position]
+	switch {
+	case char == '/':
+		return self.name()
+	case char == '(':
+		return self.literalString()
+	case char == '<' && self.position+1 < len(self.data) && self.data[self.position+1] == '<':
+		self.position += 2
+		return self.dictionary()
+	case char == '<':
+		self.position++
+		end := bytes.IndexByte(self.data[self.position:], '>')
+		if end < 0 {
+			end = len(self.data) - self.position
+		}
+		decoded, _ := pdfHexDecode(self.data[self.position : self.position+end])
+		self.position += end + 1
+		return decoded
+	case char == '[':
+		self.position++
+		self.depth++
+		defer func() { self.depth-- }()
+		array := []any{}
+		for {
+			value := self.object()
+			if value == nil || value == pdf_keyword("]") {
+				return array
+			}
+			array = append(array, value)
+		}
+	case char == ']' || char == '{' || char == '}':
+		self.position++
+		return pdf_keyword(char)
+	case char == '>' && self.position+1 < len(self.data) && self.data[self.position+1] == '>':
+		self.position += 2
+		return pdf_keyword(">>")
+	case char == '+' || char == '-' || char == '.' || char >= '0' && char <= '9':
+		return self.number()
+	}
+
+	start := self.position
+	for self.position < len(self.data) && !isPDFWhitespace(self.data[self.position]) && !isPDFDelimiter(self.data[self.position]) {
+		self.position++
+	}
+	if self.position == start {
+		//stray delimiter
+		self.position++
+	}
+	switch keyword := pdf_keyword(self.data[start:self.position]); keyword {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return pdf_keyword("null")
+	default:
+		return keyword
+	}
+}
+
+func (self *pdf_lexer) number() any {
+	start := self.position
+	self.position++
+	for self.position < len(self.data) && (self.data[self.position] == '.' || self.data[self.position] >= '0' && self.data[self.position] <= '9') {
+		self.position++
+	}
+	number, err := strconv.ParseFloat(string(self.data[start:self.position]), 64)
+	if err != nil {
+		number = 0
+	}
+
+	//integer followed by generation and R is a reference
+	if self.references && number == float64(int(number)) && number >= 0 {
+		position := self.position
+		self.references = false
+		generation, ok := self.object().(float64)
+		if ok && self.object() == pdf_keyword("R") {
+			self.references = true
+			return pdf_ref{int(number), int(generation)}
+		}
+		self.references = true
+		self.position = position
+	}
+	return number
+}
+
+func (self *pdf_lexer) name() pdf_name {
+	self.position++
+	var name []byte
+	for self.position < len(self.data) && !isPDFWhitespace(self.data[self.position]) && !isPDFDelimiter(self.data[self.position]) {
+		char := self.data[self.position]
+		if char == '#' && self.position+2 < len(self.data) {
+			if value, err := strconv.ParseUint(string(self.data[self.position+1:self.position+3]), 16, 8); err == nil {
+				name = append(name, byte(value))
+				self.position += 3
+				continue
+			}
+		}
+		name = append(name, char)
+		self.position++
+	}
+	return pdf_name(name)
+}
+
+func (self *pdf_lexer) literalString() []byte {
+	self.position++
+	var text []byte
+	for nesting := 1; self.position < len(self.data); self.position++ {
+		char := self.data[self.position]
+		switch char {
+		case '(':
+			nesting++
+		case ')':
+			nesting--
+			if nesting == 0 {
+				self.position++
+				return text
+			}
+		case '\\':
+			self.position++
+			if self.position >= len(self.data) {
+				return text
+			}
+			char = self.data[self.position]
+			switch char {
+			case 'n':
+				char = '\n'
+			case 'r':
+				char = '\r'
+			case 't':
+				char = '\t'
+			case 'b':
+				char = '\b'
+			case 'f':
+				char = '\f'
+			case '\r':
+				if self.position+1 < len(self.data) && self.data[self.position+1] == '\n' {
+					self.position++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if char >= '0' && char <= '7' {
+					value := 0
+					for digits := 0; digits < 3 && self.position < len(self.data) && self.data[self.position] >= '0' && self.data[self.position] <= '7'; digits++ {
+						value = value*8 + int(self.data[self.position]-'0')
+						self.position++
+					}
+					self.position--
+					char = byte(value)
+				}
+			}
+		}
+		text = append(text, char)
+	}
+	return text
+}
+
+func (self *pdf_lexer) dictionary() pdf_dict {
+	self.depth++
+	defer func() { self.depth-- }()
+
+	dict := make(pdf_dict)
+	for {
+		key := self.object()
+		name, ok := key.(pdf_name)
+		if !ok {
+			//end of the dictionary or a broken key
+			return dict
+		}
+		value := self.object()
+		if keyword, ok := value.(pdf_keyword); ok && keyword == ">>" {
+			return dict
+		}
+		if value != pdf_keyword("null") {
+			dict[string(name)] = value
+		}
+	}
+}
@@ -0,0 +1,1087 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"unicode/utf16"
+)
+
+const (
+	PDF_POINTS_PER_INCH = 72
+	PDF_MAX_PIXELS      = 64 * 1024 * 1024
+	PDF_DEFAULT_WIDTH   = 600 //glyph width in 1/1000 em if the font has none
+	PDF_CAP_HEIGHT      = 0.8 //em, size of the built-in font replacing missing fonts
+)
+
+// Default page size, US letter.
+var pdf_default_box = []float64{0, 0, 612, 792}
+
+// Abbreviations of inline image keys and values.
+var pdf_inline_abbreviations = map[string]string{
+	"BPC": "BitsPerComponent", "CS": "ColorSpace", "D": "Decode", "DP": "DecodeParms",
+	"F": "Filter", "H": "Height", "W": "Width", "IM": "ImageMask", "I": "Interpolate",
+}
+
+// Glyph names of the ASCII punctuation, letters are named by themselves.
+var pdf_glyph_names = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#', "dollar": '$', "percent": '%',
+	"ampersand": '&', "quoteright": '\'', "quotesingle": '\'', "parenleft": '(', "parenright": ')',
+	"asterisk": '*', "plus": '+', "comma": ',', "hyphen": '-', "minus": '-', "period": '.', "slash": '/',
+	"zero": '0', "one": '1', "two": '2', "three": '3', "four": '4', "five": '5', "six": '6', "seven": '7',
+	"eight": '8', "nine": '9', "colon": ':', "semicolon": ';', "less": '<', "equal": '=', "greater": '>',
+	"question": '?', "at": '@', "bracketleft": '[', "backslash": '\\', "bracketright": ']',
+	"asciicircum": '^', "underscore": '_', "grave": '`', "quoteleft": '`', "braceleft": '{', "bar": '|',
+	"braceright": '}', "asciitilde": '~',
+}
+
+type pdf_page struct {
+	dict      pdf_dict
+	resources pdf_dict
+	media_box []float64
+	crop_box  []float64
+	rotate    int
+}
+
+type pdf_color_space struct {
+	components int
+	kind       string
+	base       *pdf_color_space
+	high       int
+	lookup     []byte
+}
+
+// pdf_state is the graphics state saved by q and restored by Q.
+type pdf_state struct {
+	ctm              Matrix
+	fill             byte
+	stroke           byte
+	fill_none        bool
+	stroke_none      bool
+	fill_space       *pdf_color_space
+	stroke_space     *pdf_color_space
+	fill_alpha       float64
+	stroke_alpha     float64
+	line_width       float64
+	line_cap         int
+	font             *pdf_font
+	font_size        float64
+	char_spacing     float64
+	word_spacing     float64
+	horizontal_scale float64
+	leading          float64
+	rise             float64
+	render_mode      int
+}
+
+type pdf_font struct {
+	two_byte      bool
+	widths        map[int]float64
+	default_width float64
+	truetype      *truetype_font
+	cid_to_gid    []byte
+	unicode       map[int]rune
+}
+
+type pdf_renderer struct {
+	document         *pdf_document
+	img              *image.Gray
+	state            pdf_state
+	stack            []pdf_state
+	path             *Path
+	current          Point
+	start            Point
+	text_matrix      Matrix
+	text_line_matrix Matrix
+	fonts            map[pdf_ref]*pdf_font
+	depth            int
+}
+
+// RenderPDF renders a page of a PDF document at the given resolution in dpi,
+// pages are counted from 1. Vector graphics, images, and text with embedded
+// TrueType fonts are drawn. Text in other fonts, including the standard
+// fonts, is drawn with the built-in font at the width of the original
+// glyphs. Clipping, shadings and patterns are not supported.
+func RenderPDF(data []byte, page_number int, resolution float64) (*image.Gray, error) {
+	document, err := openPDF(data)
+	if err != nil {
+		return nil, err
+	}
+	pages := document.pages()
+	if page_number < 1 || page_number > len(pages) {
+		return nil, fmt.Errorf("pdf: page %d not found, the document has %d pages", page_number, len(pages))
+	}
+	page := pages[page_number-1]
+
+	box := page.media_box
+	if len(page.crop_box) == 4 {
+		box = page.crop_box
+	}
+	x0, y0 := math.Min(box[0], box[2]), math.Min(box[1], box[3])
+	x1, y1 := math.Max(box[0], box[2]), math.Max(box[1], box[3])
+	scale := resolution / PDF_POINTS_PER_INCH
+	width := int(math.Ceil((x1 - x0) * scale - 0.01))
+	height := int(math.Ceil((y1 - y0) * scale - 0.01))
+	if width <= 0 || height <= 0 || width*height > PDF_MAX_PIXELS {
+		return nil, fmt.Errorf("pdf: invalid page size %.0fx%.0f pt", x1-x0, y1-y0)
+	}
+
+	//pages are displayed rotated clockwise
+	matrix := Matrix{scale, 0, 0, -scale, -scale * x0, scale * y1}
+	switch (page.rotate%360 + 360) % 360 {
+	case 90:
+		matrix = Matrix{0, 1, -1, 0, float64(height), 0}.Multiply(matrix)
+		width, height = height, width
+	case 180:
+		matrix = Matrix{-1, 0, 0, -1, float64(width), float64(height)}.Multiply(matrix)
+	case 270:
+		matrix = Matrix{0, -1, 1, 0, 0, float64(width)}.Multiply(matrix)
+		width, height = height, width
+	}
+
+	renderer := &pdf_renderer{
+		document: document,
+		img:      NewWhiteImage(width, height),
+		state:    defaultPDFState(matrix),
+		fonts:    make(map[pdf_ref]*pdf_font),
+	}
+
+	var content []byte
+	contents := []any{page.dict["Contents"]}
+	if array := document.array(page.dict["Contents"]); array != nil {
+		contents = array
+	}
+	for _, object := range contents {
+		if stream, ok := document.resolve(object).(*pdf_stream); ok {
+			data, err := document.decodeStream(stream)
+			if err != nil {
+				return nil, err
+			}
+			content = append(append(content, data...), '\n')
+		}
+	}
+
+	renderer.execute(content, page.resources)
+	return renderer.img, nil
+}
+
+func defaultPDFState(ctm Matrix) pdf_state {
+	gray := &pdf_color_space{components: 1, kind: "gray"}
+	return pdf_state{
+		ctm:              ctm,
+		fill_space:       gray,
+		stroke_space:     gray,
+		fill_alpha:       1,
+		stroke_alpha:     1,
+		line_width:       1,
+		horizontal_scale: 1,
+	}
+}
+
+// pages returns the pages of the document with their inherited attributes.
+func (self *pdf_document) pages() []pdf_page {
+	var pages []pdf_page
+	root := self.dict(self.trailer["Root"])
+	self.collectPages(self.dict(root["Pages"]), pdf_page{media_box: pdf_default_box}, &pages, 0)
+	return pages
+}
+
+func (self *pdf_document) collectPages(node pdf_dict, page pdf_page, pages *[]pdf_page, depth int) {
+	if node == nil || depth > PDF_MAX_DEPTH {
+		return
+	}
+	if resources := self.dict(node["Resources"]); resources != nil {
+		page.resources = resources
+	}
+	if box := self.numbers(node["MediaBox"]); len(box) == 4 {
+		page.media_box = box
+	}
+	if box := self.numbers(node["CropBox"]); len(box) == 4 {
+		page.crop_box = box
+	}
+	if rotate, ok := self.resolve(node["Rotate"]).(float64); ok {
+		page.rotate = int(rotate)
+	}
+
+	kids := self.array(node["Kids"])
+	if node.name("Type") == "Page" || kids == nil {
+		page.dict = node
+		*pages = append(*pages, page)
+		return
+	}
+	for _, kid := range kids {
+		self.collectPages(self.dict(kid), page, pages, depth+1)
+	}
+}
+
+// execute interprets a content stream.
+func (self *pdf_renderer) execute(content []byte, resources pdf_dict) {
+	lexer := pdf_lexer{data: content}
+	var operands []any
+	for {
+		object := lexer.object()
+		if object == nil {
+			return
+		}
+		keyword, ok := object.(pdf_keyword)
+		if !ok || keyword == "null" {
+			operands = append(operands, object)
+			continue
+		}
+		if keyword == "BI" {
+			self.inlineImage(&lexer, resources)
+		} else {
+			self.operator(string(keyword), operands, resources)
+		}
+		operands = operands[:0]
+	}
+}
+
+func (self *pdf_renderer) operator(operator string, operands []any, resources pdf_dict) {
+	number := func(index int) float64 {
+		if index < len(operands) {
+			if value, ok := operands[index].(float64); ok {
+				return value
+			}
+		}
+		return 0
+	}
+	matrix := func() Matrix {
+		return Matrix{number(0), number(1), number(2), number(3), number(4), number(5)}
+	}
+	state := &self.state
+
+	switch operator {
+	//graphics state
+	case "q":
+		self.stack = append(self.stack, self.state)
+	case "Q":
+		if len(self.stack) > 0 {
+			self.state = self.stack[len(self.stack)-1]
+			self.stack = self.stack[:len(self.stack)-1]
+		}
+	case "cm":
+		state.ctm = state.ctm.Multiply(matrix())
+	case "w":
+		state.line_width = number(0)
+	case "J":
+		state.line_cap = int(number(0))
+	case "gs":
+		if len(operands) > 0 {
+			self.extendedState(self.document.dict(self.document.dict(resources["ExtGState"])[pdfOperandName(operands[0])]))
+		}
+
+	//path construction
+	case "m":
+		self.pathFor().MoveTo(number(0), number(1))
+		self.current = Point{number(0), number(1)}
+		self.start = self.current
+	case "l":
+		self.pathFor().LineTo(number(0), number(1))
+		self.current = Point{number(0), number(1)}
+	case "c":
+		self.pathFor().CubicTo(number(0), number(1), number(2), number(3), number(4), number(5))
+		self.current = Point{number(4), number(5)}
+	case "v":
+		self.pathFor().CubicTo(self.current.X, self.current.Y, number(0), number(1), number(2), number(3))
+		self.current = Point{number(2), number(3)}
+	case "y":
+		self.pathFor().CubicTo(number(0), number(1), number(2), number(3), number(2), number(3))
+		self.current = Point{number(2), number(3)}
+	case "h":
+		self.pathFor().Close()
+		self.current = self.start
+	case "re":
+		self.pathFor().Rect(number(0), number(1), number(2), number(3))
+		self.current = Point{number(0), number(1)}
+		self.start = self.current
+
+	//path painting, clipping is not supported
+	case "S", "s", "f", "F", "f*", "B", "B*", "b", "b*", "n":
+		if self.path != nil {
+			if operator == "s" || operator == "b" || operator == "b*" {
+				self.path.Close()
+			}
+			switch operator {
+			case "f", "F", "B", "b":
+				self.fill(self.path, false)
+			case "f*", "B*", "b*":
+				self.fill(self.path, true)
+			}
+			switch operator {
+			case "S", "s", "B", "B*", "b", "b*":
+				self.stroke(self.path)
+			}
+		}
+		self.path = nil
+
+	//colors
+	case "g", "G", "rg", "RG", "k", "K":
+		space := map[string]*pdf_color_space{
+			"g": {components: 1, kind: "gray"}, "rg": {components: 3, kind: "rgb"}, "k": {components: 4, kind: "cmyk"},
+		}[string(bytes.ToLower([]byte(operator)))]
+		values := make([]float64, space.components)
+		for index := range values {
+			values[index] = number(index)
+		}
+		self.setColor(operator == "g" || operator == "rg" || operator == "k", space, values)
+	case "cs", "CS":
+		if len(operands) > 0 {
+			space := self.colorSpace(operands[0], resources, 0)
+			initial := make([]float64, space.components)
+			if space.kind == "cmyk" {
+				initial[3] = 1
+			}
+			if space.kind == "separation" || space.kind == "devicen" {
+				for index := range initial {
+					initial[index] = 1
+				}
+			}
+			self.setColor(operator == "cs", space, initial)
+		}
+	case "sc", "scn", "SC", "SCN":
+		var values []float64
+		for index := range operands {
+			if _, ok := operands[index].(float64); ok {
+				values = append(values, number(index))
+			}
+		}
+		space := state.fill_space
+		if operator == "SC" || operator == "SCN" {
+			space = state.stroke_space
+		}
+		self.setColor(operator == "sc" || operator == "scn", space, values)
+
+	//text
+	case "BT":
+		self.text_matrix = IdentityMatrix
+		self.text_line_matrix = IdentityMatrix
+	case "Tf":
+		if len(operands) > 0 {
+			state.font = self.font(resources, pdfOperandName(operands[0]))
+		}
+		state.font_size = number(1)
+	case "Tc":
+		state.char_spacing = number(0)
+	case "Tw":
+		state.word_spacing = number(0)
+	case "Tz":
+		state.horizontal_scale = number(0) / 100
+	case "TL":
+		state.leading = number(0)
+	case "Ts":
+		state.rise = number(0)
+	case "Tr":
+		state.render_mode = int(number(0))
+	case "Td", "TD":
+		if operator == "TD" {
+			state.leading = -number(1)
+		}
+		self.text_line_matrix = self.text_line_matrix.Multiply(TranslateMatrix(number(0), number(1)))
+		self.text_matrix = self.text_line_matrix
+	case "Tm":
+		self.text_line_matrix = matrix()
+		self.text_matrix = self.text_line_matrix
+	case "T*", "'", "\"":
+		if operator == "\"" && len(operands) == 3 {
+			state.word_spacing = number(0)
+			state.char_spacing = number(1)
+			operands = operands[2:]
+		}
+		self.text_line_matrix = self.text_line_matrix.Multiply(TranslateMatrix(0, -state.leading))
+		self.text_matrix = self.text_line_matrix
+		if operator != "T*" && len(operands) > 0 {
+			text, _ := operands[0].([]byte)
+			self.showText(text)
+		}
+	case "Tj":
+		if len(operands) > 0 {
+			text, _ := operands[0].([]byte)
+			self.showText(text)
+		}
+	case "TJ":
+		if len(operands) > 0 {
+			array, _ := operands[0].([]any)
+			for _, element := range array {
+				switch element := element.(type) {
+				case []byte:
+					self.showText(element)
+				case float64:
+					adjustment := -element / 1000 * state.font_size * state.horizontal_scale
+					self.text_matrix = self.text_matrix.Multiply(TranslateMatrix(adjustment, 0))
+				}
+			}
+		}
+
+	//external objects
+	case "Do":
+		if len(operands) > 0 {
+			self.xobject(self.document.dict(resources["XObject"])[pdfOperandName(operands[0])], resources)
+		}
+	}
+}
+
+func pdfOperandName(operand any) string {
+	name, _ := operand.(pdf_name)
+	return string(name)
+}
+
+func (self *pdf_renderer) pathFor() *Path {
+	if self.path == nil {
+		self.path = NewPath(self.state.ctm)
+	}
+	return self.path
+}
+
+func (self *pdf_renderer) fill(path *Path, even_odd bool) {
+	if !self.state.fill_none {
+		path.Fill(self.img, color.Gray{self.state.fill}, self.state.fill_alpha, even_odd)
+	}
+}
+
+// stroke draws the path outline, lines thinner than a pixel are drawn one
+// pixel wide so that they are not lost on the label.
+func (self *pdf_renderer) stroke(path *Path) {
+	if self.state.stroke_none {
+		return
+	}
+	width := math.Max(self.state.line_width*self.state.ctm.Scale(), 1)
+	outline := path.StrokeOutline(width, self.state.line_cap)
+	outline.Fill(self.img, color.Gray{self.state.stroke}, self.state.stroke_alpha, false)
+}
+
+func (self *pdf_renderer) extendedState(dict pdf_dict) {
+	if width, ok := self.document.resolve(dict["LW"]).(float64); ok {
+		self.state.line_width = width
+	}
+	if line_cap, ok := self.document.resolve(dict["LC"]).(float64); ok {
+		self.state.line_cap = int(line_cap)
+	}
+	if alpha, ok := self.document.resolve(dict["CA"]).(float64); ok {
+		self.state.stroke_alpha = alpha
+	}
+	if alpha, ok := self.document.resolve(dict["ca"]).(float64); ok {
+		self.state.fill_alpha = alpha
+	}
+}
+
+func (self *pdf_renderer) setColor(fill bool, space *pdf_color_space, values []float64) {
+	gray, ok := space.gray(values)
+	if fill {
+		self.state.fill_space, self.state.fill, self.state.fill_none = space, gray, !ok
+	} else {
+		self.state.stroke_space, self.state.stroke, self.state.stroke_none = space, gray, !ok
+	}
+}
+
+// colorSpace resolves a color space by name or definition.
+func (self *pdf_renderer) colorSpace(object any, resources pdf_dict, depth int) *pdf_color_space {
+	object = self.document.resolve(object)
+	family, _ := object.(pdf_name)
+	array, _ := object.([]any)
+	if len(array) > 0 {
+		family, _ = self.document.resolve(array[0]).(pdf_name)
+	}
+	argument := func(index int) any {
+		if index < len(array) {
+			return self.document.resolve(array[index])
+		}
+		return nil
+	}
+
+	switch family {
+	case "DeviceGray", "G", "CalGray":
+		return &pdf_color_space{components: 1, kind: "gray"}
+	case "DeviceRGB", "RGB", "CalRGB":
+		return &pdf_color_space{components: 3, kind: "rgb"}
+	case "DeviceCMYK", "CMYK":
+		return &pdf_color_space{components: 4, kind: "cmyk"}
+	case "Lab":
+		return &pdf_color_space{components: 3, kind: "lab"}
+	case "Pattern":
+		return &pdf_color_space{kind: "pattern"}
+	case "ICCBased":
+		switch self.document.number(self.document.dict(argument(1))["N"], 3) {
+		case 1:
+			return &pdf_color_space{components: 1, kind: "gray"}
+		case 4:
+			return &pdf_color_space{components: 4, kind: "cmyk"}
+		}
+		return &pdf_color_space{components: 3, kind: "rgb"}
+	case "Separation":
+		return &pdf_color_space{components: 1, kind: "separation"}
+	case "DeviceN":
+		return &pdf_color_space{components: max(1, len(self.document.array(argument(1)))), kind: "devicen"}
+	case "Indexed", "I":
+		space := &pdf_color_space{components: 1, kind: "indexed", high: int(self.document.number(argument(2), 0))}
+		if depth < PDF_MAX_DEPTH {
+			space.base = self.colorSpace(argument(1), resources, depth+1)
+		}
+		switch lookup := argument(3).(type) {
+		case []byte:
+			space.lookup = lookup
+		case *pdf_stream:
+			space.lookup, _ = self.document.decodeStream(lookup)
+		}
+		return space
+	}
+
+	//named color spaces of the resources
+	if len(family) > 0 && len(array) == 0 && depth < PDF_MAX_DEPTH {
+		if definition, ok := self.document.dict(resources["ColorSpace"])[string(family)]; ok {
+			return self.colorSpace(definition, resources, depth+1)
+		}
+	}
+	return &pdf_color_space{components: 1, kind: "gray"}
+}
+
+// gray converts color components to gray, patterns have no gray value.
+func (self *pdf_color_space) gray(values []float64) (byte, bool) {
+	value := func(index int) float64 {
+		if index < len(values) {
+			return math.Max(0, math.Min(1, values[index]))
+		}
+		return 0
+	}
+
+	var gray float64
+	switch self.kind {
+	case "pattern":
+		return 0xff, false
+	case "rgb":
+		gray = 0.299*value(0) + 0.587*value(1) + 0.114*value(2)
+	case "cmyk":
+		key := 1 - value(3)
+		gray = 0.299*(1-value(0))*key + 0.587*(1-value(1))*key + 0.114*(1-value(2))*key
+	case "lab":
+		if len(values) > 0 {
+			gray = math.Max(0, math.Min(100, values[0])) / 100
+		}
+	case "separation", "devicen":
+		//tints give the amount of colorant
+		tint := 0.0
+		for index := range values {
+			tint = math.Max(tint, value(index))
+		}
+		gray = 1 - tint
+	case "indexed":
+		if self.base == nil || len(values) == 0 {
+			return 0, true
+		}
+		index := max(0, min(self.high, int(values[0])))
+		components := make([]float64, self.base.components)
+		for component := range components {
+			if position := index*self.base.components + component; position < len(self.lookup) {
+				components[component] = float64(self.lookup[position]) / 0xff
+			}
+		}
+		return self.base.gray(components)
+	default:
+		gray = value(0)
+	}
+	return byte(gray*0xff + 0.5), true
+}
+
+// font loads a font of the resources, fonts referenced by several pages or
+// forms are loaded once.
+func (self *pdf_renderer) font(resources pdf_dict, name string) *pdf_font {
+	object := self.document.dict(resources["Font"])[name]
+	reference, is_reference := object.(pdf_ref)
+	if font, ok := self.fonts[reference]; ok && is_reference {
+		return font
+	}
+
+	dict := self.document.dict(object)
+	font := &pdf_font{widths: make(map[int]float64), unicode: make(map[int]rune)}
+	descriptor := self.document.dict(dict["FontDescriptor"])
+
+	if dict.name("Subtype") == "Type0" {
+		//only the Identity-H and Identity-V encodings with two byte codes
+		font.two_byte = true
+		var descendant pdf_dict
+		if descendants := self.document.array(dict["DescendantFonts"]); len(descendants) > 0 {
+			descendant = self.document.dict(descendants[0])
+		}
+		descriptor = self.document.dict(descendant["FontDescriptor"])
+		font.default_width = self.document.number(descendant["DW"], 1000)
+
+		widths := self.document.array(descendant["W"])
+		for index := 0; index+1 < len(widths); {
+			first := int(self.document.number(widths[index], 0))
+			if list := self.document.array(widths[index+1]); list != nil {
+				for offset, width := range list {
+					font.widths[first+offset] = self.document.number(width, font.default_width)
+				}
+				index += 2
+			} else if index+2 < len(widths) {
+				last := int(self.document.number(widths[index+1], 0))
+				width := self.document.number(widths[index+2], font.default_width)
+				for code := first; code <= last && code-first < 0x10000; code++ {
+					font.widths[code] = width
+				}
+				index += 3
+			} else {
+				break
+			}
+		}
+		if stream, ok := self.document.resolve(descendant["CIDToGIDMap"]).(*pdf_stream); ok {
+			font.cid_to_gid, _ = self.document.decodeStream(stream)
+		}
+	} else {
+		first := int(self.document.number(dict["FirstChar"], 0))
+		for index, width := range self.document.numbers(dict["Widths"]) {
+			font.widths[first+index] = width
+		}
+		font.default_width = self.document.number(descriptor["MissingWidth"], 0)
+
+		for code := 0x20; code < 0x7f; code++ {
+			font.unicode[code] = rune(code)
+		}
+		code := 0
+		for _, difference := range self.document.array(self.document.dict(dict["Encoding"])["Differences"]) {
+			switch difference := self.document.resolve(difference).(type) {
+			case float64:
+				code = int(difference)
+			case pdf_name:
+				if char, ok := pdf_glyph_names[string(difference)]; ok {
+					font.unicode[code] = char
+				} else if len(difference) == 1 {
+					font.unicode[code] = rune(difference[0])
+				} else {
+					delete(font.unicode, code)
+				}
+				code++
+			}
+		}
+	}
+
+	if stream, ok := self.document.resolve(descriptor["FontFile2"]).(*pdf_stream); ok {
+		if data, err := self.document.decodeStream(stream); err == nil {
+			font.truetype, _ = parseTrueType(data)
+		}
+	}
+	if stream, ok := self.document.resolve(dict["ToUnicode"]).(*pdf_stream); ok {
+		if data, err := self.document.decodeStream(stream); err == nil {
+			parseToUnicode(data, font.unicode)
+		}
+	}
+
+	if is_reference {
+		self.fonts[reference] = font
+	}
+	return font
+}
+
+// parseToUnicode reads the bfchar and bfrange mappings of a ToUnicode CMap,
+// only the first character of every mapping is used.
+func parseToUnicode(data []byte, unicode map[int]rune) {
+	code := func(value []byte) int {
+		result := 0
+		for _, octet := range value {
+			result = result<<8 | int(octet)
+		}
+		return result
+	}
+	char := func(value []byte) rune {
+		var units []uint16
+		for index := 0; index+1 < len(value); index += 2 {
+			units = append(units, uint16(value[index])<<8|uint16(value[index+1]))
+		}
+		if runes := utf16.Decode(units); len(runes) > 0 {
+			return runes[0]
+		}
+		return 0
+	}
+
+	lexer := pdf_lexer{data: data}
+	var operands []any
+	section := ""
+	for {
+		object := lexer.object()
+		if object == nil {
+			return
+		}
+		keyword, ok := object.(pdf_keyword)
+		if !ok {
+			operands = append(operands, object)
+			switch section {
+			case "beginbfchar":
+				if len(operands) == 2 {
+					source, _ := operands[0].([]byte)
+					target, _ := operands[1].([]byte)
+					unicode[code(source)] = char(target)
+					operands = operands[:0]
+				}
+			case "beginbfrange":
+				if len(operands) == 3 {
+					low, _ := operands[0].([]byte)
+					high, _ := operands[1].([]byte)
+					for offset := 0; code(low)+offset <= code(high) && offset < 0x10000; offset++ {
+						switch target := operands[2].(type) {
+						case []byte:
+							unicode[code(low)+offset] = char(target) + rune(offset)
+						case []any:
+							if offset < len(target) {
+								value, _ := target[offset].([]byte)
+								unicode[code(low)+offset] = char(value)
+							}
+						}
+					}
+					operands = operands[:0]
+				}
+			}
+			continue
+		}
+		switch keyword {
+		case "beginbfchar", "beginbfrange":
+			section = string(keyword)
+		case "endbfchar", "endbfrange":
+			section = ""
+		}
+		operands = operands[:0]
+	}
+}
+
+func (self *pdf_font) width(code int) float64 {
+	if width, ok := self.widths[code]; ok {
+		return width
+	}
+	if self.default_width > 0 {
+		return self.default_width
+	}
+	return PDF_DEFAULT_WIDTH
+}
+
+// glyphIndex maps a character code to a glyph of the embedded TrueType
+// font, simple fonts are looked up in the cmap subtables PDF writers use.
+func (self *pdf_font) glyphIndex(code int) (uint16, bool) {
+	if self.two_byte {
+		if self.cid_to_gid == nil {
+			return uint16(code), true
+		}
+		if 2*code+1 < len(self.cid_to_gid) {
+			return uint16(self.cid_to_gid[2*code])<<8 | uint16(self.cid_to_gid[2*code+1]), true
+		}
+		return 0, false
+	}
+
+	if glyph, ok := self.truetype.GlyphIndex(3, 0, 0xf000+uint32(code)); ok {
+		return glyph, true
+	}
+	if glyph, ok := self.truetype.GlyphIndex(3, 0, uint32(code)); ok {
+		return glyph, true
+	}
+	if glyph, ok := self.truetype.GlyphIndex(1, 0, uint32(code)); ok {
+		return glyph, true
+	}
+	char, ok := self.unicode[code]
+	if !ok {
+		char = rune(code)
+	}
+	return self.truetype.GlyphIndex(3, 1, uint32(char))
+}
+
+// glyphPath adds a glyph in the text rendering matrix, glyphs of fonts that
+// are not embedded are drawn with the built-in font.
+func (self *pdf_font) glyphPath(path *Path, code int, matrix Matrix) {
+	if self.truetype != nil {
+		if glyph, ok := self.glyphIndex(code); ok {
+			scale := 1 / self.truetype.units_per_em
+			path.Matrix = matrix.Multiply(ScaleMatrix(scale, scale))
+			self.truetype.GlyphPath(path, glyph)
+			return
+		}
+	}
+
+	char, ok := self.unicode[code]
+	if !ok || char <= ' ' {
+		return
+	}
+	//narrow the glyph to the width of the original
+	cell_width := PDF_CAP_HEIGHT * FONT_CELL_WIDTH / FONT_CELL_HEIGHT
+	squeeze := math.Max(0.5, math.Min(1, self.width(code)/1000/cell_width))
+	path.Matrix = matrix.Multiply(ScaleMatrix(squeeze, -1))
+	path.GlyphPath(char, 0, 0, PDF_CAP_HEIGHT)
+}
+
+// showText draws a string with the current font and advances the text
+// matrix.
+func (self *pdf_renderer) showText(text []byte) {
+	state := &self.state
+	font := state.font
+	if font == nil {
+		return
+	}
+
+	path := NewPath(IdentityMatrix)
+	for position := 0; position < len(text); {
+		code := int(text[position])
+		position++
+		if font.two_byte && position < len(text) {
+			code = code<<8 | int(text[position])
+			position++
+		}
+
+		text_rendering := state.ctm.Multiply(self.text_matrix).Multiply(Matrix{
+			state.font_size * state.horizontal_scale, 0, 0, state.font_size, 0, state.rise,
+		})
+		font.glyphPath(path, code, text_rendering)
+
+		advance := font.width(code)/1000*state.font_size + state.char_spacing
+		if code == ' ' && !font.two_byte {
+			advance += state.word_spacing
+		}
+		self.text_matrix = self.text_matrix.Multiply(TranslateMatrix(advance*state.horizontal_scale, 0))
+	}
+
+	//render modes 3 and 7 are invisible, e.g. the text layer of scans
+	switch state.render_mode % 4 {
+	case 0:
+		self.fill(path, false)
+	case 1:
+		self.stroke(path)
+	case 2:
+		self.fill(path, false)
+		self.stroke(path)
+	}
+}
+
+func (self *pdf_renderer) xobject(object any, resources pdf_dict) {
+	stream, ok := self.document.resolve(object).(*pdf_stream)
+	if !ok {
+		return
+	}
+
+	switch stream.dict.name("Subtype") {
+	case "Form":
+		if self.depth >= PDF_MAX_DEPTH {
+			return
+		}
+		content, err := self.document.decodeStream(stream)
+		if err != nil {
+			return
+		}
+		if form_resources := self.document.dict(stream.dict["Resources"]); form_resources != nil {
+			resources = form_resources
+		}
+
+		saved_stack, saved_path := len(self.stack), self.path
+		self.stack = append(self.stack, self.state)
+		if matrix := self.document.numbers(stream.dict["Matrix"]); len(matrix) == 6 {
+			self.state.ctm = self.state.ctm.Multiply(Matrix(matrix))
+		}
+		self.path = nil
+		self.depth++
+		self.execute(content, resources)
+		self.depth--
+		self.state = self.stack[saved_stack]
+		self.stack = self.stack[:saved_stack]
+		self.path = saved_path
+	case "Image":
+		self.image(stream, resources)
+	}
+}
+
+// inlineImage reads an image between BI, ID and EI.
+func (self *pdf_renderer) inlineImage(lexer *pdf_lexer, resources pdf_dict) {
+	dict := make(pdf_dict)
+	for {
+		key := lexer.object()
+		if key == nil {
+			return
+		}
+		if key == pdf_keyword("ID") {
+			break
+		}
+		name, ok := key.(pdf_name)
+		if !ok {
+			continue
+		}
+		if full_name, ok := pdf_inline_abbreviations[string(name)]; ok {
+			name = pdf_name(full_name)
+		}
+		dict[string(name)] = lexer.object()
+	}
+
+	//a single white space separates ID and the data
+	start := lexer.position + 1
+	end := -1
+	if _, filtered := dict["Filter"]; !filtered {
+		space := self.colorSpace(dict["ColorSpace"], resources, 0)
+		components := space.components
+		if mask, _ := dict["ImageMask"].(bool); mask {
+			components = 1
+		}
+		bits := int(self.document.number(dict["BitsPerComponent"], 1))
+		row_bytes := (int(self.document.number(dict["Width"], 0))*components*bits + 7) / 8
+		end = start + row_bytes*int(self.document.number(dict["Height"], 0))
+	}
+	if end < start || end > len(lexer.data) {
+		end = start
+		for end+2 <= len(lexer.data) {
+			index := bytes.Index(lexer.data[end:], []byte("EI"))
+			if index < 0 {
+				end = len(lexer.data)
+				break
+			}
+			end += index
+			if isPDFWhitespace(lexer.data[end-1]) && (end+2 == len(lexer.data) || isPDFWhitespace(lexer.data[end+2])) {
+				break
+			}
+			end += 2
+		}
+	}
+
+	lexer.position = min(len(lexer.data), end)
+	if lexer.object() != pdf_keyword("EI") {
+		lexer.position = min(len(lexer.data), end)
+	}
+	self.image(&pdf_stream{dict, lexer.data[start:min(end, len(lexer.data))]}, resources)
+}
+
+// image draws an image into the unit square of the current transformation,
+// image masks are painted with the fill color.
+func (self *pdf_renderer) image(stream *pdf_stream, resources pdf_dict) {
+	samples, mask := self.imageSamples(stream, resources)
+	if samples == nil {
+		return
+	}
+	inverse, ok := self.state.ctm.Invert()
+	if !ok {
+		return
+	}
+
+	min_x, min_y := math.Inf(1), math.Inf(1)
+	max_x, max_y := math.Inf(-1), math.Inf(-1)
+	for _, corner := range []Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		point := self.state.ctm.Apply(corner.X, corner.Y)
+		min_x, min_y = math.Min(min_x, point.X), math.Min(min_y, point.Y)
+		max_x, max_y = math.Max(max_x, point.X), math.Max(max_y, point.Y)
+	}
+	bounds := self.img.Bounds().Intersect(image.Rect(int(math.Floor(min_x)), int(math.Floor(min_y)), int(math.Ceil(max_x)), int(math.Ceil(max_y))))
+
+	width, height := samples.Rect.Dx(), samples.Rect.Dy()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			point := inverse.Apply(float64(x)+0.5, float64(y)+0.5)
+			if point.X < 0 || point.X >= 1 || point.Y < 0 || point.Y >= 1 {
+				continue
+			}
+			//image rows run from the top of the unit square
+			sample := samples.Pix[min(height-1, int((1-point.Y)*float64(height)))*samples.Stride+min(width-1, int(point.X*float64(width)))]
+
+			gray := sample
+			if mask {
+				if sample != 0 || self.state.fill_none {
+					continue
+				}
+				gray = self.state.fill
+			}
+			offset := (y-self.img.Rect.Min.Y)*self.img.Stride + x - self.img.Rect.Min.X
+			alpha := self.state.fill_alpha
+			self.img.Pix[offset] = byte(float64(self.img.Pix[offset])*(1-alpha) + float64(gray)*alpha + 0.5)
+		}
+	}
+}
+
+// imageSamples decodes image data into gray samples, for image masks the
+// samples to paint are 0.
+func (self *pdf_renderer) imageSamples(stream *pdf_stream, resources pdf_dict) (*image.Gray, bool) {
+	dict := stream.dict
+	width := int(self.document.number(dict["Width"], 0))
+	height := int(self.document.number(dict["Height"], 0))
+	if width <= 0 || height <= 0 || width*height > PDF_MAX_PIXELS {
+		return nil, false
+	}
+	data, err := self.document.decodeStream(stream)
+	if err != nil {
+		return nil, false
+	}
+
+	samples := image.NewGray(image.Rect(0, 0, width, height))
+	filters := self.document.resolve(dict["Filter"])
+	if array, ok := filters.([]any); ok && len(array) > 0 {
+		filters = self.document.resolve(array[len(array)-1])
+	}
+	if filters == pdf_name("DCTDecode") || filters == pdf_name("DCT") {
+		decoded, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, false
+		}
+		//scale to the declared size
+		bounds := decoded.Bounds()
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				c := decoded.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height)
+				samples.Pix[y*samples.Stride+x] = color.GrayModel.Convert(c).(color.Gray).Y
+			}
+		}
+		return samples, false
+	}
+
+	mask, _ := self.document.resolve(dict["ImageMask"]).(bool)
+	space := &pdf_color_space{components: 1, kind: "gray"}
+	bits := 1
+	if !mask {
+		space = self.colorSpace(dict["ColorSpace"], resources, 0)
+		bits = int(self.document.number(dict["BitsPerComponent"], 8))
+	}
+	if bits != 1 && bits != 2 && bits != 4 && bits != 8 && bits != 16 || space.components == 0 {
+		return nil, false
+	}
+
+	maximum := float64(int(1)<<bits - 1)
+	decode := self.document.numbers(dict["Decode"])
+	row_bytes := (width*space.components*bits + 7) / 8
+	values := make([]float64, space.components)
+	for y := 0; y < height; y++ {
+		row := data[min(len(data), y*row_bytes):min(len(data), (y+1)*row_bytes)]
+		for x := 0; x < width; x++ {
+			for component := range values {
+				bit := (x*space.components + component) * bits
+				raw := 0
+				if bit/8 < len(row) {
+					if bits == 16 {
+						raw = int(row[bit/8]) << 8
+						if bit/8+1 < len(row) {
+							raw |= int(row[bit/8+1])
+						}
+					} else {
+						raw = int(row[bit/8]>>(8-bits-bit%8)) & (1<<bits - 1)
+					}
+				}
+
+				value := float64(raw)
+				if space.kind != "indexed" {
+					value /= maximum
+				}
+				if 2*component+1 < len(decode) {
+					low, high := decode[2*component], decode[2*component+1]
+					if space.kind == "indexed" {
+						value = low + value*(high-low)/maximum
+					} else {
+						value = low + value*(high-low)
+					}
+				}
+				values[component] = value
+			}
+
+			if mask {
+				if values[0] >= 0.5 {
+					samples.Pix[y*samples.Stride+x] = 0xff
+				}
+				continue
+			}
+			samples.Pix[y*samples.Stride+x], _ = space.gray(values)
+		}
+	}
+	return samples, mask
+}
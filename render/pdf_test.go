@@ -0,0 +1,193 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testPDF returns a document of the objects numbered from 1, the first
+// object is the catalog. No cross-reference table is written, the objects
+// are found by scanning the file as for damaged documents.
+func testPDF(objects ...string) []byte {
+	var document bytes.Buffer
+	document.WriteString("%PDF-1.4\n")
+	for index, object := range objects {
+		fmt.Fprintf(&document, "%d 0 obj\n%s\nendobj\n", index+1, object)
+	}
+	document.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return document.Bytes()
+}
+
+func testPDFStream(dict string, data []byte) string {
+	return fmt.Sprintf("<< /Length %d %s >>\nstream\n%s\nendstream", len(data), dict, data)
+}
+
+// testPDFPage returns a document of one page with the page attributes and
+// content stream given.
+func testPDFPage(attributes string, content string) []byte {
+	return testPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 72 36] /Contents 4 0 R "+attributes+" >>",
+		testPDFStream("", []byte(content)))
+}
+
+// The pages are rendered at 72 dpi, so that points are pixels. The content
+// fills the lower half of the left square of a 72x36 pt page.
+const pdf_test_content = "0 g 0 0 36 18 re f"
+
+func TestRenderPDFPage(t *testing.T) {
+	page, err := RenderPDF(testPDFPage("", pdf_test_content), 1, PDF_POINTS_PER_INCH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Bounds().Dx() != 72 || page.Bounds().Dy() != 36 {
+		t.Fatalf("page bounds %v", page.Bounds())
+	}
+	checkPixels(t, page.Pix, page.Stride, []test_pixel{{5, 30, true}, {30, 20, true}, {5, 10, false}, {50, 30, false}})
+
+	scaled, err := RenderPDF(testPDFPage("", pdf_test_content), 1, 2*PDF_POINTS_PER_INCH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scaled.Bounds().Dx() != 144 || scaled.Bounds().Dy() != 72 {
+		t.Fatalf("scaled page bounds %v", scaled.Bounds())
+	}
+}
+
+func TestRenderPDFRotation(t *testing.T) {
+	for _, test := range []struct {
+		rotate        int
+		width, height int
+		pixels        []test_pixel
+	}{
+		{0, 72, 36, []test_pixel{{5, 30, true}, {5, 10, false}, {60, 30, false}}},
+		//displayed clockwise, the bottom of the page is on the left
+		{90, 36, 72, []test_pixel{{5, 10, true}, {30, 10, false}, {5, 60, false}}},
+		{180, 72, 36, []test_pixel{{60, 5, true}, {10, 5, false}, {60, 30, false}}},
+		{270, 36, 72, []test_pixel{{30, 60, true}, {5, 60, false}, {30, 10, false}}},
+		{-90, 36, 72, []test_pixel{{30, 60, true}, {5, 60, false}, {30, 10, false}}},
+		{450, 36, 72, []test_pixel{{5, 10, true}, {30, 10, false}}},
+	} {
+		page, err := RenderPDF(testPDFPage(fmt.Sprintf("/Rotate %d", test.rotate), pdf_test_content), 1, PDF_POINTS_PER_INCH)
+		if err != nil {
+			t.Errorf("rotate %d: %s", test.rotate, err)
+			continue
+		}
+		if page.Bounds().Dx() != test.width || page.Bounds().Dy() != test.height {
+			t.Errorf("rotate %d: page bounds %v", test.rotate, page.Bounds())
+			continue
+		}
+		checkPixels(t, page.Pix, page.Stride, test.pixels)
+	}
+}
+
+func TestRenderPDFRotationInherited(t *testing.T) {
+	document := testPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 /Rotate 90 /MediaBox [0 0 72 36] >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+		testPDFStream("", []byte(pdf_test_content)))
+	page, err := RenderPDF(document, 1, PDF_POINTS_PER_INCH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Bounds().Dx() != 36 || page.Bounds().Dy() != 72 {
+		t.Fatalf("page bounds %v", page.Bounds())
+	}
+}
+
+func TestRenderPDFCompressed(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write([]byte(pdf_test_content))
+	writer.Close()
+
+	for _, test := range []struct {
+		name   string
+		stream string
+	}{
+		{"flate", testPDFStream("/Filter /FlateDecode", compressed.Bytes())},
+		{"hex", testPDFStream("/Filter /ASCIIHexDecode", []byte(fmt.Sprintf("%x>", pdf_test_content)))},
+		{"flate hex", testPDFStream("/Filter [/ASCIIHexDecode /FlateDecode]", []byte(fmt.Sprintf("%x>", compressed.Bytes())))},
+	} {
+		document := testPDF(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 72 36] /Contents 4 0 R >>",
+			test.stream)
+		page, err := RenderPDF(document, 1, PDF_POINTS_PER_INCH)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		checkPixels(t, page.Pix, page.Stride, []test_pixel{{5, 30, true}, {5, 10, false}})
+	}
+
+	document := testPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 72 36] /Contents 4 0 R >>",
+		testPDFStream("/Filter /LZWDecode", []byte(pdf_test_content)))
+	if _, err := RenderPDF(document, 1, PDF_POINTS_PER_INCH); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("LZW stream decoded, %v", err)
+	}
+}
+
+func TestRenderPDFPageNotFound(t *testing.T) {
+	document := testPDFPage("", pdf_test_content)
+	for _, page_number := range []int{0, 2, -1} {
+		if _, err := RenderPDF(document, page_number, PDF_POINTS_PER_INCH); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("page %d: error %v", page_number, err)
+		}
+	}
+
+	//the second page is found through the page tree
+	pages := testPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /MediaBox [0 0 72 36] >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 36 36] /Contents 5 0 R >>",
+		testPDFStream("", []byte(pdf_test_content)))
+	page, err := RenderPDF(pages, 2, PDF_POINTS_PER_INCH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Bounds().Dx() != 36 || page.Bounds().Dy() != 36 {
+		t.Errorf("second page bounds %v", page.Bounds())
+	}
+	if _, err := RenderPDF(pages, 3, PDF_POINTS_PER_INCH); err == nil || !strings.Contains(err.Error(), "document has 2 pages") {
+		t.Errorf("page 3: error %v", err)
+	}
+}
+
+func TestRenderPDFInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		document []byte
+		err      string
+	}{
+		{"no pdf", []byte("<svg/>"), "not a PDF"},
+		{"encrypted", append(testPDFPage("", pdf_test_content), "trailer\n<< /Encrypt 5 0 R >>\n"...), "encrypted"},
+		{"page too large", testPDF(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 100000 100000] >>"), "page size"},
+		{"empty page", testPDF(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 0 0] >>"), "page size"},
+	} {
+		if _, err := RenderPDF(test.document, 1, PDF_POINTS_PER_INCH); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, expected %s", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"fmt"
+)
+
+const (
+	QR_MIN_VERSION = 1
+	QR_MAX_VERSION = 40
+
+	qr_mode_byte         = 0x4
+	qr_format_generator  = 0x537
+	qr_format_mask       = 0x5412
+	qr_version_generator = 0x1f25
+	qr_gf_polynomial     = 0x11d
+)
+
+// Error correction levels in the order L, M, Q, H with the level bits of
+// the format information.
+var qr_levels = []byte{'L', 'M', 'Q', 'H'}
+var qr_level_bits = []int{1, 0, 3, 2}
+
+// Error correction codewords per block and number of blocks by level and
+// version, index 0 is unused.
+var qr_ecc_codewords = [4][41]int{
+	{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+var qr_ecc_blocks = [4][41]int{
+	{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+type qr_symbol struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// QRCodeModules encodes data in byte mode as QR code of the smallest
+// version holding it with the error correction level L, M, Q or H. The
+// modules are returned as rows, true is dark, without quiet zone.
+func QRCodeModules(data []byte, level byte) ([][]bool, error) {
+	level_index := -1
+	for index, name := range qr_levels {
+		if name == level {
+			level_index = index
+		}
+	}
+	if level_index < 0 {
+		return nil, fmt.Errorf("unknown QR code error correction level %c", level)
+	}
+
+	version := 0
+	for candidate := QR_MIN_VERSION; candidate <= QR_MAX_VERSION; candidate++ {
+		if 4 + qrCountBits(candidate) + 8*len(data) <= 8*qrDataCodewords(candidate, level_index) {
+			version = candidate
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("data too long for a QR code (%d bytes)", len(data))
+	}
+
+	//segment header, data, terminator and padding
+	var bits qr_bits
+	bits.append(qr_mode_byte, 4)
+	bits.append(len(data), qrCountBits(version))
+	for _, octet := range data {
+		bits.append(int(octet), 8)
+	}
+	capacity := 8 * qrDataCodewords(version, level_index)
+	bits.append(0, min(4, capacity - bits.length))
+	bits.append(0, (8 - bits.length % 8) % 8)
+	for pad := 0xec; bits.length < capacity; pad ^= 0xec ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	symbol := newQRSymbol(version)
+	symbol.drawCodewords(qrAddErrorCorrection(bits.data, version, level_index))
+
+	best_mask, best_penalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		symbol.applyMask(mask)
+		symbol.drawFormat(qr_level_bits[level_index], mask)
+		if penalty := symbol.penalty(); best_penalty < 0 || penalty < best_penalty {
+			best_mask, best_penalty = mask, penalty
+		}
+		symbol.applyMask(mask)
+	}
+	symbol.applyMask(best_mask)
+	symbol.drawFormat(qr_level_bits[level_index], best_mask)
+
+	return symbol.modules, nil
+}
+
+type qr_bits struct {
+	data   []byte
+	length int
+}
+
+func (self *qr_bits) append(value int, count int) {
+	for bit := count - 1; bit >= 0; bit-- {
+		if self.length % 8 == 0 {
+			self.data = append(self.data, 0)
+		}
+		if value >> bit & 1 != 0 {
+			self.data[self.length / 8] |= 0x80 >> (self.length % 8)
+		}
+		self.length++
+	}
+}
+
+func qrCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// qrRawModules returns the number of modules available for data and error
+// correction codewords.
+func qrRawModules(version int) int {
+	modules := (16 * version + 128) * version + 64
+	if version >= 2 {
+		alignments := version / 7 + 2
+		modules -= (25 * alignments - 10) * alignments - 55
+		if version >= 7 {
+			modules -= 36
+		}
+	}
+	return modules
+}
+
+func qrDataCodewords(version int, level int) int {
+	return qrRawModules(version) / 8 - qr_ecc_codewords[level][version] * qr_ecc_blocks[level][version]
+}
+
+// qrAddErrorCorrection splits the data into blocks, adds the Reed-Solomon
+// codewords and interleaves the blocks.
+func qrAddErrorCorrection(data []byte, version int, level int) []byte {
+	block_count := qr_ecc_blocks[level][version]
+	ecc_length := qr_ecc_codewords[level][version]
+	raw_codewords := qrRawModules(version) / 8
+	short_blocks := block_count - raw_codewords % block_count
+	short_length := raw_codewords / block_count - ecc_length
+
+	divisor := qrGeneratorPolynomial(ecc_length)
+	data_blocks := make([][]byte, block_count)
+	ecc_blocks := make([][]byte, block_count)
+	for index, position := 0, 0; index < block_count; index++ {
+		length := short_length
+		if index >= short_blocks {
+			length++
+		}
+		data_blocks[index] = data[position : position + length]
+		ecc_blocks[index] = qrRemainder(data_blocks[index], divisor)
+		position += length
+	}
+
+	result := make([]byte, 0, raw_codewords)
+	for index := 0; index <= short_length; index++ {
+		for _, block := range data_blocks {
+			if index < len(block) {
+				result = append(result, block[index])
+			}
+		}
+	}
+	for index := 0; index < ecc_length; index++ {
+		for _, block := range ecc_blocks {
+			result = append(result, block[index])
+		}
+	}
+	return result
+}
+
+func qrMultiply(x byte, y byte) byte {
+	product := 0
+	for bit := 7; bit >= 0; bit-- {
+		product = product << 1 ^ (product >> 7) * qr_gf_polynomial
+		product ^= int(y >> bit & 1) * int(x)
+	}
+	return byte(product)
+}
+
+func qrGeneratorPolynomial(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree - 1] = 1
+	root := byte(1)
+	for range degree {
+		for index := range result {
+			result[index] = qrMultiply(result[index], root)
+			if index + 1 < degree {
+				result[index] ^= result[index + 1]
+			}
+		}
+		root = qrMultiply(root, 2)
+	}
+	return result
+}
+
+func qrRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, octet := range data {
+		factor := octet ^ result[0]
+		copy(result, result[1:])
+		result[len(result) - 1] = 0
+		for index, coefficient := range divisor {
+			result[index] ^= qrMultiply(coefficient, factor)
+		}
+	}
+	return result
+}
+
+// newQRSymbol draws the function patterns of a version, format and version
+// information are reserved.
+func newQRSymbol(version int) *qr_symbol {
+	size := 4 * version + 17
+	self := &qr_symbol{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range size {
+		self.modules[y] = make([]bool, size)
+		self.function[y] = make([]bool, size)
+	}
+
+	for index := range size {
+		self.set(6, index, index % 2 == 0)
+		self.set(index, 6, index % 2 == 0)
+	}
+	for _, corner := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0] + dx, corner[1] + dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					distance := max(abs(dx), abs(dy))
+					self.set(x, y, distance != 2 && distance != 4)
+				}
+			}
+		}
+	}
+
+	positions := qrAlignmentPositions(version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					self.set(x + dx, y + dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	self.drawFormat(0, 0)
+	if version >= 7 {
+		remainder := version
+		for range 12 {
+			remainder = remainder << 1 ^ (remainder >> 11) * qr_version_generator
+		}
+		bits := version << 12 | remainder
+		for index := 0; index < 18; index++ {
+			dark := bits >> index & 1 != 0
+			a, b := size - 11 + index % 3, index / 3
+			self.set(a, b, dark)
+			self.set(b, a, dark)
+		}
+	}
+	return self
+}
+
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version / 7 + 2
+	step := (version * 4 + count * 2 + 1) / (count * 2 - 2) * 2
+	if version == 32 {
+		step = 26
+	}
+	positions := make([]int, count)
+	positions[0] = 6
+	for index, position := count - 1, 4 * version + 10; index >= 1; index, position = index - 1, position - step {
+		positions[index] = position
+	}
+	return positions
+}
+
+// set draws a module of a function pattern.
+func (self *qr_symbol) set(x int, y int, dark bool) {
+	self.modules[y][x] = dark
+	self.function[y][x] = true
+}
+
+func (self *qr_symbol) drawFormat(level_bits int, mask int) {
+	value := level_bits << 3 | mask
+	remainder := value
+	for range 10 {
+		remainder = remainder << 1 ^ (remainder >> 9) * qr_format_generator
+	}
+	bits := (value << 10 | remainder) ^ qr_format_mask
+	bit := func(index int) bool { return bits >> index & 1 != 0 }
+
+	for index := 0; index <= 5; index++ {
+		self.set(8, index, bit(index))
+	}
+	self.set(8, 7, bit(6))
+	self.set(8, 8, bit(7))
+	self.set(7, 8, bit(8))
+	for index := 9; index < 15; index++ {
+		self.set(14 - index, 8, bit(index))
+	}
+	for index := 0; index < 8; index++ {
+		self.set(self.size - 1 - index, 8, bit(index))
+	}
+	for index := 8; index < 15; index++ {
+		self.set(8, self.size - 15 + index, bit(index))
+	}
+	self.set(8, self.size - 8, true)
+}
+
+// drawCodewords places the codewords in the zigzag order of two module
+// wide columns from the bottom right corner.
+func (self *qr_symbol) drawCodewords(data []byte) {
+	bit := 0
+	for right := self.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vertical := 0; vertical < self.size; vertical++ {
+			for column := 0; column < 2; column++ {
+				x := right - column
+				y := vertical
+				if (right + 1) & 2 == 0 {
+					y = self.size - 1 - vertical
+				}
+				if !self.function[y][x] && bit < 8*len(data) {
+					self.modules[y][x] = data[bit / 8] >> (7 - bit % 8) & 1 != 0
+					bit++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by a mask pattern, applying
+// it twice restores the modules.
+func (self *qr_symbol) applyMask(mask int) {
+	for y := 0; y < self.size; y++ {
+		for x := 0; x < self.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x + y) % 2 == 0
+			case 1:
+				invert = y % 2 == 0
+			case 2:
+				invert = x % 3 == 0
+			case 3:
+				invert = (x + y) % 3 == 0
+			case 4:
+				invert = (x / 3 + y / 2) % 2 == 0
+			case 5:
+				invert = x * y % 2 + x * y % 3 == 0
+			case 6:
+				invert = (x * y % 2 + x * y % 3) % 2 == 0
+			case 7:
+				invert = ((x + y) % 2 + x * y % 3) % 2 == 0
+			}
+			if invert && !self.function[y][x] {
+				self.modules[y][x] = !self.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty rates a masked symbol by runs, blocks, finder like patterns and
+// the balance of dark and light modules, the lowest penalty wins.
+func (self *qr_symbol) penalty() int {
+	size := self.size
+	penalty := 0
+	line := make([]bool, size)
+	for direction := 0; direction < 2; direction++ {
+		for a := 0; a < size; a++ {
+			for b := 0; b < size; b++ {
+				if direction == 0 {
+					line[b] = self.modules[a][b]
+				} else {
+					line[b] = self.modules[b][a]
+				}
+			}
+
+			run := 1
+			for b := 1; b <= size; b++ {
+				if b < size && line[b] == line[b - 1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+
+			for b := 0; b + 7 <= size; b++ {
+				if !(line[b] && !line[b + 1] && line[b + 2] && line[b + 3] && line[b + 4] && !line[b + 5] && line[b + 6]) {
+					continue
+				}
+				if qrLight(line, b - 4, b) || qrLight(line, b + 7, b + 11) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if self.modules[y][x] {
+				dark++
+			}
+			if x + 1 < size && y + 1 < size {
+				color := self.modules[y][x]
+				if self.modules[y][x + 1] == color && self.modules[y + 1][x] == color && self.modules[y + 1][x + 1] == color {
+					penalty += 3
+				}
+			}
+		}
+	}
+	penalty += abs(dark * 20 - size * size * 10) / (size * size) * 10
+	return penalty
+}
+
+// qrLight checks that the modules from start to end are light, modules
+// outside the symbol are light.
+func qrLight(line []bool, start int, end int) bool {
+	for index := start; index < end; index++ {
+		if index >= 0 && index < len(line) && line[index] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,1072 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	SVG_XLINK_NAMESPACE  = "http://www.w3.org/1999/xlink"
+	SVG_SNIFF_LENGTH     = 4096
+	SVG_MAX_USE_DEPTH    = 8
+	SVG_MAX_PIXELS       = 64 * 1024 * 1024
+	SVG_DEFAULT_FONT_SIZE = 16
+	SVG_UNITS_PER_EM     = 1000
+)
+
+// Pixels per unit at the CSS resolution of 96 dpi.
+var svg_units = map[string]float64{
+	"":   1,
+	"px": 1,
+	"pt": 96.0 / 72,
+	"pc": 16,
+	"mm": 96 / 25.4,
+	"cm": 96 / 2.54,
+	"in": 96,
+}
+
+// Gray values of the common color keywords, other colors are given as
+// #rgb, #rrggbb or rgb().
+var svg_colors = map[string]byte{
+	"black": 0x00, "white": 0xff, "red": 0x4c, "green": 0x4b, "lime": 0x96, "blue": 0x1d,
+	"yellow": 0xe2, "cyan": 0xb3, "aqua": 0xb3, "magenta": 0x69, "fuchsia": 0x69,
+	"gray": 0x80, "grey": 0x80, "silver": 0xc0, "maroon": 0x26, "navy": 0x0f,
+	"olive": 0x71, "purple": 0x34, "teal": 0x5a, "orange": 0xad, "darkgray": 0xa9,
+	"darkgrey": 0xa9, "lightgray": 0xd3, "lightgrey": 0xd3,
+}
+
+// svg_node is an element of the SVG document, character data is kept as
+// nodes without name.
+type svg_node struct {
+	name       string
+	attributes map[string]string
+	children   []*svg_node
+	text       string
+}
+
+type svg_paint struct {
+	none bool
+	gray byte
+}
+
+// svg_style holds the inherited presentation properties of an element.
+type svg_style struct {
+	fill           svg_paint
+	fill_opacity   float64
+	even_odd       bool
+	stroke         svg_paint
+	stroke_width   float64
+	stroke_opacity float64
+	line_cap       int
+	opacity        float64
+	color          byte
+	font_family    string
+	font_size      float64
+	bold           bool
+	text_anchor    string
+	hidden         bool
+}
+
+type svg_font struct {
+	units_per_em float64
+	advance      float64
+	glyphs       map[rune]svg_glyph
+	missing      *svg_glyph
+}
+
+type svg_glyph struct {
+	path    string
+	advance float64
+}
+
+type svg_renderer struct {
+	img       *image.Gray
+	ids       map[string]*svg_node
+	fonts     map[string]*svg_font
+	use_depth int
+}
+
+// IsSVG reports whether the data looks like an SVG document.
+func IsSVG(data []byte) bool {
+	data = bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if !bytes.HasPrefix(data, []byte("<")) {
+		return false
+	}
+	return bytes.Contains(data[:min(len(data), SVG_SNIFF_LENGTH)], []byte("<svg"))
+}
+
+// RenderSVG rasterizes an SVG drawing for a label of the given height in
+// pixels, usually the printing width of the tape. The height of the drawing
+// is mapped to the height of the label, the length follows from the aspect
+// ratio. Paths, basic shapes, transforms, groups and use references are
+// drawn, text is drawn with embedded SVG fonts or the built-in font.
+// Gradients are drawn in their mean color, images, clipping and masks are
+// not supported.
+func RenderSVG(data []byte, height int) (*image.Gray, error) {
+	root, err := parseSVGDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	min_x, min_y, width, view_height, err := svgViewBox(root)
+	if err != nil {
+		return nil, err
+	}
+	scale := float64(height) / view_height
+	length := math.Ceil(width*scale - 0.01)
+	if length <= 0 || height <= 0 {
+		return nil, fmt.Errorf("svg: empty drawing")
+	}
+	//the size is checked before conversion, huge lengths overflow int
+	if length*float64(height) > SVG_MAX_PIXELS {
+		return nil, fmt.Errorf("svg: drawing too large, %.0fx%d pixels", length, height)
+	}
+
+	renderer := &svg_renderer{
+		img:   NewWhiteImage(int(length), height),
+		ids:   make(map[string]*svg_node),
+		fonts: make(map[string]*svg_font),
+	}
+	renderer.index(root)
+
+	matrix := ScaleMatrix(scale, scale).Multiply(TranslateMatrix(-min_x, -min_y))
+	renderer.renderChildren(root, matrix, renderer.style(defaultSVGStyle(), root))
+	return renderer.img, nil
+}
+
+func defaultSVGStyle() svg_style {
+	return svg_style{
+		fill:           svg_paint{gray: 0},
+		fill_opacity:   1,
+		stroke:         svg_paint{none: true},
+		stroke_width:   1,
+		stroke_opacity: 1,
+		opacity:        1,
+		font_size:      SVG_DEFAULT_FONT_SIZE,
+		text_anchor:    "start",
+	}
+}
+
+func parseSVGDocument(data []byte) (*svg_node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	var root *svg_node
+	var stack []*svg_node
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if root != nil && len(stack) == 0 {
+				break
+			}
+			return nil, fmt.Errorf("svg: %s", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			node := &svg_node{name: token.Name.Local, attributes: make(map[string]string)}
+			for _, attribute := range token.Attr {
+				switch attribute.Name.Space {
+				case "", "xml", "xlink", SVG_XLINK_NAMESPACE:
+					node.attributes[attribute.Name.Local] = attribute.Value
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root == nil {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, &svg_node{text: string(token)})
+			}
+		}
+	}
+
+	if root.name != "svg" {
+		return nil, fmt.Errorf("svg: root element is %s", root.name)
+	}
+	return root, nil
+}
+
+// svgViewBox returns the user space of the drawing given by viewBox or by
+// width and height.
+func svgViewBox(root *svg_node) (min_x float64, min_y float64, width float64, height float64, err error) {
+	if view_box := svgNumbers(root.attributes["viewBox"]); len(view_box) == 4 {
+		min_x, min_y, width, height = view_box[0], view_box[1], view_box[2], view_box[3]
+	} else {
+		width, _ = svgLength(root.attributes["width"], 0, 0)
+		height, _ = svgLength(root.attributes["height"], 0, 0)
+	}
+	if width <= 0 || height <= 0 {
+		err = fmt.Errorf("svg: drawing size missing, viewBox or width and height required")
+	}
+	return
+}
+
+// index collects the elements with id and the embedded fonts.
+func (self *svg_renderer) index(node *svg_node) {
+	if id, ok := node.attributes["id"]; ok {
+		self.ids[id] = node
+	}
+	if node.name == "font" {
+		self.addFont(node)
+	}
+	for _, child := range node.children {
+		self.index(child)
+	}
+}
+
+func (self *svg_renderer) addFont(node *svg_node) {
+	font := &svg_font{
+		units_per_em: SVG_UNITS_PER_EM,
+		advance:      svgNumber(node.attributes["horiz-adv-x"], 0),
+		glyphs:       make(map[rune]svg_glyph),
+	}
+	family := node.attributes["id"]
+
+	for _, child := range node.children {
+		switch child.name {
+		case "font-face":
+			font.units_per_em = svgNumber(child.attributes["units-per-em"], SVG_UNITS_PER_EM)
+			if name := child.attributes["font-family"]; len(name) > 0 {
+				family = name
+			}
+		case "glyph", "missing-glyph":
+			glyph := svg_glyph{child.attributes["d"], svgNumber(child.attributes["horiz-adv-x"], -1)}
+			if child.name == "missing-glyph" {
+				font.missing = &glyph
+				continue
+			}
+			//ligatures of several characters are not supported
+			if char, size := utf8.DecodeRuneInString(child.attributes["unicode"]); size > 0 && size == len(child.attributes["unicode"]) {
+				font.glyphs[char] = glyph
+			}
+		}
+	}
+	if font.advance == 0 {
+		font.advance = font.units_per_em / 2
+	}
+	self.fonts[strings.ToLower(family)] = font
+}
+
+func (self *svg_renderer) renderChildren(node *svg_node, matrix Matrix, style svg_style) {
+	for _, child := range node.children {
+		if len(child.name) > 0 {
+			self.render(child, matrix, style)
+		}
+	}
+}
+
+func (self *svg_renderer) render(node *svg_node, matrix Matrix, parent_style svg_style) {
+	style := self.style(parent_style, node)
+	if style.hidden && node.name != "g" {
+		return
+	}
+	if display, _ := svgProperty(node, "display"); display == "none" {
+		return
+	}
+
+	transform, err := svgTransform(node.attributes["transform"])
+	if err != nil {
+		return
+	}
+	matrix = matrix.Multiply(transform)
+	attribute := func(name string) float64 {
+		value, _ := svgLength(node.attributes[name], style.font_size, 0)
+		return value
+	}
+
+	path := NewPath(matrix)
+	switch node.name {
+	case "g", "a", "switch":
+		self.renderChildren(node, matrix, style)
+		return
+	case "svg":
+		self.renderChildren(node, matrix.Multiply(TranslateMatrix(attribute("x"), attribute("y"))), style)
+		return
+	case "use":
+		self.renderUse(node, matrix, style, attribute("x"), attribute("y"))
+		return
+	case "text":
+		self.renderText(node, matrix, style)
+		return
+	case "path":
+		//invalid path data is rendered up to the error as browsers do
+		parseSVGPath(path, node.attributes["d"])
+	case "rect":
+		x, y, width, height := attribute("x"), attribute("y"), attribute("width"), attribute("height")
+		rx, rx_set := svgLength(node.attributes["rx"], style.font_size, 0)
+		ry, ry_set := svgLength(node.attributes["ry"], style.font_size, 0)
+		if !rx_set {
+			rx = ry
+		}
+		if !ry_set {
+			ry = rx
+		}
+		if width <= 0 || height <= 0 {
+			return
+		}
+		svgRoundedRect(path, x, y, width, height, math.Min(rx, width/2), math.Min(ry, height/2))
+	case "circle":
+		if r := attribute("r"); r > 0 {
+			path.Ellipse(attribute("cx"), attribute("cy"), r, r)
+		}
+	case "ellipse":
+		if rx, ry := attribute("rx"), attribute("ry"); rx > 0 && ry > 0 {
+			path.Ellipse(attribute("cx"), attribute("cy"), rx, ry)
+		}
+	case "line":
+		path.MoveTo(attribute("x1"), attribute("y1"))
+		path.LineTo(attribute("x2"), attribute("y2"))
+		style.fill.none = true
+	case "polyline", "polygon":
+		points := svgNumbers(node.attributes["points"])
+		for index := 0; index+1 < len(points); index += 2 {
+			if index == 0 {
+				path.MoveTo(points[0], points[1])
+			} else {
+				path.LineTo(points[index], points[index+1])
+			}
+		}
+		if node.name == "polygon" {
+			path.Close()
+		}
+	default:
+		//definitions, metadata and unsupported elements
+		return
+	}
+
+	self.paint(path, matrix, style)
+}
+
+func (self *svg_renderer) paint(path *Path, matrix Matrix, style svg_style) {
+	if style.hidden {
+		return
+	}
+	if !style.fill.none {
+		path.Fill(self.img, color.Gray{style.fill.gray}, style.fill_opacity*style.opacity, style.even_odd)
+	}
+	if !style.stroke.none && style.stroke_width > 0 {
+		outline := path.StrokeOutline(style.stroke_width*matrix.Scale(), style.line_cap)
+		outline.Fill(self.img, color.Gray{style.stroke.gray}, style.stroke_opacity*style.opacity, false)
+	}
+}
+
+func (self *svg_renderer) renderUse(node *svg_node, matrix Matrix, style svg_style, x float64, y float64) {
+	reference := node.attributes["href"]
+	target, ok := self.ids[strings.TrimPrefix(reference, "#")]
+	if !ok || !strings.HasPrefix(reference, "#") || self.use_depth >= SVG_MAX_USE_DEPTH {
+		return
+	}
+
+	self.use_depth++
+	defer func() { self.use_depth-- }()
+
+	matrix = matrix.Multiply(TranslateMatrix(x, y))
+	if target.name == "symbol" {
+		self.renderChildren(target, matrix, self.style(style, target))
+	} else {
+		self.render(target, matrix, style)
+	}
+}
+
+// svg_text_run is a piece of text with one style, chunk marks the start of
+// a text chunk at an absolute position, chunks are aligned by text-anchor.
+type svg_text_run struct {
+	text     string
+	style    svg_style
+	position Point
+	advance  float64
+	chunk    bool
+}
+
+// renderText draws the text and tspan content, tspans may set a new
+// position with x and y or move it with dx and dy.
+func (self *svg_renderer) renderText(node *svg_node, matrix Matrix, style svg_style) {
+	var runs []svg_text_run
+	cursor := Point{}
+	self.layoutText(node, style, &cursor, &runs)
+
+	for start := 0; start < len(runs); {
+		end := start + 1
+		for end < len(runs) && !runs[end].chunk {
+			end++
+		}
+
+		width := runs[end-1].position.X + runs[end-1].advance - runs[start].position.X
+		shift := 0.0
+		switch runs[start].style.text_anchor {
+		case "middle":
+			shift = -width / 2
+		case "end":
+			shift = -width
+		}
+		for _, run := range runs[start:end] {
+			run.position.X += shift
+			self.drawText(run, matrix)
+		}
+		start = end
+	}
+}
+
+func (self *svg_renderer) layoutText(node *svg_node, style svg_style, cursor *Point, runs *[]svg_text_run) {
+	position := func(name string, current float64) float64 {
+		if values := svgNumbers(node.attributes[name]); len(values) > 0 {
+			return values[0]
+		}
+		return current
+	}
+	_, has_x := node.attributes["x"]
+	_, has_y := node.attributes["y"]
+	chunk := has_x || has_y || len(*runs) == 0
+	cursor.X = position("x", cursor.X) + position("dx", 0)
+	cursor.Y = position("y", cursor.Y) + position("dy", 0)
+
+	for index, child := range node.children {
+		switch {
+		case len(child.name) == 0:
+			text := strings.Join(strings.Fields(child.text), " ")
+			if len(text) == 0 {
+				continue
+			}
+			if strings.HasPrefix(child.text, " ") && index > 0 {
+				text = " " + text
+			}
+			if strings.HasSuffix(child.text, " ") && index < len(node.children)-1 {
+				text += " "
+			}
+
+			font := self.findFont(style.font_family)
+			advance := 0.0
+			for _, char := range text {
+				advance += self.glyphAdvance(font, char, style.font_size)
+			}
+			*runs = append(*runs, svg_text_run{text, style, *cursor, advance, chunk})
+			cursor.X += advance
+			chunk = false
+		case child.name == "tspan":
+			if display, _ := svgProperty(child, "display"); display != "none" {
+				self.layoutText(child, self.style(style, child), cursor, runs)
+			}
+		}
+	}
+}
+
+// drawText draws a text run with the embedded font or the built-in font.
+func (self *svg_renderer) drawText(run svg_text_run, matrix Matrix) {
+	font := self.findFont(run.style.font_family)
+	size := run.style.font_size
+	x, y := run.position.X, run.position.Y
+
+	path := NewPath(matrix)
+	for _, char := range run.text {
+		if font != nil {
+			glyph, ok := font.glyphs[char]
+			if !ok && font.missing != nil {
+				glyph = *font.missing
+			}
+			scale := size / font.units_per_em
+			path.Matrix = matrix.Multiply(TranslateMatrix(x, y)).Multiply(ScaleMatrix(scale, -scale))
+			parseSVGPath(path, glyph.path)
+		} else if char != ' ' {
+			path.GlyphPath(char, x, y, size)
+			if run.style.bold {
+				path.GlyphPath(char, x+size/FONT_CELL_HEIGHT/2, y, size)
+			}
+		}
+		x += self.glyphAdvance(font, char, size)
+	}
+
+	style := run.style
+	style.even_odd = false
+	self.paint(path, matrix, style)
+}
+
+func (self *svg_renderer) findFont(families string) *svg_font {
+	for _, family := range strings.Split(families, ",") {
+		family = strings.ToLower(strings.Trim(strings.TrimSpace(family), `"'`))
+		if font, ok := self.fonts[family]; ok {
+			return font
+		}
+	}
+	return nil
+}
+
+func (self *svg_renderer) glyphAdvance(font *svg_font, char rune, size float64) float64 {
+	if font == nil {
+		return size * FONT_CELL_WIDTH / FONT_CELL_HEIGHT
+	}
+	glyph, ok := font.glyphs[char]
+	if !ok && font.missing != nil {
+		glyph = *font.missing
+	}
+	if glyph.advance < 0 || !ok && font.missing == nil {
+		return font.advance * size / font.units_per_em
+	}
+	return glyph.advance * size / font.units_per_em
+}
+
+// svgProperty returns a presentation property, the style attribute takes
+// precedence over presentation attributes.
+func svgProperty(node *svg_node, name string) (string, bool) {
+	for _, declaration := range strings.Split(node.attributes["style"], ";") {
+		key, value, found := strings.Cut(declaration, ":")
+		if found && strings.TrimSpace(key) == name {
+			return strings.TrimSpace(value), true
+		}
+	}
+	value, ok := node.attributes[name]
+	return strings.TrimSpace(value), ok
+}
+
+// style returns the style of an element inheriting from the parent style.
+func (self *svg_renderer) style(parent svg_style, node *svg_node) svg_style {
+	style := parent
+	style.opacity = 1
+
+	property := func(name string, apply func(value string)) {
+		if value, ok := svgProperty(node, name); ok && value != "inherit" {
+			apply(value)
+		}
+	}
+
+	property("font-size", func(value string) {
+		if size, ok := svgLength(value, parent.font_size, parent.font_size); ok && size > 0 {
+			style.font_size = size
+		}
+	})
+	property("color", func(value string) {
+		if paint, ok := svgColor(value, style.color); ok && !paint.none {
+			style.color = paint.gray
+		}
+	})
+	property("fill", func(value string) {
+		if paint, ok := self.paintValue(value, style.color); ok {
+			style.fill = paint
+		}
+	})
+	property("stroke", func(value string) {
+		if paint, ok := self.paintValue(value, style.color); ok {
+			style.stroke = paint
+		}
+	})
+	property("fill-opacity", func(value string) { style.fill_opacity = svgOpacity(value) })
+	property("stroke-opacity", func(value string) { style.stroke_opacity = svgOpacity(value) })
+	property("opacity", func(value string) { style.opacity = svgOpacity(value) })
+	property("fill-rule", func(value string) { style.even_odd = value == "evenodd" })
+	property("stroke-width", func(value string) {
+		if width, ok := svgLength(value, style.font_size, 0); ok {
+			style.stroke_width = width
+		}
+	})
+	property("stroke-linecap", func(value string) {
+		style.line_cap = map[string]int{"round": CAP_ROUND, "square": CAP_SQUARE}[value]
+	})
+	property("font-family", func(value string) { style.font_family = value })
+	property("font-weight", func(value string) {
+		weight, err := strconv.Atoi(value)
+		style.bold = value == "bold" || value == "bolder" || err == nil && weight >= 600
+	})
+	property("font", func(value string) {
+		//shorthand, only size and family are used
+		fields := strings.Fields(value)
+		for index, field := range fields {
+			if size, ok := svgLength(strings.Split(field, "/")[0], parent.font_size, parent.font_size); ok && size > 0 {
+				style.font_size = size
+				style.font_family = strings.Join(fields[index+1:], " ")
+				break
+			}
+		}
+	})
+	property("text-anchor", func(value string) { style.text_anchor = value })
+	property("visibility", func(value string) { style.hidden = value == "hidden" || value == "collapse" })
+
+	//group opacity is applied to the elements of the group
+	style.opacity *= parent.opacity
+	return style
+}
+
+// paintValue resolves a fill or stroke value, gradients are drawn in the
+// mean color of their stops.
+func (self *svg_renderer) paintValue(value string, current_color byte) (svg_paint, bool) {
+	reference, found := strings.CutPrefix(value, "url(")
+	if !found {
+		return svgColor(value, current_color)
+	}
+	reference, fallback, _ := strings.Cut(reference, ")")
+	gradient, ok := self.ids[strings.Trim(strings.TrimPrefix(strings.Trim(reference, `"' `), "#"), `"' `)]
+
+	//the stops may be inherited from another gradient
+	for depth := 0; ok && depth < SVG_MAX_USE_DEPTH; depth++ {
+		total, count := 0.0, 0
+		for _, stop := range gradient.children {
+			if stop.name != "stop" {
+				continue
+			}
+			value, _ := svgProperty(stop, "stop-color")
+			paint, ok := svgColor(value, current_color)
+			if !ok {
+				paint = svg_paint{gray: 0}
+			}
+			opacity := 1.0
+			if value, found := svgProperty(stop, "stop-opacity"); found {
+				opacity = svgOpacity(value)
+			}
+			total += float64(paint.gray)*opacity + 0xff*(1-opacity)
+			count++
+		}
+		if count > 0 {
+			return svg_paint{gray: byte(total/float64(count) + 0.5)}, true
+		}
+		gradient, ok = self.ids[strings.TrimPrefix(gradient.attributes["href"], "#")]
+	}
+
+	if len(strings.TrimSpace(fallback)) > 0 {
+		return svgColor(fallback, current_color)
+	}
+	return svg_paint{none: true}, true
+}
+
+func svgColor(value string, current_color byte) (svg_paint, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch {
+	case value == "none" || value == "transparent":
+		return svg_paint{none: true}, true
+	case value == "currentcolor":
+		return svg_paint{gray: current_color}, true
+	case strings.HasPrefix(value, "#"):
+		hex := value[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) == 8 {
+			hex = hex[:6]
+		}
+		rgb, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return svg_paint{}, false
+		}
+		return svg_paint{gray: svgGray(float64(rgb>>16), float64(rgb>>8&0xff), float64(rgb&0xff))}, true
+	case strings.HasPrefix(value, "rgb(") || strings.HasPrefix(value, "rgba("):
+		arguments := strings.FieldsFunc(value[strings.Index(value, "(")+1:], func(char rune) bool {
+			return char == ',' || char == ' ' || char == ')' || char == '/'
+		})
+		if len(arguments) < 3 {
+			return svg_paint{}, false
+		}
+		var rgb [3]float64
+		for index := range rgb {
+			if percent, found := strings.CutSuffix(arguments[index], "%"); found {
+				rgb[index] = svgNumber(percent, 0) * 2.55
+			} else {
+				rgb[index] = svgNumber(arguments[index], 0)
+			}
+		}
+		return svg_paint{gray: svgGray(rgb[0], rgb[1], rgb[2])}, true
+	}
+	gray, ok := svg_colors[value]
+	return svg_paint{gray: gray}, ok
+}
+
+// svgGray converts a color to gray with the luma weights of image/color.
+func svgGray(red float64, green float64, blue float64) byte {
+	gray := (299*red + 587*green + 114*blue) / 1000
+	return byte(math.Max(0, math.Min(255, gray+0.5)))
+}
+
+func svgOpacity(value string) float64 {
+	if percent, found := strings.CutSuffix(value, "%"); found {
+		return math.Max(0, math.Min(1, svgNumber(percent, 100)/100))
+	}
+	return math.Max(0, math.Min(1, svgNumber(value, 1)))
+}
+
+func svgNumber(value string, default_value float64) float64 {
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return default_value
+	}
+	return number
+}
+
+// svgNumbers parses a list of numbers separated by white space or commas.
+func svgNumbers(value string) []float64 {
+	scanner := svg_scanner{data: value}
+	var numbers []float64
+	for {
+		number, ok := scanner.number()
+		if !ok {
+			return numbers
+		}
+		numbers = append(numbers, number)
+	}
+}
+
+// svgLength parses a length with unit in user units, em refers to the font
+// size and percentages to reference.
+func svgLength(value string, font_size float64, reference float64) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	end := len(value)
+	for end > 0 && (value[end-1] >= 'a' && value[end-1] <= 'z' || value[end-1] == '%') {
+		end--
+	}
+	number, err := strconv.ParseFloat(value[:end], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	switch unit := value[end:]; unit {
+	case "em":
+		return number * font_size, true
+	case "ex":
+		return number * font_size / 2, true
+	case "%":
+		return number * reference / 100, true
+	default:
+		factor, ok := svg_units[unit]
+		return number * factor, ok
+	}
+}
+
+// svgTransform parses a transform list.
+func svgTransform(value string) (Matrix, error) {
+	matrix := IdentityMatrix
+	for len(strings.TrimSpace(value)) > 0 {
+		open := strings.Index(value, "(")
+		close := strings.Index(value, ")")
+		if open < 0 || close < open {
+			return IdentityMatrix, fmt.Errorf("svg: invalid transform %s", value)
+		}
+		name := strings.Trim(value[:open], " \t\r\n,")
+		arguments := svgNumbers(value[open+1 : close])
+		value = value[close+1:]
+
+		argument := func(index int, default_value float64) float64 {
+			if index < len(arguments) {
+				return arguments[index]
+			}
+			return default_value
+		}
+
+		var transform Matrix
+		switch name {
+		case "matrix":
+			if len(arguments) != 6 {
+				return IdentityMatrix, fmt.Errorf("svg: matrix requires 6 arguments")
+			}
+			copy(transform[:], arguments)
+		case "translate":
+			transform = TranslateMatrix(argument(0, 0), argument(1, 0))
+		case "scale":
+			transform = ScaleMatrix(argument(0, 1), argument(1, argument(0, 1)))
+		case "rotate":
+			cx, cy := argument(1, 0), argument(2, 0)
+			transform = TranslateMatrix(cx, cy).Multiply(RotateMatrix(argument(0, 0))).Multiply(TranslateMatrix(-cx, -cy))
+		case "skewX":
+			transform = Matrix{1, 0, math.Tan(argument(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			transform = Matrix{1, math.Tan(argument(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return IdentityMatrix, fmt.Errorf("svg: unknown transform %s", name)
+		}
+		matrix = matrix.Multiply(transform)
+	}
+	return matrix, nil
+}
+
+func svgRoundedRect(path *Path, x float64, y float64, width float64, height float64, rx float64, ry float64) {
+	if rx <= 0 || ry <= 0 {
+		path.Rect(x, y, width, height)
+		return
+	}
+	const kappa = 0.5522847498
+	path.MoveTo(x+rx, y)
+	path.LineTo(x+width-rx, y)
+	path.CubicTo(x+width-rx*(1-kappa), y, x+width, y+ry*(1-kappa), x+width, y+ry)
+	path.LineTo(x+width, y+height-ry)
+	path.CubicTo(x+width, y+height-ry*(1-kappa), x+width-rx*(1-kappa), y+height, x+width-rx, y+height)
+	path.LineTo(x+rx, y+height)
+	path.CubicTo(x+rx*(1-kappa), y+height, x, y+height-ry*(1-kappa), x, y+height-ry)
+	path.LineTo(x, y+ry)
+	path.CubicTo(x, y+ry*(1-kappa), x+rx*(1-kappa), y, x+rx, y)
+	path.Close()
+}
+
+// Number of arguments of the path commands.
+var svg_path_arguments = map[byte]int{'M': 2, 'L': 2, 'H': 1, 'V': 1, 'C': 6, 'S': 4, 'Q': 4, 'T': 2, 'A': 7}
+
+// svg_scanner reads the tokens of path data and number lists.
+type svg_scanner struct {
+	data     string
+	position int
+}
+
+func (self *svg_scanner) skipSeparators() {
+	for self.position < len(self.data) && strings.IndexByte(" \t\r\n,", self.data[self.position]) >= 0 {
+		self.position++
+	}
+}
+
+// number reads a number, numbers may follow each other without separator
+// as in "1.5.5" or "1-2".
+func (self *svg_scanner) number() (float64, bool) {
+	self.skipSeparators()
+	start := self.position
+	end := start
+	if end < len(self.data) && (self.data[end] == '+' || self.data[end] == '-') {
+		end++
+	}
+	digits, dot := 0, false
+	for end < len(self.data) {
+		char := self.data[end]
+		if char >= '0' && char <= '9' {
+			digits++
+		} else if char == '.' && !dot {
+			dot = true
+		} else {
+			break
+		}
+		end++
+	}
+	if digits == 0 {
+		return 0, false
+	}
+	if end < len(self.data) && (self.data[end] == 'e' || self.data[end] == 'E') {
+		exponent := end + 1
+		if exponent < len(self.data) && (self.data[exponent] == '+' || self.data[exponent] == '-') {
+			exponent++
+		}
+		if exponent < len(self.data) && self.data[exponent] >= '0' && self.data[exponent] <= '9' {
+			for end = exponent; end < len(self.data) && self.data[end] >= '0' && self.data[end] <= '9'; end++ {
+			}
+		}
+	}
+
+	number, err := strconv.ParseFloat(self.data[start:end], 64)
+	if err != nil {
+		return 0, false
+	}
+	self.position = end
+	return number, true
+}
+
+// flag reads an arc flag, flags may be written without separator.
+func (self *svg_scanner) flag() (bool, bool) {
+	self.skipSeparators()
+	if self.position < len(self.data) && (self.data[self.position] == '0' || self.data[self.position] == '1') {
+		self.position++
+		return self.data[self.position-1] == '1', true
+	}
+	return false, false
+}
+
+func (self *svg_scanner) command() (byte, bool) {
+	self.skipSeparators()
+	if self.position < len(self.data) && strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", self.data[self.position]) >= 0 {
+		self.position++
+		return self.data[self.position-1], true
+	}
+	return 0, false
+}
+
+// parseSVGPath adds SVG path data to a path. The path is drawn up to the
+// first error.
+func parseSVGPath(path *Path, data string) error {
+	scanner := svg_scanner{data: data}
+	var current, start, control Point
+	var previous byte
+
+	for {
+		command, ok := scanner.command()
+		if !ok {
+			scanner.skipSeparators()
+			if scanner.position < len(scanner.data) {
+				return fmt.Errorf("svg: invalid path data at %d", scanner.position)
+			}
+			return nil
+		}
+
+		relative := command >= 'a'
+		upper := command &^ 0x20
+		for first := true; ; first = false {
+			if upper == 'Z' {
+				path.Close()
+				current = start
+				previous = 'Z'
+				break
+			}
+
+			var numbers [7]float64
+			count := svg_path_arguments[upper]
+			for index := 0; index < count; index++ {
+				if upper == 'A' && (index == 3 || index == 4) {
+					flag, ok := scanner.flag()
+					if !ok {
+						return fmt.Errorf("svg: missing arc flag")
+					}
+					if flag {
+						numbers[index] = 1
+					}
+				} else if numbers[index], ok = scanner.number(); !ok {
+					if first || index > 0 {
+						return fmt.Errorf("svg: missing path argument for %c", command)
+					}
+					count = -1
+					break
+				}
+			}
+			if count < 0 {
+				break
+			}
+
+			offset := Point{}
+			if relative {
+				offset = current
+			}
+			point := func(index int) Point {
+				return Point{numbers[index] + offset.X, numbers[index+1] + offset.Y}
+			}
+			reflected := current
+			if strings.IndexByte("CS", previous) >= 0 && (upper == 'C' || upper == 'S') ||
+				strings.IndexByte("QT", previous) >= 0 && (upper == 'Q' || upper == 'T') {
+				reflected = Point{2*current.X - control.X, 2*current.Y - control.Y}
+			}
+
+			switch upper {
+			case 'M':
+				current = point(0)
+				start = current
+				if first {
+					path.MoveTo(current.X, current.Y)
+				} else {
+					//further coordinates of a moveto are lines
+					path.LineTo(current.X, current.Y)
+				}
+			case 'L':
+				current = point(0)
+				path.LineTo(current.X, current.Y)
+			case 'H':
+				current.X = numbers[0] + offset.X
+				path.LineTo(current.X, current.Y)
+			case 'V':
+				current.Y = numbers[0] + offset.Y
+				path.LineTo(current.X, current.Y)
+			case 'C':
+				control = point(2)
+				p1, end := point(0), point(4)
+				path.CubicTo(p1.X, p1.Y, control.X, control.Y, end.X, end.Y)
+				current = end
+			case 'S':
+				control = point(0)
+				end := point(2)
+				path.CubicTo(reflected.X, reflected.Y, control.X, control.Y, end.X, end.Y)
+				current = end
+			case 'Q':
+				control = point(0)
+				end := point(2)
+				path.QuadTo(control.X, control.Y, end.X, end.Y)
+				current = end
+			case 'T':
+				control = reflected
+				end := point(0)
+				path.QuadTo(control.X, control.Y, end.X, end.Y)
+				current = end
+			case 'A':
+				end := point(5)
+				svgArc(path, current, numbers[0], numbers[1], numbers[2], numbers[3] != 0, numbers[4] != 0, end)
+				current = end
+			}
+			previous = upper
+		}
+	}
+}
+
+// svgArc adds an elliptical arc as cubic curves, converting the endpoint
+// parameters to center parameters as described in the SVG specification.
+func svgArc(path *Path, from Point, rx float64, ry float64, rotation float64, large_arc bool, sweep bool, to Point) {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 {
+		path.LineTo(to.X, to.Y)
+		return
+	}
+	if from == to {
+		return
+	}
+
+	sin, cos := math.Sincos(rotation * math.Pi / 180)
+	dx, dy := (from.X-to.X)/2, (from.Y-to.Y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+
+	if lambda := x1*x1/(rx*rx) + y1*y1/(ry*ry); lambda > 1 {
+		rx *= math.Sqrt(lambda)
+		ry *= math.Sqrt(lambda)
+	}
+
+	numerator := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	factor := math.Sqrt(math.Max(0, numerator/(rx*rx*y1*y1+ry*ry*x1*x1)))
+	if large_arc == sweep {
+		factor = -factor
+	}
+	cx1 := factor * rx * y1 / ry
+	cy1 := -factor * ry * x1 / rx
+	cx := cos*cx1 - sin*cy1 + (from.X+to.X)/2
+	cy := sin*cx1 + cos*cy1 + (from.Y+to.Y)/2
+
+	angle := func(ux float64, uy float64, vx float64, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	start := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	extent := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && extent > 0 {
+		extent -= 2 * math.Pi
+	} else if sweep && extent < 0 {
+		extent += 2 * math.Pi
+	}
+
+	//at most a quarter ellipse per curve
+	segments := int(math.Ceil(math.Abs(extent) / (math.Pi / 2)))
+	step := extent / float64(segments)
+	handle := 4.0 / 3 * math.Tan(step/4)
+	ellipse := func(theta float64) (Point, Point) {
+		sin_theta, cos_theta := math.Sincos(theta)
+		point := Point{cx + rx*cos_theta*cos - ry*sin_theta*sin, cy + rx*cos_theta*sin + ry*sin_theta*cos}
+		derivative := Point{-rx*sin_theta*cos - ry*cos_theta*sin, -rx*sin_theta*sin + ry*cos_theta*cos}
+		return point, derivative
+	}
+
+	for segment := 0; segment < segments; segment++ {
+		theta := start + float64(segment)*step
+		p0, d0 := ellipse(theta)
+		p3, d3 := ellipse(theta + step)
+		if segment == segments-1 {
+			p3 = to
+		}
+		path.CubicTo(p0.X+handle*d0.X, p0.Y+handle*d0.Y, p3.X-handle*d3.X, p3.Y-handle*d3.Y, p3.X, p3.Y)
+	}
+}
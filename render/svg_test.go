@@ -0,0 +1,211 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+type test_pixel struct {
+	x, y  int
+	black bool
+}
+
+// svg_labels are drawn in a 60x40 viewBox at a height of 40 pixels, so that
+// user units are pixels.
+var svg_labels = []struct {
+	name   string
+	svg    string
+	pixels []test_pixel
+}{
+	{"rect", `<rect x="10" y="10" width="20" height="10"/>`,
+		[]test_pixel{{10, 10, true}, {29, 19, true}, {9, 10, false}, {30, 15, false}, {20, 20, false}}},
+	{"rect_rounded", `<rect x="10" y="10" width="40" height="20" rx="8"/>`,
+		[]test_pixel{{10, 10, false}, {11, 11, false}, {12, 20, true}, {30, 10, true}, {49, 29, false}}},
+	{"circle", `<circle cx="30" cy="20" r="10"/>`,
+		[]test_pixel{{30, 20, true}, {30, 11, true}, {21, 20, true}, {22, 12, false}, {41, 20, false}}},
+	{"ellipse", `<ellipse cx="30" cy="20" rx="20" ry="5"/>`,
+		[]test_pixel{{12, 20, true}, {47, 20, true}, {30, 16, true}, {30, 12, false}, {12, 16, false}}},
+	{"line", `<line x1="0" y1="20" x2="60" y2="20" stroke="black" stroke-width="4"/>`,
+		[]test_pixel{{5, 18, true}, {55, 21, true}, {30, 16, false}, {30, 23, false}}},
+	{"polygon", `<polygon points="10,30 30,10 50,30"/>`,
+		[]test_pixel{{30, 25, true}, {15, 28, true}, {30, 9, false}, {15, 15, false}, {30, 31, false}}},
+	{"polyline_open", `<polyline points="10,10 50,10 50,30" fill="none" stroke="#000" stroke-width="2"/>`,
+		[]test_pixel{{30, 10, true}, {50, 20, true}, {30, 20, false}, {25, 25, false}}},
+	{"path", `<path d="M10 10 h20 v20 h-20 z M15 15 h10 v10 h-10 z" fill-rule="evenodd"/>`,
+		[]test_pixel{{12, 12, true}, {27, 27, true}, {20, 20, false}, {35, 20, false}}},
+	{"path_nonzero", `<path d="M10 10 h20 v20 h-20 z M15 15 h10 v10 h-10 z"/>`,
+		[]test_pixel{{12, 12, true}, {20, 20, true}}},
+	{"fill_none", `<rect x="10" y="10" width="20" height="20" fill="none" stroke="black" stroke-width="2"/>`,
+		[]test_pixel{{10, 20, true}, {29, 20, true}, {20, 20, false}}},
+	{"fill_white", `<rect width="60" height="40"/><rect x="20" y="10" width="20" height="20" fill="white"/>`,
+		[]test_pixel{{10, 10, true}, {30, 20, false}}},
+	{"display_none", `<rect width="60" height="40" style="display: none"/>`,
+		[]test_pixel{{30, 20, false}}},
+	{"use", `<defs><rect id="box" width="10" height="10"/></defs><use href="#box" x="40" y="20"/>`,
+		[]test_pixel{{45, 25, true}, {5, 5, false}}},
+
+	//transforms
+	{"translate", `<rect width="10" height="10" transform="translate(20 5)"/>`,
+		[]test_pixel{{25, 10, true}, {5, 5, false}}},
+	{"scale", `<rect width="10" height="10" transform="scale(2)"/>`,
+		[]test_pixel{{18, 18, true}, {21, 10, false}}},
+	{"rotate", `<rect x="10" y="18" width="40" height="4" transform="rotate(90 30 20)"/>`,
+		[]test_pixel{{30, 2, true}, {30, 37, true}, {15, 20, false}, {45, 20, false}}},
+	{"matrix", `<rect width="10" height="10" transform="matrix(1 0 0 1 40 20)"/>`,
+		[]test_pixel{{45, 25, true}, {5, 5, false}}},
+	{"skew", `<rect x="0" y="0" width="10" height="40" transform="skewX(45)"/>`,
+		[]test_pixel{{5, 2, true}, {45, 38, true}, {5, 38, false}}},
+	{"group", `<g transform="translate(30 0)"><g transform="scale(1 2)"><rect width="10" height="10"/></g></g>`,
+		[]test_pixel{{35, 18, true}, {35, 22, false}, {5, 5, false}}},
+	{"nested_svg", `<svg x="40" y="20"><rect width="10" height="10"/></svg>`,
+		[]test_pixel{{45, 25, true}, {5, 5, false}}},
+
+	//arcs
+	{"arc_sweep", `<path d="M10 30 A20 20 0 0 1 50 30 Z"/>`,
+		[]test_pixel{{30, 15, true}, {30, 35, false}}},
+	{"arc_sweep_reversed", `<path d="M10 10 A20 20 0 0 0 50 10 Z"/>`,
+		[]test_pixel{{30, 25, true}, {30, 5, false}}},
+	//nearly a full circle around 20.25,10
+	{"arc_large", `<path d="M20 20 a10 10 0 1 1 0.5 0 z"/>`,
+		[]test_pixel{{20, 2, true}, {12, 10, true}, {28, 10, true}, {20, 18, true}, {20, 30, false}, {35, 10, false}}},
+	{"arc_scaled", `<path d="M10 20 A1 1 0 0 1 50 20 Z"/>`,
+		[]test_pixel{{30, 5, true}, {30, 25, false}}},
+	{"arc_packed_flags", `<path d="M10 20a20 20 0 0150 0z"/>`,
+		[]test_pixel{{30, 5, true}}},
+}
+
+func TestRenderSVGShapes(t *testing.T) {
+	for _, test := range svg_labels {
+		t.Run(test.name, func(t *testing.T) {
+			svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 60 40">` + test.svg + `</svg>`
+			label, err := RenderSVG([]byte(svg), 40)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if label.Bounds().Dx() != 60 || label.Bounds().Dy() != 40 {
+				t.Fatalf("label bounds %v", label.Bounds())
+			}
+			checkPixels(t, label.Pix, label.Stride, test.pixels)
+		})
+	}
+}
+
+// The glyph of A is a square of 800 units, B has no glyph and advances by
+// the font advance.
+const svg_test_font = `<defs><font id="square" horiz-adv-x="500">
+  <font-face font-family="Square" units-per-em="1000"/>
+  <glyph unicode="A" horiz-adv-x="1000" d="M100 0 H900 V800 H100 Z"/>
+</font></defs>`
+
+func TestRenderSVGEmbeddedFont(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		text   string
+		pixels []test_pixel
+	}{
+		//font size 20, baseline at 30, the glyph covers 2..18 x 14..30
+		{"glyph", `<text x="0" y="30" font-family="Square" font-size="20">A</text>`,
+			[]test_pixel{{10, 22, true}, {3, 15, true}, {10, 10, false}, {20, 22, false}}},
+		{"advance", `<text x="0" y="30" font-family="'Square', serif" font-size="20">AA</text>`,
+			[]test_pixel{{10, 22, true}, {30, 22, true}, {20, 22, false}}},
+		{"missing_glyph", `<text x="0" y="30" font-family="Square" font-size="20">BA</text>`,
+			[]test_pixel{{5, 22, false}, {20, 22, true}}},
+		{"anchor_end", `<text x="60" y="30" font-family="Square" font-size="20" text-anchor="end">A</text>`,
+			[]test_pixel{{50, 22, true}, {10, 22, false}}},
+		{"anchor_middle", `<text x="30" y="30" font-family="Square" font-size="20" text-anchor="middle">A</text>`,
+			[]test_pixel{{30, 22, true}, {10, 22, false}, {50, 22, false}}},
+		{"tspan", `<text y="30" font-family="Square" font-size="20"><tspan x="40">A</tspan></text>`,
+			[]test_pixel{{50, 22, true}, {10, 22, false}}},
+		{"font_shorthand", `<text x="0" y="30" style="font: bold 20px Square">A</text>`,
+			[]test_pixel{{10, 22, true}, {10, 10, false}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 60 40">` + svg_test_font + test.text + `</svg>`
+			label, err := RenderSVG([]byte(svg), 40)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPixels(t, label.Pix, label.Stride, test.pixels)
+		})
+	}
+}
+
+func TestRenderSVGBuiltinFont(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 60 40"><text x="2" y="30" font-size="30">H</text></svg>`
+	label, err := RenderSVG([]byte(svg), 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	black := 0
+	for _, pixel := range label.Pix {
+		if pixel < 0x80 {
+			black++
+		}
+	}
+	if black == 0 {
+		t.Error("text not drawn")
+	}
+}
+
+func TestRenderSVGSize(t *testing.T) {
+	for _, test := range []struct {
+		svg    string
+		height int
+		length int
+		err    string
+	}{
+		{`<svg viewBox="0 0 120 20"></svg>`, 64, 384, ""},
+		{`<svg width="30mm" height="10mm"></svg>`, 128, 384, ""},
+		{`<svg viewBox="0 0 100000000 1"></svg>`, 64, 0, "too large"},
+		{`<svg viewBox="0 0 1e300 1"></svg>`, 64, 0, "too large"},
+		{`<svg viewBox="0 0 0 10"></svg>`, 64, 0, "size missing"},
+		{`<svg></svg>`, 64, 0, "size missing"},
+		{`<svg viewBox="0 0 10 10"></svg>`, 0, 0, "empty"},
+		{`<html></html>`, 64, 0, "root element"},
+	} {
+		label, err := RenderSVG([]byte(test.svg), test.height)
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, expected %s", test.svg, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.svg, err)
+			continue
+		}
+		if label.Bounds().Dx() != test.length || label.Bounds().Dy() != test.height {
+			t.Errorf("%s: size %v, expected %dx%d", test.svg, label.Bounds().Size(), test.length, test.height)
+		}
+	}
+}
+
+func TestIsSVG(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		svg  bool
+	}{
+		{`<svg viewBox="0 0 1 1"/>`, true},
+		{"\xef\xbb\xbf\n<?xml version=\"1.0\"?>\n<svg/>", true},
+		{`<?xml version="1.0"?><html/>`, false},
+		{"%PDF-1.4", false},
+	} {
+		if svg := IsSVG([]byte(test.data)); svg != test.svg {
+			t.Errorf("%q: IsSVG %t", test.data, svg)
+		}
+	}
+}
+
+func checkPixels(t *testing.T, pix []byte, stride int, pixels []test_pixel) {
+	t.Helper()
+	for _, pixel := range pixels {
+		if black := pix[pixel.y*stride+pixel.x] < 0x80; black != pixel.black {
+			t.Errorf("pixel %d,%d black %t, expected %t", pixel.x, pixel.y, black, pixel.black)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+const (
+	TERMINAL_BLOCKS = "blocks" //Unicode half blocks, two pixels per character cell
+	TERMINAL_SIXEL  = "sixel"
+	TERMINAL_KITTY  = "kitty"
+
+	RULER_HEIGHT     = 18  //px below the label, ticks and numbers in mm
+	RULER_TICK_CM    = 6   //px
+	RULER_TICK_HALF  = 4   //px, every 5 mm
+	RULER_TICK_MM    = 2   //px
+	MM_PER_INCH      = 25.4
+	KITTY_CHUNK_SIZE = 4096 //bytes of base64 data per escape sequence
+)
+
+// TerminalColors are the colors of a preview, the label is printed in the
+// ink color onto the tape color.
+type TerminalColors struct {
+	Tape  color.RGBA
+	Ink   color.RGBA
+	Ruler color.RGBA
+}
+
+// picture colors, index 0 is transparent
+const (
+	PICTURE_BACKGROUND = iota
+	PICTURE_TAPE
+	PICTURE_INK
+	PICTURE_RULER
+)
+
+// TerminalPicture draws the label as it looks on tape with a ruler in mm
+// below, scaled by an integer factor. Pixels darker than half gray are
+// printed, the label image has the resolution in dpi.
+func TerminalPicture(img *image.Gray, colors TerminalColors, resolution uint16, scale int) *image.Paletted {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	palette := color.Palette{color.RGBA{}, colors.Tape, colors.Ink, colors.Ruler}
+	picture := image.NewPaletted(image.Rect(0, 0, width * scale, (height + RULER_HEIGHT) * scale), palette)
+
+	ruler := NewWhiteImage(width, RULER_HEIGHT)
+	for _, tick := range rulerTicks(width, resolution) {
+		FillRect(ruler, tick.x, 2, 1, tick.height, color.Gray{0})
+		if len(tick.label) > 0 {
+			DrawText(ruler, tick.label, tick.x, 2 + RULER_TICK_CM + 2, 1)
+		}
+	}
+
+	for y := 0; y < height + RULER_HEIGHT; y++ {
+		for x := 0; x < width; x++ {
+			index := uint8(PICTURE_BACKGROUND)
+			switch {
+			case y < height && img.GrayAt(bounds.Min.X + x, bounds.Min.Y + y).Y < 0x80:
+				index = PICTURE_INK
+			case y < height:
+				index = PICTURE_TAPE
+			case ruler.GrayAt(x, y - height).Y < 0x80:
+				index = PICTURE_RULER
+			}
+			for offset := 0; offset < scale * scale; offset++ {
+				picture.SetColorIndex(x * scale + offset % scale, y * scale + offset / scale, index)
+			}
+		}
+	}
+	return picture
+}
+
+type ruler_tick struct {
+	x      int
+	height int
+	label  string //mm at full centimetres
+}
+
+// rulerTicks returns the ticks of every mm along a label of the width in
+// pixels, labelled every cm if the label fits and does not overlap the
+// previous one.
+func rulerTicks(width int, resolution uint16) (ticks []ruler_tick) {
+	label_end := 0
+	for mm := 0; ; mm++ {
+		x := int(float64(mm) * float64(resolution) / MM_PER_INCH + 0.5)
+		if x >= width {
+			return
+		}
+		tick := ruler_tick{x: x, height: RULER_TICK_MM}
+		switch {
+		case mm % 10 == 0:
+			tick.height = RULER_TICK_CM
+			if label := fmt.Sprint(mm); x >= label_end && x + len(label) * FONT_CELL_WIDTH <= width {
+				tick.label = label
+				label_end = x + (len(label) + 1) * FONT_CELL_WIDTH
+			}
+		case mm % 5 == 0:
+			tick.height = RULER_TICK_HALF
+		}
+		ticks = append(ticks, tick)
+	}
+}
+
+// WriteHalfBlocks writes the label with a ruler as colored Unicode half
+// blocks, scaled down to fit the columns of the terminal. Every character
+// cell shows two square blocks of pixels, blocks with any pixel darker than
+// half gray are printed.
+func WriteHalfBlocks(writer io.Writer, img *image.Gray, colors TerminalColors, resolution uint16, columns int) error {
+	bounds := img.Bounds()
+	scale := max((bounds.Dx() + columns - 1) / max(columns, 1), 1)
+	cells := (bounds.Dx() + scale - 1) / scale
+
+	dark := func(column int, row int) bool {
+		for y := row * scale; y < min((row + 1) * scale, bounds.Dy()); y++ {
+			for x := column * scale; x < min((column + 1) * scale, bounds.Dx()); x++ {
+				if img.GrayAt(bounds.Min.X + x, bounds.Min.Y + y).Y < 0x80 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	output := bufio.NewWriter(writer)
+	rows := (bounds.Dy() + scale - 1) / scale
+	for row := 0; row < rows; row += 2 {
+		fmt.Fprintf(output, "%s%s", ansiColor(38, colors.Ink), ansiColor(48, colors.Tape))
+		for column := 0; column < cells; column++ {
+			top := dark(column, row)
+			bottom := row + 1 < rows && dark(column, row + 1)
+			switch {
+			case top && bottom:
+				output.WriteString("█")
+			case top:
+				output.WriteString("▀")
+			case bottom:
+				output.WriteString("▄")
+			default:
+				output.WriteString(" ")
+			}
+		}
+		output.WriteString("\x1b[0m\n")
+	}
+
+	ticks := []rune(strings.Repeat(" ", cells))
+	labels := []rune(strings.Repeat(" ", cells))
+	for _, tick := range rulerTicks(bounds.Dx(), resolution) {
+		column := tick.x / scale
+		switch {
+		case tick.height == RULER_TICK_CM:
+			ticks[column] = '|'
+		case tick.height == RULER_TICK_HALF && ticks[column] == ' ':
+			ticks[column] = '\''
+		case ticks[column] == ' ' && float64(resolution) / MM_PER_INCH / float64(scale) >= 2:
+			ticks[column] = '.'
+		}
+		if len(tick.label) > 0 && (column == 0 || labels[column - 1] == ' ') && column + len(tick.label) <= cells {
+			copy(labels[column:], []rune(tick.label))
+		}
+	}
+	length := float64(bounds.Dx()) * MM_PER_INCH / float64(resolution)
+	fmt.Fprintf(output, "%s%s\n%s  %.1f mm\x1b[0m\n", ansiColor(38, colors.Ruler), string(ticks), strings.TrimRight(string(labels), " "), length)
+	return output.Flush()
+}
+
+func ansiColor(code int, c color.RGBA) string {
+	return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", code, c.R, c.G, c.B)
+}
+
+// WriteSixel writes a paletted image as sixel graphics, pixels of color
+// index 0 keep the background of the terminal.
+func WriteSixel(writer io.Writer, picture *image.Paletted) error {
+	bounds := picture.Bounds()
+	output := bufio.NewWriter(writer)
+	fmt.Fprintf(output, "\x1bP0;1;0q\"1;1;%d;%d", bounds.Dx(), bounds.Dy())
+	for index, c := range picture.Palette[1:] {
+		red, green, blue, _ := c.RGBA()
+		fmt.Fprintf(output, "#%d;2;%d;%d;%d", index + 1, red * 100 / 0xffff, green * 100 / 0xffff, blue * 100 / 0xffff)
+	}
+
+	sixels := make([]byte, bounds.Dx())
+	for band := bounds.Min.Y; band < bounds.Max.Y; band += 6 {
+		for index := 1; index < len(picture.Palette); index++ {
+			used := false
+			for x := range sixels {
+				sixels[x] = 0
+				for bit := 0; bit < 6 && band + bit < bounds.Max.Y; bit++ {
+					if picture.ColorIndexAt(bounds.Min.X + x, band + bit) == uint8(index) {
+						sixels[x] |= 1 << bit
+						used = true
+					}
+				}
+			}
+			if !used {
+				continue
+			}
+			fmt.Fprintf(output, "#%d", index)
+			writeSixelRuns(output, sixels)
+			output.WriteByte('$')
+		}
+		output.WriteByte('-')
+	}
+	output.WriteString("\x1b\\\n")
+	return output.Flush()
+}
+
+// writeSixelRuns writes the sixels of one color with repeated sixels run
+// length encoded.
+func writeSixelRuns(output *bufio.Writer, sixels []byte) {
+	for start := 0; start < len(sixels); {
+		end := start + 1
+		for end < len(sixels) && sixels[end] == sixels[start] {
+			end++
+		}
+		char := 0x3f + sixels[start]
+		if count := end - start; count > 3 {
+			fmt.Fprintf(output, "!%d%c", count, char)
+		} else {
+			output.Write(bytes.Repeat([]byte{char}, count))
+		}
+		start = end
+	}
+}
+
+// WriteKitty writes an image as PNG with the graphics protocol of kitty.
+func WriteKitty(writer io.Writer, img image.Image) error {
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data.Bytes())
+
+	output := bufio.NewWriter(writer)
+	for start := 0; start < len(encoded); start += KITTY_CHUNK_SIZE {
+		end := min(start + KITTY_CHUNK_SIZE, len(encoded))
+		more := 0
+		if end < len(encoded) {
+			more = 1
+		}
+		if start == 0 {
+			fmt.Fprintf(output, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, encoded[start:end])
+		} else {
+			fmt.Fprintf(output, "\x1b_Gm=%d;%s\x1b\\", more, encoded[start:end])
+		}
+	}
+	output.WriteString("\n")
+	return output.Flush()
+}
@@ -0,0 +1,129 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+// Package render draws labels for the printing width of the tape: text with
+// the built-in and TrueType fonts, barcodes, QR codes, SVG, PDF and ZPL.
+package render
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	FONT_DEFAULT = "5x7"
+	FONT_BOLD = "5x7-bold"
+)
+
+// Fonts returns the names of the built-in fonts.
+func Fonts() []string {
+	return []string{FONT_DEFAULT, FONT_BOLD}
+}
+
+func IsFont(font string) bool {
+	return font == FONT_DEFAULT || font == FONT_BOLD
+}
+
+// RenderText renders text with the built-in font onto a white image of the
+// given height, usually the printing width of the tape. Lines are separated
+// by newlines; the font is scaled by the largest factor all lines fit into.
+func RenderText(text string, height int) *image.Gray {
+	return RenderTextFont(text, height, FONT_DEFAULT)
+}
+
+// RenderTextFont renders text like RenderText with one of the built-in fonts.
+// The bold font overstrikes every glyph shifted by a fraction of the scale,
+// unknown fonts are rendered with the default font.
+func RenderTextFont(text string, height int, font string) *image.Gray {
+	lines := strings.Split(text, "\n")
+
+	scale := height / (len(lines) * FONT_CELL_HEIGHT)
+	if scale < 1 {
+		scale = 1
+	}
+
+	width, text_height := TextSize(text, scale)
+	bold_offset := 0
+	if font == FONT_BOLD {
+		bold_offset = (scale + 1) / 2
+		width += bold_offset
+	}
+	img := NewWhiteImage(width, height)
+
+	top := (height - text_height) / 2
+	if top < 0 {
+		top = 0
+	}
+
+	for index, line := range lines {
+		DrawText(img, line, 0, top + index*FONT_CELL_HEIGHT*scale, scale)
+		if bold_offset > 0 {
+			DrawText(img, line, bold_offset, top + index*FONT_CELL_HEIGHT*scale, scale)
+		}
+	}
+
+	return img
+}
+
+// TextSize returns the size of the text in pixels without the spacing after
+// the last column and row.
+func TextSize(text string, scale int) (width int, height int) {
+	lines := strings.Split(text, "\n")
+
+	columns := 0
+	for _, line := range lines {
+		if count := utf8.RuneCountInString(line); count > columns {
+			columns = count
+		}
+	}
+
+	width = columns*FONT_CELL_WIDTH*scale - scale
+	if width < 1 {
+		width = 1
+	}
+	height = len(lines)*FONT_CELL_HEIGHT*scale - scale
+	return
+}
+
+// DrawText draws a single line of text in black, x and y being the top left
+// corner of the first character.
+func DrawText(img *image.Gray, text string, x int, y int, scale int) {
+	for _, char := range text {
+		DrawGlyph(img, char, x, y, scale, scale)
+		x += FONT_CELL_WIDTH * scale
+	}
+}
+
+func DrawGlyph(img *image.Gray, char rune, x int, y int, scale_x int, scale_y int) {
+	glyph := FontGlyph(char)
+
+	for column := 0; column < FONT_GLYPH_WIDTH; column++ {
+		for row := 0; row < FONT_GLYPH_HEIGHT; row++ {
+			if glyph[column] & (1 << row) == 0 {
+				continue
+			}
+			FillRect(img, x + column*scale_x, y + row*scale_y, scale_x, scale_y, color.Gray{0})
+		}
+	}
+}
+
+func FillRect(img *image.Gray, x int, y int, width int, height int, c color.Gray) {
+	rect := image.Rect(x, y, x + width, y + height).Intersect(img.Rect)
+	for py := rect.Min.Y; py < rect.Max.Y; py++ {
+		for px := rect.Min.X; px < rect.Max.X; px++ {
+			img.SetGray(px, py, c)
+		}
+	}
+}
+
+func NewWhiteImage(width int, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for index := range img.Pix {
+		img.Pix[index] = 0xff
+	}
+	return img
+}
@@ -0,0 +1,381 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	TRUETYPE_MAX_COMPONENT_DEPTH = 8
+
+	truetype_on_curve       = 0x01
+	truetype_x_short        = 0x02
+	truetype_y_short        = 0x04
+	truetype_repeat         = 0x08
+	truetype_x_same         = 0x10
+	truetype_y_same         = 0x20
+	truetype_words          = 0x0001
+	truetype_xy_values      = 0x0002
+	truetype_scale          = 0x0008
+	truetype_more           = 0x0020
+	truetype_xy_scale       = 0x0040
+	truetype_two_by_two     = 0x0080
+)
+
+// truetype_font reads the glyph outlines of TrueType fonts, as embedded in
+// PDF documents. Hinting instructions are ignored.
+type truetype_font struct {
+	units_per_em float64
+	long_offsets bool
+	loca         []byte
+	glyf         []byte
+	cmaps        []truetype_cmap
+}
+
+type truetype_cmap struct {
+	platform uint16
+	encoding uint16
+	table    []byte
+}
+
+func parseTrueType(data []byte) (*truetype_font, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("truetype: font too short")
+	}
+	tables := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint16(data[4:]))
+	for index := 0; index < count && 12+16*(index+1) <= len(data); index++ {
+		entry := data[12+16*index:]
+		offset := binary.BigEndian.Uint32(entry[8:])
+		length := binary.BigEndian.Uint32(entry[12:])
+		if uint64(offset)+uint64(length) <= uint64(len(data)) {
+			tables[string(entry[:4])] = data[offset : offset+length]
+		}
+	}
+
+	head, loca, glyf := tables["head"], tables["loca"], tables["glyf"]
+	if len(head) < 54 || loca == nil || glyf == nil {
+		return nil, fmt.Errorf("truetype: head, loca or glyf table missing")
+	}
+	font := &truetype_font{
+		units_per_em: float64(binary.BigEndian.Uint16(head[18:])),
+		long_offsets: binary.BigEndian.Uint16(head[50:]) != 0,
+		loca:         loca,
+		glyf:         glyf,
+	}
+	if font.units_per_em == 0 {
+		font.units_per_em = 1000
+	}
+
+	if cmap := tables["cmap"]; len(cmap) >= 4 {
+		for index := 0; index < int(binary.BigEndian.Uint16(cmap[2:])) && 4+8*(index+1) <= len(cmap); index++ {
+			entry := cmap[4+8*index:]
+			if offset := binary.BigEndian.Uint32(entry[4:]); int(offset) < len(cmap) {
+				font.cmaps = append(font.cmaps, truetype_cmap{
+					binary.BigEndian.Uint16(entry),
+					binary.BigEndian.Uint16(entry[2:]),
+					cmap[offset:],
+				})
+			}
+		}
+	}
+	return font, nil
+}
+
+// GlyphIndex looks up a character code in the cmap subtable of the given
+// platform and encoding.
+func (self *truetype_font) GlyphIndex(platform uint16, encoding uint16, code uint32) (uint16, bool) {
+	for _, cmap := range self.cmaps {
+		if cmap.platform == platform && cmap.encoding == encoding {
+			if glyph := cmap.lookup(code); glyph != 0 {
+				return glyph, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (self *truetype_cmap) lookup(code uint32) uint16 {
+	table := self.table
+	if len(table) < 4 {
+		return 0
+	}
+	u16 := func(offset int) uint32 {
+		if offset+2 > len(table) {
+			return 0
+		}
+		return uint32(binary.BigEndian.Uint16(table[offset:]))
+	}
+	u32 := func(offset int) uint32 {
+		if offset+4 > len(table) {
+			return 0
+		}
+		return binary.BigEndian.Uint32(table[offset:])
+	}
+
+	switch u16(0) {
+	case 0:
+		if code < 256 && 6+int(code) < len(table) {
+			return uint16(table[6+code])
+		}
+	case 4:
+		segments := int(u16(6) / 2)
+		for segment := 0; segment < segments; segment++ {
+			end := u16(14 + 2*segment)
+			if code > end {
+				continue
+			}
+			start := u16(16 + 2*segments + 2*segment)
+			if code < start {
+				return 0
+			}
+			delta := u16(16 + 4*segments + 2*segment)
+			range_offset_position := 16 + 6*segments + 2*segment
+			range_offset := u16(range_offset_position)
+			if range_offset == 0 {
+				return uint16(code + delta)
+			}
+			glyph := u16(range_offset_position + int(range_offset) + 2*int(code-start))
+			if glyph == 0 {
+				return 0
+			}
+			return uint16(glyph + delta)
+		}
+	case 6:
+		first, count := u16(6), u16(8)
+		if code >= first && code < first+count {
+			return uint16(u16(10 + 2*int(code-first)))
+		}
+	case 12:
+		for group := 0; group < int(u32(12)); group++ {
+			start, end, glyph := u32(16+12*group), u32(20+12*group), u32(24+12*group)
+			if code >= start && code <= end {
+				return uint16(glyph + code - start)
+			}
+		}
+	}
+	return 0
+}
+
+// glyphData returns the outline data of a glyph, empty for blank glyphs.
+func (self *truetype_font) glyphData(glyph uint16) []byte {
+	var start, end uint32
+	if self.long_offsets {
+		if 4*int(glyph)+8 > len(self.loca) {
+			return nil
+		}
+		start = binary.BigEndian.Uint32(self.loca[4*int(glyph):])
+		end = binary.BigEndian.Uint32(self.loca[4*int(glyph)+4:])
+	} else {
+		if 2*int(glyph)+4 > len(self.loca) {
+			return nil
+		}
+		start = 2 * uint32(binary.BigEndian.Uint16(self.loca[2*int(glyph):]))
+		end = 2 * uint32(binary.BigEndian.Uint16(self.loca[2*int(glyph)+2:]))
+	}
+	if start >= end || end > uint32(len(self.glyf)) {
+		return nil
+	}
+	return self.glyf[start:end]
+}
+
+// GlyphPath adds the outline of a glyph in font units to the path.
+func (self *truetype_font) GlyphPath(path *Path, glyph uint16) {
+	self.glyphPath(path, glyph, 0)
+}
+
+func (self *truetype_font) glyphPath(path *Path, glyph uint16, depth int) {
+	data := self.glyphData(glyph)
+	if len(data) < 10 || depth > TRUETYPE_MAX_COMPONENT_DEPTH {
+		return
+	}
+
+	contours := int(int16(binary.BigEndian.Uint16(data)))
+	if contours < 0 {
+		self.compositePath(path, data[10:], depth)
+		return
+	}
+
+	position := 10 + 2*contours
+	if position+2 > len(data) {
+		return
+	}
+	end_points := make([]int, contours)
+	for index := range end_points {
+		end_points[index] = int(binary.BigEndian.Uint16(data[10+2*index:]))
+	}
+	if contours == 0 {
+		return
+	}
+	points := end_points[contours-1] + 1
+	position += 2 + int(binary.BigEndian.Uint16(data[position:]))
+
+	flags := make([]byte, 0, points)
+	for len(flags) < points && position < len(data) {
+		flag := data[position]
+		position++
+		flags = append(flags, flag)
+		if flag&truetype_repeat != 0 && position < len(data) {
+			for repeat := data[position]; repeat > 0 && len(flags) < points; repeat-- {
+				flags = append(flags, flag)
+			}
+			position++
+		}
+	}
+	if len(flags) < points {
+		return
+	}
+
+	coordinates := func(short byte, same byte) []float64 {
+		values := make([]float64, points)
+		value := 0
+		for index, flag := range flags {
+			switch {
+			case flag&short != 0 && position < len(data):
+				if flag&same != 0 {
+					value += int(data[position])
+				} else {
+					value -= int(data[position])
+				}
+				position++
+			case flag&short == 0 && flag&same == 0 && position+1 < len(data):
+				value += int(int16(binary.BigEndian.Uint16(data[position:])))
+				position += 2
+			}
+			values[index] = float64(value)
+		}
+		return values
+	}
+	xs := coordinates(truetype_x_short, truetype_x_same)
+	ys := coordinates(truetype_y_short, truetype_y_same)
+
+	start := 0
+	for _, end := range end_points {
+		if end < start || end >= points {
+			return
+		}
+		self.contourPath(path, xs[start:end+1], ys[start:end+1], flags[start:end+1])
+		start = end + 1
+	}
+}
+
+// contourPath adds a contour of quadratic curves, points between two off
+// curve points are implied on the curve.
+func (self *truetype_font) contourPath(path *Path, xs []float64, ys []float64, flags []byte) {
+	count := len(xs)
+	on := func(index int) bool { return flags[index%count]&truetype_on_curve != 0 }
+	point := func(index int) Point { return Point{xs[index%count], ys[index%count]} }
+	middle := func(a Point, b Point) Point { return Point{(a.X + b.X) / 2, (a.Y + b.Y) / 2} }
+
+	var control *Point
+	add := func(current Point, on_curve bool) {
+		switch {
+		case on_curve && control != nil:
+			path.QuadTo(control.X, control.Y, current.X, current.Y)
+			control = nil
+		case on_curve:
+			path.LineTo(current.X, current.Y)
+		default:
+			if control != nil {
+				implied := middle(*control, current)
+				path.QuadTo(control.X, control.Y, implied.X, implied.Y)
+			}
+			control = &current
+		}
+	}
+
+	//start at an on curve point, or between two off curve points
+	first := -1
+	for index := 0; index < count; index++ {
+		if on(index) {
+			first = index
+			break
+		}
+	}
+	if first >= 0 {
+		path.MoveTo(xs[first], ys[first])
+		for step := 1; step <= count; step++ {
+			add(point(first+step), on(first+step))
+		}
+	} else {
+		start := middle(point(count-1), point(0))
+		path.MoveTo(start.X, start.Y)
+		for index := 0; index < count; index++ {
+			add(point(index), false)
+		}
+		add(start, true)
+	}
+	path.Close()
+}
+
+// compositePath adds the components of a composite glyph.
+func (self *truetype_font) compositePath(path *Path, data []byte, depth int) {
+	matrix := path.Matrix
+	defer func() { path.Matrix = matrix }()
+
+	position := 0
+	for {
+		if position+4 > len(data) {
+			return
+		}
+		flags := binary.BigEndian.Uint16(data[position:])
+		glyph := binary.BigEndian.Uint16(data[position+2:])
+		position += 4
+
+		var dx, dy float64
+		if flags&truetype_words != 0 {
+			if position+4 > len(data) {
+				return
+			}
+			dx = float64(int16(binary.BigEndian.Uint16(data[position:])))
+			dy = float64(int16(binary.BigEndian.Uint16(data[position+2:])))
+			position += 4
+		} else {
+			if position+2 > len(data) {
+				return
+			}
+			dx = float64(int8(data[position]))
+			dy = float64(int8(data[position+1]))
+			position += 2
+		}
+		if flags&truetype_xy_values == 0 {
+			//point matching is not supported
+			dx, dy = 0, 0
+		}
+
+		f2dot14 := func() float64 {
+			if position+2 > len(data) {
+				return 0
+			}
+			value := float64(int16(binary.BigEndian.Uint16(data[position:]))) / 16384
+			position += 2
+			return value
+		}
+		transform := Matrix{1, 0, 0, 1, dx, dy}
+		switch {
+		case flags&truetype_scale != 0:
+			transform[0] = f2dot14()
+			transform[3] = transform[0]
+		case flags&truetype_xy_scale != 0:
+			transform[0] = f2dot14()
+			transform[3] = f2dot14()
+		case flags&truetype_two_by_two != 0:
+			transform[0] = f2dot14()
+			transform[1] = f2dot14()
+			transform[2] = f2dot14()
+			transform[3] = f2dot14()
+		}
+
+		path.Matrix = matrix.Multiply(transform)
+		self.glyphPath(path, glyph, depth+1)
+
+		if flags&truetype_more == 0 {
+			return
+		}
+	}
+}
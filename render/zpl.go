@@ -0,0 +1,537 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ZPL_RESOLUTION   = 203 //dpi of the Zebra printers ZPL coordinates are meant for
+	ZPL_SNIFF_LENGTH = 1024
+
+	ZPL_DEFAULT_FONT          = 'A'
+	ZPL_DEFAULT_FONT_HEIGHT   = 9 //dots, size of the Zebra font A
+	ZPL_DEFAULT_FONT_WIDTH    = 5
+	ZPL_DEFAULT_MODULE_WIDTH  = 2
+	ZPL_DEFAULT_BAR_HEIGHT    = 10
+	ZPL_DEFAULT_MAGNIFICATION = 2
+	ZPL_DEFAULT_QR_LEVEL      = 'M'
+	ZPL_MAX_DOTS              = 32000 //largest coordinate or size accepted
+)
+
+const (
+	zpl_text = iota
+	zpl_code128
+	zpl_qrcode
+	zpl_box
+)
+
+// zpl_field is a text, barcode or box of a label with sizes in dots.
+type zpl_field struct {
+	kind        int
+	x, y        float64
+	typeset     bool //x, y is the bottom left corner (^FT) instead of the top left
+	orientation byte
+	data        string
+	font        byte
+	height      float64
+	width       float64
+	module      float64
+	line        bool
+	line_above  bool
+	level       byte
+	thickness   float64
+	rounding    float64
+	white       bool
+}
+
+// zpl_label holds the state of the commands between ^XA and ^XZ.
+type zpl_label struct {
+	fields      []zpl_field
+	home_x      float64
+	home_y      float64
+	field       zpl_field
+	has_data    bool
+	hex         byte
+	orientation byte
+	font        byte
+	font_height float64
+	font_width  float64
+	module      float64
+	bar_height  float64
+}
+
+// IsZPL reports whether data looks like ZPL, a label format starting with
+// ^XA after optional control commands.
+func IsZPL(data []byte) bool {
+	data = bytes.TrimLeft(data[:min(len(data), ZPL_SNIFF_LENGTH)], " \t\r\n")
+	return len(data) > 0 && (data[0] == '^' || data[0] == '~') && bytes.Contains(data, []byte("^XA"))
+}
+
+// RenderZPL renders every label between ^XA and ^XZ. The subset covers
+// fields placed with ^FO or ^FT, text in ^A fonts, Code 128 (^BC) and QR
+// codes (^BQ) and boxes (^GB); other commands are ignored. All fonts are
+// drawn with the built-in font at the given size. Labels are scaled from
+// the Zebra resolution to the printer resolution in dpi, cropped to their
+// content and reduced to fit the height in pixels, usually the printing
+// width of the tape.
+func RenderZPL(data []byte, height int, resolution float64) ([]*image.Gray, error) {
+	var labels []*image.Gray
+	var label *zpl_label
+
+	for position := 0; position < len(data); {
+		if data[position] != '^' && data[position] != '~' || position+3 > len(data) {
+			position++
+			continue
+		}
+		prefix := data[position]
+		name := strings.ToUpper(string(data[position+1 : position+3]))
+		position += 3
+
+		//field data may contain the control prefix ~, comments end at ^ too
+		end := position
+		for end < len(data) && data[end] != '^' && (data[end] != '~' || name == "FD" || name == "FX") {
+			end++
+		}
+		parameters := strings.NewReplacer("\r", "", "\n", "").Replace(string(data[position:end]))
+		position = end
+
+		switch {
+		case prefix == '~':
+			continue
+		case name == "XA":
+			label = newZPLLabel()
+		case name == "XZ":
+			if label != nil {
+				if img := label.render(height, resolution); img != nil {
+					labels = append(labels, img)
+				}
+			}
+			label = nil
+		case label != nil:
+			if err := label.command(name, parameters); err != nil {
+				return nil, fmt.Errorf("zpl: ^%s%s: %s", name, parameters, err)
+			}
+		}
+	}
+
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("zpl: no label found")
+	}
+	return labels, nil
+}
+
+func newZPLLabel() *zpl_label {
+	self := &zpl_label{
+		orientation: 'N',
+		font:        ZPL_DEFAULT_FONT,
+		font_height: ZPL_DEFAULT_FONT_HEIGHT,
+		font_width:  ZPL_DEFAULT_FONT_WIDTH,
+		module:      ZPL_DEFAULT_MODULE_WIDTH,
+		bar_height:  ZPL_DEFAULT_BAR_HEIGHT,
+	}
+	self.newField()
+	return self
+}
+
+// newField starts a field with the label defaults.
+func (self *zpl_label) newField() {
+	self.field = zpl_field{
+		kind:        zpl_text,
+		x:           self.field.x,
+		y:           self.field.y,
+		typeset:     self.field.typeset,
+		orientation: self.orientation,
+		font:        self.font,
+		height:      self.font_height,
+		width:       self.font_width,
+	}
+	self.has_data = false
+	self.hex = 0
+}
+
+func (self *zpl_label) command(name string, parameters string) error {
+	arguments := strings.Split(parameters, ",")
+	argument := func(index int) string {
+		if index < len(arguments) {
+			return strings.TrimSpace(arguments[index])
+		}
+		return ""
+	}
+	number := func(index int, default_value float64) (float64, error) {
+		value := argument(index)
+		if len(value) == 0 {
+			return default_value, nil
+		}
+		result, err := strconv.ParseFloat(value, 64)
+		if err != nil || result < 0 || result > ZPL_MAX_DOTS {
+			return 0, fmt.Errorf("invalid number %s", value)
+		}
+		return result, nil
+	}
+	orientation := func(index int, default_value byte) byte {
+		if value := strings.ToUpper(argument(index)); len(value) > 0 && strings.Contains("NRIB", value[:1]) {
+			return value[0]
+		}
+		return default_value
+	}
+	numbers := func(first int, targets ...*float64) error {
+		for index, target := range targets {
+			value, err := number(first+index, *target)
+			if err != nil {
+				return err
+			}
+			*target = value
+		}
+		return nil
+	}
+
+	field := &self.field
+	switch {
+	case name == "FO" || name == "FT":
+		x, y := field.x-self.home_x, field.y-self.home_y
+		if err := numbers(0, &x, &y); err != nil {
+			return err
+		}
+		field.x, field.y = x+self.home_x, y+self.home_y
+		field.typeset = name == "FT"
+	case name == "LH":
+		if err := numbers(0, &self.home_x, &self.home_y); err != nil {
+			return err
+		}
+	case name == "FW":
+		self.orientation = orientation(0, self.orientation)
+		field.orientation = self.orientation
+	case name == "CF":
+		if font := argument(0); len(font) > 0 {
+			self.font = strings.ToUpper(font)[0]
+		}
+		height, width := self.font_height, self.font_width
+		if len(argument(1)) > 0 && len(argument(2)) == 0 {
+			width = 0
+		}
+		if err := numbers(1, &height, &width); err != nil {
+			return err
+		}
+		self.font_height, self.font_width = zplFontSize(self.font, height, width)
+		field.font, field.height, field.width = self.font, self.font_height, self.font_width
+	case name[0] == 'A':
+		//^Afo,h,w selects the font f for this field
+		font := strings.ToUpper(name[1:])[0]
+		field.orientation = orientation(0, self.orientation)
+		height, width := field.height, field.width
+		if len(argument(1)) > 0 && len(argument(2)) == 0 {
+			width = 0
+		}
+		if err := numbers(1, &height, &width); err != nil {
+			return err
+		}
+		field.font = font
+		field.height, field.width = zplFontSize(font, height, width)
+	case name == "BY":
+		height := self.bar_height
+		if err := numbers(0, &self.module, new(float64), &height); err != nil {
+			return err
+		}
+		self.module = max(1, self.module)
+		self.bar_height = height
+	case name == "BC":
+		field.kind = zpl_code128
+		field.orientation = orientation(0, self.orientation)
+		field.height = self.bar_height
+		field.module = self.module
+		if err := numbers(1, &field.height); err != nil {
+			return err
+		}
+		field.line = !strings.EqualFold(argument(2), "N")
+		field.line_above = strings.EqualFold(argument(3), "Y")
+	case name == "BQ":
+		field.kind = zpl_qrcode
+		field.orientation = 'N'
+		field.module = ZPL_DEFAULT_MAGNIFICATION
+		if err := numbers(2, &field.module); err != nil {
+			return err
+		}
+		field.module = max(1, field.module)
+	case name == "GB":
+		box := zpl_field{kind: zpl_box, x: field.x, y: field.y, typeset: field.typeset, thickness: 1}
+		if err := numbers(0, &box.width, &box.height, &box.thickness); err != nil {
+			return err
+		}
+		box.thickness = max(1, box.thickness)
+		box.width = max(box.width, box.thickness)
+		box.height = max(box.height, box.thickness)
+		box.white = strings.EqualFold(argument(3), "W")
+		rounding, err := number(4, 0)
+		if err != nil {
+			return err
+		}
+		box.rounding = min(rounding, 8)
+		self.fields = append(self.fields, box)
+	case name == "FH":
+		self.hex = '_'
+		if indicator := argument(0); len(indicator) > 0 {
+			self.hex = indicator[0]
+		}
+	case name == "FD":
+		field.data = parameters
+		if self.hex != 0 {
+			field.data = zplHexDecode(field.data, self.hex)
+		}
+		self.has_data = true
+	case name == "FS":
+		if self.has_data {
+			if field.kind == zpl_qrcode {
+				field.level, field.data = zplQRData(field.data)
+			}
+			self.fields = append(self.fields, *field)
+		}
+		self.newField()
+	}
+	return nil
+}
+
+// zplFontSize completes the font size, a missing width is derived from the
+// height with the aspect ratio of the font.
+func zplFontSize(font byte, height float64, width float64) (float64, float64) {
+	height = max(1, height)
+	switch {
+	case width > 0:
+		return height, width
+	case font == '0':
+		return height, height
+	}
+	return height, height * FONT_CELL_WIDTH / FONT_CELL_HEIGHT
+}
+
+// zplHexDecode replaces the hexadecimal escapes of ^FH, e.g. _7E for ~.
+func zplHexDecode(data string, indicator byte) string {
+	var result []byte
+	for index := 0; index < len(data); index++ {
+		if data[index] == indicator && index+2 < len(data) {
+			if value, err := strconv.ParseUint(data[index+1:index+3], 16, 8); err == nil {
+				result = append(result, byte(value))
+				index += 2
+				continue
+			}
+		}
+		result = append(result, data[index])
+	}
+	return string(result)
+}
+
+// zplQRData splits the error correction level and input mode from QR code
+// field data, e.g. QA,text. Manual mode gives the character mode, and the
+// length for binary data, before the text.
+func zplQRData(data string) (byte, string) {
+	level := byte(ZPL_DEFAULT_QR_LEVEL)
+	if len(data) < 3 || data[2] != ',' {
+		return level, data
+	}
+	if strings.Contains("HQML", strings.ToUpper(data[:1])) {
+		level = strings.ToUpper(data[:1])[0]
+	}
+	manual := strings.EqualFold(data[1:2], "M")
+	data = data[3:]
+	if manual && len(data) > 0 {
+		mode := strings.ToUpper(data[:1])
+		data = data[1:]
+		if mode == "B" && len(data) >= 4 {
+			data = data[4:]
+		}
+	}
+	return level, data
+}
+
+// render draws the fields onto a label cropped to their extent, the label
+// is reduced if it is higher than height.
+func (self *zpl_label) render(height int, resolution float64) *image.Gray {
+	scale := resolution / ZPL_RESOLUTION
+	paths, bounds := self.paths(scale)
+	if bounds.Dy() > height && height > 0 {
+		paths, bounds = self.paths(scale * float64(height) / float64(bounds.Dy()))
+	}
+	if bounds.Empty() {
+		return nil
+	}
+
+	img := NewWhiteImage(bounds.Dx(), bounds.Dy())
+	img.Rect = bounds
+	for index, path := range paths {
+		gray := color.Gray{0}
+		if self.fields[index].white {
+			gray = color.Gray{0xff}
+		}
+		path.Fill(img, gray, 1, self.fields[index].kind == zpl_box)
+	}
+	return img
+}
+
+// paths returns the outlines of the fields in pixels and their bounds.
+func (self *zpl_label) paths(scale float64) ([]*Path, image.Rectangle) {
+	var bounds image.Rectangle
+	paths := make([]*Path, len(self.fields))
+	for index := range self.fields {
+		paths[index] = self.fields[index].path(scale)
+		if min_point, max_point, ok := paths[index].Bounds(); ok {
+			bounds = bounds.Union(image.Rect(
+				int(math.Floor(min_point.X)), int(math.Floor(min_point.Y)),
+				int(math.Ceil(max_point.X)), int(math.Ceil(max_point.Y)),
+			))
+		}
+	}
+	return paths, bounds
+}
+
+// matrix places a field of the given size in pixels, rotated fields keep
+// their top left corner at the field origin.
+func (self *zpl_field) matrix(scale float64, width float64, height float64) Matrix {
+	x, y := math.Round(self.x*scale), math.Round(self.y*scale)
+	if self.typeset {
+		//the origin is at the bottom left of the unrotated field
+		switch self.orientation {
+		case 'R':
+			return Matrix{0, 1, -1, 0, x, y}.Multiply(TranslateMatrix(0, -height))
+		case 'I':
+			return Matrix{-1, 0, 0, -1, x, y}.Multiply(TranslateMatrix(0, -height))
+		case 'B':
+			return Matrix{0, -1, 1, 0, x, y}.Multiply(TranslateMatrix(0, -height))
+		}
+		return TranslateMatrix(x, y-height)
+	}
+
+	switch self.orientation {
+	case 'R':
+		return Matrix{0, 1, -1, 0, x + height, y}
+	case 'I':
+		return Matrix{-1, 0, 0, -1, x + width, y + height}
+	case 'B':
+		return Matrix{0, -1, 1, 0, x, y + width}
+	}
+	return TranslateMatrix(x, y)
+}
+
+// path returns the outline of the field in pixels. Bars and modules are
+// snapped to whole pixels, so that barcodes scan after scaling.
+func (self *zpl_field) path(scale float64) *Path {
+	switch self.kind {
+	case zpl_box:
+		x, y := math.Round(self.x*scale), math.Round(self.y*scale)
+		width, height := math.Round(self.width*scale), math.Round(self.height*scale)
+		thickness := max(1, math.Round(self.thickness*scale))
+		if self.typeset {
+			y -= height
+		}
+		radius := self.rounding / 8 * min(width, height) / 2
+
+		path := NewPath(IdentityMatrix)
+		svgRoundedRect(path, x, y, max(width, thickness), max(height, thickness), radius, radius)
+		if width > 2*thickness && height > 2*thickness {
+			inner := max(0, radius-thickness)
+			svgRoundedRect(path, x+thickness, y+thickness, width-2*thickness, height-2*thickness, inner, inner)
+		}
+		return path
+	case zpl_code128:
+		return self.code128Path(scale)
+	case zpl_qrcode:
+		modules, err := QRCodeModules([]byte(self.data), self.level)
+		path := NewPath(IdentityMatrix)
+		if err != nil {
+			return path
+		}
+		unit := max(1, math.Round(self.module*scale))
+		size := float64(len(modules)) * unit
+		path.Matrix = self.matrix(scale, size, size)
+		for y, row := range modules {
+			for x, dark := range row {
+				if dark {
+					path.Rect(float64(x)*unit, float64(y)*unit, unit, unit)
+				}
+			}
+		}
+		return path
+	}
+
+	size := self.height * scale
+	stretch := self.width / self.height
+	if self.font != '0' {
+		stretch = self.width / (self.height * FONT_CELL_WIDTH / FONT_CELL_HEIGHT)
+	}
+	advance := size * FONT_CELL_WIDTH / FONT_CELL_HEIGHT * stretch
+	width := float64(utf8.RuneCountInString(self.data)) * advance
+
+	path := NewPath(self.matrix(scale, width, size).Multiply(ScaleMatrix(stretch, 1)))
+	baseline := size * FONT_GLYPH_HEIGHT / FONT_CELL_HEIGHT
+	column := 0
+	for _, char := range self.data {
+		if char != ' ' {
+			path.GlyphPath(char, float64(column)*advance/stretch, baseline, size)
+		}
+		column++
+	}
+	return path
+}
+
+// code128Path draws the bars of a Code 128 barcode with the optional
+// interpretation line, start code invocations (>9, >: and >;) are skipped
+// as the code sets are chosen automatically.
+func (self *zpl_field) code128Path(scale float64) *Path {
+	data := self.data
+	for _, invocation := range []string{">9", ">:", ">;"} {
+		data, _ = strings.CutPrefix(data, invocation)
+	}
+	path := NewPath(IdentityMatrix)
+	symbols, err := Code128Symbols(data)
+	if err != nil {
+		return path
+	}
+
+	unit := max(1, math.Round(self.module*scale))
+	bar_height := max(1, math.Round(self.height*scale))
+	modules := 0
+	for _, symbol := range symbols {
+		for _, width := range code128_patterns[symbol] {
+			modules += int(width - '0')
+		}
+	}
+	width := float64(modules) * unit
+
+	//the interpretation line is not part of the field size
+	text_size := FONT_CELL_HEIGHT * unit
+	text_width := float64(len(data)) * text_size * FONT_CELL_WIDTH / FONT_CELL_HEIGHT
+	path.Matrix = self.matrix(scale, width, bar_height)
+
+	x := 0.0
+	for _, symbol := range symbols {
+		for index, bar := range code128_patterns[symbol] {
+			bar_width := float64(bar-'0') * unit
+			if index%2 == 0 {
+				path.Rect(x, 0, bar_width, bar_height)
+			}
+			x += bar_width
+		}
+	}
+
+	if self.line {
+		baseline := bar_height + unit + text_size*FONT_GLYPH_HEIGHT/FONT_CELL_HEIGHT
+		if self.line_above {
+			baseline = -unit - text_size/FONT_CELL_HEIGHT
+		}
+		left := (width - text_width) / 2
+		for index, char := range data {
+			path.GlyphPath(char, left+float64(index)*text_size*FONT_CELL_WIDTH/FONT_CELL_HEIGHT, baseline, text_size)
+		}
+	}
+	return path
+}
@@ -0,0 +1,136 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package render
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update_golden = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+// zpl_golden_labels are rendered at the Zebra resolution, so that the
+// ZPL coordinates are pixels.
+var zpl_golden_labels = []struct {
+	name string
+	zpl  string
+}{
+	{"text", "^XA^FO10,10^A0N,30,20^FDplabel^FS^XZ"},
+	{"text_rotated", "^XA^FO10,10^A0R,30,20^FDplabel^FS^XZ"},
+	{"code128", "^XA^BY2^FO10,10^BCN,40,Y,N^FD12345^FS^XZ"},
+	{"qrcode", "^XA^FO10,10^BQN,2,3^FDMA,plabel^FS^XZ"},
+	{"box", "^XA^FO0,0^GB100,50,4^FS^XZ"},
+	{"box_rounded", "^XA^FO0,0^GB100,50,4,B,4^FS^XZ"},
+	{"label", "^XA^FO0,0^GB300,120,3^FS^FO15,15^A0N,28^FDPart 42^FS" +
+		"^BY2^FO15,50^BCN,40,N^FD42^FS^FO200,15^BQN,2,3^FDQA,42^FS^XZ"},
+}
+
+func TestRenderZPLGolden(t *testing.T) {
+	for _, test := range zpl_golden_labels {
+		t.Run(test.name, func(t *testing.T) {
+			labels, err := RenderZPL([]byte(test.zpl), 0, ZPL_RESOLUTION)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(labels) != 1 {
+				t.Fatalf("rendered %d labels", len(labels))
+			}
+			label := labels[0]
+
+			file_name := filepath.Join("testdata", "zpl_"+test.name+".png")
+			if *update_golden {
+				writeGolden(t, file_name, label)
+				return
+			}
+			compareGolden(t, file_name, label)
+		})
+	}
+}
+
+func TestRenderZPLBox(t *testing.T) {
+	labels, err := RenderZPL([]byte("^XA^FO20,30^GB100,50,4^FS^XZ"), 0, ZPL_RESOLUTION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	label := labels[0]
+	if label.Bounds() != image.Rect(20, 30, 120, 80) {
+		t.Fatalf("box bounds %v", label.Bounds())
+	}
+	for _, point := range []struct {
+		x, y  int
+		black bool
+	}{
+		{20, 30, true}, {23, 33, true}, {24, 34, false}, {70, 55, false}, {116, 76, true}, {119, 79, true},
+	} {
+		if black := label.GrayAt(point.x, point.y).Y < 0x80; black != point.black {
+			t.Errorf("pixel %d,%d black %t, expected %t", point.x, point.y, black, point.black)
+		}
+	}
+}
+
+func TestRenderZPLScaled(t *testing.T) {
+	labels, err := RenderZPL([]byte("^XA^FO0,0^GB100,50,4^FS^XZ"), 25, ZPL_RESOLUTION*2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := labels[0].Bounds().Size(); size != image.Pt(50, 25) {
+		t.Errorf("label reduced to %v, expected 50x25", size)
+	}
+}
+
+func TestRenderZPLWithoutLabel(t *testing.T) {
+	if _, err := RenderZPL([]byte("^FO10,10^FDno label^FS"), 0, ZPL_RESOLUTION); err == nil {
+		t.Error("no error without ^XA")
+	}
+}
+
+func writeGolden(t *testing.T, file_name string, img *image.Gray) {
+	if err := os.MkdirAll(filepath.Dir(file_name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(file_name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func compareGolden(t *testing.T, file_name string, img *image.Gray) {
+	file, err := os.Open(file_name)
+	if err != nil {
+		t.Fatalf("%s, run go test -update to create it", err)
+	}
+	defer file.Close()
+	golden, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bounds := img.Bounds()
+	if golden.Bounds().Size() != bounds.Size() {
+		t.Fatalf("rendered %v, golden image %v", bounds.Size(), golden.Bounds().Size())
+	}
+	differences := 0
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			expected, _, _, _ := golden.At(golden.Bounds().Min.X+x, golden.Bounds().Min.Y+y).RGBA()
+			actual := img.GrayAt(bounds.Min.X+x, bounds.Min.Y+y).Y
+			if (expected>>8 < 0x80) != (actual < 0x80) {
+				differences++
+			}
+		}
+	}
+	if differences > 0 {
+		t.Errorf("%d pixels differ from %s", differences, file_name)
+	}
+}
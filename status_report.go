@@ -0,0 +1,71 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+// The JSON field names of the status report are part of the command line
+// interface, do not rename them.
+
+type CodeDescription struct {
+	Code uint16 `json:"code"`
+	Description string `json:"description"`
+}
+
+type ModelReport struct {
+	Code byte `json:"code"`
+	Name string `json:"name"`
+	PixelWidth uint16 `json:"pixel_width"`
+	Resolution uint16 `json:"resolution"`
+}
+
+type MediaReport struct {
+	Type CodeDescription `json:"type"`
+	Width byte `json:"width_mm"`
+	Length byte `json:"length_mm"`
+	PixelWidth uint16 `json:"pixel_width"`
+	TapeColor CodeDescription `json:"tape_color"`
+	TextColor CodeDescription `json:"text_color"`
+}
+
+type StatusReport struct {
+	Valid bool `json:"valid"`
+	Model ModelReport `json:"model"`
+	ErrorCode uint16 `json:"error_code"`
+	Errors []CodeDescription `json:"errors"`
+	Status CodeDescription `json:"status"`
+	PhaseType CodeDescription `json:"phase_type"`
+	Phase CodeDescription `json:"phase"`
+	Notification CodeDescription `json:"notification"`
+	Media MediaReport `json:"media"`
+	MaxPrintingWidth uint16 `json:"max_printing_width"`
+}
+
+func (self *PrinterStatus) Report(model_information *ModelInformation) (report StatusReport) {
+	report.Valid = self.IsValid()
+	report.Model = ModelReport{self.ModelCode, model_information.ModelName, model_information.PixelWidth, model_information.Resolution}
+	report.ErrorCode = self.ErrorCode
+	report.Errors = self.ErrorList()
+	report.Status = CodeDescription{uint16(self.StatusCode), self.StatusDescription()}
+	report.PhaseType = CodeDescription{uint16(self.PhaseType), self.PhaseTypeDescription()}
+	report.Phase = CodeDescription{self.PhaseNumber, self.PhaseDescription()}
+	report.Notification = CodeDescription{uint16(self.NotificationCode), self.NotificationDescription()}
+	report.Media = MediaReport{
+		Type: CodeDescription{uint16(self.MediaType), self.MediaTypeDescription()},
+		Width: self.MediaWidth,
+		Length: self.MediaLength,
+		PixelWidth: MediaWidthToMaxPixel(self.MediaWidth, model_information.Resolution),
+		TapeColor: CodeDescription{uint16(self.TapeColor), self.TapeColorDescription()},
+		TextColor: CodeDescription{uint16(self.TextColor), self.TextColorDescription()},
+	}
+	return
+}
+
+func (self *Plabel) StatusReport() StatusReport {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	report := self.PrinterStatus.Report(&self.ModelInformation)
+	report.MaxPrintingWidth = self.MaxPrintingWidth
+	return report
+}
@@ -0,0 +1,101 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package plabel
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	TEMPLATE_MIN_KEY     = 1
+	TEMPLATE_MAX_KEY     = 255
+	TEMPLATE_MAX_COPIES  = 999
+	TEMPLATE_MAX_DATA    = 0xffff //bytes of data inserted into one object
+)
+
+// TemplateObject is the data for a text or barcode object of a template,
+// objects are selected by the name given in P-touch Editor.
+type TemplateObject struct {
+	Name string
+	Data string
+}
+
+// SwitchTemplateMode selects the P-touch Template mode, templates are
+// stored in the printer with P-touch Transfer Manager.
+func (self *Plabel) SwitchTemplateMode() {
+	self.SwitchDynamicCommandMode(COMMAND_MODE_TEMPLATE)
+}
+
+// InitializeTemplate resets the template mode settings (^II).
+func (self *Plabel) InitializeTemplate() {
+	self.SendCommand([]byte("^II"))
+}
+
+// SelectTemplate selects a stored template by its key (^TS).
+func (self *Plabel) SelectTemplate(key int) {
+	self.SendCommand([]byte(fmt.Sprintf("^TS%03d", key)))
+}
+
+// SelectObject selects an object of the template by name (^ON).
+func (self *Plabel) SelectObject(name string) {
+	self.SendCommand(append([]byte("^ON" + name), 0x00))
+}
+
+// InsertData replaces the data of the selected object (^DI).
+func (self *Plabel) InsertData(data string) {
+	command := []byte{'^', 'D', 'I', byte(len(data)), byte(len(data) >> 8)}
+	self.SendCommand(append(command, data...))
+}
+
+// SetTemplateCopies sets the number of copies printed (^CN).
+func (self *Plabel) SetTemplateCopies(copies int) {
+	self.SendCommand([]byte(fmt.Sprintf("^CN%03d", copies)))
+}
+
+// PrintTemplateData starts printing the selected template (^FF).
+func (self *Plabel) PrintTemplateData() {
+	self.SendCommand([]byte("^FF"))
+}
+
+// PrintTemplate fills the objects of a stored template and prints it. The
+// objects not given keep the data stored with the template.
+func (self *Plabel) PrintTemplate(key int, objects []TemplateObject, copies int) error {
+	if err := CheckTemplate(key, objects, copies); err != nil {
+		return err
+	}
+
+	self.SwitchTemplateMode()
+	self.InitializeTemplate()
+	self.SelectTemplate(key)
+	for _, object := range objects {
+		self.SelectObject(object.Name)
+		self.InsertData(object.Data)
+	}
+	self.SetTemplateCopies(copies)
+	self.PrintTemplateData()
+	return nil
+}
+
+// CheckTemplate checks the template key, object names and data before
+// anything is sent to the printer.
+func CheckTemplate(key int, objects []TemplateObject, copies int) error {
+	if key < TEMPLATE_MIN_KEY || key > TEMPLATE_MAX_KEY {
+		return fmt.Errorf("template key %d out of range %d-%d", key, TEMPLATE_MIN_KEY, TEMPLATE_MAX_KEY)
+	}
+	if copies < 1 || copies > TEMPLATE_MAX_COPIES {
+		return fmt.Errorf("copies %d out of range 1-%d", copies, TEMPLATE_MAX_COPIES)
+	}
+	for _, object := range objects {
+		if len(object.Name) == 0 || strings.ContainsRune(object.Name, 0) {
+			return fmt.Errorf("invalid object name %q", object.Name)
+		}
+		if len(object.Data) > TEMPLATE_MAX_DATA {
+			return fmt.Errorf("data of object %s longer than %d bytes", object.Name, TEMPLATE_MAX_DATA)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+// Package transport provides the devices a printer is attached to: the USB
+// printers found in sysfs, the recording and replay of sessions and the
+// printer emulator.
+package transport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"github.com/spag/plabel"
+)
+
+const (
+	BROTHER_VENDOR_ID = 0x04f9
+
+	SYSFS_ROOT  = "/sys"
+	DEVICE_ROOT = "/dev/usb"
+
+	DISCOVERY_TIMEOUT = 1000 //ms
+)
+
+type PrinterDevice struct {
+	DevicePath string
+	SysfsPath string
+	VendorID uint16
+	ProductID uint16
+	Manufacturer string
+	Product string
+	Serial string
+
+	StatusValid bool
+	PrinterStatus plabel.PrinterStatus
+	ModelInformation plabel.ModelInformation
+}
+
+// FindPrinters lists the Brother printers attached through the usblp driver.
+// The USB descriptors are taken from sysfs; if sysfs is not available every
+// lp device node is returned without vendor information.
+func FindPrinters(sysfs_root string, device_root string) (devices []PrinterDevice) {
+	class_path := filepath.Join(sysfs_root, "class", "usbmisc")
+
+	entries, err := os.ReadDir(class_path)
+	if err != nil {
+		nodes, _ := filepath.Glob(filepath.Join(device_root, "lp*"))
+		for _, node := range nodes {
+			devices = append(devices, PrinterDevice{DevicePath: node})
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "lp") {
+			continue
+		}
+
+		usb_path := findUsbDevicePath(filepath.Join(class_path, entry.Name(), "device"))
+		if len(usb_path) == 0 {
+			continue
+		}
+
+		device := PrinterDevice{
+			DevicePath: filepath.Join(device_root, entry.Name()),
+			SysfsPath: usb_path,
+			VendorID: readSysfsHex(usb_path, "idVendor"),
+			ProductID: readSysfsHex(usb_path, "idProduct"),
+			Manufacturer: readSysfsString(usb_path, "manufacturer"),
+			Product: readSysfsString(usb_path, "product"),
+			Serial: readSysfsString(usb_path, "serial"),
+		}
+
+		if device.VendorID == BROTHER_VENDOR_ID {
+			devices = append(devices, device)
+		}
+	}
+
+	return
+}
+
+// LookupPrinterDevice returns the discovery information of a device node.
+func LookupPrinterDevice(device_path string) (*PrinterDevice, bool) {
+	for _, device := range FindPrinters(SYSFS_ROOT, DEVICE_ROOT) {
+		if device.DevicePath == device_path {
+			return &device, true
+		}
+	}
+	return nil, false
+}
+
+// ResolvePrinterDevice maps a printer specification to a device node.
+// Supported forms are "serial:<serial number>", "model:<model name>" and a
+// plain device path, which is returned unchanged.
+func ResolvePrinterDevice(spec string) (string, error) {
+	kind, value, found := strings.Cut(spec, ":")
+	if !found || (kind != "serial" && kind != "model") {
+		return spec, nil
+	}
+
+	return resolvePrinterDevice(kind, value, FindPrinters(SYSFS_ROOT, DEVICE_ROOT))
+}
+
+func resolvePrinterDevice(kind string, value string, devices []PrinterDevice) (string, error) {
+	for _, device := range devices {
+		switch kind {
+		case "serial":
+			if device.Serial == value {
+				return device.DevicePath, nil
+			}
+		case "model":
+			device.QueryStatus(DISCOVERY_TIMEOUT)
+			if strings.EqualFold(device.ModelName(), value) {
+				return device.DevicePath, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no printer found matching %s:%s", kind, value)
+}
+
+// QueryStatus opens the device and requests a status frame to identify the model.
+func (self *PrinterDevice) QueryStatus(timeout uint16) bool {
+	printer := plabel.New()
+
+	if !printer.Open(self.DevicePath) {
+		return false
+	}
+
+	defer printer.Close()
+	go printer.ProcessStatus()
+
+	printer.Invalidate()
+	printer.Initialize()
+	printer.RequestStatus()
+
+	if !printer.WaitForPrinterStatus(timeout) {
+		return false
+	}
+
+	self.PrinterStatus = printer.Status()
+	self.ModelInformation = printer.Model()
+	self.StatusValid = self.PrinterStatus.IsValid()
+	return self.StatusValid
+}
+
+func (self *PrinterDevice) ModelName() string {
+	if self.StatusValid {
+		return self.ModelInformation.ModelName
+	}
+	if len(self.Product) > 0 {
+		return self.Product
+	}
+	return "Unknown"
+}
+
+// The usbmisc device link points to the USB interface, the descriptors are
+// found at the parent USB device.
+func findUsbDevicePath(path string) string {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+
+	for level := 0; level < 3; level++ {
+		if _, err := os.Stat(filepath.Join(path, "idVendor")); err == nil {
+			return path
+		}
+		path = filepath.Dir(path)
+	}
+
+	return ""
+}
+
+func readSysfsString(path string, name string) string {
+	data, err := os.ReadFile(filepath.Join(path, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readSysfsHex(path string, name string) uint16 {
+	value, err := strconv.ParseUint(readSysfsString(path, name), 16, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(value)
+}
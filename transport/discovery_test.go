@@ -0,0 +1,113 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package transport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeUsbPrinter creates the sysfs entries of a usblp device node, linked to
+// the interface of a USB device with the given descriptors.
+func fakeUsbPrinter(t *testing.T, sysfs_root string, node string, usb_id string, vendor string, product string, name string, serial string) {
+	t.Helper()
+	usb_path := filepath.Join(sysfs_root, "devices", "pci0000:00", "usb1", usb_id)
+	interface_path := filepath.Join(usb_path, usb_id + ":1.0")
+	if err := os.MkdirAll(interface_path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, value := range map[string]string{"idVendor": vendor, "idProduct": product, "manufacturer": "Brother", "product": name, "serial": serial} {
+		if err := os.WriteFile(filepath.Join(usb_path, file), []byte(value + "\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	class_path := filepath.Join(sysfs_root, "class", "usbmisc", node)
+	if err := os.MkdirAll(class_path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(interface_path, filepath.Join(class_path, "device")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindPrinters(t *testing.T) {
+	sysfs_root := t.TempDir()
+	fakeUsbPrinter(t, sysfs_root, "lp0", "1-1", "04f9", "2061", "PT-P700", "A1B2C3")
+	fakeUsbPrinter(t, sysfs_root, "lp1", "1-2", "03f0", "0517", "LaserJet", "X9")
+	fakeUsbPrinter(t, sysfs_root, "lp2", "1-3", "04f9", "2062", "PT-P750W", "D4E5F6")
+	//an lp node whose USB device was unplugged
+	if err := os.MkdirAll(filepath.Join(sysfs_root, "class", "usbmisc", "lp3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink(filepath.Join(sysfs_root, "devices", "gone"), filepath.Join(sysfs_root, "class", "usbmisc", "lp3", "device"))
+
+	devices := FindPrinters(sysfs_root, "/dev/usb")
+	if len(devices) != 2 {
+		t.Fatalf("found %d printers, expected 2: %+v", len(devices), devices)
+	}
+	found := make(map[string]PrinterDevice)
+	for _, device := range devices {
+		found[device.DevicePath] = device
+	}
+	printer, ok := found["/dev/usb/lp0"]
+	if !ok || printer.ProductID != 0x2061 || printer.Product != "PT-P700" || printer.Serial != "A1B2C3" {
+		t.Errorf("lp0 = %+v", printer)
+	}
+	if _, ok := found["/dev/usb/lp2"]; !ok {
+		t.Errorf("lp2 not found in %+v", devices)
+	}
+}
+
+func TestFindPrintersWithoutSysfs(t *testing.T) {
+	device_root := t.TempDir()
+	for _, node := range []string{"lp0", "lp1", "hiddev0"} {
+		if err := os.WriteFile(filepath.Join(device_root, node), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	devices := FindPrinters(filepath.Join(t.TempDir(), "missing"), device_root)
+	if len(devices) != 2 || devices[0].DevicePath != filepath.Join(device_root, "lp0") || devices[1].VendorID != 0 {
+		t.Errorf("devices = %+v", devices)
+	}
+}
+
+func TestResolvePrinterDevice(t *testing.T) {
+	sysfs_root := t.TempDir()
+	device_root := t.TempDir()
+	fakeUsbPrinter(t, sysfs_root, "lp0", "1-1", "04f9", "2061", "PT-P700", "A1B2C3")
+	fakeUsbPrinter(t, sysfs_root, "lp1", "1-2", "04f9", "2062", "PT-P750W", "D4E5F6")
+	devices := FindPrinters(sysfs_root, device_root)
+
+	tests := []struct {
+		kind   string
+		value  string
+		device string
+	}{
+		{"serial", "D4E5F6", "lp1"},
+		{"serial", "A1B2C3", "lp0"},
+		//the device nodes do not answer, the model is the USB product name
+		{"model", "pt-p700", "lp0"},
+		{"model", "PT-P750W", "lp1"},
+	}
+	for _, test := range tests {
+		device, err := resolvePrinterDevice(test.kind, test.value, devices)
+		if err != nil || device != filepath.Join(device_root, test.device) {
+			t.Errorf("%s:%s = %s, %v, expected %s", test.kind, test.value, device, err, test.device)
+		}
+	}
+
+	for _, spec := range [][2]string{{"serial", "000000"}, {"model", "PT-H500"}} {
+		if device, err := resolvePrinterDevice(spec[0], spec[1], devices); err == nil {
+			t.Errorf("%s:%s = %s, expected no printer found", spec[0], spec[1], device)
+		}
+	}
+	if device, err := ResolvePrinterDevice("/dev/usb/lp7"); err != nil || device != "/dev/usb/lp7" {
+		t.Errorf("device path = %s, %v", device, err)
+	}
+}
@@ -0,0 +1,386 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"sync"
+	"github.com/spag/plabel"
+	"github.com/spag/plabel/render"
+)
+
+const (
+	EMULATOR_SERIES_CODE  = 0x30
+	EMULATOR_COUNTRY_CODE = 0x30
+	EMULATOR_TAPE_COLOR   = 0x01 //white
+	EMULATOR_TEXT_COLOR   = 0x08 //black
+)
+
+// Emulator is a printer device for Plabel.Attach that answers status
+// requests and renders the printed labels, so that jobs can be previewed and
+// tested without a printer. Raster and ESC/P mode are rendered, ESC/P text
+// is drawn with the built-in 5x7 font scaled to the character size and
+// barcodes other than Code 128 as framed data. Templates are stored in the
+// printer, they are acknowledged but not rendered.
+type Emulator struct {
+	ModelInformation plabel.ModelInformation
+	MediaWidth byte
+	ErrorCode uint16 //reported with every status, e.g. to test error handling
+
+	mutex    sync.Mutex
+	readable *sync.Cond
+	closed   bool
+	output   []byte
+	labels   []*image.Gray
+
+	disassembler *plabel.Disassembler
+	mirror       bool
+	raster       [][]byte
+	escp         emulator_escp
+}
+
+// emulator_escp is the ESC/P page being composed, lines are completed by
+// line feeds.
+type emulator_escp struct {
+	style plabel.EscpStyle
+	line  []emulator_item
+	lines []emulator_line
+}
+
+type emulator_line struct {
+	align byte
+	items []emulator_item
+	size  uint16 //height of an empty line
+}
+
+type emulator_item struct {
+	text    string
+	style   plabel.EscpStyle
+	barcode *plabel.EscpBarcode
+}
+
+// NewEmulator emulates a printer of the model with tape of the given width
+// in mm installed.
+func NewEmulator(model_information *plabel.ModelInformation, media_width byte) *Emulator {
+	self := &Emulator{ModelInformation: *model_information, MediaWidth: media_width}
+	self.readable = sync.NewCond(&self.mutex)
+	self.disassembler = plabel.NewDisassembler()
+	self.reset()
+	return self
+}
+
+// Labels returns the labels printed so far. The tape runs along x, y is the
+// printing width of the tape as in plabel.PreviewImage.
+func (self *Emulator) Labels() []*image.Gray {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return append([]*image.Gray(nil), self.labels...)
+}
+
+// PrintingWidth returns the printable dots across the installed tape.
+func (self *Emulator) PrintingWidth() int {
+	printing_width := plabel.MediaWidthToMaxPixel(self.MediaWidth, self.ModelInformation.Resolution)
+	if printing_width == 0 || printing_width > self.ModelInformation.PixelWidth {
+		printing_width = self.ModelInformation.PixelWidth
+	}
+	return int(printing_width)
+}
+
+// Read returns the status frames sent by the emulated printer, it blocks
+// until a status is sent or the emulator is closed.
+func (self *Emulator) Read(data []byte) (int, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for len(self.output) == 0 && !self.closed {
+		self.readable.Wait()
+	}
+	if len(self.output) == 0 {
+		return 0, io.EOF
+	}
+	count := copy(data, self.output)
+	self.output = self.output[count:]
+	return count, nil
+}
+
+// Write processes the commands as split by the disassembler, incomplete
+// commands are kept until the rest is written.
+func (self *Emulator) Write(data []byte) (int, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.closed {
+		return 0, io.ErrClosedPipe
+	}
+	for _, instruction := range self.disassembler.Decode(data) {
+		self.execute(instruction)
+	}
+	return len(data), nil
+}
+
+func (self *Emulator) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.closed = true
+	self.readable.Broadcast()
+	return nil
+}
+
+func (self *Emulator) reset() {
+	self.mirror = false
+	self.raster = nil
+	self.escp = emulator_escp{style: plabel.EscpStyle{Size: plabel.ESCP_DEFAULT_SIZE}}
+}
+
+// sendStatus queues a status frame for Read.
+func (self *Emulator) sendStatus(status_code byte, phase_type byte) {
+	status := plabel.PrinterStatus{
+		PrintHeadMark:    0x80,
+		Size:             0x20,
+		ManufacturerCode: 0x42,
+		SeriesCode:       EMULATOR_SERIES_CODE,
+		ModelCode:        self.ModelInformation.ModelCode,
+		CountryCode:      EMULATOR_COUNTRY_CODE,
+		ErrorCode:        self.ErrorCode,
+		MediaWidth:       self.MediaWidth,
+		MediaType:        plabel.MEDIA_TYPE_LAMINATED,
+		Mode:             self.disassembler.Mode,
+		StatusCode:       status_code,
+		PhaseType:        phase_type,
+		TapeColor:        EMULATOR_TAPE_COLOR,
+		TextColor:        EMULATOR_TEXT_COLOR,
+	}
+
+	var frame bytes.Buffer
+	binary.Write(&frame, binary.LittleEndian, &status)
+	self.output = append(self.output, frame.Bytes()...)
+	self.readable.Broadcast()
+}
+
+// printPage prints the current page and reports the printing phases.
+func (self *Emulator) printPage() {
+	if self.ErrorCode > 0 {
+		self.sendStatus(plabel.STATUS_ERROR, plabel.PHASE_EDITING)
+		return
+	}
+
+	self.sendStatus(plabel.STATUS_PHASE_CHANGE, plabel.PHASE_PRINTING)
+	switch self.disassembler.Mode {
+	case plabel.COMMAND_MODE_RASTER:
+		self.labels = append(self.labels, self.renderRaster())
+		self.raster = nil
+	case plabel.COMMAND_MODE_ESCP:
+		self.labels = append(self.labels, self.renderEscp())
+		self.escp.line = nil
+		self.escp.lines = nil
+	}
+	self.sendStatus(plabel.STATUS_PRINTING_COMPLETED, plabel.PHASE_PRINTING)
+	self.sendStatus(plabel.STATUS_PHASE_CHANGE, plabel.PHASE_EDITING)
+}
+
+// execute carries out a command decoded by the disassembler.
+func (self *Emulator) execute(instruction plabel.Instruction) {
+	data := instruction.Data
+	style := &self.escp.style
+
+	switch instruction.Name {
+	case "FF", "SUB", "^FF":
+		self.printPage()
+	case "ESC @":
+		self.reset()
+	case "ESC i S":
+		self.sendStatus(0x00, plabel.PHASE_EDITING)
+	case "ESC i M":
+		self.mirror = data[3] & (1 << 7) != 0
+	case "Z":
+		spec := plabel.NewRasterSpec(&self.ModelInformation, self.MediaWidth)
+		self.raster = append(self.raster, make([]byte, spec.LineLength()))
+	case "G":
+		line := data[3:]
+		if self.disassembler.Compression == plabel.COMPRESSION_TIFF {
+			line, _ = plabel.UnpackBits(line)
+		}
+		self.raster = append(self.raster, line)
+	case "ESC X":
+		style.Size = uint16(data[3]) | uint16(data[4]) << 8
+	case "ESC k":
+		style.Font = data[2]
+	case "ESC a":
+		style.Align = data[2]
+	case "ESC E", "ESC F":
+		style.Bold = data[1] == 'E'
+	case "ESC 4", "ESC 5":
+		style.Italic = data[1] == '4'
+	case "text":
+		self.escpText(string(data))
+	case "HT":
+		self.escpText(" ")
+	case "LF":
+		self.escp.lines = append(self.escp.lines, emulator_line{style.Align, self.escp.line, style.Size})
+		self.escp.line = nil
+	case "ESC i B":
+		barcode, barcode_data, _ := plabel.ParseEscpBarcode(data)
+		self.escp.line = append(self.escp.line, emulator_item{text: barcode_data, style: *style, barcode: &barcode})
+	}
+}
+
+// renderRaster renders the raster lines, every line is one column of the
+// label with the first pin at the top.
+func (self *Emulator) renderRaster() *image.Gray {
+	spec := plabel.NewRasterSpec(&self.ModelInformation, self.MediaWidth)
+	img := render.NewWhiteImage(max(len(self.raster), 1), spec.PrintingWidth)
+
+	for x, line := range self.raster {
+		if self.mirror {
+			x = len(self.raster) - 1 - x
+		}
+		for y := 0; y < spec.PrintingWidth; y++ {
+			if spec.Pin(line, spec.Offset + y) {
+				img.SetGray(x, y, color.Gray{0})
+			}
+		}
+	}
+	return img
+}
+
+// escpText adds text to the current line.
+func (self *Emulator) escpText(text string) {
+	items := self.escp.line
+	if count := len(items); count > 0 && items[count-1].barcode == nil && items[count-1].style == self.escp.style {
+		items[count-1].text += text
+	} else {
+		self.escp.line = append(items, emulator_item{text: text, style: self.escp.style})
+	}
+}
+
+// size returns the size of an item in dots.
+func (self *emulator_item) size() (width int, height int) {
+	if self.barcode != nil {
+		module := int(self.barcode.Width) + 1
+		width = len(self.text) * render.FONT_CELL_WIDTH + 2 * render.BARCODE_QUIET_ZONE
+		if symbols, err := render.Code128Symbols(self.text); err == nil && self.barcode.Type == plabel.ESCP_BARCODE_CODE128 {
+			width = render.Code128Modules(symbols) * module
+		}
+		height = int(self.barcode.Height)
+		if self.barcode.Text {
+			height += render.FONT_CELL_HEIGHT + render.FONT_CELL_HEIGHT/2
+		}
+		return
+	}
+
+	scale := self.scale()
+	width = len(self.text) * render.FONT_CELL_WIDTH * scale
+	if self.style.Bold {
+		width += (scale + 1) / 2
+	}
+	if self.style.Italic {
+		width += render.FONT_GLYPH_HEIGHT * scale / 3
+	}
+	return width, render.FONT_CELL_HEIGHT * scale
+}
+
+func (self *emulator_item) scale() int {
+	return max(1, (int(self.style.Size) + render.FONT_CELL_HEIGHT/2) / render.FONT_CELL_HEIGHT)
+}
+
+// draw draws the item with its bottom left corner at x and y.
+func (self *emulator_item) draw(img *image.Gray, x int, y int) {
+	width, height := self.size()
+	top := y - height
+
+	if self.barcode != nil {
+		bar_height := int(self.barcode.Height)
+		symbols, err := render.Code128Symbols(self.text)
+		if err == nil && self.barcode.Type == plabel.ESCP_BARCODE_CODE128 {
+			render.DrawCode128(img, symbols, x, top, int(self.barcode.Width) + 1, bar_height)
+		} else {
+			//other symbologies are shown as framed data
+			render.FillRect(img, x, top, width, 1, color.Gray{0})
+			render.FillRect(img, x, top + bar_height - 1, width, 1, color.Gray{0})
+			render.FillRect(img, x, top, 1, bar_height, color.Gray{0})
+			render.FillRect(img, x + width - 1, top, 1, bar_height, color.Gray{0})
+			render.DrawText(img, self.text, x + render.BARCODE_QUIET_ZONE, top + (bar_height - render.FONT_CELL_HEIGHT) / 2, 1)
+		}
+		if self.barcode.Text {
+			text_width, _ := render.TextSize(self.text, 1)
+			render.DrawText(img, self.text, x + (width - text_width) / 2, y - render.FONT_CELL_HEIGHT, 1)
+		}
+		return
+	}
+
+	scale := self.scale()
+	slant := 0
+	if self.style.Italic {
+		slant = scale
+	}
+	for _, char := range self.text {
+		glyph := render.FontGlyph(char)
+		for row := 0; row < render.FONT_GLYPH_HEIGHT; row++ {
+			//italic glyphs are sheared by a third of the row height
+			shift := (render.FONT_GLYPH_HEIGHT - 1 - row) * slant / 3
+			for column := 0; column < render.FONT_GLYPH_WIDTH; column++ {
+				if glyph[column] & (1 << row) == 0 {
+					continue
+				}
+				render.FillRect(img, x + shift + column*scale, top + row*scale, scale, scale, color.Gray{0})
+				if self.style.Bold {
+					render.FillRect(img, x + shift + column*scale + (scale + 1) / 2, top + row*scale, scale, scale, color.Gray{0})
+				}
+			}
+		}
+		x += render.FONT_CELL_WIDTH * scale
+	}
+}
+
+// renderEscp renders the lines of the page centered across the tape, the
+// label is as long as the longest line.
+func (self *Emulator) renderEscp() *image.Gray {
+	lines := self.escp.lines
+	if len(self.escp.line) > 0 {
+		lines = append(lines, emulator_line{self.escp.style.Align, self.escp.line, self.escp.style.Size})
+	}
+
+	widths := make([]int, len(lines))
+	heights := make([]int, len(lines))
+	length, total_height := 1, 0
+	for index, line := range lines {
+		heights[index] = int(line.size)
+		if len(line.items) > 0 {
+			heights[index] = 0
+		}
+		for _, item := range line.items {
+			width, height := item.size()
+			widths[index] += width
+			heights[index] = max(heights[index], height)
+		}
+		length = max(length, widths[index])
+		total_height += heights[index]
+	}
+
+	printing_width := self.PrintingWidth()
+	img := render.NewWhiteImage(length, printing_width)
+	y := (printing_width - total_height) / 2
+	for index, line := range lines {
+		y += heights[index]
+		x := 0
+		switch line.align {
+		case plabel.ESCP_ALIGN_CENTER:
+			x = (length - widths[index]) / 2
+		case plabel.ESCP_ALIGN_RIGHT:
+			x = length - widths[index]
+		}
+		for _, item := range line.items {
+			item.draw(img, x, y)
+			width, _ := item.size()
+			x += width
+		}
+	}
+	return img
+}
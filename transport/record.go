@@ -0,0 +1,174 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"github.com/spag/plabel"
+)
+
+const (
+	RECORD_MAGIC   = "PLREC"
+	RECORD_VERSION = 1
+
+	RECORD_MAX_DATA = 1 << 24
+)
+
+// RecordEntry is one command or status frame of a recorded session.
+type RecordEntry struct {
+	Kind byte
+	Time time.Time
+	Data []byte
+}
+
+// Recorder writes the commands sent to and the status frames received from
+// the printer into a session file. The file starts with RECORD_MAGIC and the
+// version, every entry consists of the kind, the time in nanoseconds since
+// 1970, the length of the data and the data, all little endian.
+type Recorder struct {
+	mutex  sync.Mutex
+	file   io.WriteCloser
+	writer *bufio.Writer
+	err    error
+}
+
+func NewRecorder(file io.WriteCloser) *Recorder {
+	self := &Recorder{file: file, writer: bufio.NewWriter(file)}
+	self.writer.WriteString(RECORD_MAGIC)
+	self.writer.WriteByte(RECORD_VERSION)
+	return self
+}
+
+// Record appends an entry, the first error is kept and returned by Close.
+func (self *Recorder) Record(kind byte, data []byte) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.err != nil {
+		return
+	}
+	header := make([]byte, 13)
+	header[0] = kind
+	binary.LittleEndian.PutUint64(header[1:], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint32(header[9:], uint32(len(data)))
+	if _, err := self.writer.Write(header); err != nil {
+		self.err = err
+		return
+	}
+	if _, err := self.writer.Write(data); err != nil {
+		self.err = err
+		return
+	}
+	//keep the session when the program is interrupted
+	self.err = self.writer.Flush()
+}
+
+func (self *Recorder) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.err == nil {
+		self.err = self.writer.Flush()
+	}
+	if err := self.file.Close(); self.err == nil {
+		self.err = err
+	}
+	return self.err
+}
+
+// ReadRecording reads all entries of a session file.
+func ReadRecording(reader io.Reader) ([]RecordEntry, error) {
+	buffered := bufio.NewReader(reader)
+	header := make([]byte, len(RECORD_MAGIC) + 1)
+	if _, err := io.ReadFull(buffered, header); err != nil || string(header[:len(RECORD_MAGIC)]) != RECORD_MAGIC {
+		return nil, fmt.Errorf("not a recorded session")
+	}
+	if header[len(RECORD_MAGIC)] != RECORD_VERSION {
+		return nil, fmt.Errorf("unsupported session version %d", header[len(RECORD_MAGIC)])
+	}
+
+	var entries []RecordEntry
+	entry_header := make([]byte, 13)
+	for {
+		if _, err := io.ReadFull(buffered, entry_header); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, fmt.Errorf("entry %d: %s", len(entries) + 1, err)
+		}
+
+		length := binary.LittleEndian.Uint32(entry_header[9:])
+		if length > RECORD_MAX_DATA {
+			return entries, fmt.Errorf("entry %d: length %d too large", len(entries) + 1, length)
+		}
+		entry := RecordEntry{
+			Kind: entry_header[0],
+			Time: time.Unix(0, int64(binary.LittleEndian.Uint64(entry_header[1:]))),
+			Data: make([]byte, length),
+		}
+		if _, err := io.ReadFull(buffered, entry.Data); err != nil {
+			return entries, fmt.Errorf("entry %d: %s", len(entries) + 1, err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// Replay sends the commands of a recorded session to the printer. Where the
+// recorded printer answered a command, the replay waits for the answer of
+// this printer, after print commands until printing is completed.
+func Replay(printer *plabel.Plabel, entries []RecordEntry, timeout uint16) error {
+	for index, entry := range entries {
+		if entry.Kind != plabel.RECORD_COMMAND {
+			continue
+		}
+
+		answered, printed := false, false
+		for _, reply := range entries[index+1:] {
+			if reply.Kind == plabel.RECORD_COMMAND {
+				break
+			}
+			var status plabel.PrinterStatus
+			if reply.Kind != plabel.RECORD_STATUS || binary.Read(bytes.NewReader(reply.Data), binary.LittleEndian, &status) != nil {
+				continue
+			}
+			answered = true
+			switch status.StatusCode {
+			case plabel.STATUS_PRINTING_COMPLETED, plabel.STATUS_ERROR:
+				printed = true
+			case plabel.STATUS_PHASE_CHANGE:
+				printed = printed || status.PhaseType == plabel.PHASE_PRINTING
+			}
+		}
+
+		if answered {
+			printer.ResetStatus()
+		}
+		printer.SendCommand(entry.Data)
+		if printer.Simulate {
+			continue
+		}
+
+		switch {
+		case printed:
+			completed := printer.WaitForPrintingCompleted(timeout)
+			if status := printer.Status(); status.StatusCode == plabel.STATUS_ERROR {
+				return fmt.Errorf("entry %d: printer error: %s", index + 1, status.ErrorDescription())
+			}
+			if !completed {
+				return fmt.Errorf("entry %d: timeout waiting for printing to complete", index + 1)
+			}
+		case answered:
+			//unsolicited status frames may have been recorded as answers
+			printer.WaitForPrinterStatus(1000)
+		}
+	}
+	return nil
+}
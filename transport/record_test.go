@@ -0,0 +1,160 @@
+/*
+ * plabel -- Brother p-touch label printer driver
+ * Copyright (c) 2021-2022
+ */
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"github.com/spag/plabel"
+)
+
+// testPrinter returns a printer attached to an emulated PT-P700 with 12 mm
+// tape, started as the plabel command starts printers.
+func testPrinter(t *testing.T) (*plabel.Plabel, *Emulator) {
+	t.Helper()
+	model_information, _ := plabel.GetModelInformationByName("PT-P700")
+	emulator := NewEmulator(model_information, 12)
+	printer := plabel.New()
+	printer.Logger = slog.New(slog.DiscardHandler)
+	printer.Attach(emulator)
+	t.Cleanup(printer.Close)
+
+	go printer.ProcessStatus()
+	printer.Invalidate()
+	printer.Initialize()
+	printer.RequestStatus()
+	if !printer.WaitForPrinterStatus(1000) {
+		t.Fatal("emulator not responding")
+	}
+	return printer, emulator
+}
+
+func testLabel() image.Image {
+	img := image.NewGray(image.Rect(0, 0, 40, 70))
+	for y := 0; y < 70; y++ {
+		for x := 0; x < 40; x++ {
+			if x < 20 && y > 35 || x == y {
+				img.SetGray(x, y, color.Gray{0})
+			} else {
+				img.SetGray(x, y, color.Gray{0xff})
+			}
+		}
+	}
+	return img
+}
+
+func TestRecordReplay(t *testing.T) {
+	file_name := filepath.Join(t.TempDir(), "session.plrec")
+	file, err := os.Create(file_name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	printer, emulator := testPrinter(t)
+	printer.Record(NewRecorder(file))
+	options := plabel.PrintOptions{}
+	raster, err := printer.RasterLabel(testLabel(), "test", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	printer.RequestStatus()
+	printer.WaitForPrinterStatus(1000)
+	if err := printer.PrintRaster(context.Background(), raster, options); err != nil {
+		t.Fatal(err)
+	}
+	printer.Close()
+
+	data, err := os.ReadFile(file_name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte{'P', 'L', 'R', 'E', 'C', RECORD_VERSION}) {
+		t.Fatalf("session header %q", data[:min(len(data), 6)])
+	}
+	entries, err := ReadRecording(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var commands []byte
+	statuses := 0
+	for index, entry := range entries {
+		switch entry.Kind {
+		case plabel.RECORD_COMMAND:
+			commands = append(commands, entry.Data...)
+		case plabel.RECORD_STATUS:
+			statuses++
+			if len(entry.Data) != 32 {
+				t.Errorf("status frame of %d bytes", len(entry.Data))
+			}
+		default:
+			t.Errorf("entry of kind %c", entry.Kind)
+		}
+		if index > 0 && entry.Time.Before(entries[index-1].Time) {
+			t.Errorf("entry %d recorded before entry %d", index+1, index)
+		}
+	}
+	//the answer to the status request and the status after printing
+	if statuses < 2 {
+		t.Errorf("%d status frames recorded", statuses)
+	}
+
+	//the recorded commands print the label again
+	replay_printer, replay_emulator := testPrinter(t)
+	if err := Replay(replay_printer, entries, 2000); err != nil {
+		t.Fatal(err)
+	}
+	labels, replayed := emulator.Labels(), replay_emulator.Labels()
+	if len(labels) != 1 || len(replayed) != 1 {
+		t.Fatalf("%d labels printed, %d replayed", len(labels), len(replayed))
+	}
+	if labels[0].Bounds() != replayed[0].Bounds() || !slices.Equal(labels[0].Pix, replayed[0].Pix) {
+		t.Errorf("replayed label %v differs from label %v", replayed[0].Bounds(), labels[0].Bounds())
+	}
+
+	//the commands of the session are those the emulator received
+	disassembler := plabel.NewDisassembler()
+	disassembler.Decode(commands)
+	disassembler.Flush()
+	if disassembler.Pages != 1 || disassembler.Lines != len(raster) || disassembler.Warnings != 0 {
+		t.Errorf("recorded %d pages of %d lines, %d warnings", disassembler.Pages, disassembler.Lines, disassembler.Warnings)
+	}
+}
+
+func TestReadRecordingErrors(t *testing.T) {
+	entry := []byte{plabel.RECORD_COMMAND, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0x1b, 0x40}
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		entries int
+		err     string
+	}{
+		{"empty session", []byte("PLREC\x01"), 0, ""},
+		{"one entry", append([]byte("PLREC\x01"), entry...), 1, ""},
+		{"no session", []byte("%PDF-1.4"), 0, "not a recorded session"},
+		{"short header", []byte("PLR"), 0, "not a recorded session"},
+		{"version", []byte("PLREC\x02"), 0, "unsupported session version 2"},
+		{"truncated entry header", append(append([]byte("PLREC\x01"), entry...), entry[:5]...), 1, "entry 2:"},
+		{"truncated data", append([]byte("PLREC\x01"), entry[:14]...), 0, "entry 1:"},
+		{"length", append([]byte("PLREC\x01"), plabel.RECORD_STATUS, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x10), 0, "too large"},
+	} {
+		entries, err := ReadRecording(bytes.NewReader(test.data))
+		if len(test.err) == 0 && err != nil || len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error %v, expected %q", test.name, err, test.err)
+		}
+		if len(entries) != test.entries {
+			t.Errorf("%s: %d entries", test.name, len(entries))
+		}
+	}
+}